@@ -0,0 +1,19 @@
+#!/bin/bash
+
+# Crea la tabla de productos (con sus GSIs) en DynamoDB Local
+set -e
+
+ENDPOINT="${DYNAMODB_ENDPOINT:-http://localhost:8000}"
+DIR="$(cd "$(dirname "$0")" && pwd)"
+
+export AWS_ACCESS_KEY_ID="${AWS_ACCESS_KEY_ID:-local}"
+export AWS_SECRET_ACCESS_KEY="${AWS_SECRET_ACCESS_KEY:-local}"
+export AWS_REGION="${AWS_REGION:-us-east-1}"
+
+echo "🧪 Creating products table on $ENDPOINT..."
+aws dynamodb delete-table --table-name products --endpoint-url "$ENDPOINT" >/dev/null 2>&1 || true
+aws dynamodb create-table --cli-input-json "file://$DIR/products-table.json" --endpoint-url "$ENDPOINT" >/dev/null
+aws dynamodb wait table-exists --table-name products --endpoint-url "$ENDPOINT"
+aws dynamodb update-time-to-live --table-name products --endpoint-url "$ENDPOINT" \
+    --time-to-live-specification "Enabled=true,AttributeName=expires_at" >/dev/null
+echo "✅ products table ready (DYNAMODB_ENDPOINT=$ENDPOINT)"
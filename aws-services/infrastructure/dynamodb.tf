@@ -0,0 +1,96 @@
+# =================================
+# 📦 PRODUCTS TABLE (products-api)
+# =================================
+# Los GSIs usan created_at como sort key para que Query devuelva los
+# productos de una categoría/estado en orden de creación.
+
+variable "products_table_name" {
+  description = "DynamoDB table used by the products API"
+  type        = string
+  default     = "products"
+}
+
+resource "aws_dynamodb_table" "products" {
+  name         = var.products_table_name
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "id"
+
+  attribute {
+    name = "id"
+    type = "S"
+  }
+
+  attribute {
+    name = "category"
+    type = "S"
+  }
+
+  attribute {
+    name = "status"
+    type = "S"
+  }
+
+  attribute {
+    name = "created_at"
+    type = "S"
+  }
+
+  attribute {
+    name = "history_product_id"
+    type = "S"
+  }
+
+  attribute {
+    name = "version"
+    type = "N"
+  }
+
+  global_secondary_index {
+    name            = "category-index"
+    hash_key        = "category"
+    range_key       = "created_at"
+    projection_type = "ALL"
+  }
+
+  global_secondary_index {
+    name            = "status-index"
+    hash_key        = "status"
+    range_key       = "created_at"
+    projection_type = "ALL"
+  }
+
+  # Historial de precios: un item por cambio, leído por producto
+  global_secondary_index {
+    name            = "price-history-index"
+    hash_key        = "history_product_id"
+    range_key       = "version"
+    projection_type = "ALL"
+  }
+
+  # El stream alimenta las estadísticas incrementales (/products/stats)
+  stream_enabled   = true
+  stream_view_type = "NEW_AND_OLD_IMAGES"
+
+  # Registros de idempotencia (Idempotency-Key) expiran solos
+  ttl {
+    attribute_name = "expires_at"
+    enabled        = true
+  }
+
+  point_in_time_recovery {
+    enabled = true
+  }
+
+  tags = {
+    Project = "ecommerce-multicloud-monitoring"
+    Service = "products-api"
+  }
+}
+
+output "products_table_arn" {
+  value = aws_dynamodb_table.products.arn
+}
+
+output "products_stream_arn" {
+  value = aws_dynamodb_table.products.stream_arn
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// APIKeyHeader -> Cabecera con la clave de las rutas internas (reservas y mantenimiento)
+const APIKeyHeader = "X-Api-Key"
+
+// internalAPIKey lee INTERNAL_API_KEY, la clave que comparten la API y el
+// order-processor. Sin ella, en modo local las rutas internas quedan abiertas
+// (nil) y en Lambda se usa una clave aleatoria que nadie conoce: se rechaza todo.
+func internalAPIKey() []byte {
+	if key := os.Getenv("INTERNAL_API_KEY"); key != "" {
+		return []byte(key)
+	}
+	if os.Getenv("LOCAL_MODE") == "true" {
+		log.Println("⚠️  INTERNAL_API_KEY not set, internal routes are open in local mode")
+		return nil
+	}
+
+	log.Println("❌ INTERNAL_API_KEY not set, internal routes will reject every request")
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		log.Fatalf("Failed to generate internal API key: %v", err)
+	}
+	return []byte(hex.EncodeToString(random))
+}
+
+// authorizeInternal comprueba la clave de una ruta interna en tiempo constante
+func (h *ProductHandler) authorizeInternal(request events.APIGatewayProxyRequest) bool {
+	if h.internalKey == nil {
+		return true
+	}
+	provided := headerValue(request.Headers, APIKeyHeader)
+	return subtle.ConstantTimeCompare([]byte(provided), h.internalKey) == 1
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// Límites de la importación/exportación masiva
+const (
+	MaxBulkRows          = 1000
+	bulkWriteConcurrency = 10 // Transacciones de importación en paralelo
+	maxBatchWriteRetries = 5
+	exportPageSize       = 100
+	MaxInlineExportSize  = 5 << 20          // Respuesta de Lambda: 6 MB con cabeceras
+	ExportURLExpiry      = 15 * time.Minute // Validez de la URL firmada del export
+	tagSeparator         = "|"
+)
+
+// Columnas del CSV exportado; la importación usa name, description, price,
+// currency, category, stock, image_url, sku y tags (separados por "|")
+var csvExportColumns = []string{"id", "sku", "name", "description", "price", "currency", "category", "stock", "status", "image_url", "tags", "version", "created_at", "updated_at", "deleted_at"}
+
+// BulkRowResult -> Resultado de una fila de la importación
+type BulkRowResult struct {
+	Row    int               `json:"row"` // 1 = primera fila de datos
+	Status string            `json:"status"`
+	ID     string            `json:"id,omitempty"`
+	SKU    string            `json:"sku,omitempty"`
+	Errors []ValidationError `json:"errors,omitempty"`
+}
+
+// BulkImportReport -> Informe por fila de POST /products/bulk
+type BulkImportReport struct {
+	Total   int             `json:"total"`
+	Created int             `json:"created"`
+	Failed  int             `json:"failed"`
+	Rows    []BulkRowResult `json:"rows"`
+}
+
+const (
+	bulkRowCreated = "created"
+	bulkRowFailed  = "failed"
+)
+
+// bulkRow -> Fila leída con su petición o su error de parseo
+type bulkRow struct {
+	request CreateProductRequest
+	errs    ValidationErrors
+}
+
+// bulkFormat decide el formato por Content-Type (o ?format=)
+func bulkFormat(contentType, format string) string {
+	switch {
+	case format != "":
+		return strings.ToLower(format)
+	case strings.Contains(contentType, "csv"):
+		return "csv"
+	case strings.Contains(contentType, "ndjson"), strings.Contains(contentType, "jsonl"):
+		return "ndjson"
+	}
+	return ""
+}
+
+// parseCSVRows lee un CSV con cabecera; las columnas pueden venir en cualquier orden
+func parseCSVRows(body string) ([]bulkRow, error) {
+	reader := csv.NewReader(strings.NewReader(body))
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"name", "price", "category", "sku"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing CSV column %q", required)
+		}
+	}
+
+	var rows []bulkRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading CSV: %w", err)
+		}
+		value := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		var row bulkRow
+		row.request = CreateProductRequest{
+			Name:        value("name"),
+			Description: value("description"),
+			Category:    value("category"),
+			ImageURL:    value("image_url"),
+			SKU:         value("sku"),
+			Price:       json.Number(value("price")), // Validate comprueba el decimal
+			Currency:    value("currency"),
+		}
+		if raw := value("stock"); raw != "" {
+			if row.request.Stock, err = strconv.Atoi(raw); err != nil {
+				row.errs = append(row.errs, ValidationError{Field: "stock", Message: "must be an integer"})
+			}
+		}
+		if raw := value("tags"); raw != "" {
+			row.request.Tags = strings.Split(raw, tagSeparator)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseNDJSONRows lee un objeto CreateProductRequest por línea
+func parseNDJSONRows(body string) ([]bulkRow, error) {
+	var rows []bulkRow
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var row bulkRow
+		if err := json.Unmarshal([]byte(line), &row.request); err != nil {
+			row.errs = ValidationErrors{{Field: "row", Message: "invalid JSON"}}
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// bulkImportProducts crea productos desde CSV o NDJSON con un informe por fila.
+// Cada fila válida se escribe en una transacción junto a la reserva de su SKU.
+func (h *ProductHandler) bulkImportProducts(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	var rows []bulkRow
+	var err error
+	switch bulkFormat(headerValue(request.Headers, "Content-Type"), request.QueryStringParameters["format"]) {
+	case "csv":
+		rows, err = parseCSVRows(request.Body)
+	case "ndjson":
+		rows, err = parseNDJSONRows(request.Body)
+	default:
+		return h.errorResponse(headers, 415, "Content-Type must be text/csv or application/x-ndjson"), nil
+	}
+	if err != nil {
+		return h.errorResponse(headers, 400, err.Error()), nil
+	}
+	if len(rows) == 0 {
+		return h.errorResponse(headers, 400, "No rows to import"), nil
+	}
+	if len(rows) > MaxBulkRows {
+		return h.errorResponse(headers, 413, fmt.Sprintf("Too many rows: maximum is %d", MaxBulkRows)), nil
+	}
+
+	report := BulkImportReport{Total: len(rows), Rows: make([]BulkRowResult, len(rows))}
+	products := make(map[int]Product, len(rows))
+	skuRows := map[string]int{}
+	now := time.Now()
+
+	for i := range rows {
+		row := &rows[i]
+		result := &report.Rows[i]
+		result.Row = i + 1
+		result.SKU = strings.TrimSpace(row.request.SKU)
+
+		if len(row.errs) == 0 {
+			row.errs = row.request.Validate()
+		}
+		// SKUs repetidos dentro del mismo fichero
+		if len(row.errs) == 0 {
+			guard := skuGuardID(row.request.SKU)
+			if first, ok := skuRows[guard]; ok {
+				row.errs = ValidationErrors{{Field: "sku", Message: fmt.Sprintf("duplicates row %d", first+1)}}
+			} else {
+				skuRows[guard] = i
+			}
+		}
+		if len(row.errs) > 0 {
+			result.Status, result.Errors = bulkRowFailed, row.errs
+			continue
+		}
+		products[i] = row.request.NewProduct(uuid.New().String(), now)
+	}
+
+	// Los SKUs que ya existen en la tabla fallan en la condición de su transacción
+	failed := h.importProductRows(ctx, products)
+
+	for i, product := range products {
+		result := &report.Rows[i]
+		if errs, ok := failed[i]; ok {
+			result.Status, result.Errors = bulkRowFailed, errs
+			continue
+		}
+		result.Status, result.ID = bulkRowCreated, product.ID
+		h.search.Upsert(product)
+	}
+	for _, result := range report.Rows {
+		if result.Status == bulkRowCreated {
+			report.Created++
+		} else {
+			report.Failed++
+		}
+	}
+
+	response := ProductResponse{
+		Success: report.Failed == 0,
+		Message: fmt.Sprintf("Imported %d of %d products", report.Created, report.Total),
+		Data:    report,
+	}
+	body, _ := json.Marshal(response)
+	statusCode := 200
+	if report.Failed > 0 {
+		statusCode = 207 // Multi-Status: el informe dice qué filas fallaron
+	}
+	return events.APIGatewayProxyResponse{StatusCode: statusCode, Headers: headers, Body: string(body)}, nil
+}
+
+// importProductRows escribe cada producto con la reserva de su SKU en una
+// transacción propia, condicionada a que ninguno de los dos exista: una fila
+// queda entera o no se escribe. Devuelve los errores de las filas que fallaron.
+func (h *ProductHandler) importProductRows(ctx context.Context, products map[int]Product) map[int]ValidationErrors {
+	rows := make(chan int)
+	var mu sync.Mutex
+	failed := map[int]ValidationErrors{}
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < bulkWriteConcurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range rows {
+				if errs := h.importProduct(ctx, products[i]); errs != nil {
+					mu.Lock()
+					failed[i] = errs
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range products {
+		rows <- i
+	}
+	close(rows)
+	wg.Wait()
+	return failed
+}
+
+// importProduct crea un producto y su reserva de SKU, reintentando los fallos
+// transitorios (conflictos de transacción, throttling)
+func (h *ProductHandler) importProduct(ctx context.Context, product Product) ValidationErrors {
+	item, err := attributevalue.MarshalMap(product)
+	if err != nil {
+		return ValidationErrors{{Field: "row", Message: fmt.Sprintf("marshaling product: %v", err)}}
+	}
+	writes := []types.TransactWriteItem{
+		{Put: &types.Put{
+			TableName:           aws.String(h.tableName),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(id)"),
+		}},
+		{Put: &types.Put{
+			TableName:           aws.String(h.tableName),
+			Item:                skuGuardItem(product.SKU, product.ID),
+			ConditionExpression: aws.String("attribute_not_exists(id)"),
+		}},
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if err := waitBackoff(ctx, attempt); err != nil {
+				return ValidationErrors{{Field: "row", Message: fmt.Sprintf("not written: %v", err)}}
+			}
+		}
+		_, err = h.dynamoClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: writes})
+		if err == nil {
+			return nil
+		}
+		codes := cancellationReasons(err)
+		switch {
+		case conditionFailedAt(codes, 1):
+			return ValidationErrors{{Field: "sku", Message: "already exists"}}
+		case conditionFailedAt(codes, 0):
+			return ValidationErrors{{Field: "row", Message: "product ID collision"}}
+		case attempt >= maxBatchWriteRetries || ctx.Err() != nil:
+			return ValidationErrors{{Field: "row", Message: fmt.Sprintf("not written: %v", err)}}
+		}
+	}
+}
+
+// batchBackoff -> Espera exponencial entre reintentos de lotes (50ms, 100ms, ...)
+func batchBackoff(attempt int) time.Duration {
+	return time.Duration(50<<uint(attempt-1)) * time.Millisecond
+}
+
+// waitBackoff espera batchBackoff(attempt) salvo que el contexto termine antes
+// (una Lambda cerca de su deadline no debe dormir más allá de él)
+func waitBackoff(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(batchBackoff(attempt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// exportProducts escribe el catálogo página a página con Scans paginados
+func (h *ProductHandler) exportProducts(ctx context.Context, w io.Writer, format string, includeDeleted bool) (int, error) {
+	plan := planProductQuery(&ProductFilter{IncludeDeleted: includeDeleted})
+
+	var csvWriter *csv.Writer
+	encoder := json.NewEncoder(w)
+	if format == "csv" {
+		csvWriter = csv.NewWriter(w)
+		if err := csvWriter.Write(csvExportColumns); err != nil {
+			return 0, err
+		}
+	}
+
+	count := 0
+	var from pagePosition
+	for {
+		products, next, err := h.readProductsPage(ctx, plan, from, exportPageSize, nil)
+		if err != nil {
+			return count, err
+		}
+		for _, p := range products {
+			if csvWriter != nil {
+				err = csvWriter.Write(productCSVRecord(&p))
+			} else {
+				err = encoder.Encode(p)
+			}
+			if err != nil {
+				return count, err
+			}
+			count++
+		}
+		if csvWriter != nil {
+			csvWriter.Flush()
+			if err := csvWriter.Error(); err != nil {
+				return count, err
+			}
+		}
+		if next == nil {
+			return count, nil
+		}
+		from = *next
+	}
+}
+
+// productCSVRecord -> Fila CSV en el orden de csvExportColumns
+func productCSVRecord(p *Product) []string {
+	deletedAt := ""
+	if p.DeletedAt != nil {
+		deletedAt = p.DeletedAt.Format(time.RFC3339)
+	}
+	return []string{
+		p.ID,
+		p.SKU,
+		p.Name,
+		p.Description,
+		formatMinorUnits(p.PriceMinor, p.Currency),
+		p.Currency,
+		p.Category,
+		strconv.Itoa(p.Stock),
+		p.Status,
+		p.ImageURL,
+		strings.Join(p.Tags, tagSeparator),
+		strconv.FormatInt(p.Version, 10),
+		p.CreatedAt.Format(time.RFC3339),
+		p.UpdatedAt.Format(time.RFC3339),
+		deletedAt,
+	}
+}
+
+// ExportResult -> Respuesta de GET /products/export cuando el fichero va a S3
+type ExportResult struct {
+	Format    string    `json:"format"`
+	Count     int       `json:"count"`
+	Location  string    `json:"location"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// errExportTooLarge -> El export no cabe en una respuesta de Lambda
+var errExportTooLarge = errors.New("export too large for an inline response")
+
+// cappedWriter -> Buffer que falla al pasar de limit bytes
+type cappedWriter struct {
+	bytes.Buffer
+	limit int
+}
+
+func (w *cappedWriter) Write(p []byte) (int, error) {
+	if w.Len()+len(p) > w.limit {
+		return 0, errExportTooLarge
+	}
+	return w.Buffer.Write(p)
+}
+
+// exportCatalog responde GET /products/export?format=csv|ndjson. Con bucket de
+// exports el fichero se escribe página a página en /tmp, se sube a S3 y se
+// devuelve una URL firmada; sin él (modo local) va en el cuerpo, hasta 5 MB.
+func (h *ProductHandler) exportCatalog(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	format := strings.ToLower(request.QueryStringParameters["format"])
+	if format == "" {
+		format = "ndjson"
+	}
+	if format != "csv" && format != "ndjson" {
+		return h.validationErrorResponse(headers, ValidationErrors{{Field: "format", Message: "must be csv or ndjson"}}), nil
+	}
+	includeDeleted, _ := strconv.ParseBool(request.QueryStringParameters["include_deleted"])
+	contentType := "application/x-ndjson"
+	if format == "csv" {
+		contentType = "text/csv; charset=utf-8"
+	}
+
+	if h.exports != nil {
+		result, err := h.exportToS3(ctx, format, contentType, includeDeleted)
+		if err != nil {
+			return h.errorResponse(headers, 500, fmt.Sprintf("Error exporting products: %v", err)), nil
+		}
+		return h.successResponse(headers, ProductResponse{
+			Success: true,
+			Message: fmt.Sprintf("Exported %d products", result.Count),
+			Data:    result,
+		}), nil
+	}
+
+	body := cappedWriter{limit: MaxInlineExportSize}
+	if _, err := h.exportProducts(ctx, &body, format, includeDeleted); err != nil {
+		if errors.Is(err, errExportTooLarge) {
+			return h.errorResponse(headers, 413, "Catalog export is too large for an inline response: set EXPORT_BUCKET"), nil
+		}
+		return h.errorResponse(headers, 500, fmt.Sprintf("Error exporting products: %v", err)), nil
+	}
+
+	exportHeaders := make(map[string]string, len(headers)+1)
+	for name, value := range headers {
+		exportHeaders[name] = value
+	}
+	exportHeaders["Content-Type"] = contentType
+	exportHeaders["Content-Disposition"] = fmt.Sprintf(`attachment; filename="products.%s"`, format)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    exportHeaders,
+		Body:       body.String(),
+	}, nil
+}
+
+// exportToS3 escribe el export en un fichero temporal y lo sube al bucket
+func (h *ProductHandler) exportToS3(ctx context.Context, format, contentType string, includeDeleted bool) (*ExportResult, error) {
+	file, err := os.CreateTemp("", "products-export-*."+format)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	count, err := h.exportProducts(ctx, file, format, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	key := fmt.Sprintf("%sproducts-%s-%s.%s", ExportPrefix, now.Format("20060102T150405Z"), uuid.New().String()[:8], format)
+	if err := h.exports.Put(ctx, key, contentType, file); err != nil {
+		return nil, fmt.Errorf("uploading export: %w", err)
+	}
+	url, err := h.exports.PresignGet(ctx, key, ExportURLExpiry)
+	if err != nil {
+		return nil, fmt.Errorf("signing export URL: %w", err)
+	}
+	return &ExportResult{
+		Format:    format,
+		Count:     count,
+		Location:  h.exports.Location(key),
+		URL:       url,
+		ExpiresAt: now.Add(ExportURLExpiry),
+	}, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrInvalidCursor -> El cursor no se puede decodificar, fue alterado o es de otra búsqueda
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursorValue -> Un atributo de la clave con su tipo DynamoDB
+type cursorValue struct {
+	Type  string `json:"t"`
+	Value string `json:"v"`
+}
+
+// pageCursor -> Contenido del token next_cursor antes de firmarlo
+type pageCursor struct {
+	Key     map[string]cursorValue `json:"k,omitempty"`
+	Segment int                    `json:"s,omitempty"`
+	After   *sortPosition          `json:"a,omitempty"`
+	Filter  string                 `json:"f"` // Huella de los filtros con que se emitió
+}
+
+// cursorSigningKey lee CURSOR_SECRET; todas las instancias deben compartirlo.
+// Fuera de LOCAL_MODE main no arranca sin él; en local basta una clave
+// derivada de la tabla.
+func cursorSigningKey() []byte {
+	if secret := os.Getenv("CURSOR_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	return []byte("products-cursor:" + TableName)
+}
+
+// encodeCursor convierte una posición de lectura en un token opaco y firmado
+func encodeCursor(position pagePosition, filter string, secret []byte) (string, error) {
+	cursor := pageCursor{
+		Key:     make(map[string]cursorValue, len(position.Key)),
+		Segment: position.Segment,
+		After:   position.After,
+		Filter:  filter,
+	}
+	for name, av := range position.Key {
+		switch v := av.(type) {
+		case *types.AttributeValueMemberS:
+			cursor.Key[name] = cursorValue{Type: "S", Value: v.Value}
+		case *types.AttributeValueMemberN:
+			cursor.Key[name] = cursorValue{Type: "N", Value: v.Value}
+		default:
+			return "", fmt.Errorf("unsupported key attribute type for %s", name)
+		}
+	}
+
+	payload, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + signCursor(encoded, secret), nil
+}
+
+// decodeCursor verifica la firma y que el cursor pertenezca a los mismos filtros
+func decodeCursor(token, filter string, secret []byte) (pagePosition, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(signCursor(encoded, secret))) {
+		return pagePosition{}, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return pagePosition{}, ErrInvalidCursor
+	}
+
+	var cursor pageCursor
+	if err := json.Unmarshal(payload, &cursor); err != nil {
+		return pagePosition{}, ErrInvalidCursor
+	}
+	if cursor.Filter != filter || cursor.Segment < 0 || cursor.Segment >= ParallelScanSegments {
+		return pagePosition{}, ErrInvalidCursor
+	}
+
+	key := make(map[string]types.AttributeValue, len(cursor.Key))
+	for name, v := range cursor.Key {
+		switch v.Type {
+		case "S":
+			key[name] = &types.AttributeValueMemberS{Value: v.Value}
+		case "N":
+			key[name] = &types.AttributeValueMemberN{Value: v.Value}
+		default:
+			return pagePosition{}, ErrInvalidCursor
+		}
+	}
+	if len(key) == 0 {
+		key = nil
+	}
+	return pagePosition{Segment: cursor.Segment, Key: key, After: cursor.After}, nil
+}
+
+func signCursor(encoded string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// cursorScope resume los filtros que deben coincidir para reutilizar un cursor
+func (f *ProductFilter) cursorScope() string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		f.Category,
+		f.Status,
+		strings.ToLower(f.Search),
+		strconv.FormatFloat(f.MinPrice, 'f', -1, 64),
+		strconv.FormatFloat(f.MaxPrice, 'f', -1, 64),
+		f.Currency,
+		strconv.FormatBool(f.InStock),
+		strconv.FormatBool(f.IncludeDeleted),
+		f.SortBy,
+		f.SortOrder,
+	}, "\x00")))
+	return base64.RawURLEncoding.EncodeToString(sum[:9])
+}
+
+// keyOf extrae de un item los atributos de clave indicados
+func keyOf(item map[string]types.AttributeValue, attributes []string) map[string]types.AttributeValue {
+	key := make(map[string]types.AttributeValue, len(attributes))
+	for _, name := range attributes {
+		if av, ok := item[name]; ok {
+			key[name] = av
+		}
+	}
+	return key
+}
@@ -21,6 +21,15 @@ HANDLER="main"
 ROLE_NAME="lambda-products-api-role"
 TABLE_NAME="products"
 ZIP_FILE="products-api.zip"
+# Clave para firmar los cursores de paginación (compartida por todas las instancias)
+CURSOR_SECRET="${CURSOR_SECRET:-$(openssl rand -hex 32)}"
+# Bus de EventBridge para los eventos de producto (product.created, ...)
+EVENT_BUS_NAME="${EVENT_BUS_NAME:-default}"
+# Clave de las rutas /reservations; el order-processor la envía como PRODUCTS_API_KEY.
+# Si no se da, se reutiliza la de la función desplegada o se genera una nueva
+INTERNAL_API_KEY="${INTERNAL_API_KEY:-}"
+# Bucket de datos de la API: exports del catálogo (exports/) y archivos del purge (archive/)
+DATA_BUCKET="${DATA_BUCKET:-}"
 
 echo -e "${BLUE}🚀 Starting AWS Lambda deployment...${NC}"
 
@@ -45,6 +54,9 @@ fi
 
 print_status "AWS CLI configured correctly"
 
+ACCOUNT_ID=$(aws sts get-caller-identity --query 'Account' --output text)
+DATA_BUCKET="${DATA_BUCKET:-ecommerce-products-data-$ACCOUNT_ID}"
+
 # Get current directory
 SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
 cd "$SCRIPT_DIR"
@@ -63,13 +75,19 @@ if ! aws dynamodb describe-table --table-name "$TABLE_NAME" --region "$REGION" &
             AttributeName=id,AttributeType=S \
             AttributeName=category,AttributeType=S \
             AttributeName=status,AttributeType=S \
+            AttributeName=created_at,AttributeType=S \
+            AttributeName=history_product_id,AttributeType=S \
+            AttributeName=version,AttributeType=N \
         --key-schema \
             AttributeName=id,KeyType=HASH \
         --global-secondary-indexes \
-            IndexName=category-index,KeySchema=[{AttributeName=category,KeyType=HASH}],Projection={ProjectionType=ALL},ProvisionedThroughput={ReadCapacityUnits=5,WriteCapacityUnits=5} \
-            IndexName=status-index,KeySchema=[{AttributeName=status,KeyType=HASH}],Projection={ProjectionType=ALL},ProvisionedThroughput={ReadCapacityUnits=5,WriteCapacityUnits=5} \
+            IndexName=category-index,KeySchema=[{AttributeName=category,KeyType=HASH},{AttributeName=created_at,KeyType=RANGE}],Projection={ProjectionType=ALL},ProvisionedThroughput={ReadCapacityUnits=5,WriteCapacityUnits=5} \
+            IndexName=status-index,KeySchema=[{AttributeName=status,KeyType=HASH},{AttributeName=created_at,KeyType=RANGE}],Projection={ProjectionType=ALL},ProvisionedThroughput={ReadCapacityUnits=5,WriteCapacityUnits=5} \
+            IndexName=price-history-index,KeySchema=[{AttributeName=history_product_id,KeyType=HASH},{AttributeName=version,KeyType=RANGE}],Projection={ProjectionType=ALL},ProvisionedThroughput={ReadCapacityUnits=5,WriteCapacityUnits=5} \
         --provisioned-throughput \
             ReadCapacityUnits=5,WriteCapacityUnits=5 \
+        --stream-specification \
+            StreamEnabled=true,StreamViewType=NEW_AND_OLD_IMAGES \
         --region "$REGION"
     
     print_status "DynamoDB table created. Waiting for it to be active..."
@@ -77,10 +95,65 @@ if ! aws dynamodb describe-table --table-name "$TABLE_NAME" --region "$REGION" &
     # Wait for table to be active
     aws dynamodb wait table-exists --table-name "$TABLE_NAME" --region "$REGION"
     print_status "DynamoDB table is active"
+
+    # TTL para los registros de idempotencia y las reservas de stock
+    aws dynamodb update-time-to-live \
+        --table-name "$TABLE_NAME" \
+        --time-to-live-specification "Enabled=true,AttributeName=expires_at" \
+        --region "$REGION"
 else
     print_status "DynamoDB table already exists"
+
+    # El stream alimenta las estadísticas incrementales
+    if [ "$(aws dynamodb describe-table --table-name "$TABLE_NAME" --region "$REGION" --query 'Table.StreamSpecification.StreamEnabled' --output text)" != "True" ]; then
+        print_warning "Enabling DynamoDB stream on $TABLE_NAME"
+        aws dynamodb update-table \
+            --table-name "$TABLE_NAME" \
+            --stream-specification StreamEnabled=true,StreamViewType=NEW_AND_OLD_IMAGES \
+            --region "$REGION" > /dev/null
+    fi
+
+    # Historial de precios: una entrada por item, leído por producto con este GSI
+    if ! aws dynamodb describe-table --table-name "$TABLE_NAME" --region "$REGION" --query 'Table.GlobalSecondaryIndexes[].IndexName' --output text | grep -qw "price-history-index"; then
+        print_warning "Adding price-history-index to $TABLE_NAME"
+        aws dynamodb wait table-exists --table-name "$TABLE_NAME" --region "$REGION"
+        aws dynamodb update-table \
+            --table-name "$TABLE_NAME" \
+            --attribute-definitions \
+                AttributeName=history_product_id,AttributeType=S \
+                AttributeName=version,AttributeType=N \
+            --global-secondary-index-updates \
+                '[{"Create":{"IndexName":"price-history-index","KeySchema":[{"AttributeName":"history_product_id","KeyType":"HASH"},{"AttributeName":"version","KeyType":"RANGE"}],"Projection":{"ProjectionType":"ALL"},"ProvisionedThroughput":{"ReadCapacityUnits":5,"WriteCapacityUnits":5}}}]' \
+            --region "$REGION" > /dev/null
+    fi
+fi
+
+# Step 1b: Bucket de datos (exports del catálogo)
+echo -e "${BLUE}🪣 Setting up S3 bucket...${NC}"
+
+if ! aws s3api head-bucket --bucket "$DATA_BUCKET" --region "$REGION" &> /dev/null; then
+    print_warning "Creating S3 bucket: $DATA_BUCKET"
+    if [ "$REGION" == "us-east-1" ]; then
+        aws s3api create-bucket --bucket "$DATA_BUCKET" --region "$REGION" > /dev/null
+    else
+        aws s3api create-bucket --bucket "$DATA_BUCKET" --region "$REGION" \
+            --create-bucket-configuration LocationConstraint="$REGION" > /dev/null
+    fi
+    aws s3api put-public-access-block \
+        --bucket "$DATA_BUCKET" \
+        --public-access-block-configuration BlockPublicAcls=true,IgnorePublicAcls=true,BlockPublicPolicy=true,RestrictPublicBuckets=true \
+        --region "$REGION"
+    print_status "S3 bucket created"
+else
+    print_status "S3 bucket already exists"
 fi
 
+# Los exports solo se descargan con la URL firmada (15 min): se borran al día
+aws s3api put-bucket-lifecycle-configuration \
+    --bucket "$DATA_BUCKET" \
+    --lifecycle-configuration '{"Rules":[{"ID":"expire-exports","Filter":{"Prefix":"exports/"},"Status":"Enabled","Expiration":{"Days":1}}]}' \
+    --region "$REGION"
+
 # Step 2: Create IAM role for Lambda if it doesn't exist
 echo -e "${BLUE}🔐 Setting up IAM role...${NC}"
 
@@ -127,12 +200,29 @@ EOF
                 "dynamodb:UpdateItem",
                 "dynamodb:DeleteItem",
                 "dynamodb:Scan",
-                "dynamodb:Query"
+                "dynamodb:Query",
+                "dynamodb:BatchGetItem",
+                "dynamodb:BatchWriteItem"
             ],
             "Resource": [
                 "arn:aws:dynamodb:$REGION:*:table/$TABLE_NAME",
                 "arn:aws:dynamodb:$REGION:*:table/$TABLE_NAME/index/*"
             ]
+        },
+        {
+            "Effect": "Allow",
+            "Action": [
+                "dynamodb:DescribeStream",
+                "dynamodb:GetRecords",
+                "dynamodb:GetShardIterator",
+                "dynamodb:ListStreams"
+            ],
+            "Resource": "arn:aws:dynamodb:$REGION:*:table/$TABLE_NAME/stream/*"
+        },
+        {
+            "Effect": "Allow",
+            "Action": "events:PutEvents",
+            "Resource": "arn:aws:events:$REGION:*:event-bus/$EVENT_BUS_NAME"
         }
     ]
 }
@@ -154,6 +244,27 @@ else
     print_status "IAM role already exists"
 fi
 
+# Acceso al bucket de datos (también en roles ya existentes)
+cat > s3-policy.json << EOF
+{
+    "Version": "2012-10-17",
+    "Statement": [
+        {
+            "Effect": "Allow",
+            "Action": ["s3:PutObject", "s3:GetObject"],
+            "Resource": "arn:aws:s3:::$DATA_BUCKET/*"
+        }
+    ]
+}
+EOF
+
+aws iam put-role-policy \
+    --role-name "$ROLE_NAME" \
+    --policy-name "S3DataAccess" \
+    --policy-document file://s3-policy.json
+
+rm -f s3-policy.json
+
 # Get role ARN
 ROLE_ARN=$(aws iam get-role --role-name "$ROLE_NAME" --query 'Role.Arn' --output text)
 print_status "Role ARN: $ROLE_ARN"
@@ -190,6 +301,17 @@ print_status "Deployment package created: $ZIP_FILE ($ZIP_SIZE)"
 # Step 5: Deploy or update Lambda function
 echo -e "${BLUE}🚀 Deploying Lambda function...${NC}"
 
+if [ -z "$INTERNAL_API_KEY" ]; then
+    INTERNAL_API_KEY=$(aws lambda get-function-configuration \
+        --function-name "$FUNCTION_NAME" \
+        --region "$REGION" \
+        --query 'Environment.Variables.INTERNAL_API_KEY' --output text 2> /dev/null || true)
+    if [ -z "$INTERNAL_API_KEY" ] || [ "$INTERNAL_API_KEY" == "None" ]; then
+        INTERNAL_API_KEY=$(openssl rand -hex 32)
+        print_warning "Generated a new INTERNAL_API_KEY; set PRODUCTS_API_KEY in the order-processor to the same value"
+    fi
+fi
+
 if aws lambda get-function --function-name "$FUNCTION_NAME" --region "$REGION" &> /dev/null; then
     print_warning "Updating existing Lambda function..."
     
@@ -206,7 +328,7 @@ if aws lambda get-function --function-name "$FUNCTION_NAME" --region "$REGION" &
         --role "$ROLE_ARN" \
         --timeout 30 \
         --memory-size 128 \
-        --environment Variables="{TABLE_NAME=$TABLE_NAME}" \
+        --environment Variables="{TABLE_NAME=$TABLE_NAME,CURSOR_SECRET=$CURSOR_SECRET,EVENT_BUS_NAME=$EVENT_BUS_NAME,INTERNAL_API_KEY=$INTERNAL_API_KEY,EXPORT_BUCKET=$DATA_BUCKET,ARCHIVE_BUCKET=$DATA_BUCKET}" \
         --region "$REGION" > /dev/null
     
     print_status "Lambda function updated successfully"
@@ -221,12 +343,35 @@ else
         --zip-file "fileb://$ZIP_FILE" \
         --timeout 30 \
         --memory-size 128 \
-        --environment Variables="{TABLE_NAME=$TABLE_NAME}" \
+        --environment Variables="{TABLE_NAME=$TABLE_NAME,CURSOR_SECRET=$CURSOR_SECRET,EVENT_BUS_NAME=$EVENT_BUS_NAME,INTERNAL_API_KEY=$INTERNAL_API_KEY,EXPORT_BUCKET=$DATA_BUCKET,ARCHIVE_BUCKET=$DATA_BUCKET}" \
         --region "$REGION" > /dev/null
     
     print_status "Lambda function created successfully"
 fi
 
+# Step 5b: Connect the table stream to the function (incremental statistics)
+echo -e "${BLUE}📈 Connecting DynamoDB stream...${NC}"
+
+STREAM_ARN=$(aws dynamodb describe-table --table-name "$TABLE_NAME" --region "$REGION" --query 'Table.LatestStreamArn' --output text)
+MAPPING_UUID=$(aws lambda list-event-source-mappings \
+    --function-name "$FUNCTION_NAME" \
+    --event-source-arn "$STREAM_ARN" \
+    --region "$REGION" \
+    --query 'EventSourceMappings[0].UUID' --output text)
+
+if [ -z "$MAPPING_UUID" ] || [ "$MAPPING_UUID" == "None" ]; then
+    aws lambda create-event-source-mapping \
+        --function-name "$FUNCTION_NAME" \
+        --event-source-arn "$STREAM_ARN" \
+        --starting-position LATEST \
+        --batch-size 100 \
+        --maximum-retry-attempts 5 \
+        --region "$REGION" > /dev/null
+    print_status "Stream connected. Run POST /products/stats/rebuild once to seed the statistics"
+else
+    print_status "Stream already connected"
+fi
+
 # Step 6: Create API Gateway (optional)
 echo -e "${BLUE}🌐 Setting up API Gateway...${NC}"
 
@@ -311,6 +456,40 @@ if [ -z "$API_ID" ] || [ "$API_ID" == "None" ]; then
             --region "$REGION" > /dev/null
     done
     
+    # Reservas de stock (workflow de pedidos): /reservations y todo lo que cuelga.
+    # Sin autorizador en el gateway: la Lambda exige X-Api-Key (INTERNAL_API_KEY)
+    RESERVATIONS_RESOURCE_ID=$(aws apigateway create-resource \
+        --rest-api-id "$API_ID" \
+        --parent-id "$ROOT_ID" \
+        --path-part "reservations" \
+        --region "$REGION" \
+        --query 'id' --output text)
+
+    RESERVATIONS_PROXY_ID=$(aws apigateway create-resource \
+        --rest-api-id "$API_ID" \
+        --parent-id "$RESERVATIONS_RESOURCE_ID" \
+        --path-part "{proxy+}" \
+        --region "$REGION" \
+        --query 'id' --output text)
+
+    for RESOURCE_ID in "$RESERVATIONS_RESOURCE_ID" "$RESERVATIONS_PROXY_ID"; do
+        aws apigateway put-method \
+            --rest-api-id "$API_ID" \
+            --resource-id "$RESOURCE_ID" \
+            --http-method ANY \
+            --authorization-type NONE \
+            --region "$REGION" > /dev/null
+
+        aws apigateway put-integration \
+            --rest-api-id "$API_ID" \
+            --resource-id "$RESOURCE_ID" \
+            --http-method ANY \
+            --type AWS_PROXY \
+            --integration-http-method POST \
+            --uri "arn:aws:apigateway:$REGION:lambda:path/2015-03-31/functions/$LAMBDA_ARN/invocations" \
+            --region "$REGION" > /dev/null
+    done
+    
     # Deploy API
     aws apigateway create-deployment \
         --rest-api-id "$API_ID" \
@@ -337,6 +516,9 @@ echo -e "${GREEN}================================${NC}"
 echo -e "🚀 Function Name: $FUNCTION_NAME"
 echo -e "🌍 Region: $REGION"
 echo -e "📊 DynamoDB Table: $TABLE_NAME"
+echo -e "📣 Event Bus: $EVENT_BUS_NAME"
+echo -e "🪣 Data Bucket: $DATA_BUCKET"
+echo -e "🔑 Reservations API key: $INTERNAL_API_KEY (PRODUCTS_API_KEY del order-processor)"
 echo -e "🔐 IAM Role: $ROLE_NAME"
 echo -e "🌐 API Gateway ID: $API_ID"
 echo -e "📡 API Endpoint: https://$API_ID.execute-api.$REGION.amazonaws.com/prod/products"
@@ -365,6 +547,8 @@ rm -f main "$ZIP_FILE"
 echo -e "${GREEN}🎉 Deployment completed successfully!${NC}"
 echo -e "${BLUE}💡 Quick test commands:${NC}"
 echo -e "curl -X GET $API_ENDPOINT"
-echo -e "curl -X POST $API_ENDPOINT -H 'Content-Type: application/json' -d '{\"name\":\"Test Product\",\"price\":19.99,\"category\":\"electronics\",\"sku\":\"TEST001\",\"stock\":10}'"
+echo -e "curl -X POST $API_ENDPOINT -H 'Content-Type: application/json' -H 'Idempotency-Key: $(uuidgen)' -d '{\"name\":\"Test Product\",\"price\":19.99,\"category\":\"electronics\",\"sku\":\"TEST001\",\"stock\":10}'"
+echo -e "# Holds vencidos: el stream los devuelve cuando el TTL los borra; esto los libera ya"
+echo -e "curl -X POST ${API_ENDPOINT%/products}/reservations/expire -H 'X-Api-Key: $INTERNAL_API_KEY'"
 
 print_status "All done! Your Products API is live! 🚀"
\ No newline at end of file
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrInvalidIfMatch -> La cabecera If-Match no contiene ETags de producto válidos
+var ErrInvalidIfMatch = errors.New("invalid If-Match header")
+
+// productETag -> ETag fuerte derivado de la versión del producto
+func productETag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// headerValue lee una cabecera sin distinguir mayúsculas
+func headerValue(requestHeaders map[string]string, name string) string {
+	for key, value := range requestHeaders {
+		if strings.EqualFold(key, name) {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// versionPrecondition -> Versiones aceptadas por If-Match; vacío = sin condición
+type versionPrecondition struct {
+	versions []int64
+}
+
+// parseIfMatch acepta "*", un ETag o una lista separada por comas
+func parseIfMatch(header string) (versionPrecondition, error) {
+	if header == "" || header == "*" {
+		return versionPrecondition{}, nil
+	}
+
+	var precondition versionPrecondition
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		version, err := strconv.ParseInt(strings.Trim(tag, `"`), 10, 64)
+		if err != nil || version < 0 {
+			return versionPrecondition{}, ErrInvalidIfMatch
+		}
+		precondition.versions = append(precondition.versions, version)
+	}
+	return precondition, nil
+}
+
+// active indica si hay que comprobar la versión
+func (p versionPrecondition) active() bool {
+	return len(p.versions) > 0
+}
+
+// condition devuelve la ConditionExpression sobre version y añade sus valores.
+// Los productos sin versión (anteriores a este campo) cuentan como versión 0.
+func (p versionPrecondition) condition(values map[string]types.AttributeValue) string {
+	var clauses []string
+	for i, version := range p.versions {
+		placeholder := fmt.Sprintf(":if_match_%d", i)
+		values[placeholder] = &types.AttributeValueMemberN{Value: strconv.FormatInt(version, 10)}
+		clauses = append(clauses, "version = "+placeholder)
+		if version == 0 {
+			clauses = append(clauses, "attribute_not_exists(version)")
+		}
+	}
+	return "(" + strings.Join(clauses, " OR ") + ")"
+}
+
+// matches compara con la versión actual (para If-None-Match en GET)
+func (p versionPrecondition) matches(version int64) bool {
+	for _, v := range p.versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// preconditionFailed responde 412 con la representación actual del producto
+func (h *ProductHandler) preconditionFailed(headers map[string]string, item map[string]types.AttributeValue) events.APIGatewayProxyResponse {
+	var current Product
+	if err := attributevalue.UnmarshalMap(item, &current); err != nil {
+		return h.errorResponse(headers, 500, fmt.Sprintf("Error unmarshaling product: %v", err))
+	}
+
+	headers["ETag"] = productETag(current.Version)
+	body, _ := json.Marshal(ProductResponse{
+		Success: false,
+		Message: "Precondition failed: the product was modified by another request",
+		Data:    current,
+	})
+	return events.APIGatewayProxyResponse{
+		StatusCode: 412,
+		Headers:    headers,
+		Body:       string(body),
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+)
+
+// Eventos de dominio de productos. Se derivan del stream de la tabla, que hace de
+// outbox: DynamoDB solo registra un cambio si la escritura se confirmó, y un lote
+// que no se publica se reintenta entero.
+const (
+	EventProductCreated      = "product.created"
+	EventProductPriceChanged = "product.price_changed"
+	EventProductStockLow     = "product.stock_low"
+	EventProductOutOfStock   = "product.out_of_stock"
+	EventProductDeleted      = "product.deleted"
+
+	EventSource         = "ecommerce.products-api"
+	maxEventBridgeBatch = 10 // Máximo de entradas por PutEvents
+)
+
+// ProductEvent -> Evento publicado cuando cambia un producto
+type ProductEvent struct {
+	ID            string    `json:"id"` // Estable entre reintentos del lote, para deduplicar
+	Type          string    `json:"type"`
+	ProductID     string    `json:"product_id"`
+	SKU           string    `json:"sku"`
+	OccurredAt    time.Time `json:"occurred_at"`
+	Product       Product   `json:"product"`                  // Estado tras el cambio (antes, si se borró)
+	PreviousPrice *Money    `json:"previous_price,omitempty"` // Solo en price_changed
+}
+
+// EventPublisher -> Destino de los eventos de producto
+type EventPublisher interface {
+	Publish(ctx context.Context, events []ProductEvent) error
+}
+
+// productEvents traduce un cambio del stream a eventos de dominio
+func productEvents(change productChange) []ProductEvent {
+	old, current := change.Old, change.New
+	oldLive := old != nil && !old.IsDeleted()
+	currentLive := current != nil && !current.IsDeleted()
+
+	var events []ProductEvent
+	emit := func(eventType string, product *Product) *ProductEvent {
+		events = append(events, ProductEvent{
+			ID:         change.EventID + ":" + eventType,
+			Type:       eventType,
+			ProductID:  product.ID,
+			SKU:        product.SKU,
+			OccurredAt: change.OccurredAt,
+			Product:    *product,
+		})
+		return &events[len(events)-1]
+	}
+
+	if oldLive && !currentLive {
+		emit(EventProductDeleted, old)
+		return events
+	}
+	if !currentLive {
+		return events
+	}
+
+	if old == nil {
+		emit(EventProductCreated, current)
+	}
+	if oldLive && old.BasePrice() != current.BasePrice() {
+		previous := old.BasePrice()
+		emit(EventProductPriceChanged, current).PreviousPrice = &previous
+	}
+	// Los umbrales se notifican al cruzarlos, no en cada escritura por debajo
+	if current.Status == StatusOutOfStock && (!oldLive || old.Status != StatusOutOfStock) {
+		emit(EventProductOutOfStock, current)
+	} else if current.Stock > 0 && current.IsLowStock() && (!oldLive || !old.IsLowStock() || old.Stock <= 0) {
+		emit(EventProductStockLow, current)
+	}
+	return events
+}
+
+// eventBridgePublisher publica en un bus de EventBridge
+type eventBridgePublisher struct {
+	client  *eventbridge.Client
+	busName string
+}
+
+func NewEventBridgePublisher(client *eventbridge.Client, busName string) EventPublisher {
+	return &eventBridgePublisher{client: client, busName: busName}
+}
+
+// Publish envía los eventos en lotes de 10 y reintenta las entradas rechazadas
+func (p *eventBridgePublisher) Publish(ctx context.Context, events []ProductEvent) error {
+	for start := 0; start < len(events); start += maxEventBridgeBatch {
+		end := min(start+maxEventBridgeBatch, len(events))
+
+		entries := make([]ebtypes.PutEventsRequestEntry, 0, end-start)
+		for _, event := range events[start:end] {
+			detail, err := json.Marshal(event)
+			if err != nil {
+				return fmt.Errorf("marshaling %s: %w", event.ID, err)
+			}
+			entries = append(entries, ebtypes.PutEventsRequestEntry{
+				EventBusName: aws.String(p.busName),
+				Source:       aws.String(EventSource),
+				DetailType:   aws.String(event.Type),
+				Detail:       aws.String(string(detail)),
+				Resources:    []string{"product/" + event.ProductID},
+				Time:         aws.Time(event.OccurredAt),
+			})
+		}
+
+		for attempt := 0; len(entries) > 0; attempt++ {
+			if attempt > maxBatchWriteRetries {
+				return fmt.Errorf("%d events rejected by EventBridge after retries", len(entries))
+			}
+			if attempt > 0 {
+				if err := waitBackoff(ctx, attempt); err != nil {
+					return err
+				}
+			}
+			result, err := p.client.PutEvents(ctx, &eventbridge.PutEventsInput{Entries: entries})
+			if err != nil {
+				return fmt.Errorf("putting events: %w", err)
+			}
+			if result.FailedEntryCount == 0 {
+				break
+			}
+			// Los resultados vienen en el mismo orden que las entradas
+			var failed []ebtypes.PutEventsRequestEntry
+			for i, entry := range result.Entries {
+				if entry.ErrorCode != nil && i < len(entries) {
+					failed = append(failed, entries[i])
+				}
+			}
+			entries = failed
+		}
+	}
+	return nil
+}
+
+// MemoryPublisher guarda los eventos en memoria (desarrollo local y pruebas)
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	events []ProductEvent
+}
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (p *MemoryPublisher) Publish(ctx context.Context, events []ProductEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, event := range events {
+		log.Printf("📣 %s %s", event.Type, event.ProductID)
+	}
+	p.events = append(p.events, events...)
+	return nil
+}
+
+// Events devuelve una copia de los eventos publicados
+func (p *MemoryPublisher) Events() []ProductEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]ProductEvent(nil), p.events...)
+}
+
+// Reset descarta los eventos publicados
+func (p *MemoryPublisher) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = nil
+}
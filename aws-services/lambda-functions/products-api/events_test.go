@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProductEvents(t *testing.T) {
+	deletedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	product := func(mutate func(*Product)) *Product {
+		p := &Product{ID: "p1", SKU: "SKU-1", PriceMinor: 1999, Currency: "USD", Stock: 50, Status: StatusActive}
+		if mutate != nil {
+			mutate(p)
+		}
+		return p
+	}
+	withStock := func(stock int) func(*Product) {
+		return func(p *Product) {
+			p.Stock = stock
+			p.Status = deriveStatus(p.Status, stock)
+		}
+	}
+	deleted := func(p *Product) { p.DeletedAt = &deletedAt }
+
+	tests := []struct {
+		name string
+		old  *Product
+		new  *Product
+		want []string
+	}{
+		{"created", nil, product(nil), []string{EventProductCreated}},
+		{"created low stock", nil, product(withStock(3)), []string{EventProductCreated, EventProductStockLow}},
+		{"created out of stock", nil, product(withStock(0)), []string{EventProductCreated, EventProductOutOfStock}},
+		{"created already deleted", nil, product(deleted), nil},
+		{"unchanged", product(nil), product(nil), nil},
+		{"price changed", product(nil), product(func(p *Product) { p.PriceMinor = 2499 }), []string{EventProductPriceChanged}},
+		{"currency changed", product(nil), product(func(p *Product) { p.Currency = "EUR" }), []string{EventProductPriceChanged}},
+		{"stock crosses low threshold", product(nil), product(withStock(5)), []string{EventProductStockLow}},
+		{"stock stays low", product(withStock(5)), product(withStock(4)), nil},
+		{"stock runs out", product(withStock(5)), product(withStock(0)), []string{EventProductOutOfStock}},
+		{"stock stays out", product(withStock(0)), product(withStock(0)), nil},
+		{"restock to low", product(withStock(0)), product(withStock(2)), []string{EventProductStockLow}},
+		{"restock above threshold", product(withStock(0)), product(withStock(40)), nil},
+		{"price and stock change", product(nil), product(func(p *Product) { p.PriceMinor = 999; withStock(0)(p) }), []string{EventProductPriceChanged, EventProductOutOfStock}},
+		{"soft deleted", product(nil), product(deleted), []string{EventProductDeleted}},
+		{"hard deleted", product(nil), nil, []string{EventProductDeleted}},
+		{"deleted item removed", product(deleted), nil, nil},
+		{"restored from trash", product(deleted), product(nil), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			change := productChange{EventID: "evt-1", OccurredAt: deletedAt, Old: tt.old, New: tt.new}
+			events := productEvents(change)
+
+			var got []string
+			for _, event := range events {
+				got = append(got, event.Type)
+				if event.ID != "evt-1:"+event.Type {
+					t.Errorf("event ID %q is not derived from the stream record", event.ID)
+				}
+				if event.ProductID != "p1" || event.SKU != "SKU-1" {
+					t.Errorf("%s: product %s/%s, want p1/SKU-1", event.Type, event.ProductID, event.SKU)
+				}
+				if (event.Type == EventProductPriceChanged) != (event.PreviousPrice != nil) {
+					t.Errorf("%s: previous price %v", event.Type, event.PreviousPrice)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProductEventsPreviousPrice(t *testing.T) {
+	old := &Product{ID: "p1", PriceMinor: 1999, Currency: "USD", Stock: 50, Status: StatusActive}
+	current := *old
+	current.PriceMinor = 2499
+
+	events := productEvents(productChange{EventID: "evt-2", Old: old, New: &current})
+	if len(events) != 1 || events[0].PreviousPrice == nil {
+		t.Fatalf("expected one price_changed event with previous price, got %+v", events)
+	}
+	if *events[0].PreviousPrice != (Money{Amount: 1999, Currency: "USD"}) {
+		t.Fatalf("previous price = %+v", *events[0].PreviousPrice)
+	}
+	if events[0].Product.PriceMinor != 2499 {
+		t.Fatalf("event carries price %d, want the new price", events[0].Product.PriceMinor)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Campos por los que se puede ordenar un listado
+var sortableFields = map[string]bool{
+	"name":       true,
+	"price":      true,
+	"created_at": true,
+}
+
+// parseProductFilter lee los filtros del query string y los valida.
+// Devuelve todos los errores encontrados, no solo el primero.
+func parseProductFilter(params map[string]string) (ProductFilter, ValidationErrors) {
+	var errs ValidationErrors
+	filter := ProductFilter{
+		Category:  params["category"],
+		Status:    params["status"],
+		Search:    params["search"],
+		SortBy:    params["sort_by"],
+		SortOrder: strings.ToLower(params["sort_order"]),
+	}
+	if raw := params["currency"]; raw != "" {
+		filter.Currency = normalizeCurrency(raw)
+		if !validCurrency(filter.Currency) {
+			errs = append(errs, ValidationError{Field: "currency", Message: "unsupported currency"})
+		}
+	}
+
+	parseInt := func(field string, target *int) {
+		if raw := params[field]; raw != "" {
+			value, err := strconv.Atoi(raw)
+			if err != nil {
+				errs = append(errs, ValidationError{Field: field, Message: "must be an integer"})
+				return
+			}
+			*target = value
+		}
+	}
+	// parsePrice devuelve true si el parámetro venía y es un número válido
+	parsePrice := func(field string, target *float64) bool {
+		raw := params[field]
+		if raw == "" {
+			return false
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			errs = append(errs, ValidationError{Field: field, Message: "must be a number"})
+			return false
+		}
+		if value < 0 {
+			errs = append(errs, ValidationError{Field: field, Message: "must be greater than or equal to 0"})
+			return false
+		}
+		*target = value
+		return true
+	}
+
+	parseInt("page", &filter.Page)
+	parseInt("page_size", &filter.PageSize)
+	parsePrice("min_price", &filter.MinPrice)
+	if parsePrice("max_price", &filter.MaxPrice) && filter.MaxPrice == 0 {
+		errs = append(errs, ValidationError{Field: "max_price", Message: "must be greater than 0"})
+	}
+	if raw := params["include_deleted"]; raw != "" {
+		includeDeleted, err := strconv.ParseBool(raw)
+		if err != nil {
+			errs = append(errs, ValidationError{Field: "include_deleted", Message: "must be true or false"})
+		}
+		filter.IncludeDeleted = includeDeleted
+	}
+	if raw := params["in_stock"]; raw != "" {
+		inStock, err := strconv.ParseBool(raw)
+		if err != nil {
+			errs = append(errs, ValidationError{Field: "in_stock", Message: "must be true or false"})
+		}
+		filter.InStock = inStock
+	}
+
+	// Los precios solo se comparan dentro de una moneda: filtrar u ordenar por
+	// precio sin currency se queda con DefaultCurrency
+	if filter.Currency == "" && (filter.MinPrice > 0 || filter.MaxPrice > 0 || filter.SortBy == "price") {
+		filter.Currency = DefaultCurrency
+	}
+
+	if err := filter.Validate(); err != nil {
+		if fieldErrs, ok := err.(ValidationErrors); ok {
+			errs = append(errs, fieldErrs...)
+		}
+	}
+	return filter, errs
+}
+
+// explicitSort indica si el cliente pidió un orden concreto
+func explicitSort(params map[string]string) bool {
+	return params["sort_by"] != "" || params["sort_order"] != ""
+}
+
+// matches aplica en memoria los filtros que no resuelve DynamoDB (resultados de búsqueda)
+func (f *ProductFilter) matches(p *Product) bool {
+	if f.Category != "" && p.Category != f.Category {
+		return false
+	}
+	if f.Status != "" && p.Status != f.Status {
+		return false
+	}
+	if f.Currency != "" && p.Currency != f.Currency {
+		return false
+	}
+	if lower, ok := f.minorPriceBound(f.MinPrice); ok && p.PriceMinor < lower {
+		return false
+	}
+	if upper, ok := f.minorPriceBound(f.MaxPrice); ok && p.PriceMinor > upper {
+		return false
+	}
+	if f.InStock && p.Stock <= 0 {
+		return false
+	}
+	if !f.IncludeDeleted && p.IsDeleted() {
+		return false
+	}
+	return true
+}
+
+// minorPriceBound pasa un límite min/max_price a unidades menores de la moneda del
+// filtro (parseProductFilter la fija a DefaultCurrency si había límites); ok =
+// false si el límite no se usa
+func (f *ProductFilter) minorPriceBound(bound float64) (int64, bool) {
+	if bound <= 0 {
+		return 0, false
+	}
+	return floatToMinorUnits(bound, normalizeCurrency(f.Currency)), true
+}
+
+// compareProducts ordena por el campo pedido y desempata por ID para que el
+// orden sea total y estable entre páginas
+func compareProducts(a, b *Product, sortBy string) int {
+	c := 0
+	switch sortBy {
+	case "name":
+		c = strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		if c == 0 {
+			c = strings.Compare(a.Name, b.Name)
+		}
+	case "price":
+		c = compareFloat(a.Price(), b.Price())
+	case "relevance":
+		c = compareFloat(a.Score, b.Score)
+	default:
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	}
+	if c == 0 {
+		c = strings.Compare(a.ID, b.ID)
+	}
+	return c
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// sortProducts ordena en memoria según sort_by/sort_order
+func sortProducts(products []Product, sortBy, sortOrder string) {
+	sort.SliceStable(products, func(i, j int) bool {
+		c := compareProducts(&products[i], &products[j], sortBy)
+		if sortOrder == "desc" {
+			return c > 0
+		}
+		return c < 0
+	})
+}
+
+// sortPosition -> Último producto entregado en un listado ordenado en memoria
+type sortPosition struct {
+	ID    string `json:"i"`
+	Value string `json:"v"`
+}
+
+// sortPositionOf guarda el valor del campo de orden de un producto
+func sortPositionOf(p *Product, sortBy string) *sortPosition {
+	position := &sortPosition{ID: p.ID}
+	switch sortBy {
+	case "name":
+		position.Value = p.Name
+	case "price":
+		position.Value = strconv.FormatInt(p.PriceMinor, 10) + " " + p.Currency
+	case "relevance":
+		position.Value = strconv.FormatFloat(p.Score, 'f', -1, 64)
+	default:
+		position.Value = p.CreatedAt.Format(time.RFC3339Nano)
+	}
+	return position
+}
+
+// product reconstruye lo necesario de un producto para compararlo
+func (s *sortPosition) product(sortBy string) (Product, error) {
+	p := Product{ID: s.ID}
+	var err error
+	switch sortBy {
+	case "name":
+		p.Name = s.Value
+	case "price":
+		amount, currency, _ := strings.Cut(s.Value, " ")
+		p.Currency = currency
+		p.PriceMinor, err = strconv.ParseInt(amount, 10, 64)
+	case "relevance":
+		p.Score, err = strconv.ParseFloat(s.Value, 64)
+	default:
+		p.CreatedAt, err = time.Parse(time.RFC3339Nano, s.Value)
+	}
+	return p, err
+}
+
+// pageAfter devuelve hasta limit productos posteriores a after en una lista ya
+// ordenada. Si quedan más, devuelve también la posición para continuar.
+func pageAfter(products []Product, after *sortPosition, limit int, sortBy, sortOrder string) ([]Product, *sortPosition, error) {
+	start := 0
+	if after != nil {
+		last, err := after.product(sortBy)
+		if err != nil {
+			return nil, nil, err
+		}
+		start = sort.Search(len(products), func(i int) bool {
+			c := compareProducts(&products[i], &last, sortBy)
+			if sortOrder == "desc" {
+				return c < 0
+			}
+			return c > 0
+		})
+	}
+
+	end := start + limit
+	if end >= len(products) {
+		return products[start:], nil, nil
+	}
+	return products[start:end], sortPositionOf(&products[end-1], sortBy), nil
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"strconv"
 	"strings"
 	"time"
@@ -19,145 +21,109 @@ import (
 type ProductHandler struct {
 	dynamoClient *dynamodb.Client
 	tableName    string
+	cursorKey    []byte
+	search       *SearchIndex
+	archive      ArchiveStore
+	events       EventPublisher
+	internalKey  []byte   // Clave de las rutas internas; nil = abiertas (solo local)
+	exports      *S3Store // Bucket de GET /products/export; nil = respuesta directa
 }
 
+// productKeyAttributes -> Clave primaria de la tabla
+var productKeyAttributes = []string{"id"}
+
 func NewProductHandler(dynamoClient *dynamodb.Client) *ProductHandler {
 	return &ProductHandler{
 		dynamoClient: dynamoClient,
 		tableName:    TableName,
-	}
-}
-
-// HandleRequest maneja todas las rutas de la API
-func (h *ProductHandler) HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	// CORS headers
-	headers := map[string]string{
-		"Content-Type":                 "application/json",
-		"Access-Control-Allow-Origin":  "*",
-		"Access-Control-Allow-Methods": "GET, POST, PUT, DELETE, OPTIONS",
-		"Access-Control-Allow-Headers": "Content-Type, Authorization",
-	}
-
-	// Handle preflight OPTIONS request
-	if request.HTTPMethod == "OPTIONS" {
-		return events.APIGatewayProxyResponse{
-			StatusCode: 200,
-			Headers:    headers,
-			Body:       "",
-		}, nil
-	}
-
-	// Router básico
-	switch {
-	case request.HTTPMethod == "GET" && request.PathParameters == nil:
-		// GET /products
-		return h.listProducts(ctx, request, headers)
-		
-	case request.HTTPMethod == "GET" && request.PathParameters["id"] != "":
-		// GET /products/{id}
-		return h.getProduct(ctx, request, headers)
-		
-	case request.HTTPMethod == "POST":
-		// POST /products
-		return h.createProduct(ctx, request, headers)
-		
-	case request.HTTPMethod == "PUT" && request.PathParameters["id"] != "":
-		// PUT /products/{id}
-		return h.updateProduct(ctx, request, headers)
-		
-	case request.HTTPMethod == "DELETE" && request.PathParameters["id"] != "":
-		// DELETE /products/{id}
-		return h.deleteProduct(ctx, request, headers)
-		
-	case request.HTTPMethod == "GET" && strings.Contains(request.Path, "/stats"):
-		// GET /products/stats
-		return h.getProductStats(ctx, request, headers)
-		
-	default:
-		return h.errorResponse(headers, 404, "Route not found"), nil
+		cursorKey:    cursorSigningKey(),
+		search:       NewSearchIndex(),
+		archive:      archiveFromEnv(),
+		events:       NewMemoryPublisher(),
+		internalKey:  internalAPIKey(),
 	}
 }
 
 // listProducts lista todos los productos con filtros
 func (h *ProductHandler) listProducts(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
-	// Parsear query parameters
-	filter := ProductFilter{}
-	if category := request.QueryStringParameters["category"]; category != "" {
-		filter.Category = category
-	}
-	if status := request.QueryStringParameters["status"]; status != "" {
-		filter.Status = status
-	}
-	if search := request.QueryStringParameters["search"]; search != "" {
-		filter.Search = search
-	}
-	if page := request.QueryStringParameters["page"]; page != "" {
-		if p, err := strconv.Atoi(page); err == nil {
-			filter.Page = p
-		}
-	}
-	if pageSize := request.QueryStringParameters["page_size"]; pageSize != "" {
-		if ps, err := strconv.Atoi(pageSize); err == nil {
-			filter.PageSize = ps
+	// Parsear y validar query parameters
+	filter, errs := parseProductFilter(request.QueryStringParameters)
+	if len(errs) > 0 {
+		return h.validationErrorResponse(headers, errs), nil
+	}
+	// Con ?cursor (aunque venga vacío) se usa paginación por cursor
+	cursorMode := false
+	if cursor, ok := request.QueryStringParameters["cursor"]; ok {
+		filter.Cursor = cursor
+		cursorMode = true
+	}
+
+	// Query sobre el GSI si se filtra por categoría o estado, Scan paralelo si no
+	plan := planProductQuery(&filter)
+
+	// La búsqueda y los órdenes que DynamoDB no da se resuelven en memoria;
+	// sin orden explícito un Scan conserva el orden de la tabla
+	inMemorySort := filter.Search != "" ||
+		(explicitSort(request.QueryStringParameters) && !plan.sortedBy(filter.SortBy))
+
+	if cursorMode {
+		var from pagePosition
+		if filter.Cursor != "" {
+			position, err := decodeCursor(filter.Cursor, filter.cursorScope(), h.cursorKey)
+			if err != nil || (position.After != nil) != inMemorySort {
+				return h.errorResponse(headers, 400, "Invalid cursor"), nil
+			}
+			from = position
 		}
-	}
-	
-	filter.Validate()
-
-	// Scan DynamoDB (en producción usaríamos Query con índices)
-	input := &dynamodb.ScanInput{
-		TableName: aws.String(h.tableName),
-	}
 
-	// Aplicar filtros
-	var filterExpression strings.Builder
-	expressionAttributeValues := make(map[string]types.AttributeValue)
-	
-	if filter.Category != "" {
-		filterExpression.WriteString("category = :category")
-		expressionAttributeValues[":category"] = &types.AttributeValueMemberS{Value: filter.Category}
-	}
-	
-	if filter.Status != "" {
-		if filterExpression.Len() > 0 {
-			filterExpression.WriteString(" AND ")
+		var products []Product
+		var next *pagePosition
+		var err error
+		if inMemorySort {
+			products, err = h.readSortedProducts(ctx, plan, &filter)
+			if err == nil {
+				var after *sortPosition
+				products, after, err = pageAfter(products, from.After, filter.PageSize, filter.SortBy, filter.SortOrder)
+				if after != nil {
+					next = &pagePosition{After: after}
+				}
+			}
+		} else {
+			products, next, err = h.readProductsPage(ctx, plan, from, filter.PageSize, nil)
+		}
+		if err != nil {
+			return h.errorResponse(headers, 500, fmt.Sprintf("Error reading products: %v", err)), nil
 		}
-		filterExpression.WriteString("#status = :status")
-		expressionAttributeValues[":status"] = &types.AttributeValueMemberS{Value: filter.Status}
-		input.ExpressionAttributeNames = map[string]string{"#status": "status"}
-	}
 
-	if filterExpression.Len() > 0 {
-		input.FilterExpression = aws.String(filterExpression.String())
-		input.ExpressionAttributeValues = expressionAttributeValues
-	}
+		response := ProductsListResponse{
+			Success:  true,
+			Message:  "Products retrieved successfully",
+			Data:     products,
+			Total:    len(products),
+			PageSize: filter.PageSize,
+		}
+		if next != nil {
+			if response.NextCursor, err = encodeCursor(*next, filter.cursorScope(), h.cursorKey); err != nil {
+				return h.errorResponse(headers, 500, fmt.Sprintf("Error encoding cursor: %v", err)), nil
+			}
+		}
 
-	result, err := h.dynamoClient.Scan(ctx, input)
-	if err != nil {
-		return h.errorResponse(headers, 500, fmt.Sprintf("Error scanning products: %v", err)), nil
+		return h.successResponse(headers, response), nil
 	}
 
-	// Deserializar productos
+	// Paginación por offset: lee todas las páginas de DynamoDB, pensada para catálogos pequeños
 	var products []Product
-	err = attributevalue.UnmarshalListOfMaps(result.Items, &products)
-	if err != nil {
-		return h.errorResponse(headers, 500, fmt.Sprintf("Error unmarshaling products: %v", err)), nil
+	var segments []int
+	var err error
+	if inMemorySort {
+		products, err = h.readSortedProducts(ctx, plan, &filter)
+	} else {
+		products, segments, err = h.readAllProducts(ctx, plan, nil)
 	}
-
-	// Filtrar por búsqueda si se especifica
-	if filter.Search != "" {
-		filteredProducts := []Product{}
-		searchLower := strings.ToLower(filter.Search)
-		for _, p := range products {
-			if strings.Contains(strings.ToLower(p.Name), searchLower) || 
-			   strings.Contains(strings.ToLower(p.Description), searchLower) {
-				filteredProducts = append(filteredProducts, p)
-			}
-		}
-		products = filteredProducts
+	if err != nil {
+		return h.errorResponse(headers, 500, fmt.Sprintf("Error reading products: %v", err)), nil
 	}
 
-	// Paginación manual (en producción usaríamos DynamoDB pagination)
 	total := len(products)
 	start := (filter.Page - 1) * filter.PageSize
 	end := start + filter.PageSize
@@ -183,6 +149,75 @@ func (h *ProductHandler) listProducts(ctx context.Context, request events.APIGat
 		TotalPages: totalPages,
 	}
 
+	// Permite continuar con cursores a partir de esta página
+	if end < total && len(products) > 0 {
+		last := products[len(products)-1]
+		position := pagePosition{After: sortPositionOf(&last, filter.SortBy)}
+		if !inMemorySort {
+			position, err = plan.positionAfter(last, segments[end-1])
+		}
+		if err == nil {
+			response.NextCursor, err = encodeCursor(position, filter.cursorScope(), h.cursorKey)
+		}
+		if err != nil {
+			return h.errorResponse(headers, 500, fmt.Sprintf("Error encoding cursor: %v", err)), nil
+		}
+	}
+
+	return h.successResponse(headers, response), nil
+}
+
+// readSortedProducts devuelve todos los productos del listado en el orden pedido:
+// con search salen del índice de búsqueda, si no de DynamoDB
+func (h *ProductHandler) readSortedProducts(ctx context.Context, plan productQuery, filter *ProductFilter) ([]Product, error) {
+	var products []Product
+	if filter.Search != "" {
+		if err := h.ensureSearchIndex(ctx); err != nil {
+			return nil, err
+		}
+		products = h.search.Search(filter.Search, filter.matches)
+	} else {
+		var err error
+		if products, _, err = h.readAllProducts(ctx, plan, nil); err != nil {
+			return nil, err
+		}
+	}
+	sortProducts(products, filter.SortBy, filter.SortOrder)
+	return products, nil
+}
+
+// ensureSearchIndex reconstruye el índice si nunca se construyó o es más viejo que
+// SearchIndexTTL (otras instancias también escriben en la tabla)
+func (h *ProductHandler) ensureSearchIndex(ctx context.Context) error {
+	if time.Since(h.search.BuiltAt()) < SearchIndexTTL {
+		return nil
+	}
+	return h.rebuildSearchIndex(ctx)
+}
+
+// rebuildSearchIndex indexa de nuevo toda la tabla
+func (h *ProductHandler) rebuildSearchIndex(ctx context.Context) error {
+	products, _, err := h.readAllProducts(ctx, planProductQuery(&ProductFilter{IncludeDeleted: true}), nil)
+	if err != nil {
+		return err
+	}
+	h.search.Rebuild(products, time.Now())
+	log.Printf("🔎 Search index rebuilt: %d products", len(products))
+	return nil
+}
+
+// reindexProducts fuerza la reconstrucción del índice de búsqueda
+func (h *ProductHandler) reindexProducts(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	if err := h.rebuildSearchIndex(ctx); err != nil {
+		return h.errorResponse(headers, 500, fmt.Sprintf("Error rebuilding search index: %v", err)), nil
+	}
+
+	response := ProductResponse{
+		Success: true,
+		Message: "Search index rebuilt successfully",
+		Data:    map[string]int{"indexed_products": h.search.Len()},
+	}
+
 	return h.successResponse(headers, response), nil
 }
 
@@ -205,7 +240,7 @@ func (h *ProductHandler) getProduct(ctx context.Context, request events.APIGatew
 		return h.errorResponse(headers, 500, fmt.Sprintf("Error getting product: %v", err)), nil
 	}
 
-	if result.Item == nil {
+	if result.Item == nil || isAuxiliaryRecord(result.Item) {
 		return h.errorResponse(headers, 404, "Product not found"), nil
 	}
 
@@ -215,6 +250,11 @@ func (h *ProductHandler) getProduct(ctx context.Context, request events.APIGatew
 		return h.errorResponse(headers, 500, fmt.Sprintf("Error unmarshaling product: %v", err)), nil
 	}
 
+	headers["ETag"] = productETag(product.Version)
+	if ifNoneMatch, err := parseIfMatch(headerValue(request.Headers, "If-None-Match")); err == nil && ifNoneMatch.matches(product.Version) {
+		return events.APIGatewayProxyResponse{StatusCode: 304, Headers: headers}, nil
+	}
+
 	response := ProductResponse{
 		Success: true,
 		Message: "Product retrieved successfully",
@@ -224,38 +264,31 @@ func (h *ProductHandler) getProduct(ctx context.Context, request events.APIGatew
 	return h.successResponse(headers, response), nil
 }
 
-// createProduct crea un nuevo producto
+// createProduct crea un nuevo producto. El SKU se reserva en la misma transacción
+// y con Idempotency-Key los reintentos devuelven la respuesta original.
 func (h *ProductHandler) createProduct(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	key := idempotencyKey(request.Headers)
+	if key != "" {
+		if !validIdempotencyKey(key) {
+			return h.errorResponse(headers, 400, "Invalid Idempotency-Key header"), nil
+		}
+		if replay, err := h.replayIdempotent(ctx, "create", key, request.Body, headers); err != nil || replay != nil {
+			return h.idempotencyResult(replay, err, headers), nil
+		}
+	}
+
 	var req CreateProductRequest
 	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
 		return h.errorResponse(headers, 400, "Invalid JSON body"), nil
 	}
 
 	// Validaciones básicas
-	if req.Name == "" || req.Price <= 0 || req.Category == "" || req.SKU == "" {
-		return h.errorResponse(headers, 400, "Missing required fields: name, price, category, sku"), nil
+	if errs := req.Validate(); len(errs) > 0 {
+		return h.validationErrorResponse(headers, errs), nil
 	}
 
 	// Crear producto
-	now := time.Now()
-	product := Product{
-		ID:          uuid.New().String(),
-		Name:        req.Name,
-		Description: req.Description,
-		Price:       req.Price,
-		Category:    req.Category,
-		Stock:       req.Stock,
-		ImageURL:    req.ImageURL,
-		SKU:         req.SKU,
-		Status:      StatusActive,
-		CreatedAt:   now,
-		UpdatedAt:   now,
-		Tags:        req.Tags,
-	}
-
-	if product.Stock <= 0 {
-		product.Status = StatusOutOfStock
-	}
+	product := req.NewProduct(uuid.New().String(), time.Now())
 
 	// Serializar para DynamoDB
 	item, err := attributevalue.MarshalMap(product)
@@ -263,28 +296,68 @@ func (h *ProductHandler) createProduct(ctx context.Context, request events.APIGa
 		return h.errorResponse(headers, 500, fmt.Sprintf("Error marshaling product: %v", err)), nil
 	}
 
-	// Insertar en DynamoDB
-	input := &dynamodb.PutItemInput{
-		TableName: aws.String(h.tableName),
-		Item:      item,
-		ConditionExpression: aws.String("attribute_not_exists(id)"), // Evitar duplicados
+	response := h.successResponse(headers, ProductResponse{
+		Success: true,
+		Message: "Product created successfully",
+		Data:    product,
+	})
+
+	// Producto + reserva del SKU (+ respuesta idempotente) en una sola transacción
+	writes := []types.TransactWriteItem{
+		{Put: &types.Put{
+			TableName:           aws.String(h.tableName),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(id)"), // Evitar duplicados
+		}},
+		{Put: &types.Put{
+			TableName:           aws.String(h.tableName),
+			Item:                skuGuardItem(product.SKU, product.ID),
+			ConditionExpression: aws.String("attribute_not_exists(id)"),
+		}},
+	}
+	if key != "" {
+		idempotencyPut, err := h.newIdempotencyPut("create", key, request.Body, response)
+		if err != nil {
+			return h.errorResponse(headers, 500, fmt.Sprintf("Error marshaling idempotency record: %v", err)), nil
+		}
+		writes = append(writes, idempotencyPut)
 	}
 
-	_, err = h.dynamoClient.PutItem(ctx, input)
+	_, err = h.dynamoClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: writes,
+	})
 	if err != nil {
+		codes := cancellationReasons(err)
+		switch {
+		case conditionFailedAt(codes, 2):
+			// Un reintento concurrente con la misma clave ganó la carrera
+			replay, err := h.replayIdempotent(ctx, "create", key, request.Body, headers)
+			return h.idempotencyResult(replay, err, headers), nil
+		case conditionFailedAt(codes, 1):
+			return h.errorResponse(headers, 409, fmt.Sprintf("A product with SKU %s already exists", product.SKU)), nil
+		}
 		return h.errorResponse(headers, 500, fmt.Sprintf("Error creating product: %v", err)), nil
 	}
+	h.search.Upsert(product)
 
-	response := ProductResponse{
-		Success: true,
-		Message: "Product created successfully",
-		Data:    product,
-	}
+	return response, nil
+}
 
-	return h.successResponse(headers, response), nil
+// idempotencyResult convierte el resultado de replayIdempotent en respuesta
+func (h *ProductHandler) idempotencyResult(replay *events.APIGatewayProxyResponse, err error, headers map[string]string) events.APIGatewayProxyResponse {
+	switch {
+	case errors.Is(err, ErrIdempotencyKeyReused):
+		return h.errorResponse(headers, 422, "Idempotency-Key was already used with a different request body")
+	case err != nil:
+		return h.errorResponse(headers, 500, fmt.Sprintf("Error checking idempotency: %v", err))
+	case replay == nil:
+		return h.errorResponse(headers, 409, "A request with this Idempotency-Key is still being processed")
+	}
+	return *replay
 }
 
-// updateProduct actualiza un producto existente
+// updateProduct actualiza un producto existente. Lee la versión actual y escribe
+// condicionado a ella, así el estado derivado del stock se calcula sobre datos frescos.
 func (h *ProductHandler) updateProduct(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
 	productID := request.PathParameters["id"]
 	if productID == "" {
@@ -295,19 +368,98 @@ func (h *ProductHandler) updateProduct(ctx context.Context, request events.APIGa
 	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
 		return h.errorResponse(headers, 400, "Invalid JSON body"), nil
 	}
+	if errs := req.Validate(); len(errs) > 0 {
+		return h.validationErrorResponse(headers, errs), nil
+	}
+
+	ifMatch, err := parseIfMatch(headerValue(request.Headers, "If-Match"))
+	if err != nil {
+		return h.errorResponse(headers, 400, "Invalid If-Match header"), nil
+	}
+
+	var product Product
+	for attempt := 1; ; attempt++ {
+		item, err := h.fetchProductItem(ctx, productID)
+		if err != nil {
+			return h.errorResponse(headers, 500, fmt.Sprintf("Error getting product: %v", err)), nil
+		}
+		if item == nil {
+			return h.errorResponse(headers, 404, "Product not found"), nil
+		}
+
+		var current Product
+		if err := attributevalue.UnmarshalMap(item, &current); err != nil {
+			return h.errorResponse(headers, 500, fmt.Sprintf("Error unmarshaling product: %v", err)), nil
+		}
+		if current.IsDeleted() {
+			return h.errorResponse(headers, 409, "Product is deleted, restore it before updating"), nil
+		}
+		if ifMatch.active() && !ifMatch.matches(current.Version) {
+			return h.preconditionFailed(headers, item), nil
+		}
+
+		status, errs := req.resolveStatus(&current)
+		price, priceList, priceChanged, priceErrs := req.resolvePrices(&current)
+		if errs = append(errs, priceErrs...); len(errs) > 0 {
+			return h.validationErrorResponse(headers, errs), nil
+		}
 
+		input, err := h.buildProductUpdate(productID, &req, &current, status, price, priceList, priceChanged)
+		if err != nil {
+			return h.errorResponse(headers, 500, fmt.Sprintf("Error building update: %v", err)), nil
+		}
+
+		result, err := h.dynamoClient.UpdateItem(ctx, input)
+		if err != nil {
+			var conditionFailed *types.ConditionalCheckFailedException
+			if !errors.As(err, &conditionFailed) {
+				return h.errorResponse(headers, 500, fmt.Sprintf("Error updating product: %v", err)), nil
+			}
+			switch {
+			case conditionFailed.Item == nil:
+				return h.errorResponse(headers, 404, "Product not found"), nil
+			case ifMatch.active():
+				return h.preconditionFailed(headers, conditionFailed.Item), nil
+			case attempt < maxUpdateAttempts:
+				// Otra escritura ganó: recalcular sobre la versión nueva
+				continue
+			}
+			return h.codedErrorResponse(headers, 409, ErrorCodeConcurrentUpdate, "Product is being modified concurrently, please retry"), nil
+		}
+
+		if err := attributevalue.UnmarshalMap(result.Attributes, &product); err != nil {
+			return h.errorResponse(headers, 500, fmt.Sprintf("Error unmarshaling updated product: %v", err)), nil
+		}
+		break
+	}
+	h.search.Upsert(product)
+	headers["ETag"] = productETag(product.Version)
+
+	response := ProductResponse{
+		Success: true,
+		Message: "Product updated successfully",
+		Data:    product,
+	}
+
+	return h.successResponse(headers, response), nil
+}
+
+// buildProductUpdate arma un UpdateItem atómico: campos pedidos, estado derivado
+// y versión + 1, condicionado a la versión leída
+func (h *ProductHandler) buildProductUpdate(productID string, req *UpdateProductRequest, current *Product, status string, price Money, priceList map[string]int64, priceChanged bool) (*dynamodb.UpdateItemInput, error) {
 	// Construir update expression
 	var updateExpression strings.Builder
 	expressionAttributeValues := make(map[string]types.AttributeValue)
 	expressionAttributeNames := make(map[string]string)
 	
-	updateExpression.WriteString("SET updated_at = :updated_at")
+	updateExpression.WriteString("SET updated_at = :updated_at, version = :next_version")
 	expressionAttributeValues[":updated_at"] = &types.AttributeValueMemberS{Value: time.Now().Format(time.RFC3339)}
+	expressionAttributeValues[":next_version"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(current.Version+1, 10)}
 
 	if req.Name != nil {
 		updateExpression.WriteString(", #name = :name")
 		expressionAttributeNames["#name"] = "name"
-		expressionAttributeValues[":name"] = &types.AttributeValueMemberS{Value: *req.Name}
+		expressionAttributeValues[":name"] = &types.AttributeValueMemberS{Value: strings.TrimSpace(*req.Name)}
 	}
 	
 	if req.Description != nil {
@@ -315,14 +467,30 @@ func (h *ProductHandler) updateProduct(ctx context.Context, request events.APIGa
 		expressionAttributeValues[":description"] = &types.AttributeValueMemberS{Value: *req.Description}
 	}
 	
-	if req.Price != nil {
-		updateExpression.WriteString(", price = :price")
-		expressionAttributeValues[":price"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%.2f", *req.Price)}
+	// Precio en unidades menores; de paso se migran los items con el precio decimal
+	var remove []string
+	if priceChanged {
+		updateExpression.WriteString(", price_minor = :price_minor, currency = :currency")
+		expressionAttributeValues[":price_minor"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(price.Amount, 10)}
+		expressionAttributeValues[":currency"] = &types.AttributeValueMemberS{Value: price.Currency}
+		if len(priceList) > 0 {
+			list, err := attributevalue.Marshal(priceList)
+			if err != nil {
+				return nil, err
+			}
+			updateExpression.WriteString(", price_list = :price_list")
+			expressionAttributeValues[":price_list"] = list
+		} else {
+			remove = append(remove, "price_list")
+		}
+		if current.legacyPrice {
+			remove = append(remove, "price")
+		}
 	}
 	
 	if req.Category != nil {
 		updateExpression.WriteString(", category = :category")
-		expressionAttributeValues[":category"] = &types.AttributeValueMemberS{Value: *req.Category}
+		expressionAttributeValues[":category"] = &types.AttributeValueMemberS{Value: strings.TrimSpace(*req.Category)}
 	}
 	
 	if req.Stock != nil {
@@ -334,147 +502,236 @@ func (h *ProductHandler) updateProduct(ctx context.Context, request events.APIGa
 		updateExpression.WriteString(", image_url = :image_url")
 		expressionAttributeValues[":image_url"] = &types.AttributeValueMemberS{Value: *req.ImageURL}
 	}
+
+	if req.Tags != nil {
+		tags, err := attributevalue.Marshal(normalizeTags(*req.Tags))
+		if err != nil {
+			return nil, err
+		}
+		updateExpression.WriteString(", tags = :tags")
+		expressionAttributeValues[":tags"] = tags
+	}
 	
-	if req.Status != nil {
+	// El estado se escribe siempre que cambie, pedido o derivado del stock
+	if status != current.Status {
 		updateExpression.WriteString(", #status = :status")
 		expressionAttributeNames["#status"] = "status"
-		expressionAttributeValues[":status"] = &types.AttributeValueMemberS{Value: *req.Status}
+		expressionAttributeValues[":status"] = &types.AttributeValueMemberS{Value: status}
+	}
+
+	if len(remove) > 0 {
+		updateExpression.WriteString(" REMOVE " + strings.Join(remove, ", "))
 	}
 
+	// Solo productos existentes y sin cambios desde que se leyeron
+	read := versionPrecondition{versions: []int64{current.Version}}
 	input := &dynamodb.UpdateItemInput{
 		TableName: aws.String(h.tableName),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: productID},
 		},
 		UpdateExpression:          aws.String(updateExpression.String()),
+		ConditionExpression:       aws.String("attribute_exists(id) AND " + read.condition(expressionAttributeValues)),
 		ExpressionAttributeValues: expressionAttributeValues,
-		ConditionExpression:       aws.String("attribute_exists(id)"), // Solo actualizar si existe
 		ReturnValues:              types.ReturnValueAllNew,
+		// Con el item actual se distingue 404 de 412
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
 	}
 
 	if len(expressionAttributeNames) > 0 {
 		input.ExpressionAttributeNames = expressionAttributeNames
 	}
 
-	result, err := h.dynamoClient.UpdateItem(ctx, input)
+	return input, nil
+}
+
+// fetchProductItem lee un producto con lectura consistente; nil si no existe
+func (h *ProductHandler) fetchProductItem(ctx context.Context, productID string) (map[string]types.AttributeValue, error) {
+	result, err := h.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(h.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: productID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
 	if err != nil {
-		return h.errorResponse(headers, 500, fmt.Sprintf("Error updating product: %v", err)), nil
+		return nil, err
+	}
+	if result.Item == nil || isAuxiliaryRecord(result.Item) {
+		return nil, nil
 	}
+	return result.Item, nil
+}
 
-	var product Product
-	err = attributevalue.UnmarshalMap(result.Attributes, &product)
+// applyVersionedUpdate aplica update (SET ... [REMOVE ...]) sobre la versión leída
+// de current, incrementándola junto con updated_at
+func (h *ProductHandler) applyVersionedUpdate(ctx context.Context, current *Product, update string, names map[string]string, values map[string]types.AttributeValue, now time.Time) (Product, error) {
+	read := versionPrecondition{versions: []int64{current.Version}}
+	values[":updated_at"] = &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)}
+	values[":next_version"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(current.Version+1, 10)}
+	update = strings.Replace(update, "SET ", "SET updated_at = :updated_at, version = :next_version, ", 1)
+
+	result, err := h.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(h.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: current.ID},
+		},
+		UpdateExpression:                    aws.String(update),
+		ConditionExpression:                 aws.String("attribute_exists(id) AND " + read.condition(values)),
+		ExpressionAttributeNames:            names,
+		ExpressionAttributeValues:           values,
+		ReturnValues:                        types.ReturnValueAllNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
 	if err != nil {
-		return h.errorResponse(headers, 500, fmt.Sprintf("Error unmarshaling updated product: %v", err)), nil
+		return Product{}, err
 	}
 
-	response := ProductResponse{
-		Success: true,
-		Message: "Product updated successfully",
-		Data:    product,
+	var product Product
+	if err := attributevalue.UnmarshalMap(result.Attributes, &product); err != nil {
+		return Product{}, fmt.Errorf("unmarshaling product: %w", err)
+	}
+	return product, nil
+}
+
+// versionedUpdateError traduce el error de applyVersionedUpdate: 412 con If-Match,
+// 409 si otra escritura se adelantó, 404 si el producto ya no existe
+func (h *ProductHandler) versionedUpdateError(headers map[string]string, err error, ifMatch versionPrecondition, message string) events.APIGatewayProxyResponse {
+	var conditionFailed *types.ConditionalCheckFailedException
+	if !errors.As(err, &conditionFailed) {
+		return h.errorResponse(headers, 500, fmt.Sprintf("%s: %v", message, err))
+	}
+	switch {
+	case conditionFailed.Item == nil:
+		return h.errorResponse(headers, 404, "Product not found")
+	case ifMatch.active():
+		return h.preconditionFailed(headers, conditionFailed.Item)
 	}
+	return h.codedErrorResponse(headers, 409, ErrorCodeConcurrentUpdate, "Product is being modified concurrently, please retry")
+}
 
-	return h.successResponse(headers, response), nil
+// normalizeTags quita espacios y tags repetidos conservando el orden
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if !seen[strings.ToLower(tag)] {
+			seen[strings.ToLower(tag)] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
 }
 
-// deleteProduct elimina un producto
+// deleteProduct marca un producto como borrado (soft delete). El item se conserva
+// porque los pedidos en Firestore referencian su product_id; el SKU sigue reservado
+// hasta que el purge lo archive.
 func (h *ProductHandler) deleteProduct(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
 	productID := request.PathParameters["id"]
 	if productID == "" {
 		return h.errorResponse(headers, 400, "Product ID is required"), nil
 	}
 
-	input := &dynamodb.DeleteItemInput{
-		TableName: aws.String(h.tableName),
-		Key: map[string]types.AttributeValue{
-			"id": &types.AttributeValueMemberS{Value: productID},
-		},
-		ConditionExpression: aws.String("attribute_exists(id)"),
-		ReturnValues:        types.ReturnValueAllOld,
+	ifMatch, err := parseIfMatch(headerValue(request.Headers, "If-Match"))
+	if err != nil {
+		return h.errorResponse(headers, 400, "Invalid If-Match header"), nil
 	}
 
-	result, err := h.dynamoClient.DeleteItem(ctx, input)
+	item, err := h.fetchProductItem(ctx, productID)
 	if err != nil {
-		return h.errorResponse(headers, 500, fmt.Sprintf("Error deleting product: %v", err)), nil
+		return h.errorResponse(headers, 500, fmt.Sprintf("Error getting product: %v", err)), nil
+	}
+	if item == nil {
+		return h.errorResponse(headers, 404, "Product not found"), nil
 	}
 
-	if result.Attributes == nil {
+	var product Product
+	if err := attributevalue.UnmarshalMap(item, &product); err != nil {
+		return h.errorResponse(headers, 500, fmt.Sprintf("Error unmarshaling product: %v", err)), nil
+	}
+	if product.IsDeleted() {
 		return h.errorResponse(headers, 404, "Product not found"), nil
 	}
+	if ifMatch.active() && !ifMatch.matches(product.Version) {
+		return h.preconditionFailed(headers, item), nil
+	}
+
+	now := time.Now().UTC()
+	result, err := h.applyVersionedUpdate(ctx, &product,
+		"SET deleted_at = :deleted_at, previous_status = :previous_status, #status = :status",
+		map[string]string{"#status": "status"},
+		map[string]types.AttributeValue{
+			":deleted_at":      &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+			":previous_status": &types.AttributeValueMemberS{Value: product.Status},
+			":status":          &types.AttributeValueMemberS{Value: StatusInactive},
+		}, now)
+	if err != nil {
+		return h.versionedUpdateError(headers, err, ifMatch, "Error deleting product"), nil
+	}
+	h.search.Upsert(result)
+	headers["ETag"] = productETag(result.Version)
 
 	response := ProductResponse{
 		Success: true,
 		Message: "Product deleted successfully",
+		Data:    result,
 	}
 
 	return h.successResponse(headers, response), nil
 }
 
-// getProductStats obtiene estadísticas de productos
-func (h *ProductHandler) getProductStats(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
-	// Scan todos los productos para calcular stats
-	input := &dynamodb.ScanInput{
-		TableName: aws.String(h.tableName),
+// restoreProduct deshace un soft delete y recupera el estado anterior (según el stock)
+func (h *ProductHandler) restoreProduct(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	productID := request.PathParameters["id"]
+	if productID == "" {
+		return h.errorResponse(headers, 400, "Product ID is required"), nil
 	}
 
-	result, err := h.dynamoClient.Scan(ctx, input)
+	ifMatch, err := parseIfMatch(headerValue(request.Headers, "If-Match"))
 	if err != nil {
-		return h.errorResponse(headers, 500, fmt.Sprintf("Error scanning products: %v", err)), nil
+		return h.errorResponse(headers, 400, "Invalid If-Match header"), nil
 	}
 
-	var products []Product
-	err = attributevalue.UnmarshalListOfMaps(result.Items, &products)
+	item, err := h.fetchProductItem(ctx, productID)
 	if err != nil {
-		return h.errorResponse(headers, 500, fmt.Sprintf("Error unmarshaling products: %v", err)), nil
+		return h.errorResponse(headers, 500, fmt.Sprintf("Error getting product: %v", err)), nil
 	}
-
-	// Calcular estadísticas
-	stats := ProductStats{
-		TotalProducts: len(products),
+	if item == nil {
+		return h.errorResponse(headers, 404, "Product not found"), nil
 	}
 
-	if len(products) > 0 {
-		totalValue := 0.0
-		activeCount := 0
-		outOfStockCount := 0
-		lowStockCount := 0
-		categoryCount := make(map[string]int)
-
-		for _, p := range products {
-			totalValue += p.Price * float64(p.Stock)
-			
-			if p.Status == StatusActive {
-				activeCount++
-			}
-			if p.Status == StatusOutOfStock {
-				outOfStockCount++
-			}
-			if p.IsLowStock() {
-				lowStockCount++
-			}
-			
-			categoryCount[p.Category]++
-		}
+	var product Product
+	if err := attributevalue.UnmarshalMap(item, &product); err != nil {
+		return h.errorResponse(headers, 500, fmt.Sprintf("Error unmarshaling product: %v", err)), nil
+	}
+	if !product.IsDeleted() {
+		return h.errorResponse(headers, 409, "Product is not deleted"), nil
+	}
+	if ifMatch.active() && !ifMatch.matches(product.Version) {
+		return h.preconditionFailed(headers, item), nil
+	}
 
-		stats.ActiveProducts = activeCount
-		stats.OutOfStock = outOfStockCount
-		stats.TotalValue = totalValue
-		stats.AveragePrice = totalValue / float64(len(products))
-		stats.LowStockItems = lowStockCount
-
-		// Encontrar categoría top
-		maxCount := 0
-		for category, count := range categoryCount {
-			if count > maxCount {
-				maxCount = count
-				stats.TopCategory = category
-			}
-		}
+	status := product.PreviousStatus
+	if status == "" {
+		status = StatusActive
+	}
+	result, err := h.applyVersionedUpdate(ctx, &product,
+		"SET #status = :status REMOVE deleted_at, previous_status",
+		map[string]string{"#status": "status"},
+		map[string]types.AttributeValue{
+			":status": &types.AttributeValueMemberS{Value: deriveStatus(status, product.Stock)},
+		}, time.Now())
+	if err != nil {
+		return h.versionedUpdateError(headers, err, ifMatch, "Error restoring product"), nil
 	}
+	h.search.Upsert(result)
+	headers["ETag"] = productETag(result.Version)
 
 	response := ProductResponse{
 		Success: true,
-		Message: "Product statistics retrieved successfully",
-		Data:    stats,
+		Message: "Product restored successfully",
+		Data:    result,
 	}
 
 	return h.successResponse(headers, response), nil
@@ -490,10 +747,31 @@ func (h *ProductHandler) successResponse(headers map[string]string, data interfa
 	}
 }
 
+// validationErrorResponse devuelve 400 con el detalle de cada campo inválido
+func (h *ProductHandler) validationErrorResponse(headers map[string]string, errs ValidationErrors) events.APIGatewayProxyResponse {
+	response := ErrorResponse{
+		Success: false,
+		Message: "Validation failed",
+		Errors:  errs,
+	}
+	body, _ := json.Marshal(response)
+	return events.APIGatewayProxyResponse{
+		StatusCode: 400,
+		Headers:    headers,
+		Body:       string(body),
+	}
+}
+
 func (h *ProductHandler) errorResponse(headers map[string]string, statusCode int, message string) events.APIGatewayProxyResponse {
+	return h.codedErrorResponse(headers, statusCode, "", message)
+}
+
+// codedErrorResponse -> Error con un código que otros servicios pueden interpretar
+func (h *ProductHandler) codedErrorResponse(headers map[string]string, statusCode int, code, message string) events.APIGatewayProxyResponse {
 	response := ErrorResponse{
 		Success: false,
 		Message: message,
+		Code:    code,
 	}
 	body, _ := json.Marshal(response)
 	return events.APIGatewayProxyResponse{
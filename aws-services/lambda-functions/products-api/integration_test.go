@@ -0,0 +1,208 @@
+//go:build integration
+
+// Pruebas contra DynamoDB Local:
+//
+//	docker compose -f ../../infrastructure/dynamodb-local/docker-compose.yml up -d
+//	DYNAMODB_ENDPOINT=http://localhost:8000 go test -tags integration ./...
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// integrationHandler crea una tabla temporal con el esquema de DynamoDB Local
+// y un handler que la usa; la tabla se borra al terminar el test
+func integrationHandler(t *testing.T) *ProductHandler {
+	t.Helper()
+	endpoint := os.Getenv("DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("DYNAMODB_ENDPOINT not set, skipping DynamoDB Local tests")
+	}
+
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx, localConfigOptions()...)
+	if err != nil {
+		t.Fatalf("loading AWS config: %v", err)
+	}
+	client := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+	})
+
+	schema, err := os.ReadFile("../../infrastructure/dynamodb-local/products-table.json")
+	if err != nil {
+		t.Fatalf("reading table schema: %v", err)
+	}
+	var input dynamodb.CreateTableInput
+	if err := json.Unmarshal(schema, &input); err != nil {
+		t.Fatalf("parsing table schema: %v", err)
+	}
+	input.TableName = aws.String(fmt.Sprintf("products-test-%d", time.Now().UnixNano()))
+	if _, err := client.CreateTable(ctx, &input); err != nil {
+		t.Fatalf("creating table: %v", err)
+	}
+	t.Cleanup(func() {
+		client.DeleteTable(context.Background(), &dynamodb.DeleteTableInput{TableName: input.TableName})
+	})
+
+	h := NewProductHandler(client)
+	h.tableName = *input.TableName
+	h.internalKey = nil
+	return h
+}
+
+func mustCreateProduct(t *testing.T, h *ProductHandler, name, category string, stock int) Product {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{
+		"name":     name,
+		"price":    "9.99",
+		"category": category,
+		"stock":    stock,
+		"sku":      "SKU-" + name,
+	})
+	response, err := h.HandleRequest(context.Background(), events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/products",
+		Body:       string(body),
+	})
+	if err != nil || response.StatusCode != 200 {
+		t.Fatalf("creating %s: status %d, err %v: %s", name, response.StatusCode, err, response.Body)
+	}
+	var created ProductResponse
+	if err := json.Unmarshal([]byte(response.Body), &created); err != nil {
+		t.Fatalf("decoding created product: %v", err)
+	}
+	product, _ := created.Data.(map[string]interface{})
+	return Product{ID: fmt.Sprint(product["id"]), Category: category}
+}
+
+// listAllPages recorre el listado con next_cursor y devuelve los IDs en orden
+func listAllPages(t *testing.T, h *ProductHandler, query map[string]string) []string {
+	t.Helper()
+	var ids []string
+	cursor := ""
+	for page := 0; page < 50; page++ {
+		params := map[string]string{"cursor": cursor, "page_size": "2"}
+		for name, value := range query {
+			params[name] = value
+		}
+		response, err := h.HandleRequest(context.Background(), events.APIGatewayProxyRequest{
+			HTTPMethod:            "GET",
+			Path:                  "/products",
+			QueryStringParameters: params,
+		})
+		if err != nil || response.StatusCode != 200 {
+			t.Fatalf("listing %v: status %d, err %v: %s", params, response.StatusCode, err, response.Body)
+		}
+		var list ProductsListResponse
+		if err := json.Unmarshal([]byte(response.Body), &list); err != nil {
+			t.Fatalf("decoding list: %v", err)
+		}
+		for _, product := range list.Data {
+			ids = append(ids, product.ID)
+		}
+		if list.NextCursor == "" {
+			return ids
+		}
+		cursor = list.NextCursor
+	}
+	t.Fatalf("listing %v did not finish after 50 pages", query)
+	return nil
+}
+
+func TestListProductsCursorPagination(t *testing.T) {
+	h := integrationHandler(t)
+
+	expected := map[string]map[string]bool{"": {}, "books": {}, StatusOutOfStock: {}}
+	for i := 0; i < 5; i++ {
+		product := mustCreateProduct(t, h, fmt.Sprintf("book-%d", i), "books", 10)
+		expected[""][product.ID] = true
+		expected["books"][product.ID] = true
+	}
+	for i := 0; i < 3; i++ {
+		product := mustCreateProduct(t, h, fmt.Sprintf("game-%d", i), "games", 0)
+		expected[""][product.ID] = true
+		expected[StatusOutOfStock][product.ID] = true
+	}
+
+	tests := []struct {
+		name  string
+		query map[string]string
+		index string
+		want  map[string]bool
+	}{
+		{"scan", map[string]string{}, "", expected[""]},
+		{"category", map[string]string{"category": "books"}, GSIByCategory, expected["books"]},
+		{"status", map[string]string{"status": StatusOutOfStock}, GSIByStatus, expected[StatusOutOfStock]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, errs := parseProductFilter(tt.query)
+			if len(errs) > 0 {
+				t.Fatalf("parsing filter: %v", errs)
+			}
+			plan := planProductQuery(&filter)
+			if plan.index != tt.index {
+				t.Fatalf("planProductQuery index = %q, want %q", plan.index, tt.index)
+			}
+			if tt.index == "" && plan.segments() != ParallelScanSegments {
+				t.Fatalf("scan uses %d segments, want %d", plan.segments(), ParallelScanSegments)
+			}
+
+			ids := listAllPages(t, h, tt.query)
+			seen := map[string]bool{}
+			for _, id := range ids {
+				if seen[id] {
+					t.Fatalf("product %s returned twice across pages", id)
+				}
+				if !tt.want[id] {
+					t.Fatalf("unexpected product %s in %s listing", id, tt.name)
+				}
+				seen[id] = true
+			}
+			if len(seen) != len(tt.want) {
+				t.Fatalf("got %d products across pages, want %d", len(seen), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestListProductsRejectsCursorFromOtherFilter(t *testing.T) {
+	h := integrationHandler(t)
+	for i := 0; i < 3; i++ {
+		mustCreateProduct(t, h, fmt.Sprintf("book-%d", i), "books", 10)
+	}
+
+	response, err := h.HandleRequest(context.Background(), events.APIGatewayProxyRequest{
+		HTTPMethod:            "GET",
+		Path:                  "/products",
+		QueryStringParameters: map[string]string{"cursor": "", "page_size": "1", "category": "books"},
+	})
+	if err != nil || response.StatusCode != 200 {
+		t.Fatalf("first page: status %d, err %v", response.StatusCode, err)
+	}
+	var list ProductsListResponse
+	json.Unmarshal([]byte(response.Body), &list)
+	if list.NextCursor == "" {
+		t.Fatal("expected a next_cursor after the first page")
+	}
+
+	response, _ = h.HandleRequest(context.Background(), events.APIGatewayProxyRequest{
+		HTTPMethod:            "GET",
+		Path:                  "/products",
+		QueryStringParameters: map[string]string{"cursor": list.NextCursor, "page_size": "1", "category": "games"},
+	})
+	if response.StatusCode != 400 {
+		t.Fatalf("cursor from another filter: status %d, want 400", response.StatusCode)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultLocalAddr = ":8080"
+	maxLocalBodySize = 6 << 20 // Mismo límite de payload que Lambda
+)
+
+// toProxyRequest traduce una petición HTTP al evento de API Gateway (REST, v1).
+// Resource y PathParameters los rellena el router en HandleRequest.
+func toProxyRequest(r *http.Request) (events.APIGatewayProxyRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxLocalBodySize+1))
+	if err != nil {
+		return events.APIGatewayProxyRequest{}, err
+	}
+	if len(body) > maxLocalBodySize {
+		return events.APIGatewayProxyRequest{}, errors.New("request body too large")
+	}
+
+	request := events.APIGatewayProxyRequest{
+		Path:                            r.URL.Path,
+		HTTPMethod:                      r.Method,
+		Headers:                         map[string]string{},
+		MultiValueHeaders:               map[string][]string{},
+		QueryStringParameters:           map[string]string{},
+		MultiValueQueryStringParameters: map[string][]string{},
+		Body:                            string(body),
+		RequestContext: events.APIGatewayProxyRequestContext{
+			RequestID:  uuid.New().String(),
+			Stage:      "local",
+			HTTPMethod: r.Method,
+			Path:       r.URL.Path,
+			Identity: events.APIGatewayRequestIdentity{
+				SourceIP:  sourceIP(r.RemoteAddr),
+				UserAgent: r.UserAgent(),
+			},
+		},
+	}
+	// Como API Gateway: el mapa simple se queda con el último valor
+	for name, values := range r.Header {
+		request.Headers[name] = values[len(values)-1]
+		request.MultiValueHeaders[name] = values
+	}
+	if r.Host != "" {
+		request.Headers["Host"] = r.Host
+	}
+	for name, values := range r.URL.Query() {
+		request.QueryStringParameters[name] = values[len(values)-1]
+		request.MultiValueQueryStringParameters[name] = values
+	}
+	return request, nil
+}
+
+func sourceIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// writeProxyResponse copia la respuesta del handler al ResponseWriter
+func writeProxyResponse(w http.ResponseWriter, response events.APIGatewayProxyResponse) {
+	for name, value := range response.Headers {
+		w.Header().Set(name, value)
+	}
+	for name, values := range response.MultiValueHeaders {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+
+	body := []byte(response.Body)
+	if response.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(response.Body)
+		if err != nil {
+			http.Error(w, "invalid base64 response body", http.StatusBadGateway)
+			return
+		}
+		body = decoded
+	}
+
+	statusCode := response.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
+// localHandler sirve la API con net/http llamando a HandleRequest
+func localHandler(h *ProductHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		request, err := toProxyRequest(r)
+		if err != nil {
+			writeProxyResponse(w, h.errorResponse(map[string]string{"Content-Type": "application/json"}, 413, err.Error()))
+			return
+		}
+
+		response, err := h.HandleRequest(r.Context(), request)
+		if err != nil {
+			log.Printf("❌ %s %s: %v", r.Method, r.URL.Path, err)
+			http.Error(w, "Internal Server Error", http.StatusBadGateway)
+			return
+		}
+		writeProxyResponse(w, response)
+		log.Printf("%s %s -> %d (%v)", r.Method, r.URL.RequestURI(), response.StatusCode, time.Since(start))
+	})
+}
+
+// runLocalServer arranca el servidor HTTP local (LOCAL_ADDR, por defecto :8080)
+// y se detiene limpiamente con SIGINT/SIGTERM
+func runLocalServer(h *ProductHandler) error {
+	addr := os.Getenv("LOCAL_ADDR")
+	if addr == "" {
+		addr = defaultLocalAddr
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           localHandler(h),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("🏠 Products API listening on http://localhost%s/products", addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("🛑 Shutting down local server...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
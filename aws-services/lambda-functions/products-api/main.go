@@ -2,12 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 var (
@@ -16,31 +21,113 @@ var (
 
 func init() {
 	// Cargar configuración de AWS
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	cfg, err := config.LoadDefaultConfig(context.TODO(), localConfigOptions()...)
 	if err != nil {
 		log.Fatalf("Failed to load AWS config: %v", err)
 	}
 
-	// Crear cliente de DynamoDB
-	dynamoClient := dynamodb.NewFromConfig(cfg)
+	// Crear cliente de DynamoDB (DYNAMODB_ENDPOINT apunta a DynamoDB Local)
+	dynamoClient := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
 
 	// Inicializar handler
 	handler = NewProductHandler(dynamoClient)
 
+	// Eventos de producto a EventBridge; sin bus (solo en modo local) se quedan en memoria
+	if busName := os.Getenv("EVENT_BUS_NAME"); busName != "" {
+		handler.events = NewEventBridgePublisher(eventbridge.NewFromConfig(cfg), busName)
+		log.Printf("📣 Event bus: %s", busName)
+	}
+
+	// Archivo de los productos purgados: en Lambda solo S3, /tmp no sobrevive a la instancia
+	if bucket := os.Getenv("ARCHIVE_BUCKET"); bucket != "" {
+		handler.archive = NewS3Store(s3.NewFromConfig(cfg), bucket)
+		log.Printf("🗄️  Archive bucket: %s", bucket)
+	} else if os.Getenv("LOCAL_MODE") != "true" {
+		log.Println("❌ ARCHIVE_BUCKET not set, purging deleted products is disabled")
+	}
+
+	// Exports del catálogo a S3 (en Lambda la respuesta no pasa de 6 MB)
+	if bucket := os.Getenv("EXPORT_BUCKET"); bucket != "" {
+		handler.exports = NewS3Store(s3.NewFromConfig(cfg), bucket)
+		log.Printf("🪣 Export bucket: %s", bucket)
+	} else if os.Getenv("LOCAL_MODE") != "true" {
+		log.Println("⚠️  EXPORT_BUCKET not set, catalog exports are limited to 5 MB")
+	}
+
 	log.Println("🚀 Products API Lambda initialized successfully")
 	log.Printf("📊 Table: %s", TableName)
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		log.Printf("🧪 DynamoDB endpoint: %s", endpoint)
+	}
 	log.Printf("🌍 Region: %s", cfg.Region)
 }
 
+// localConfigOptions da región y credenciales ficticias para DynamoDB Local,
+// que acepta cualquier firma, si no hay otras configuradas
+func localConfigOptions() []func(*config.LoadOptions) error {
+	if os.Getenv("DYNAMODB_ENDPOINT") == "" {
+		return nil
+	}
+
+	var options []func(*config.LoadOptions) error
+	if os.Getenv("AWS_REGION") == "" && os.Getenv("AWS_DEFAULT_REGION") == "" {
+		options = append(options, config.WithRegion("us-east-1"))
+	}
+	if os.Getenv("AWS_ACCESS_KEY_ID") == "" && os.Getenv("AWS_PROFILE") == "" {
+		options = append(options, config.WithCredentialsProvider(aws.CredentialsProviderFunc(
+			func(context.Context) (aws.Credentials, error) {
+				return aws.Credentials{AccessKeyID: "local", SecretAccessKey: "local", Source: "DynamoDB Local"}, nil
+			})))
+	}
+	return options
+}
+
 func main() {
-	// Verificar si estamos en modo local para testing
+	// "rebuild-stats" recalcula los agregados de /products/stats y termina
+	if len(os.Args) > 1 && os.Args[1] == "rebuild-stats" {
+		stats, err := handler.RebuildStats(context.Background())
+		if err != nil {
+			log.Fatalf("Rebuilding statistics failed: %v", err)
+		}
+		log.Printf("📊 %d products, inventory value %.2f", stats.TotalProducts, stats.TotalValue)
+		return
+	}
+
+	// "migrate-prices" pasa los precios decimales antiguos a unidades menores
+	if len(os.Args) > 1 && os.Args[1] == "migrate-prices" {
+		migrated, err := handler.MigrateLegacyPrices(context.Background())
+		if err != nil {
+			log.Fatalf("Migrating prices failed: %v", err)
+		}
+		log.Printf("💱 Migrated %d products to price_minor", migrated)
+		return
+	}
+
+	// En modo local se sirve la API con net/http en vez de Lambda
 	if os.Getenv("LOCAL_MODE") == "true" {
-		log.Println("🏠 Running in LOCAL MODE for testing")
-		// Aquí podrías agregar un servidor HTTP local para pruebas
+		log.Println("🏠 Running in LOCAL MODE")
+		if err := runLocalServer(handler); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Local server failed: %v", err)
+		}
 		return
 	}
 
+	// Sin bus, el stream confirmaría lotes cuyos eventos solo quedan en memoria
+	if os.Getenv("EVENT_BUS_NAME") == "" {
+		log.Fatal("EVENT_BUS_NAME environment variable must be set outside LOCAL_MODE")
+	}
+
+	// Con la clave derivada del nombre de la tabla, cualquiera podría firmar cursores
+	if os.Getenv("CURSOR_SECRET") == "" {
+		log.Fatal("CURSOR_SECRET environment variable must be set outside LOCAL_MODE")
+	}
+
 	// Iniciar función Lambda
 	log.Println("🚀 Starting Products API Lambda function")
-	lambda.Start(handler.HandleRequest)
+	// Invoke acepta eventos REST API (v1), HTTP API (v2) y Function URLs
+	lambda.Start(handler)
 }
\ No newline at end of file
@@ -1,6 +1,9 @@
 package main 
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -9,7 +12,9 @@ type Product struct {
 	ID          string    `json:"id" dynamodbav:"id"`
 	Name        string    `json:"name" dynamodbav:"name"`
 	Description string    `json:"description" dynamodbav:"description"`
-	Price       float64   `json:"price" dynamodbav:"price"`
+	PriceMinor  int64     `json:"price_minor" dynamodbav:"price_minor"` // Unidades menores de Currency; price en JSON
+	Currency    string    `json:"currency" dynamodbav:"currency"`       // ISO 4217
+	PriceList   map[string]int64 `json:"-" dynamodbav:"price_list,omitempty"` // Precios fijos en otras monedas
 	Category    string    `json:"category" dynamodbav:"category"`
 	Stock       int       `json:"stock" dynamodbav:"stock"`
 	ImageURL    string    `json:"image_url" dynamodbav:"image_url"`
@@ -18,13 +23,29 @@ type Product struct {
 	CreatedAt   time.Time `json:"created_at" dynamodbav:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" dynamodbav:"updated_at"`
 	Tags        []string  `json:"tags" dynamodbav:"tags"`
+	Version     int64     `json:"version" dynamodbav:"version"` // Se incrementa en cada escritura (ETag)
+	DeletedAt   *time.Time `json:"deleted_at,omitempty" dynamodbav:"deleted_at,omitempty"` // Soft delete
+	Variants    []Variant  `json:"variants,omitempty" dynamodbav:"variants,omitempty"`     // Con variantes, Stock es su suma
+
+	// Estado antes del soft delete, para restaurarlo
+	PreviousStatus string `json:"-" dynamodbav:"previous_status,omitempty"`
+
+	// Precio decimal de los items anteriores a price_minor; se migra al leer
+	LegacyPrice float64 `json:"-" dynamodbav:"price,omitempty"`
+	legacyPrice bool    // El item aún guarda el atributo price
+
+	// Solo en resultados de búsqueda
+	Score      float64           `json:"score,omitempty" dynamodbav:"-"`
+	Highlights map[string]string `json:"highlights,omitempty" dynamodbav:"-"`
 }
 
 // CreateProductRequest -> Para crear productos
 type CreateProductRequest struct {
 	Name        string   `json:"name" binding:"required"`
 	Description string   `json:"description"`
-	Price       float64  `json:"price" binding:"required,gt=0"`
+	Price       json.Number `json:"price" binding:"required,gt=0"` // Decimal en Currency
+	Currency    string      `json:"currency"`                        // Por defecto USD
+	PriceList   map[string]json.Number `json:"price_list"`
 	Category    string   `json:"category" binding:"required"`
 	Stock       int      `json:"stock" binding:"required,gte=0"`
 	ImageURL    string   `json:"image_url"`
@@ -36,7 +57,9 @@ type CreateProductRequest struct {
 type UpdateProductRequest struct {
 	Name        *string   `json:"name,omitempty"`
 	Description *string   `json:"description,omitempty"`
-	Price       *float64  `json:"price,omitempty"`
+	Price       *json.Number `json:"price,omitempty"`
+	Currency    *string      `json:"currency,omitempty"` // Solo junto con price
+	PriceList   *map[string]json.Number `json:"price_list,omitempty"` // Reemplaza la lista completa
 	Category    *string   `json:"category,omitempty"`
 	Stock       *int      `json:"stock,omitempty"`
 	ImageURL    *string   `json:"image_url,omitempty"`
@@ -61,6 +84,7 @@ type ProductsListResponse struct {
 	Page       int       `json:"page"`
 	PageSize   int       `json:"page_size"`
 	TotalPages int       `json:"total_pages"`
+	NextCursor string    `json:"next_cursor,omitempty"` // Token opaco para pedir la siguiente página
 }
 
 // ProductStats -> Estadisitcas de productos
@@ -71,7 +95,34 @@ type ProductStats struct {
 	TotalValue      float64 `json:"total_value"`
 	AveragePrice    float64 `json:"average_price"`
 	TopCategory     string  `json:"top_category"`
-	LowStockItems   int     `json:"low_stock_items"` // Stock < 10
+	LowStockItems   int     `json:"low_stock_items"` // Stock < 10 (con variantes, alguna por debajo)
+	TotalVariants    int     `json:"total_variants"`
+	LowStockVariants int     `json:"low_stock_variants"`
+
+	Currency          string                   `json:"currency"` // Moneda de total_value, average_price y price_distribution
+	ByCurrency        map[string]CurrencyStats `json:"by_currency"`
+	Category          string                   `json:"category,omitempty"` // Agregado de una sola categoría
+	ByStatus          map[string]int           `json:"by_status"`
+	ByCategory        map[string]int           `json:"by_category,omitempty"`
+	PriceDistribution []PriceBucketCount       `json:"price_distribution"`
+	UpdatedAt         *time.Time               `json:"updated_at,omitempty"`
+	RebuiltAt         *time.Time               `json:"rebuilt_at,omitempty"`
+}
+
+// CurrencyStats -> Importes de los productos de una moneda
+type CurrencyStats struct {
+	Products          int                `json:"products"`
+	TotalValue        float64            `json:"total_value"`
+	AveragePrice      float64            `json:"average_price"`
+	PriceDistribution []PriceBucketCount `json:"price_distribution"`
+}
+
+// PriceBucketCount -> Productos en un rango de precio [min, max)
+type PriceBucketCount struct {
+	Range string   `json:"range"`
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max,omitempty"` // nil en el último rango
+	Count int      `json:"count"`
 }
 
 // ValidationError -> Para errores de validacion
@@ -80,29 +131,55 @@ type ValidationError struct {
 	Message string `json:"message"`
 }
 
+// ValidationErrors -> Errores de validación de varios campos
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Field + ": " + err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
 // ErrorResponse -> Respuesta de error
 type ErrorResponse struct {
 	Success bool              `json:"success"`
 	Message string            `json:"message"`
+	Code    string            `json:"code,omitempty"` // Para otros servicios; el mensaje puede cambiar
 	Errors  []ValidationError `json:"errors,omitempty"`
 }
 
+// Códigos de error que interpretan otros servicios (p. ej. el order-processor)
+const (
+	ErrorCodeProductNotFound     = "product_not_found"
+	ErrorCodeProductUnavailable  = "product_unavailable"
+	ErrorCodeConcurrentUpdate    = "concurrent_update" // Se puede reintentar
+	ErrorCodeInsufficientStock   = "insufficient_stock"
+	ErrorCodeReservationNotFound = "reservation_not_found"
+	ErrorCodeReservationExpired  = "reservation_expired"
+	ErrorCodeReservationConflict = "reservation_conflict" // Estado o petición incompatibles
+)
+
 // ProductFilter -> Filtros para busquedad
 type ProductFilter struct {
 	Category    string  `json:"category,omitempty"`
 	MinPrice    float64 `json:"min_price,omitempty"`
 	MaxPrice    float64 `json:"max_price,omitempty"`
+	Currency    string  `json:"currency,omitempty"` // Moneda de min/max_price; también filtra por ella
 	Status      string  `json:"status,omitempty"`
 	InStock     bool    `json:"in_stock,omitempty"`
 	Search      string  `json:"search,omitempty"` // Buscar en nombre/descripción
 	Page        int     `json:"page,omitempty"`
 	PageSize    int     `json:"page_size,omitempty"`
-	SortBy      string  `json:"sort_by,omitempty"`      // name, price, created_at
+	Cursor      string  `json:"cursor,omitempty"`      // next_cursor de la página anterior
+	IncludeDeleted bool `json:"include_deleted,omitempty"`
+	SortBy      string  `json:"sort_by,omitempty"`      // name, price, created_at, relevance (con search)
 	SortOrder   string  `json:"sort_order,omitempty"`   // asc, desc
 } 
 
 // Constantes para el sistema
-const {
+const (
 
 	//Status de productos
 	StatusActive       = "active"
@@ -113,32 +190,223 @@ const {
 	MaxPageSize        = 100
 	DefaultPageSize    = 28
 	LowStocktThreshold = 10
+	maxUpdateAttempts  = 3 // Reintentos de updateProduct ante escrituras concurrentes
+	DeletedRetention   = 30 * 24 * time.Hour // Tiempo en papelera antes del purge
+	MinPurgeRetention  = 24 * time.Hour      // Un purge nunca borra lo eliminado en el último día
 
 	// DynamoDB
 	TableName 		   = "products"
 	GSIByCategory      = "category-index"
 	GSIByStatus        = "status-index"
+	ParallelScanSegments = 4
 
-}
+	// Búsqueda
+	SearchIndexTTL = 5 * time.Minute // Reconstruir el índice si es más viejo
+
+)
 
 // Helper functions
 func (p *Product) IsLowStock() bool {
+	if len(p.Variants) > 0 {
+		return p.LowStockVariants() > 0
+	}
 	return p.Stock <= LowStocktThreshold
 }
 
-func (p *Product) IsAvailable() boolean {
-	return p.Status == StatusActive && p.Stock > 0
+func (p *Product) IsAvailable() bool {
+	return p.Status == StatusActive && p.Stock > 0 && !p.IsDeleted()
+}
+
+func (p *Product) IsDeleted() bool {
+	return p.DeletedAt != nil
 }
 
 func (p *Product) UpdateStock(quantity int) {
 	p.Stock = quantity
 	p.UpdatedAt = time.Now()
-	
-	f p.Stock <= 0 {
-		p.Status = StatusOutOfStock
-	} else if p.Status == StatusOutOfStock {
-		p.Status = StatusActive
+	p.Status = deriveStatus(p.Status, p.Stock)
+}
+
+// deriveStatus aplica el stock al estado: sin stock pasa a out_of_stock y al
+// reponer vuelve a active. Un producto inactive sigue inactive.
+func deriveStatus(status string, stock int) string {
+	if status == StatusInactive {
+		return status
+	}
+	if stock <= 0 {
+		return StatusOutOfStock
+	}
+	if status == StatusOutOfStock {
+		return StatusActive
+	}
+	return status
+}
+
+// validStatuses -> Estados que acepta la API
+var validStatuses = map[string]bool{
+	StatusActive:     true,
+	StatusInactive:   true,
+	StatusOutOfStock: true,
+}
+
+// Validate aplica las reglas de creación (también en la importación masiva)
+func (r *CreateProductRequest) Validate() ValidationErrors {
+	var errs ValidationErrors
+	if strings.TrimSpace(r.Name) == "" {
+		errs = append(errs, ValidationError{Field: "name", Message: "is required"})
+	}
+	_, _, _, priceErrs := r.prices()
+	errs = append(errs, priceErrs...)
+	if strings.TrimSpace(r.Category) == "" {
+		errs = append(errs, ValidationError{Field: "category", Message: "is required"})
+	}
+	if r.Stock < 0 {
+		errs = append(errs, ValidationError{Field: "stock", Message: "must be greater than or equal to 0"})
+	}
+	if strings.TrimSpace(r.SKU) == "" {
+		errs = append(errs, ValidationError{Field: "sku", Message: "is required"})
+	}
+	for i, tag := range r.Tags {
+		if strings.TrimSpace(tag) == "" {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("tags[%d]", i), Message: "must not be empty"})
+		}
+	}
+	return errs
+}
+
+// NewProduct construye el producto a crear a partir de una petición válida
+func (r *CreateProductRequest) NewProduct(id string, now time.Time) Product {
+	product := Product{
+		ID:          id,
+		Name:        strings.TrimSpace(r.Name),
+		Description: r.Description,
+		Category:    strings.TrimSpace(r.Category),
+		Stock:       r.Stock,
+		ImageURL:    r.ImageURL,
+		SKU:         strings.TrimSpace(r.SKU),
+		Status:      StatusActive,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Tags:        normalizeTags(r.Tags),
+		Version:     1,
+	}
+	product.PriceMinor, product.Currency, product.PriceList, _ = r.prices()
+	product.Status = deriveStatus(product.Status, product.Stock)
+	return product
+}
+
+// prices convierte precio base y lista de precios a unidades menores
+func (r *CreateProductRequest) prices() (int64, string, map[string]int64, ValidationErrors) {
+	var errs ValidationErrors
+	currency := normalizeCurrency(r.Currency)
+	if !validCurrency(currency) {
+		return 0, currency, nil, ValidationErrors{{Field: "currency", Message: "unsupported currency"}}
+	}
+
+	var amount int64
+	if r.Price == "" {
+		errs = append(errs, ValidationError{Field: "price", Message: "is required"})
+	} else if parsed, err := parseMinorUnits(r.Price.String(), currency); err != nil {
+		errs = append(errs, ValidationError{Field: "price", Message: err.Error()})
+	} else if parsed <= 0 {
+		errs = append(errs, ValidationError{Field: "price", Message: "must be greater than 0"})
+	} else {
+		amount = parsed
+	}
+
+	list, listErrs := parsePriceList(r.PriceList, currency)
+	return amount, currency, list, append(errs, listErrs...)
+}
+
+// Validate revisa los campos presentes de una actualización parcial
+func (r *UpdateProductRequest) Validate() ValidationErrors {
+	var errs ValidationErrors
+	if r.Name != nil && strings.TrimSpace(*r.Name) == "" {
+		errs = append(errs, ValidationError{Field: "name", Message: "must not be empty"})
+	}
+	if r.Category != nil && strings.TrimSpace(*r.Category) == "" {
+		errs = append(errs, ValidationError{Field: "category", Message: "must not be empty"})
+	}
+	if r.Currency != nil {
+		if r.Price == nil {
+			errs = append(errs, ValidationError{Field: "currency", Message: "can only change together with price"})
+		} else if !validCurrency(normalizeCurrency(*r.Currency)) {
+			errs = append(errs, ValidationError{Field: "currency", Message: "unsupported currency"})
+		}
+	}
+	if r.Stock != nil && *r.Stock < 0 {
+		errs = append(errs, ValidationError{Field: "stock", Message: "must be greater than or equal to 0"})
+	}
+	if r.Status != nil && !validStatuses[*r.Status] {
+		errs = append(errs, ValidationError{Field: "status", Message: "must be one of active, inactive, out_of_stock"})
 	}
+	if r.Tags != nil {
+		for i, tag := range *r.Tags {
+			if strings.TrimSpace(tag) == "" {
+				errs = append(errs, ValidationError{Field: fmt.Sprintf("tags[%d]", i), Message: "must not be empty"})
+			}
+		}
+	}
+	return errs
+}
+
+// resolvePrices calcula precio, moneda y lista finales sobre el producto actual;
+// changed indica si hay que escribirlos
+func (r *UpdateProductRequest) resolvePrices(current *Product) (price Money, list map[string]int64, changed bool, errs ValidationErrors) {
+	price, list = current.BasePrice(), current.PriceList
+	if r.Currency != nil {
+		price.Currency = normalizeCurrency(*r.Currency)
+	}
+	if r.Price != nil {
+		amount, err := parseMinorUnits(r.Price.String(), price.Currency)
+		switch {
+		case err != nil:
+			errs = append(errs, ValidationError{Field: "price", Message: err.Error()})
+		case amount < 0:
+			errs = append(errs, ValidationError{Field: "price", Message: "must be greater than or equal to 0"})
+		}
+		price.Amount = amount
+	}
+	if r.PriceList != nil {
+		var listErrs ValidationErrors
+		list, listErrs = parsePriceList(*r.PriceList, price.Currency)
+		errs = append(errs, listErrs...)
+	} else if _, clash := list[price.Currency]; clash {
+		errs = append(errs, ValidationError{Field: "price_list", Message: "already has a price in " + price.Currency})
+	}
+	if price.Currency != current.Currency {
+		for _, variant := range current.Variants {
+			if variant.Price != nil {
+				errs = append(errs, ValidationError{Field: "currency", Message: "cannot change while variants have their own price"})
+				break
+			}
+		}
+	}
+	changed = r.Price != nil || r.PriceList != nil || current.legacyPrice
+	return price, list, changed, errs
+}
+
+// resolveStatus calcula el estado final a partir del producto actual. Un estado
+// explícito que contradice el stock es un error; si no, se deriva del stock.
+func (r *UpdateProductRequest) resolveStatus(current *Product) (string, ValidationErrors) {
+	stock := current.Stock
+	if r.Stock != nil && len(current.Variants) > 0 {
+		return "", ValidationErrors{{Field: "stock", Message: "is the sum of the variants' stock, update the variants instead"}}
+	}
+	if r.Stock != nil {
+		stock = *r.Stock
+	}
+
+	if r.Status == nil {
+		return deriveStatus(current.Status, stock), nil
+	}
+	switch {
+	case *r.Status == StatusActive && stock <= 0:
+		return "", ValidationErrors{{Field: "status", Message: "cannot be active while stock is 0"}}
+	case *r.Status == StatusOutOfStock && stock > 0:
+		return "", ValidationErrors{{Field: "status", Message: "cannot be out_of_stock while stock is greater than 0"}}
+	}
+	return *r.Status, nil
 }
 
 func (f *ProductFilter) Validate() error {
@@ -151,12 +419,32 @@ func (f *ProductFilter) Validate() error {
 	if f.Page <= 0 {
 		f.Page = 1
 	}
+	if f.SortBy == "" && f.Search != "" {
+		f.SortBy = "relevance"
+	}
 	if f.SortBy == "" {
 		f.SortBy = "created_at"
 	}
 	if f.SortOrder == "" {
 		f.SortOrder = "desc"
 	}
+
+	var errs ValidationErrors
+	if !sortableFields[f.SortBy] && !(f.SortBy == "relevance" && f.Search != "") {
+		errs = append(errs, ValidationError{Field: "sort_by", Message: "must be one of name, price, created_at (or relevance with search)"})
+	}
+	if f.SortOrder != "asc" && f.SortOrder != "desc" {
+		errs = append(errs, ValidationError{Field: "sort_order", Message: "must be asc or desc"})
+	}
+	if f.MinPrice < 0 {
+		errs = append(errs, ValidationError{Field: "min_price", Message: "must be greater than or equal to 0"})
+	}
+	if f.MaxPrice > 0 && f.MinPrice > f.MaxPrice {
+		errs = append(errs, ValidationError{Field: "max_price", Message: "must be greater than or equal to min_price"})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
 	return nil
 }
 
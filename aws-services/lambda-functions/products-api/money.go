@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Los precios se guardan como enteros en unidades menores (centavos) de una
+// moneda ISO 4217; en la API viajan como decimales exactos, sin pasar por float.
+
+// DefaultCurrency -> Moneda de los precios sin currency (la del order processor)
+const DefaultCurrency = "USD"
+
+// maxPriceDigits -> Dígitos significativos aceptados en un precio (cabe en int64)
+const maxPriceDigits = 15
+
+// currencyExponents -> Monedas aceptadas y sus decimales
+var currencyExponents = map[string]int{
+	"USD": 2, "EUR": 2, "GBP": 2, "CAD": 2, "AUD": 2, "CHF": 2,
+	"MXN": 2, "BRL": 2, "ARS": 2, "COP": 2, "PEN": 2,
+	"CLP": 0, "JPY": 0, "KRW": 0,
+	"KWD": 3, "BHD": 3,
+}
+
+var errInvalidDecimal = errors.New("must be a decimal number")
+
+// Money -> Importe en unidades menores con su moneda
+type Money struct {
+	Amount   int64  `json:"amount" dynamodbav:"amount"`
+	Currency string `json:"currency" dynamodbav:"currency"`
+}
+
+// MarshalJSON añade el importe como decimal exacto
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount      json.Number `json:"amount"`
+		AmountMinor int64       `json:"amount_minor"`
+		Currency    string      `json:"currency"`
+	}{json.Number(formatMinorUnits(m.Amount, m.Currency)), m.Amount, m.Currency})
+}
+
+// normalizeCurrency pasa el código a mayúsculas; vacío = DefaultCurrency
+func normalizeCurrency(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return DefaultCurrency
+	}
+	return code
+}
+
+func validCurrency(code string) bool {
+	_, ok := currencyExponents[code]
+	return ok
+}
+
+// parseMinorUnits convierte un decimal ("19.99") a unidades menores de la moneda.
+// Rechaza exponentes y más decimales de los que admite la moneda.
+func parseMinorUnits(raw string, currency string) (int64, error) {
+	exponent, ok := currencyExponents[currency]
+	if !ok {
+		return 0, fmt.Errorf("unsupported currency %s", currency)
+	}
+
+	raw = strings.TrimSpace(raw)
+	negative := strings.HasPrefix(raw, "-")
+	raw = strings.TrimPrefix(raw, "-")
+	whole, fraction, _ := strings.Cut(raw, ".")
+	fraction = strings.TrimRight(fraction, "0")
+	if whole == "" || !isDigits(whole) || !isDigits(fraction) {
+		return 0, errInvalidDecimal
+	}
+	if len(fraction) > exponent {
+		return 0, fmt.Errorf("must have at most %d decimal places for %s", exponent, currency)
+	}
+
+	digits := strings.TrimLeft(whole+fraction+strings.Repeat("0", exponent-len(fraction)), "0")
+	if len(digits) > maxPriceDigits {
+		return 0, errors.New("is too large")
+	}
+	if digits == "" {
+		return 0, nil
+	}
+	amount, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, errInvalidDecimal
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// formatMinorUnits es la inversa de parseMinorUnits: 1999 USD -> "19.99"
+func formatMinorUnits(amount int64, currency string) string {
+	exponent := currencyExponents[currency]
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	digits := strconv.FormatInt(amount, 10)
+	if exponent == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
+
+// minorUnitsToFloat -> Valor aproximado, solo para agregados y comparaciones
+func minorUnitsToFloat(amount int64, currency string) float64 {
+	return float64(amount) / math.Pow10(currencyExponents[currency])
+}
+
+// floatToMinorUnits redondea un decimal aproximado (filtros de precio) a unidades menores
+func floatToMinorUnits(value float64, currency string) int64 {
+	return int64(math.Round(value * math.Pow10(currencyExponents[currency])))
+}
+
+// parsePriceList valida una lista de precios por moneda. La moneda base del
+// producto no puede repetirse en la lista.
+func parsePriceList(list map[string]json.Number, baseCurrency string) (map[string]int64, ValidationErrors) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	var errs ValidationErrors
+	prices := make(map[string]int64, len(list))
+	for code, raw := range list {
+		field := "price_list." + code
+		currency := normalizeCurrency(code)
+		switch {
+		case !validCurrency(currency):
+			errs = append(errs, ValidationError{Field: field, Message: "unsupported currency"})
+			continue
+		case currency == baseCurrency:
+			errs = append(errs, ValidationError{Field: field, Message: "must differ from the product currency"})
+			continue
+		}
+		amount, err := parseMinorUnits(raw.String(), currency)
+		if err != nil {
+			errs = append(errs, ValidationError{Field: field, Message: err.Error()})
+			continue
+		}
+		if amount <= 0 {
+			errs = append(errs, ValidationError{Field: field, Message: "must be greater than 0"})
+			continue
+		}
+		prices[currency] = amount
+	}
+	return prices, errs
+}
+
+// formatPriceList -> Lista de precios como decimales para la API
+func formatPriceList(list map[string]int64) map[string]json.Number {
+	if len(list) == 0 {
+		return nil
+	}
+	formatted := make(map[string]json.Number, len(list))
+	for currency, amount := range list {
+		formatted[currency] = json.Number(formatMinorUnits(amount, currency))
+	}
+	return formatted
+}
+
+// Price -> Precio base como decimal aproximado
+func (p *Product) Price() float64 {
+	return minorUnitsToFloat(p.PriceMinor, p.Currency)
+}
+
+// BasePrice -> Precio base con su moneda
+func (p *Product) BasePrice() Money {
+	return Money{Amount: p.PriceMinor, Currency: p.Currency}
+}
+
+// MarshalJSON expone price y price_list como decimales exactos junto a price_minor
+func (p Product) MarshalJSON() ([]byte, error) {
+	type plain Product
+	return json.Marshal(struct {
+		plain
+		Price     json.Number            `json:"price"`
+		PriceList map[string]json.Number `json:"price_list,omitempty"`
+	}{
+		plain:     plain(p),
+		Price:     json.Number(formatMinorUnits(p.PriceMinor, p.Currency)),
+		PriceList: formatPriceList(p.PriceList),
+	})
+}
+
+// UnmarshalDynamoDBAttributeValue lee el item y migra en memoria los productos
+// guardados con el precio decimal anterior (atributo price)
+func (p *Product) UnmarshalDynamoDBAttributeValue(av types.AttributeValue) error {
+	item, ok := av.(*types.AttributeValueMemberM)
+	if !ok {
+		return fmt.Errorf("product must be a map, got %T", av)
+	}
+	type plain Product
+	if err := attributevalue.UnmarshalMap(item.Value, (*plain)(p)); err != nil {
+		return err
+	}
+
+	if p.Currency == "" {
+		p.Currency = DefaultCurrency
+	}
+	if p.LegacyPrice != 0 {
+		if p.PriceMinor == 0 {
+			p.PriceMinor = floatToMinorUnits(p.LegacyPrice, p.Currency)
+		}
+		p.LegacyPrice = 0
+		p.legacyPrice = true
+	}
+	return nil
+}
+
+// MigrateLegacyPrices reescribe los productos que aún guardan el precio decimal
+// (atributo price) con price_minor y currency. No cambia la versión: el producto
+// es el mismo, solo su representación en la tabla.
+func (h *ProductHandler) MigrateLegacyPrices(ctx context.Context) (int, error) {
+	legacy := func(p *Product) bool { return p.legacyPrice }
+	products, _, err := h.readAllProducts(ctx, planProductQuery(&ProductFilter{IncludeDeleted: true}), legacy)
+	if err != nil {
+		return 0, fmt.Errorf("reading products: %w", err)
+	}
+
+	migrated := 0
+	for _, product := range products {
+		values := map[string]types.AttributeValue{
+			":price_minor": &types.AttributeValueMemberN{Value: strconv.FormatInt(product.PriceMinor, 10)},
+			":currency":    &types.AttributeValueMemberS{Value: product.Currency},
+		}
+		condition := versionPrecondition{versions: []int64{product.Version}}.condition(values)
+		_, err := h.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName: aws.String(h.tableName),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: product.ID},
+			},
+			UpdateExpression:          aws.String("SET price_minor = :price_minor, currency = :currency REMOVE price"),
+			ConditionExpression:       aws.String("attribute_exists(id) AND " + condition),
+			ExpressionAttributeValues: values,
+		})
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			continue // Cambió entretanto; la actualización ya lo migró
+		}
+		if err != nil {
+			return migrated, fmt.Errorf("migrating %s: %w", product.ID, err)
+		}
+		migrated++
+	}
+	return migrated, nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// El historial de precios son registros auxiliares, uno por cambio de precio
+// (price_history#<id>#<version>), que escribe el consumidor del stream. Llevan
+// history_product_id para leerlos con una Query sobre el GSI price-history-index.
+const (
+	RecordPriceHistory = "price_history"
+	GSIPriceHistory    = "price-history-index"
+)
+
+// PriceHistoryEntry -> Precio vigente desde ChangedAt
+type PriceHistoryEntry struct {
+	Version       int64            `json:"version" dynamodbav:"version"`
+	ChangedAt     time.Time        `json:"changed_at" dynamodbav:"changed_at"`
+	Price         Money            `json:"price" dynamodbav:"price"`
+	PreviousPrice *Money           `json:"previous_price,omitempty" dynamodbav:"previous_price,omitempty"`
+	PriceList     map[string]int64 `json:"price_list_minor,omitempty" dynamodbav:"price_list,omitempty"`
+
+	// Overrides de precio de las variantes, por id de variante
+	VariantPrices         map[string]Money `json:"variant_prices,omitempty" dynamodbav:"variant_prices,omitempty"`
+	PreviousVariantPrices map[string]Money `json:"previous_variant_prices,omitempty" dynamodbav:"previous_variant_prices,omitempty"`
+}
+
+// PriceHistory -> Respuesta de GET /products/{id}/price-history
+type PriceHistory struct {
+	ProductID string              `json:"product_id"`
+	Entries   []PriceHistoryEntry `json:"entries"` // En orden cronológico
+}
+
+// priceHistoryRecord -> Item de una entrada del historial en DynamoDB
+type priceHistoryRecord struct {
+	ID         string `dynamodbav:"id"`
+	RecordType string `dynamodbav:"record_type"`
+	ProductID  string `dynamodbav:"history_product_id"`
+	PriceHistoryEntry
+}
+
+func priceHistoryID(productID string, version int64) string {
+	return "price_history#" + productID + "#" + strconv.FormatInt(version, 10)
+}
+
+// priceHistoryEntry devuelve la entrada que genera un cambio, o nil si el precio
+// no cambió. La creación registra el precio inicial.
+func priceHistoryEntry(change productChange) *PriceHistoryEntry {
+	old, current := change.Old, change.New
+	if current == nil {
+		return nil
+	}
+	variantPrices := current.variantPrices()
+	if old != nil && old.BasePrice() == current.BasePrice() && maps.Equal(old.PriceList, current.PriceList) &&
+		maps.Equal(old.variantPrices(), variantPrices) {
+		return nil
+	}
+
+	entry := &PriceHistoryEntry{
+		Version:       current.Version,
+		ChangedAt:     current.UpdatedAt.UTC(),
+		Price:         current.BasePrice(),
+		PriceList:     current.PriceList,
+		VariantPrices: variantPrices,
+	}
+	if old != nil {
+		previous := old.BasePrice()
+		entry.PreviousPrice = &previous
+		entry.PreviousVariantPrices = old.variantPrices()
+	}
+	return entry
+}
+
+// variantPrices -> Overrides de precio de las variantes; nil si no hay ninguno
+func (p *Product) variantPrices() map[string]Money {
+	var prices map[string]Money
+	for i := range p.Variants {
+		if p.Variants[i].Price == nil {
+			continue
+		}
+		if prices == nil {
+			prices = map[string]Money{}
+		}
+		prices[p.Variants[i].ID] = *p.Variants[i].Price
+	}
+	return prices
+}
+
+// appendPriceHistory escribe una entrada por cambio de precio del lote. La clave
+// lleva la versión del producto: si Lambda reintenta el lote, la entrada ya
+// escrita falla en la condición y se salta.
+func (h *ProductHandler) appendPriceHistory(ctx context.Context, changes []productChange) error {
+	for _, change := range changes {
+		entry := priceHistoryEntry(change)
+		if entry == nil {
+			continue
+		}
+
+		productID := change.New.ID
+		item, err := attributevalue.MarshalMap(priceHistoryRecord{
+			ID:                priceHistoryID(productID, entry.Version),
+			RecordType:        RecordPriceHistory,
+			ProductID:         productID,
+			PriceHistoryEntry: *entry,
+		})
+		if err != nil {
+			return fmt.Errorf("marshaling price history entry: %w", err)
+		}
+		_, err = h.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:           aws.String(h.tableName),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(id)"),
+		})
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			continue // Ya registrado en un intento anterior
+		}
+		if err != nil {
+			return fmt.Errorf("appending price history of %s: %w", productID, err)
+		}
+	}
+	return nil
+}
+
+// readPriceHistory lee las entradas de un producto en orden de versión (la sort
+// key del GSI)
+func (h *ProductHandler) readPriceHistory(ctx context.Context, productID string) ([]PriceHistoryEntry, error) {
+	var entries []PriceHistoryEntry
+	var lastKey map[string]types.AttributeValue
+	for {
+		result, err := h.dynamoClient.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(h.tableName),
+			IndexName:              aws.String(GSIPriceHistory),
+			KeyConditionExpression: aws.String("history_product_id = :product_id"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":product_id": &types.AttributeValueMemberS{Value: productID},
+			},
+			ExclusiveStartKey: lastKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range result.Items {
+			var record priceHistoryRecord
+			if err := attributevalue.UnmarshalMap(item, &record); err != nil {
+				return nil, fmt.Errorf("unmarshaling price history entry: %w", err)
+			}
+			entries = append(entries, record.PriceHistoryEntry)
+		}
+		if len(result.LastEvaluatedKey) == 0 {
+			break
+		}
+		lastKey = result.LastEvaluatedKey
+	}
+	return entries, nil
+}
+
+// getPriceHistory devuelve el historial de precios: ?from= y ?to= (RFC3339) acotan por fecha
+func (h *ProductHandler) getPriceHistory(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	productID := request.PathParameters["id"]
+
+	var errs ValidationErrors
+	parseTime := func(field string) time.Time {
+		raw := request.QueryStringParameters[field]
+		if raw == "" {
+			return time.Time{}
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			errs = append(errs, ValidationError{Field: field, Message: "must be an RFC3339 timestamp"})
+		}
+		return t
+	}
+	from, to := parseTime("from"), parseTime("to")
+	if len(errs) > 0 {
+		return h.validationErrorResponse(headers, errs), nil
+	}
+
+	entries, err := h.readPriceHistory(ctx, productID)
+	if err != nil {
+		return h.errorResponse(headers, 500, fmt.Sprintf("Error reading price history: %v", err)), nil
+	}
+	if len(entries) == 0 {
+		// Sin historial: 404 solo si el producto tampoco existe
+		item, err := h.fetchProductItem(ctx, productID)
+		if err != nil {
+			return h.errorResponse(headers, 500, fmt.Sprintf("Error getting product: %v", err)), nil
+		}
+		if item == nil {
+			return h.errorResponse(headers, 404, "Product not found"), nil
+		}
+	}
+
+	history := PriceHistory{ProductID: productID, Entries: []PriceHistoryEntry{}}
+	for _, entry := range entries {
+		if (!from.IsZero() && entry.ChangedAt.Before(from)) || (!to.IsZero() && entry.ChangedAt.After(to)) {
+			continue
+		}
+		history.Entries = append(history.Entries, entry)
+	}
+
+	response := ProductResponse{
+		Success: true,
+		Message: "Price history retrieved successfully",
+		Data:    history,
+	}
+
+	return h.successResponse(headers, response), nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ArchiveStore -> Destino de los exports JSON de productos purgados
+type ArchiveStore interface {
+	Save(ctx context.Context, name string, data []byte) (string, error)
+}
+
+// ErrArchiveNotConfigured -> Sin destino para el export no se purga nada
+var ErrArchiveNotConfigured = errors.New("no archive configured, set ARCHIVE_BUCKET")
+
+// fileArchive guarda los exports en un directorio (solo en modo local: el /tmp
+// de Lambda se pierde con la instancia)
+type fileArchive struct {
+	dir string
+}
+
+func NewFileArchive(dir string) ArchiveStore {
+	return &fileArchive{dir: dir}
+}
+
+func (a *fileArchive) Save(ctx context.Context, name string, data []byte) (string, error) {
+	if err := os.MkdirAll(a.dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(a.dir, name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// archiveFromEnv usa ARCHIVE_DIR o /tmp/products-archive en modo local. En
+// Lambda devuelve nil: el archivo va a S3 (ARCHIVE_BUCKET, lo configura main).
+func archiveFromEnv() ArchiveStore {
+	if os.Getenv("LOCAL_MODE") != "true" {
+		return nil
+	}
+	dir := os.Getenv("ARCHIVE_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "products-archive")
+	}
+	return NewFileArchive(dir)
+}
+
+// productArchive -> Contenido del export
+type productArchive struct {
+	ArchivedAt time.Time `json:"archived_at"`
+	Cutoff     time.Time `json:"deleted_before"`
+	Products   []Product `json:"products"`
+}
+
+// PurgeReport -> Resultado de un purge
+type PurgeReport struct {
+	Cutoff          time.Time         `json:"deleted_before"`
+	DryRun          bool              `json:"dry_run"`
+	Candidates      int               `json:"candidates"`
+	ArchiveLocation string            `json:"archive_location,omitempty"`
+	Purged          []string          `json:"purged"`
+	Skipped         map[string]string `json:"skipped,omitempty"` // id -> motivo
+}
+
+// PurgeDeleted archiva y borra definitivamente los productos borrados antes de cutoff.
+// El export se escribe antes de borrar nada; un producto restaurado entretanto se omite.
+func (h *ProductHandler) PurgeDeleted(ctx context.Context, cutoff time.Time, dryRun bool) (*PurgeReport, error) {
+	expired := func(p *Product) bool {
+		return p.IsDeleted() && p.DeletedAt.Before(cutoff)
+	}
+	products, _, err := h.readAllProducts(ctx, planProductQuery(&ProductFilter{IncludeDeleted: true}), expired)
+	if err != nil {
+		return nil, fmt.Errorf("reading deleted products: %w", err)
+	}
+
+	report := &PurgeReport{Cutoff: cutoff, DryRun: dryRun, Candidates: len(products), Purged: []string{}}
+	if dryRun || len(products) == 0 {
+		return report, nil
+	}
+	if h.archive == nil {
+		return nil, ErrArchiveNotConfigured
+	}
+
+	now := time.Now().UTC()
+	data, err := json.MarshalIndent(productArchive{ArchivedAt: now, Cutoff: cutoff, Products: products}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshaling archive: %w", err)
+	}
+	name := fmt.Sprintf("products-deleted-%s.json", now.Format("20060102T150405Z"))
+	if report.ArchiveLocation, err = h.archive.Save(ctx, name, data); err != nil {
+		return nil, fmt.Errorf("saving archive: %w", err)
+	}
+
+	for _, product := range products {
+		if err := h.purgeProduct(ctx, &product); err != nil {
+			if report.Skipped == nil {
+				report.Skipped = map[string]string{}
+			}
+			report.Skipped[product.ID] = err.Error()
+			continue
+		}
+		h.search.Remove(product.ID)
+		report.Purged = append(report.Purged, product.ID)
+	}
+
+	log.Printf("🗑️  Purged %d/%d deleted products (archive: %s)", len(report.Purged), report.Candidates, report.ArchiveLocation)
+	return report, nil
+}
+
+// purgeProduct borra el item y libera su SKU (y los de sus variantes) si sigue en la versión archivada
+func (h *ProductHandler) purgeProduct(ctx context.Context, product *Product) error {
+	values := map[string]types.AttributeValue{}
+	condition := "attribute_exists(deleted_at) AND " + versionPrecondition{versions: []int64{product.Version}}.condition(values)
+
+	writes := []types.TransactWriteItem{
+		{Delete: &types.Delete{
+			TableName: aws.String(h.tableName),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: product.ID},
+			},
+			ConditionExpression:       aws.String(condition),
+			ExpressionAttributeValues: values,
+		}},
+		h.releaseSKUGuard(product.SKU, product.ID),
+	}
+	for _, variant := range product.Variants {
+		writes = append(writes, h.releaseSKUGuard(variant.SKU, product.ID))
+	}
+
+	_, err := h.dynamoClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: writes})
+	if conditionFailedAt(cancellationReasons(err), 0) {
+		return fmt.Errorf("product changed since it was archived")
+	}
+	return err
+}
+
+// purgeDeletedProducts ejecuta el purge: ?retention_days=N (por defecto 30, mínimo 1), ?dry_run=true
+func (h *ProductHandler) purgeDeletedProducts(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	var errs ValidationErrors
+	retention := DeletedRetention
+	if raw := request.QueryStringParameters["retention_days"]; raw != "" {
+		days, err := strconv.Atoi(raw)
+		retention = time.Duration(days) * 24 * time.Hour
+		if err != nil || retention < MinPurgeRetention {
+			errs = append(errs, ValidationError{Field: "retention_days", Message: fmt.Sprintf("must be an integer of at least %d", int(MinPurgeRetention.Hours()/24))})
+		}
+	}
+	dryRun := false
+	if raw := request.QueryStringParameters["dry_run"]; raw != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(raw); err != nil {
+			errs = append(errs, ValidationError{Field: "dry_run", Message: "must be true or false"})
+		}
+	}
+	if len(errs) > 0 {
+		return h.validationErrorResponse(headers, errs), nil
+	}
+
+	report, err := h.PurgeDeleted(ctx, time.Now().Add(-retention), dryRun)
+	if errors.Is(err, ErrArchiveNotConfigured) {
+		return h.errorResponse(headers, 503, "Purge is disabled: no archive bucket configured"), nil
+	}
+	if err != nil {
+		return h.errorResponse(headers, 500, fmt.Sprintf("Error purging products: %v", err)), nil
+	}
+
+	response := ProductResponse{
+		Success: true,
+		Message: "Deleted products purged successfully",
+		Data:    report,
+	}
+
+	return h.successResponse(headers, response), nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// productQuery -> Plan de acceso a DynamoDB para un filtro
+type productQuery struct {
+	index            string // Vacío = Scan de la tabla
+	keyAttributes    []string
+	keyCondition     string
+	filterExpression string
+	scanForward      bool // Orden de created_at en el GSI
+	names            map[string]string
+	values           map[string]types.AttributeValue
+}
+
+// pagePosition -> Dónde continuar la lectura: segmento del Scan y última clave leída,
+// o el último producto entregado si el listado se ordena en memoria
+type pagePosition struct {
+	Segment int
+	Key     map[string]types.AttributeValue
+	After   *sortPosition
+}
+
+// planProductQuery usa el GSI que coincide con el filtro más selectivo y deja
+// el resto (precio, stock) como FilterExpression. Sin category ni status se hace
+// un Scan paralelo.
+func planProductQuery(filter *ProductFilter) productQuery {
+	q := productQuery{
+		keyAttributes: productKeyAttributes,
+		scanForward:   filter.SortOrder == "asc",
+		names:         map[string]string{},
+		values:        map[string]types.AttributeValue{},
+	}
+
+	var filters []string
+	switch {
+	case filter.Category != "":
+		q.index = GSIByCategory
+		q.keyAttributes = []string{"id", "category", "created_at"}
+		q.keyCondition = "category = :category"
+		q.values[":category"] = &types.AttributeValueMemberS{Value: filter.Category}
+		if filter.Status != "" {
+			filters = append(filters, "#status = :status")
+			q.names["#status"] = "status"
+			q.values[":status"] = &types.AttributeValueMemberS{Value: filter.Status}
+		}
+	case filter.Status != "":
+		q.index = GSIByStatus
+		q.keyAttributes = []string{"id", "status", "created_at"}
+		q.keyCondition = "#status = :status"
+		q.names["#status"] = "status"
+		q.values[":status"] = &types.AttributeValueMemberS{Value: filter.Status}
+	}
+
+	// Los precios se comparan en unidades menores (price_minor)
+	minPrice, hasMin := filter.minorPriceBound(filter.MinPrice)
+	maxPrice, hasMax := filter.minorPriceBound(filter.MaxPrice)
+	switch {
+	case hasMin && hasMax:
+		filters = append(filters, "price_minor BETWEEN :min_price AND :max_price")
+	case hasMin:
+		filters = append(filters, "price_minor >= :min_price")
+	case hasMax:
+		filters = append(filters, "price_minor <= :max_price")
+	}
+	if hasMin {
+		q.values[":min_price"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(minPrice, 10)}
+	}
+	if hasMax {
+		q.values[":max_price"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(maxPrice, 10)}
+	}
+	if filter.Currency != "" {
+		filters = append(filters, "currency = :currency")
+		q.values[":currency"] = &types.AttributeValueMemberS{Value: filter.Currency}
+	}
+	if filter.InStock {
+		filters = append(filters, "stock > :zero")
+		q.values[":zero"] = &types.AttributeValueMemberN{Value: "0"}
+	}
+
+	if !filter.IncludeDeleted {
+		filters = append(filters, "attribute_not_exists(deleted_at)")
+	}
+
+	// Los registros auxiliares (reservas de SKU, idempotencia) no están en los GSIs
+	if q.index == "" {
+		filters = append(filters, "attribute_not_exists("+recordTypeAttribute+")")
+	}
+
+	q.filterExpression = strings.Join(filters, " AND ")
+	return q
+}
+
+// sortedBy indica si DynamoDB ya devuelve los items en el orden pedido
+// (los GSIs usan created_at como sort key)
+func (q productQuery) sortedBy(sortBy string) bool {
+	return q.index != "" && sortBy == "created_at"
+}
+
+// segments -> Número de segmentos que hay que recorrer
+func (q productQuery) segments() int {
+	if q.index != "" {
+		return 1
+	}
+	return ParallelScanSegments
+}
+
+// pageFunc lee una página desde startKey y devuelve sus items y LastEvaluatedKey
+type pageFunc func(ctx context.Context, startKey map[string]types.AttributeValue) ([]map[string]types.AttributeValue, map[string]types.AttributeValue, error)
+
+// pager construye el lector de páginas del plan para un segmento
+func (h *ProductHandler) pager(q productQuery, limit int, segment int) pageFunc {
+	var names map[string]string
+	if len(q.names) > 0 {
+		names = q.names
+	}
+	var values map[string]types.AttributeValue
+	if len(q.values) > 0 {
+		values = q.values
+	}
+	var filterExpression *string
+	if q.filterExpression != "" {
+		filterExpression = aws.String(q.filterExpression)
+	}
+	var pageLimit *int32
+	if limit > 0 {
+		pageLimit = aws.Int32(int32(limit))
+	}
+
+	if q.index != "" {
+		return func(ctx context.Context, startKey map[string]types.AttributeValue) ([]map[string]types.AttributeValue, map[string]types.AttributeValue, error) {
+			result, err := h.dynamoClient.Query(ctx, &dynamodb.QueryInput{
+				TableName:                 aws.String(h.tableName),
+				IndexName:                 aws.String(q.index),
+				KeyConditionExpression:    aws.String(q.keyCondition),
+				FilterExpression:          filterExpression,
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+				ExclusiveStartKey:         startKey,
+				ScanIndexForward:          aws.Bool(q.scanForward),
+				Limit:                     pageLimit,
+			})
+			if err != nil {
+				return nil, nil, fmt.Errorf("querying %s: %w", q.index, err)
+			}
+			return result.Items, result.LastEvaluatedKey, nil
+		}
+	}
+
+	return func(ctx context.Context, startKey map[string]types.AttributeValue) ([]map[string]types.AttributeValue, map[string]types.AttributeValue, error) {
+		result, err := h.dynamoClient.Scan(ctx, &dynamodb.ScanInput{
+			TableName:                 aws.String(h.tableName),
+			FilterExpression:          filterExpression,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+			ExclusiveStartKey:         startKey,
+			Limit:                     pageLimit,
+			Segment:                   aws.Int32(int32(segment)),
+			TotalSegments:             aws.Int32(int32(ParallelScanSegments)),
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("scanning segment %d: %w", segment, err)
+		}
+		return result.Items, result.LastEvaluatedKey, nil
+	}
+}
+
+// collectProducts sigue LastEvaluatedKey hasta reunir limit productos (0 = todos).
+// Si quedan items por leer devuelve la clave del último producto entregado.
+func collectProducts(ctx context.Context, next pageFunc, startKey map[string]types.AttributeValue, limit int, keep func(*Product) bool, keyAttributes []string) ([]Product, map[string]types.AttributeValue, error) {
+	products := []Product{}
+
+	for {
+		items, lastEvaluatedKey, err := next(ctx, startKey)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for i, item := range items {
+			var product Product
+			if err := attributevalue.UnmarshalMap(item, &product); err != nil {
+				return nil, nil, fmt.Errorf("unmarshaling product: %w", err)
+			}
+			if keep != nil && !keep(&product) {
+				continue
+			}
+			products = append(products, product)
+
+			if limit > 0 && len(products) == limit {
+				if i < len(items)-1 || lastEvaluatedKey != nil {
+					return products, keyOf(item, keyAttributes), nil
+				}
+				return products, nil, nil
+			}
+		}
+
+		if lastEvaluatedKey == nil {
+			return products, nil, nil
+		}
+		startKey = lastEvaluatedKey
+	}
+}
+
+// readProductsPage lee hasta limit productos desde from recorriendo los segmentos
+// en orden. Devuelve nil como posición cuando no queda nada por leer.
+func (h *ProductHandler) readProductsPage(ctx context.Context, q productQuery, from pagePosition, limit int, keep func(*Product) bool) ([]Product, *pagePosition, error) {
+	products := []Product{}
+	startKey := from.Key
+
+	for segment := from.Segment; segment < q.segments(); segment++ {
+		got, lastKey, err := collectProducts(ctx, h.pager(q, limit, segment), startKey, limit-len(products), keep, q.keyAttributes)
+		if err != nil {
+			return nil, nil, err
+		}
+		products = append(products, got...)
+
+		if lastKey != nil {
+			return products, &pagePosition{Segment: segment, Key: lastKey}, nil
+		}
+		if len(products) == limit && segment+1 < q.segments() {
+			return products, &pagePosition{Segment: segment + 1}, nil
+		}
+		startKey = nil
+	}
+	return products, nil, nil
+}
+
+// readAllProducts lee todos los productos del plan; los segmentos del Scan se
+// leen en paralelo y se concatenan en orden de segmento.
+func (h *ProductHandler) readAllProducts(ctx context.Context, q productQuery, keep func(*Product) bool) ([]Product, []int, error) {
+	segments := q.segments()
+	results := make([][]Product, segments)
+	errs := make([]error, segments)
+
+	var wg sync.WaitGroup
+	for segment := 0; segment < segments; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+			results[segment], _, errs[segment] = collectProducts(ctx, h.pager(q, 0, segment), nil, 0, keep, q.keyAttributes)
+		}(segment)
+	}
+	wg.Wait()
+
+	var products []Product
+	var productSegments []int
+	for segment := 0; segment < segments; segment++ {
+		if errs[segment] != nil {
+			return nil, nil, errs[segment]
+		}
+		products = append(products, results[segment]...)
+		for range results[segment] {
+			productSegments = append(productSegments, segment)
+		}
+	}
+	if products == nil {
+		products = []Product{}
+	}
+	return products, productSegments, nil
+}
+
+// positionAfter -> Posición para continuar justo después de un producto ya entregado
+func (q productQuery) positionAfter(product Product, segment int) (pagePosition, error) {
+	item, err := attributevalue.MarshalMap(product)
+	if err != nil {
+		return pagePosition{}, err
+	}
+	return pagePosition{Segment: segment, Key: keyOf(item, q.keyAttributes)}, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Registros auxiliares que viven en la tabla de productos. Llevan record_type y no
+// tienen category/status, así que no aparecen en los GSIs; los Scans los excluyen.
+const (
+	recordTypeAttribute   = "record_type"
+	RecordSKUGuard        = "sku_guard"
+	RecordIdempotency     = "idempotency"
+	IdempotencyKeyHeader  = "Idempotency-Key"
+	IdempotencyTTL        = 24 * time.Hour
+	maxIdempotencyKeySize = 255
+)
+
+// ErrIdempotencyKeyReused -> La misma Idempotency-Key llegó con otro cuerpo
+var ErrIdempotencyKeyReused = errors.New("idempotency key reused with a different request")
+
+// skuGuardID -> Clave del registro que reserva un SKU (sin distinguir mayúsculas)
+func skuGuardID(sku string) string {
+	return "sku#" + strings.ToUpper(strings.TrimSpace(sku))
+}
+
+// skuGuardItem reserva el SKU para un producto
+func skuGuardItem(sku, productID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"id":                &types.AttributeValueMemberS{Value: skuGuardID(sku)},
+		recordTypeAttribute: &types.AttributeValueMemberS{Value: RecordSKUGuard},
+		"product_id":        &types.AttributeValueMemberS{Value: productID},
+	}
+}
+
+// releaseSKUGuard borra la reserva del SKU si pertenece al producto. Si no existe
+// (productos anteriores a las reservas) la condición también se cumple.
+func (h *ProductHandler) releaseSKUGuard(sku, productID string) types.TransactWriteItem {
+	return types.TransactWriteItem{
+		Delete: &types.Delete{
+			TableName: aws.String(h.tableName),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: skuGuardID(sku)},
+			},
+			ConditionExpression: aws.String("attribute_not_exists(id) OR product_id = :product_id"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":product_id": &types.AttributeValueMemberS{Value: productID},
+			},
+		},
+	}
+}
+
+// isAuxiliaryRecord indica si un item no es un producto
+func isAuxiliaryRecord(item map[string]types.AttributeValue) bool {
+	_, ok := item[recordTypeAttribute]
+	return ok
+}
+
+// idempotencyRecord -> Respuesta guardada para repetirla ante reintentos
+type idempotencyRecord struct {
+	ID          string `dynamodbav:"id"`
+	RecordType  string `dynamodbav:"record_type"`
+	RequestHash string `dynamodbav:"request_hash"`
+	StatusCode  int    `dynamodbav:"status_code"`
+	Body        string `dynamodbav:"body"`
+	CreatedAt   string `dynamodbav:"created_at"`
+	ExpiresAt   int64  `dynamodbav:"expires_at"` // TTL de DynamoDB
+}
+
+// idempotencyKey lee la cabecera Idempotency-Key
+func idempotencyKey(requestHeaders map[string]string) string {
+	return headerValue(requestHeaders, IdempotencyKeyHeader)
+}
+
+func idempotencyRecordID(operation, key string) string {
+	return "idempotency#" + operation + "#" + key
+}
+
+func requestHash(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// newIdempotencyPut guarda la respuesta en la misma transacción que la escritura,
+// así un reintento concurrente no puede crear un segundo producto
+func (h *ProductHandler) newIdempotencyPut(operation, key, body string, response events.APIGatewayProxyResponse) (types.TransactWriteItem, error) {
+	now := time.Now()
+	item, err := attributevalue.MarshalMap(idempotencyRecord{
+		ID:          idempotencyRecordID(operation, key),
+		RecordType:  RecordIdempotency,
+		RequestHash: requestHash(body),
+		StatusCode:  response.StatusCode,
+		Body:        response.Body,
+		CreatedAt:   now.Format(time.RFC3339),
+		ExpiresAt:   now.Add(IdempotencyTTL).Unix(),
+	})
+	if err != nil {
+		return types.TransactWriteItem{}, err
+	}
+	return types.TransactWriteItem{
+		Put: &types.Put{
+			TableName:           aws.String(h.tableName),
+			Item:                item,
+			// Un registro caducado que el TTL aún no borró (tarda hasta 48 h) se reemplaza
+			ConditionExpression: aws.String("attribute_not_exists(id) OR expires_at < :now"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			},
+		},
+	}, nil
+}
+
+// replayIdempotent devuelve la respuesta guardada para la clave, si existe y no expiró
+func (h *ProductHandler) replayIdempotent(ctx context.Context, operation, key, body string, headers map[string]string) (*events.APIGatewayProxyResponse, error) {
+	result, err := h.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(h.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: idempotencyRecordID(operation, key)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading idempotency record: %w", err)
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var record idempotencyRecord
+	if err := attributevalue.UnmarshalMap(result.Item, &record); err != nil {
+		return nil, fmt.Errorf("unmarshaling idempotency record: %w", err)
+	}
+	// El TTL de DynamoDB borra con retraso
+	if record.ExpiresAt < time.Now().Unix() {
+		return nil, nil
+	}
+	if record.RequestHash != requestHash(body) {
+		return nil, ErrIdempotencyKeyReused
+	}
+
+	replayHeaders := make(map[string]string, len(headers)+1)
+	for name, value := range headers {
+		replayHeaders[name] = value
+	}
+	replayHeaders["Idempotent-Replayed"] = "true"
+	return &events.APIGatewayProxyResponse{
+		StatusCode: record.StatusCode,
+		Headers:    replayHeaders,
+		Body:       record.Body,
+	}, nil
+}
+
+// cancellationReasons devuelve el código de cada operación de una transacción
+// cancelada, o nil si el error es de otro tipo
+func cancellationReasons(err error) []string {
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return nil
+	}
+	codes := make([]string, len(canceled.CancellationReasons))
+	for i, reason := range canceled.CancellationReasons {
+		codes[i] = aws.ToString(reason.Code)
+	}
+	return codes
+}
+
+// cancellationItem devuelve el item actual de la operación i si su condición falló
+// y se pidió ReturnValuesOnConditionCheckFailure
+func cancellationItem(err error, i int) map[string]types.AttributeValue {
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) || i >= len(canceled.CancellationReasons) {
+		return nil
+	}
+	return canceled.CancellationReasons[i].Item
+}
+
+// conditionFailedAt indica si la operación i de la transacción falló por su condición
+func conditionFailedAt(codes []string, i int) bool {
+	return i < len(codes) && codes[i] == "ConditionalCheckFailed"
+}
+
+// validIdempotencyKey acepta hasta 255 caracteres ASCII imprimibles
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeySize {
+		return false
+	}
+	for _, r := range key {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,752 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Reservas de stock para los pedidos (el workflow de GCP). Reservar descuenta el
+// stock del producto o de la variante en la misma transacción que crea el
+// registro de la reserva, condicionada a la versión leída; consumir la confirma
+// y liberar devuelve el stock. Una reserva sin consumir caduca: el TTL de
+// DynamoDB borra el registro y el stream devuelve el stock, y POST
+// /reservations/expire lo hace sin esperar al TTL. Una reserva consumida se
+// puede reponer por partes (devoluciones y reembolsos del pedido). El ID lo
+// elige el cliente, así que repetir cualquier llamada no reserva ni devuelve
+// stock dos veces.
+
+// Reservas
+const (
+	RecordReservation       = "reservation"
+	RecordReservationExpiry = "reservation_expiry"
+
+	ReservationHeld     = "held"
+	ReservationConsumed = "consumed"
+	ReservationReleased = "released"
+	ReservationExpired  = "expired"
+
+	DefaultReservationTTL = 15 * time.Minute
+	MaxReservationTTL     = 24 * time.Hour
+	ReservationRetention  = 7 * 24 * time.Hour  // Registro de las reservas ya cerradas
+	ConsumedRetention     = 90 * 24 * time.Hour // Las consumidas se guardan mientras se admiten devoluciones
+)
+
+// errReservationChanged -> El estado de la reserva cambió desde que se leyó
+var errReservationChanged = errors.New("reservation changed concurrently")
+
+// Reservation -> Stock apartado para un pedido
+type Reservation struct {
+	ID            string    `json:"id" dynamodbav:"reservation_id"`
+	ProductID     string    `json:"product_id" dynamodbav:"product_id"`
+	VariantID     string    `json:"variant_id,omitempty" dynamodbav:"variant_id,omitempty"`
+	SKU           string    `json:"sku" dynamodbav:"sku"`
+	Quantity      int       `json:"quantity" dynamodbav:"quantity"`
+	Returned      int       `json:"returned,omitempty" dynamodbav:"returned,omitempty"` // Unidades consumidas que se repusieron
+	RestockIDs    []string  `json:"-" dynamodbav:"restock_ids,stringset,omitempty"`
+	Status        string    `json:"status" dynamodbav:"state"` // "status" metería el item en status-index
+	Reference     string    `json:"reference,omitempty" dynamodbav:"reference,omitempty"`
+	HoldExpiresAt time.Time `json:"hold_expires_at" dynamodbav:"hold_expires_at"`
+	CreatedAt     time.Time `json:"created_at" dynamodbav:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" dynamodbav:"updated_at"`
+	ExpiresAt     int64     `json:"-" dynamodbav:"expires_at"` // TTL: fin del hold y, al cerrarse, de la retención
+}
+
+// CreateReservationRequest -> Para reservar stock
+type CreateReservationRequest struct {
+	ID         string `json:"reservation_id"`
+	ProductID  string `json:"product_id"`
+	SKU        string `json:"sku,omitempty"` // Obligatorio si el producto tiene variantes
+	Quantity   int    `json:"quantity"`
+	TTLSeconds int    `json:"ttl_seconds,omitempty"`
+	Reference  string `json:"reference,omitempty"` // Pedido que reserva
+}
+
+// RestockRequest -> Para reponer parte de una reserva consumida
+type RestockRequest struct {
+	ID       string `json:"restock_id"` // Idempotencia: repetirlo no repone dos veces
+	Quantity int    `json:"quantity"`
+}
+
+// ExpireReport -> Resultado de POST /reservations/expire
+type ExpireReport struct {
+	Candidates int               `json:"candidates"`
+	Expired    []string          `json:"expired"`
+	Skipped    map[string]string `json:"skipped,omitempty"` // id -> motivo
+}
+
+func (r *CreateReservationRequest) Validate() ValidationErrors {
+	var errs ValidationErrors
+	if r.ID == "" || !validIdempotencyKey(r.ID) {
+		errs = append(errs, ValidationError{Field: "reservation_id", Message: "is required (up to 255 printable ASCII characters)"})
+	}
+	if strings.TrimSpace(r.ProductID) == "" {
+		errs = append(errs, ValidationError{Field: "product_id", Message: "is required"})
+	}
+	if r.Quantity <= 0 {
+		errs = append(errs, ValidationError{Field: "quantity", Message: "must be greater than 0"})
+	}
+	if r.TTLSeconds < 0 || time.Duration(r.TTLSeconds)*time.Second > MaxReservationTTL {
+		errs = append(errs, ValidationError{Field: "ttl_seconds", Message: fmt.Sprintf("must be between 1 and %d", int(MaxReservationTTL.Seconds()))})
+	}
+	return errs
+}
+
+func (r *CreateReservationRequest) ttl() time.Duration {
+	if r.TTLSeconds == 0 {
+		return DefaultReservationTTL
+	}
+	return time.Duration(r.TTLSeconds) * time.Second
+}
+
+// sameRequest indica si una reserva existente corresponde a esta petición
+func (r *CreateReservationRequest) sameRequest(reservation *Reservation) bool {
+	return reservation.ProductID == r.ProductID &&
+		reservation.Quantity == r.Quantity &&
+		(r.SKU == "" || strings.EqualFold(reservation.SKU, strings.TrimSpace(r.SKU)))
+}
+
+func (r *RestockRequest) Validate() ValidationErrors {
+	var errs ValidationErrors
+	if r.ID == "" || !validIdempotencyKey(r.ID) {
+		errs = append(errs, ValidationError{Field: "restock_id", Message: "is required (up to 255 printable ASCII characters)"})
+	}
+	if r.Quantity <= 0 {
+		errs = append(errs, ValidationError{Field: "quantity", Message: "must be greater than 0"})
+	}
+	return errs
+}
+
+func reservationKey(reservationID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"id": &types.AttributeValueMemberS{Value: "reservation#" + reservationID},
+	}
+}
+
+// reservationItem -> Item de la reserva en la tabla
+func reservationItem(reservation *Reservation) (map[string]types.AttributeValue, error) {
+	item, err := attributevalue.MarshalMap(reservation)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling reservation: %w", err)
+	}
+	item["id"] = reservationKey(reservation.ID)["id"]
+	item[recordTypeAttribute] = &types.AttributeValueMemberS{Value: RecordReservation}
+	return item, nil
+}
+
+// readReservation lee una reserva con lectura consistente; nil si no existe
+func (h *ProductHandler) readReservation(ctx context.Context, reservationID string) (*Reservation, error) {
+	result, err := h.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(h.tableName),
+		Key:            reservationKey(reservationID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading reservation: %w", err)
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	var reservation Reservation
+	if err := attributevalue.UnmarshalMap(result.Item, &reservation); err != nil {
+		return nil, fmt.Errorf("unmarshaling reservation: %w", err)
+	}
+	return &reservation, nil
+}
+
+// reservationTarget devuelve la variante que se reserva (-1 = el producto). Un
+// producto con variantes solo se reserva por el SKU de una de ellas.
+func reservationTarget(product *Product, sku string) (int, ValidationErrors) {
+	sku = strings.TrimSpace(sku)
+	if sku == "" || strings.EqualFold(sku, product.SKU) {
+		if len(product.Variants) > 0 {
+			return -1, ValidationErrors{{Field: "sku", Message: "product has variants, reserve one of them by its SKU"}}
+		}
+		return -1, nil
+	}
+	for i := range product.Variants {
+		if strings.EqualFold(sku, product.Variants[i].SKU) {
+			return i, nil
+		}
+	}
+	return -1, ValidationErrors{{Field: "sku", Message: "does not belong to the product"}}
+}
+
+// writeStockChange suma delta al stock del producto (o de la variante vi) con la
+// versión + 1, en la misma transacción que las escrituras de la reserva, y
+// actualiza el producto en el índice de búsqueda
+func (h *ProductHandler) writeStockChange(ctx context.Context, current *Product, vi, delta int, now time.Time, writes ...types.TransactWriteItem) error {
+	values := map[string]types.AttributeValue{
+		":updated_at":   &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+		":next_version": &types.AttributeValueMemberN{Value: strconv.FormatInt(current.Version+1, 10)},
+	}
+	update := "SET "
+	updated := *current
+	stock := current.Stock + delta
+	if vi >= 0 {
+		variants := append([]Variant(nil), current.Variants...)
+		variants[vi].Stock += delta
+		variants[vi].UpdatedAt = now
+		list, err := attributevalue.Marshal(variants)
+		if err != nil {
+			return fmt.Errorf("marshaling variants: %w", err)
+		}
+		values[":variants"] = list
+		update += "variants = :variants, "
+		stock = variantStock(variants)
+		updated.Variants = variants
+	}
+	values[":stock"] = &types.AttributeValueMemberN{Value: strconv.Itoa(stock)}
+	values[":status"] = &types.AttributeValueMemberS{Value: deriveStatus(current.Status, stock)}
+	update += "stock = :stock, #status = :status, updated_at = :updated_at, version = :next_version"
+	read := versionPrecondition{versions: []int64{current.Version}}
+
+	_, err := h.dynamoClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append([]types.TransactWriteItem{
+			{Update: &types.Update{
+				TableName: aws.String(h.tableName),
+				Key: map[string]types.AttributeValue{
+					"id": &types.AttributeValueMemberS{Value: current.ID},
+				},
+				UpdateExpression:          aws.String(update),
+				ConditionExpression:       aws.String("attribute_exists(id) AND " + read.condition(values)),
+				ExpressionAttributeNames:  map[string]string{"#status": "status"},
+				ExpressionAttributeValues: values,
+			}},
+		}, writes...),
+	})
+	if err != nil {
+		return err
+	}
+
+	updated.Stock = stock
+	updated.Status = deriveStatus(current.Status, stock)
+	updated.UpdatedAt = now
+	updated.Version = current.Version + 1
+	h.search.Upsert(updated)
+	return nil
+}
+
+// conditionFailedFrom indica si falló la condición de alguna escritura desde la i
+func conditionFailedFrom(codes []string, i int) bool {
+	for ; i < len(codes); i++ {
+		if conditionFailedAt(codes, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// returnStock devuelve al producto quantity unidades de la reserva junto con
+// writes, el cambio de estado de la reserva. Si el producto se purgó o la
+// variante ya no existe solo se escriben los de la reserva.
+func (h *ProductHandler) returnStock(ctx context.Context, reservation *Reservation, quantity int, writes ...types.TransactWriteItem) error {
+	for attempt := 1; ; attempt++ {
+		item, err := h.fetchProductItem(ctx, reservation.ProductID)
+		if err != nil {
+			return fmt.Errorf("reading product: %w", err)
+		}
+		vi := -1
+		var current Product
+		if item != nil {
+			if err := attributevalue.UnmarshalMap(item, &current); err != nil {
+				return fmt.Errorf("unmarshaling product: %w", err)
+			}
+			if reservation.VariantID != "" {
+				vi = current.variantIndex(reservation.VariantID)
+			}
+		}
+
+		if item == nil || (reservation.VariantID != "" && vi < 0) {
+			_, err := h.dynamoClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+				TransactItems: writes,
+			})
+			if conditionFailedFrom(cancellationReasons(err), 0) {
+				return errReservationChanged
+			}
+			return err
+		}
+
+		err = h.writeStockChange(ctx, &current, vi, quantity, time.Now().UTC(), writes...)
+		codes := cancellationReasons(err)
+		switch {
+		case err == nil:
+			return nil
+		case conditionFailedFrom(codes, 1):
+			return errReservationChanged
+		case conditionFailedAt(codes, 0) && attempt < maxUpdateAttempts:
+			continue
+		case conditionFailedAt(codes, 0):
+			return errors.New("product is being modified concurrently")
+		}
+		return err
+	}
+}
+
+// closeReservationUpdate pasa la reserva de from a to y programa su borrado
+func (h *ProductHandler) closeReservationUpdate(reservationID, from, to string, now time.Time) types.TransactWriteItem {
+	return types.TransactWriteItem{
+		Update: &types.Update{
+			TableName:           aws.String(h.tableName),
+			Key:                 reservationKey(reservationID),
+			UpdateExpression:    aws.String("SET #state = :to, updated_at = :updated_at, expires_at = :expires_at"),
+			ConditionExpression: aws.String("attribute_exists(id) AND #state = :from"),
+			ExpressionAttributeNames: map[string]string{
+				"#state": "state",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":from":       &types.AttributeValueMemberS{Value: from},
+				":to":         &types.AttributeValueMemberS{Value: to},
+				":updated_at": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+				":expires_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(ReservationRetention).Unix(), 10)},
+			},
+		},
+	}
+}
+
+// reservationExpiryID -> Marca de que el hold se devolvió. Lleva el created_at
+// de la reserva: si el cliente reutiliza el ID, la nueva reserva tiene otra marca.
+func reservationExpiryID(reservation *Reservation) string {
+	return fmt.Sprintf("reservation_expiry#%s#%d", reservation.ID, reservation.CreatedAt.UnixNano())
+}
+
+// expireReservation devuelve el stock de un hold vencido. La marca de
+// reservationExpiryID va en la misma transacción, así que repetirlo (barrido y
+// stream, o reintentos del lote) no devuelve stock dos veces. Con removed el TTL
+// ya borró la reserva y no se recrea: el ID queda libre. Si no, la reserva pasa
+// a expired siempre que siga siendo el mismo hold (mismo created_at).
+func (h *ProductHandler) expireReservation(ctx context.Context, reservation Reservation, now time.Time, removed bool) error {
+	writes := []types.TransactWriteItem{{
+		Put: &types.Put{
+			TableName: aws.String(h.tableName),
+			Item: map[string]types.AttributeValue{
+				"id":                &types.AttributeValueMemberS{Value: reservationExpiryID(&reservation)},
+				recordTypeAttribute: &types.AttributeValueMemberS{Value: RecordReservationExpiry},
+				"reservation_id":    &types.AttributeValueMemberS{Value: reservation.ID},
+				"expires_at":        &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(ReservationRetention).Unix(), 10)},
+			},
+			ConditionExpression: aws.String("attribute_not_exists(id)"),
+		},
+	}}
+
+	if !removed {
+		createdAt, err := attributevalue.Marshal(reservation.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("marshaling created_at: %w", err)
+		}
+		reservation.Status = ReservationExpired
+		reservation.UpdatedAt = now
+		reservation.ExpiresAt = now.Add(ReservationRetention).Unix()
+		item, err := reservationItem(&reservation)
+		if err != nil {
+			return err
+		}
+		writes = append(writes, types.TransactWriteItem{
+			Put: &types.Put{
+				TableName:           aws.String(h.tableName),
+				Item:                item,
+				ConditionExpression: aws.String("#state = :held AND created_at = :created_at AND expires_at <= :now"),
+				ExpressionAttributeNames: map[string]string{
+					"#state": "state",
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":held":       &types.AttributeValueMemberS{Value: ReservationHeld},
+					":created_at": createdAt,
+					":now":        &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+				},
+			},
+		})
+	}
+	return h.returnStock(ctx, &reservation, reservation.Quantity, writes...)
+}
+
+// expireStreamReservations devuelve el stock de los holds que borró el TTL
+func (h *ProductHandler) expireStreamReservations(ctx context.Context, records []events.DynamoDBEventRecord) error {
+	for _, record := range records {
+		if record.EventName != "REMOVE" || len(record.Change.OldImage) == 0 {
+			continue
+		}
+		item := streamImageItem(record.Change.OldImage)
+		if recordType, ok := item[recordTypeAttribute].(*types.AttributeValueMemberS); !ok || recordType.Value != RecordReservation {
+			continue
+		}
+		var reservation Reservation
+		if err := attributevalue.UnmarshalMap(item, &reservation); err != nil {
+			return fmt.Errorf("record %s: unmarshaling reservation: %w", record.EventID, err)
+		}
+		if reservation.Status != ReservationHeld {
+			continue
+		}
+		err := h.expireReservation(ctx, reservation, time.Now().UTC(), true)
+		if errors.Is(err, errReservationChanged) {
+			continue // Ya devuelto en un intento anterior del lote
+		}
+		if err != nil {
+			return fmt.Errorf("expiring reservation %s: %w", reservation.ID, err)
+		}
+		log.Printf("⏰ Reservation %s expired, %d units of %s returned", reservation.ID, reservation.Quantity, reservation.SKU)
+	}
+	return nil
+}
+
+// ExpireReservations devuelve el stock de los holds vencidos que el TTL aún no borró
+func (h *ProductHandler) ExpireReservations(ctx context.Context, now time.Time) (*ExpireReport, error) {
+	var expired []Reservation
+	var startKey map[string]types.AttributeValue
+	for {
+		result, err := h.dynamoClient.Scan(ctx, &dynamodb.ScanInput{
+			TableName:        aws.String(h.tableName),
+			FilterExpression: aws.String("record_type = :record_type AND #state = :held AND expires_at <= :now"),
+			ExpressionAttributeNames: map[string]string{
+				"#state": "state",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":record_type": &types.AttributeValueMemberS{Value: RecordReservation},
+				":held":        &types.AttributeValueMemberS{Value: ReservationHeld},
+				":now":         &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scanning reservations: %w", err)
+		}
+		for _, item := range result.Items {
+			var reservation Reservation
+			if err := attributevalue.UnmarshalMap(item, &reservation); err != nil {
+				return nil, fmt.Errorf("unmarshaling reservation: %w", err)
+			}
+			expired = append(expired, reservation)
+		}
+		if len(result.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = result.LastEvaluatedKey
+	}
+
+	report := &ExpireReport{Candidates: len(expired), Expired: []string{}}
+	for _, reservation := range expired {
+		if err := h.expireReservation(ctx, reservation, now, false); err != nil {
+			if report.Skipped == nil {
+				report.Skipped = map[string]string{}
+			}
+			report.Skipped[reservation.ID] = err.Error()
+			continue
+		}
+		report.Expired = append(report.Expired, reservation.ID)
+	}
+	if report.Candidates > 0 {
+		log.Printf("⏰ Expired %d/%d stock reservations", len(report.Expired), report.Candidates)
+	}
+	return report, nil
+}
+
+// reservationResponse -> Respuesta con una reserva; replayed marca las repetidas
+func (h *ProductHandler) reservationResponse(headers map[string]string, statusCode int, message string, reservation *Reservation, replayed bool) events.APIGatewayProxyResponse {
+	if replayed {
+		headers["Idempotent-Replayed"] = "true"
+	}
+	body, _ := json.Marshal(ProductResponse{
+		Success: true,
+		Message: message,
+		Data:    reservation,
+	})
+	return events.APIGatewayProxyResponse{StatusCode: statusCode, Headers: headers, Body: string(body)}
+}
+
+// createReservation reserva stock. Repetir la petición con el mismo
+// reservation_id devuelve la reserva existente (200) sin volver a descontar.
+func (h *ProductHandler) createReservation(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	var req CreateReservationRequest
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+		return h.errorResponse(headers, 400, "Invalid JSON body"), nil
+	}
+	if errs := req.Validate(); len(errs) > 0 {
+		return h.validationErrorResponse(headers, errs), nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		existing, err := h.readReservation(ctx, req.ID)
+		if err != nil {
+			return h.errorResponse(headers, 500, err.Error()), nil
+		}
+		if existing != nil {
+			switch {
+			case !req.sameRequest(existing):
+				return h.codedErrorResponse(headers, 409, ErrorCodeReservationConflict, "Reservation ID already used for a different request"), nil
+			case existing.Status == ReservationReleased || existing.Status == ReservationExpired:
+				return h.codedErrorResponse(headers, 409, ErrorCodeReservationConflict, fmt.Sprintf("Reservation is already %s", existing.Status)), nil
+			}
+			return h.reservationResponse(headers, 200, "Reservation already exists", existing, true), nil
+		}
+
+		item, err := h.fetchProductItem(ctx, req.ProductID)
+		if err != nil {
+			return h.errorResponse(headers, 500, fmt.Sprintf("Error getting product: %v", err)), nil
+		}
+		if item == nil {
+			return h.codedErrorResponse(headers, 404, ErrorCodeProductNotFound, "Product not found"), nil
+		}
+		var current Product
+		if err := attributevalue.UnmarshalMap(item, &current); err != nil {
+			return h.errorResponse(headers, 500, fmt.Sprintf("Error unmarshaling product: %v", err)), nil
+		}
+		if current.IsDeleted() || current.Status == StatusInactive {
+			return h.codedErrorResponse(headers, 409, ErrorCodeProductUnavailable, "Product is not available"), nil
+		}
+		vi, errs := reservationTarget(&current, req.SKU)
+		if len(errs) > 0 {
+			return h.validationErrorResponse(headers, errs), nil
+		}
+
+		now := time.Now().UTC()
+		reservation := Reservation{
+			ID:            req.ID,
+			ProductID:     current.ID,
+			SKU:           current.SKU,
+			Quantity:      req.Quantity,
+			Status:        ReservationHeld,
+			Reference:     req.Reference,
+			HoldExpiresAt: now.Add(req.ttl()),
+			CreatedAt:     now,
+			UpdatedAt:     now,
+			ExpiresAt:     now.Add(req.ttl()).Unix(),
+		}
+		available := current.Stock
+		if vi >= 0 {
+			reservation.VariantID = current.Variants[vi].ID
+			reservation.SKU = current.Variants[vi].SKU
+			available = current.Variants[vi].Stock
+		}
+		if available < req.Quantity {
+			return h.codedErrorResponse(headers, 409, ErrorCodeInsufficientStock, fmt.Sprintf("Insufficient stock for SKU %s: requested %d, available %d", reservation.SKU, req.Quantity, available)), nil
+		}
+
+		reservationPut, err := reservationItem(&reservation)
+		if err != nil {
+			return h.errorResponse(headers, 500, err.Error()), nil
+		}
+		err = h.writeStockChange(ctx, &current, vi, -req.Quantity, now, types.TransactWriteItem{
+			Put: &types.Put{
+				TableName:           aws.String(h.tableName),
+				Item:                reservationPut,
+				ConditionExpression: aws.String("attribute_not_exists(id)"),
+			},
+		})
+		if err == nil {
+			log.Printf("📦 Reserved %d units of %s (%s)", reservation.Quantity, reservation.SKU, reservation.ID)
+			return h.reservationResponse(headers, 201, "Stock reserved successfully", &reservation, false), nil
+		}
+
+		// 0: otra escritura cambió el producto; 1: una petición concurrente con el
+		// mismo ID creó la reserva (la siguiente vuelta la devuelve)
+		codes := cancellationReasons(err)
+		if (conditionFailedAt(codes, 0) || conditionFailedAt(codes, 1)) && attempt < maxUpdateAttempts {
+			continue
+		}
+		if conditionFailedAt(codes, 0) {
+			return h.codedErrorResponse(headers, 409, ErrorCodeConcurrentUpdate, "Product is being modified concurrently, please retry"), nil
+		}
+		return h.errorResponse(headers, 500, fmt.Sprintf("Error reserving stock: %v", err)), nil
+	}
+}
+
+// getReservation devuelve una reserva
+func (h *ProductHandler) getReservation(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	reservation, err := h.readReservation(ctx, request.PathParameters["reservationId"])
+	if err != nil {
+		return h.errorResponse(headers, 500, err.Error()), nil
+	}
+	if reservation == nil {
+		return h.codedErrorResponse(headers, 404, ErrorCodeReservationNotFound, "Reservation not found"), nil
+	}
+	return h.reservationResponse(headers, 200, "Reservation retrieved successfully", reservation, false), nil
+}
+
+// consumeReservation confirma un hold vigente (el stock ya está descontado).
+// Consumir dos veces devuelve 200; un hold vencido, 410.
+func (h *ProductHandler) consumeReservation(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	reservationID := request.PathParameters["reservationId"]
+	now := time.Now().UTC()
+	result, err := h.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(h.tableName),
+		Key:                 reservationKey(reservationID),
+		UpdateExpression:    aws.String("SET #state = :consumed, updated_at = :updated_at, expires_at = :expires_at"),
+		ConditionExpression: aws.String("attribute_exists(id) AND #state = :held AND expires_at > :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#state": "state",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":consumed":   &types.AttributeValueMemberS{Value: ReservationConsumed},
+			":held":       &types.AttributeValueMemberS{Value: ReservationHeld},
+			":now":        &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			":updated_at": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+			":expires_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(ConsumedRetention).Unix(), 10)},
+		},
+		ReturnValues:                        types.ReturnValueAllNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	switch {
+	case errors.As(err, &conditionFailed):
+		if conditionFailed.Item == nil {
+			return h.codedErrorResponse(headers, 404, ErrorCodeReservationNotFound, "Reservation not found"), nil
+		}
+		var current Reservation
+		if err := attributevalue.UnmarshalMap(conditionFailed.Item, &current); err != nil {
+			return h.errorResponse(headers, 500, fmt.Sprintf("Error unmarshaling reservation: %v", err)), nil
+		}
+		switch current.Status {
+		case ReservationConsumed:
+			return h.reservationResponse(headers, 200, "Reservation already consumed", &current, true), nil
+		case ReservationHeld:
+			return h.codedErrorResponse(headers, 410, ErrorCodeReservationExpired, "Reservation expired"), nil
+		}
+		return h.codedErrorResponse(headers, 409, ErrorCodeReservationConflict, fmt.Sprintf("Reservation is already %s", current.Status)), nil
+	case err != nil:
+		return h.errorResponse(headers, 500, fmt.Sprintf("Error consuming reservation: %v", err)), nil
+	}
+
+	var reservation Reservation
+	if err := attributevalue.UnmarshalMap(result.Attributes, &reservation); err != nil {
+		return h.errorResponse(headers, 500, fmt.Sprintf("Error unmarshaling reservation: %v", err)), nil
+	}
+	log.Printf("✅ Consumed reservation %s (%d units of %s)", reservation.ID, reservation.Quantity, reservation.SKU)
+	return h.reservationResponse(headers, 200, "Reservation consumed successfully", &reservation, false), nil
+}
+
+// releaseReservation devuelve el stock de una reserva, retenida o ya consumida
+// (pedido cancelado tras el cobro); de una consumida, lo que no se repuso ya.
+// Liberar dos veces devuelve 200.
+func (h *ProductHandler) releaseReservation(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	reservationID := request.PathParameters["reservationId"]
+	for attempt := 1; ; attempt++ {
+		reservation, err := h.readReservation(ctx, reservationID)
+		if err != nil {
+			return h.errorResponse(headers, 500, err.Error()), nil
+		}
+		if reservation == nil {
+			return h.codedErrorResponse(headers, 404, ErrorCodeReservationNotFound, "Reservation not found"), nil
+		}
+		if reservation.Status == ReservationReleased || reservation.Status == ReservationExpired {
+			return h.reservationResponse(headers, 200, fmt.Sprintf("Reservation already %s", reservation.Status), reservation, true), nil
+		}
+
+		now := time.Now().UTC()
+		err = h.returnStock(ctx, reservation, reservation.Quantity-reservation.Returned, h.closeReservationUpdate(reservation.ID, reservation.Status, ReservationReleased, now))
+		switch {
+		case errors.Is(err, errReservationChanged) && attempt < maxUpdateAttempts:
+			continue // Consumida, liberada o caducada entretanto: se vuelve a leer
+		case err != nil:
+			return h.errorResponse(headers, 500, fmt.Sprintf("Error releasing reservation: %v", err)), nil
+		}
+
+		log.Printf("↩️  Released reservation %s (%d units of %s)", reservation.ID, reservation.Quantity-reservation.Returned, reservation.SKU)
+		reservation.Status = ReservationReleased
+		reservation.UpdatedAt = now
+		reservation.ExpiresAt = now.Add(ReservationRetention).Unix()
+		return h.reservationResponse(headers, 200, "Reservation released successfully", reservation, false), nil
+	}
+}
+
+// restockReservation repone parte de una reserva consumida (devolución o
+// reembolso de unidades del pedido). Repetir el restock_id devuelve 200 sin
+// volver a reponer; reponer todo lo consumido deja la reserva como released.
+func (h *ProductHandler) restockReservation(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	var req RestockRequest
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+		return h.errorResponse(headers, 400, "Invalid JSON body"), nil
+	}
+	if errs := req.Validate(); len(errs) > 0 {
+		return h.validationErrorResponse(headers, errs), nil
+	}
+
+	reservationID := request.PathParameters["reservationId"]
+	for attempt := 1; ; attempt++ {
+		reservation, err := h.readReservation(ctx, reservationID)
+		if err != nil {
+			return h.errorResponse(headers, 500, err.Error()), nil
+		}
+		if reservation == nil {
+			return h.codedErrorResponse(headers, 404, ErrorCodeReservationNotFound, "Reservation not found"), nil
+		}
+		for _, id := range reservation.RestockIDs {
+			if id == req.ID {
+				return h.reservationResponse(headers, 200, "Restock already applied", reservation, true), nil
+			}
+		}
+		if reservation.Status != ReservationConsumed {
+			return h.codedErrorResponse(headers, 409, ErrorCodeReservationConflict, fmt.Sprintf("Reservation is %s, only consumed reservations can be restocked", reservation.Status)), nil
+		}
+		if left := reservation.Quantity - reservation.Returned; req.Quantity > left {
+			return h.codedErrorResponse(headers, 409, ErrorCodeReservationConflict, fmt.Sprintf("Cannot restock %d units of %s: only %d left", req.Quantity, reservation.SKU, left)), nil
+		}
+
+		now := time.Now().UTC()
+		returned := reservation.Returned + req.Quantity
+		state := ReservationConsumed
+		if returned == reservation.Quantity {
+			state = ReservationReleased
+		}
+		write := types.TransactWriteItem{
+			Update: &types.Update{
+				TableName:           aws.String(h.tableName),
+				Key:                 reservationKey(reservation.ID),
+				UpdateExpression:    aws.String("SET #state = :state, returned = :returned, updated_at = :updated_at ADD restock_ids :restock_ids"),
+				ConditionExpression: aws.String("attribute_exists(id) AND #state = :consumed AND (attribute_not_exists(returned) OR returned = :previous)"),
+				ExpressionAttributeNames: map[string]string{
+					"#state": "state",
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":state":       &types.AttributeValueMemberS{Value: state},
+					":consumed":    &types.AttributeValueMemberS{Value: ReservationConsumed},
+					":returned":    &types.AttributeValueMemberN{Value: strconv.Itoa(returned)},
+					":previous":    &types.AttributeValueMemberN{Value: strconv.Itoa(reservation.Returned)},
+					":restock_ids": &types.AttributeValueMemberSS{Value: []string{req.ID}},
+					":updated_at":  &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+				},
+			},
+		}
+		err = h.returnStock(ctx, reservation, req.Quantity, write)
+		switch {
+		case errors.Is(err, errReservationChanged) && attempt < maxUpdateAttempts:
+			continue // Otra reposición o la liberación se adelantó: se vuelve a leer
+		case err != nil:
+			return h.errorResponse(headers, 500, fmt.Sprintf("Error restocking reservation: %v", err)), nil
+		}
+
+		log.Printf("📥 Restocked %d units of %s (%s)", req.Quantity, reservation.SKU, reservation.ID)
+		reservation.Status = state
+		reservation.Returned = returned
+		reservation.RestockIDs = append(reservation.RestockIDs, req.ID)
+		reservation.UpdatedAt = now
+		return h.reservationResponse(headers, 200, "Reservation restocked successfully", reservation, false), nil
+	}
+}
+
+// expireReservations ejecuta el barrido de holds vencidos (Scheduler o manual)
+func (h *ProductHandler) expireReservations(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	report, err := h.ExpireReservations(ctx, time.Now().UTC())
+	if err != nil {
+		return h.errorResponse(headers, 500, fmt.Sprintf("Error expiring reservations: %v", err)), nil
+	}
+
+	response := ProductResponse{
+		Success: true,
+		Message: "Expired reservations released successfully",
+		Data:    report,
+	}
+
+	return h.successResponse(headers, response), nil
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// routeHandler -> Firma común de los handlers de la API
+type routeHandler func(h *ProductHandler, ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error)
+
+// route -> Método + plantilla de ruta ({param} captura un segmento)
+type route struct {
+	method   string
+	template string
+	segments []string
+	handle   routeHandler
+	internal bool // Otros servicios y mantenimiento: exige la clave de APIKeyHeader
+}
+
+// productRoutes -> Tabla de rutas de la API. El orden no importa: un segmento
+// fijo siempre gana a un parámetro (/products/stats antes que /products/{id}).
+var productRoutes = newRouteTable([]route{
+	{method: "GET", template: "/products", handle: (*ProductHandler).listProducts},
+	{method: "POST", template: "/products", handle: (*ProductHandler).createProduct},
+	{method: "GET", template: "/products/stats", handle: (*ProductHandler).getProductStats},
+	{method: "POST", template: "/products/stats/rebuild", handle: (*ProductHandler).rebuildProductStats, internal: true},
+	{method: "GET", template: "/products/export", handle: (*ProductHandler).exportCatalog},
+	{method: "POST", template: "/products/bulk", handle: (*ProductHandler).bulkImportProducts, internal: true},
+	{method: "POST", template: "/products/purge", handle: (*ProductHandler).purgeDeletedProducts, internal: true},
+	{method: "POST", template: "/products/search/reindex", handle: (*ProductHandler).reindexProducts, internal: true},
+	{method: "GET", template: "/products/{id}", handle: (*ProductHandler).getProduct},
+	{method: "PUT", template: "/products/{id}", handle: (*ProductHandler).updateProduct},
+	{method: "DELETE", template: "/products/{id}", handle: (*ProductHandler).deleteProduct},
+	{method: "POST", template: "/products/{id}/restore", handle: (*ProductHandler).restoreProduct},
+	{method: "GET", template: "/products/{id}/price-history", handle: (*ProductHandler).getPriceHistory},
+	{method: "GET", template: "/products/{id}/variants", handle: (*ProductHandler).listVariants},
+	{method: "POST", template: "/products/{id}/variants", handle: (*ProductHandler).createVariant},
+	{method: "GET", template: "/products/{id}/variants/{variantId}", handle: (*ProductHandler).getVariant},
+	{method: "PUT", template: "/products/{id}/variants/{variantId}", handle: (*ProductHandler).updateVariant},
+	{method: "DELETE", template: "/products/{id}/variants/{variantId}", handle: (*ProductHandler).deleteVariant},
+	{method: "POST", template: "/reservations", handle: (*ProductHandler).createReservation, internal: true},
+	{method: "POST", template: "/reservations/expire", handle: (*ProductHandler).expireReservations, internal: true},
+	{method: "GET", template: "/reservations/{reservationId}", handle: (*ProductHandler).getReservation, internal: true},
+	{method: "POST", template: "/reservations/{reservationId}/consume", handle: (*ProductHandler).consumeReservation, internal: true},
+	{method: "POST", template: "/reservations/{reservationId}/release", handle: (*ProductHandler).releaseReservation, internal: true},
+	{method: "POST", template: "/reservations/{reservationId}/restock", handle: (*ProductHandler).restockReservation, internal: true},
+})
+
+// newRouteTable prepara los segmentos y ordena las rutas por especificidad
+func newRouteTable(routes []route) []route {
+	for i := range routes {
+		routes[i].segments = splitPath(routes[i].template)
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routeSpecificity(routes[i].segments) > routeSpecificity(routes[j].segments)
+	})
+	return routes
+}
+
+// routeSpecificity puntúa los segmentos fijos, con más peso cuanto antes aparecen
+func routeSpecificity(segments []string) int {
+	score := 0
+	for i, segment := range segments {
+		if !isParam(segment) {
+			score += 1 << (16 - i)
+		}
+	}
+	return score
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func isParam(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+// match compara la ruta con la plantilla y devuelve los parámetros capturados
+func (r *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+	var params map[string]string
+	for i, segment := range r.segments {
+		if isParam(segment) {
+			value, err := url.PathUnescape(segments[i])
+			if err != nil || value == "" {
+				return nil, false
+			}
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[strings.Trim(segment, "{}")] = value
+			continue
+		}
+		if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// resolveRoute busca la ruta para método y path. Devuelve también los métodos
+// permitidos en ese path para responder 405 y OPTIONS.
+func resolveRoute(method, path string) (*route, map[string]string, []string) {
+	segments := splitPath(path)
+
+	var found *route
+	var foundParams map[string]string
+	var matchedTemplate string
+	var allowed []string
+	for i := range productRoutes {
+		r := &productRoutes[i]
+		params, ok := r.match(segments)
+		if !ok {
+			continue
+		}
+		// Solo cuenta la plantilla más específica que encaja con el path
+		if matchedTemplate == "" {
+			matchedTemplate = r.template
+		}
+		if r.template != matchedTemplate {
+			continue
+		}
+		allowed = append(allowed, r.method)
+		if r.method == method && found == nil {
+			found, foundParams = r, params
+		}
+	}
+	return found, foundParams, allowed
+}
+
+// defaultHeaders -> Cabeceras comunes (CORS) de todas las respuestas
+func defaultHeaders() map[string]string {
+	return map[string]string{
+		"Content-Type":                  "application/json",
+		"Access-Control-Allow-Origin":   "*",
+		"Access-Control-Allow-Methods":  "GET, POST, PUT, DELETE, OPTIONS",
+		"Access-Control-Allow-Headers":  "Content-Type, Authorization, Idempotency-Key, If-Match, If-None-Match, X-Api-Key",
+		"Access-Control-Expose-Headers": "ETag",
+	}
+}
+
+// HandleRequest maneja todas las rutas de la API (eventos REST API v1)
+func (h *ProductHandler) HandleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	headers := defaultHeaders()
+
+	if request.IsBase64Encoded {
+		body, err := base64.StdEncoding.DecodeString(request.Body)
+		if err != nil {
+			return h.errorResponse(headers, 400, "Invalid base64 body"), nil
+		}
+		request.Body, request.IsBase64Encoded = string(body), false
+	}
+
+	matched, params, allowed := resolveRoute(request.HTTPMethod, request.Path)
+	if len(allowed) == 0 {
+		return h.errorResponse(headers, 404, "Route not found"), nil
+	}
+
+	allow := strings.Join(append(allowed, "OPTIONS"), ", ")
+	switch {
+	case request.HTTPMethod == "OPTIONS":
+		// Preflight automático con los métodos reales del path
+		headers["Allow"] = allow
+		headers["Access-Control-Allow-Methods"] = allow
+		return events.APIGatewayProxyResponse{StatusCode: 204, Headers: headers}, nil
+	case matched == nil:
+		headers["Allow"] = allow
+		return h.errorResponse(headers, 405, fmt.Sprintf("Method %s not allowed", request.HTTPMethod)), nil
+	}
+
+	if matched.internal && !h.authorizeInternal(request) {
+		return h.errorResponse(headers, 401, "Missing or invalid API key"), nil
+	}
+
+	request.Resource = matched.template
+	request.RequestContext.ResourcePath = matched.template
+	request.PathParameters = params
+	return matched.handle(h, ctx, request, headers)
+}
+
+// HandleHTTPRequest atiende eventos de HTTP API (payload 2.0) y Function URLs
+func (h *ProductHandler) HandleHTTPRequest(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	response, err := h.HandleRequest(ctx, proxyRequestFromV2(request))
+	if err != nil {
+		return events.APIGatewayV2HTTPResponse{}, err
+	}
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode:        response.StatusCode,
+		Headers:           response.Headers,
+		MultiValueHeaders: response.MultiValueHeaders,
+		Body:              response.Body,
+		IsBase64Encoded:   response.IsBase64Encoded,
+	}, nil
+}
+
+// proxyRequestFromV2 traduce un evento 2.0 al formato REST v1 que usan los handlers
+func proxyRequestFromV2(request events.APIGatewayV2HTTPRequest) events.APIGatewayProxyRequest {
+	path := request.RawPath
+	// Con un stage distinto de $default, rawPath lleva el stage delante
+	if stage := request.RequestContext.Stage; stage != "" && stage != "$default" {
+		path = strings.TrimPrefix(path, "/"+stage)
+	}
+
+	headers := make(map[string]string, len(request.Headers)+1)
+	multiHeaders := make(map[string][]string, len(request.Headers)+1)
+	for name, value := range request.Headers {
+		headers[name] = value
+		multiHeaders[name] = strings.Split(value, ",")
+	}
+	if len(request.Cookies) > 0 {
+		headers["cookie"] = strings.Join(request.Cookies, "; ")
+		multiHeaders["cookie"] = request.Cookies
+	}
+
+	// rawQueryString conserva los valores repetidos; como v1, el mapa simple se
+	// queda con el último
+	query := map[string]string{}
+	multiQuery := map[string][]string{}
+	if values, err := url.ParseQuery(request.RawQueryString); err == nil && request.RawQueryString != "" {
+		for name, list := range values {
+			query[name] = list[len(list)-1]
+			multiQuery[name] = list
+		}
+	} else {
+		for name, value := range request.QueryStringParameters {
+			query[name] = value
+			multiQuery[name] = []string{value}
+		}
+	}
+
+	return events.APIGatewayProxyRequest{
+		Path:                            path,
+		HTTPMethod:                      request.RequestContext.HTTP.Method,
+		Headers:                         headers,
+		MultiValueHeaders:               multiHeaders,
+		QueryStringParameters:           query,
+		MultiValueQueryStringParameters: multiQuery,
+		PathParameters:                  request.PathParameters,
+		StageVariables:                  request.StageVariables,
+		Body:                            request.Body,
+		IsBase64Encoded:                 request.IsBase64Encoded,
+		RequestContext: events.APIGatewayProxyRequestContext{
+			AccountID:  request.RequestContext.AccountID,
+			APIID:      request.RequestContext.APIID,
+			DomainName: request.RequestContext.DomainName,
+			RequestID:  request.RequestContext.RequestID,
+			Stage:      request.RequestContext.Stage,
+			HTTPMethod: request.RequestContext.HTTP.Method,
+			Path:       request.RequestContext.HTTP.Path,
+			Identity: events.APIGatewayRequestIdentity{
+				SourceIP:  request.RequestContext.HTTP.SourceIP,
+				UserAgent: request.RequestContext.HTTP.UserAgent,
+			},
+		},
+	}
+}
+
+// eventShape -> Campos que distinguen los tipos de evento que recibe la Lambda
+type eventShape struct {
+	Version    string `json:"version"`
+	RawPath    string `json:"rawPath"`
+	HTTPMethod string `json:"httpMethod"`
+	Records    []struct {
+		EventSource string `json:"eventSource"`
+	} `json:"Records"`
+}
+
+// Invoke implementa lambda.Handler: detecta el tipo de evento y lo despacha.
+// Así la misma función sirve REST API, HTTP API, Function URLs y el stream de la tabla.
+func (h *ProductHandler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	var shape eventShape
+	if err := json.Unmarshal(payload, &shape); err != nil {
+		return nil, fmt.Errorf("decoding event: %w", err)
+	}
+
+	switch {
+	case shape.Version == "2.0" && shape.RawPath != "":
+		var request events.APIGatewayV2HTTPRequest
+		if err := json.Unmarshal(payload, &request); err != nil {
+			return nil, fmt.Errorf("decoding HTTP API event: %w", err)
+		}
+		response, err := h.HandleHTTPRequest(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(response)
+
+	case shape.HTTPMethod != "":
+		var request events.APIGatewayProxyRequest
+		if err := json.Unmarshal(payload, &request); err != nil {
+			return nil, fmt.Errorf("decoding REST API event: %w", err)
+		}
+		response, err := h.HandleRequest(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(response)
+
+	case len(shape.Records) > 0 && shape.Records[0].EventSource == "aws:dynamodb":
+		var event events.DynamoDBEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("decoding DynamoDB stream event: %w", err)
+		}
+		if err := h.HandleStream(ctx, event); err != nil {
+			return nil, err
+		}
+		return []byte("null"), nil
+	}
+
+	return nil, fmt.Errorf("unsupported event type")
+}
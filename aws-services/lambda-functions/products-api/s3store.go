@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Prefijos del bucket de datos
+const (
+	ExportPrefix  = "exports/" // Exports del catálogo; caducan en un día
+	ArchivePrefix = "archive/" // Exports de productos purgados; se conservan
+)
+
+// S3Store -> Bucket donde se dejan los ficheros grandes de la API (exports y
+// archivos del purge)
+type S3Store struct {
+	client  *s3.Client
+	presign *s3.PresignClient
+	bucket  string
+}
+
+func NewS3Store(client *s3.Client, bucket string) *S3Store {
+	return &S3Store{client: client, presign: s3.NewPresignClient(client), bucket: bucket}
+}
+
+// Location -> URI s3:// de una clave del bucket
+func (s *S3Store) Location(key string) string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, key)
+}
+
+// Put sube un objeto. body debe poder rebobinarse (un fichero) para firmar la
+// petición sin cargarlo en memoria.
+func (s *S3Store) Put(ctx context.Context, key, contentType string, body io.ReadSeeker) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+		Body:        body,
+	})
+	return err
+}
+
+// Save guarda un archivo del purge bajo ArchivePrefix (implementa ArchiveStore)
+func (s *S3Store) Save(ctx context.Context, name string, data []byte) (string, error) {
+	key := ArchivePrefix + name
+	if err := s.Put(ctx, key, "application/json", bytes.NewReader(data)); err != nil {
+		return "", err
+	}
+	return s.Location(key), nil
+}
+
+// PresignGet devuelve una URL de descarga temporal
+func (s *S3Store) PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	request, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", err
+	}
+	return request.URL, nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Parámetros de BM25
+const (
+	bm25K1        = 1.2
+	bm25B         = 0.75
+	prefixPenalty = 0.7 // Un término que solo coincide por prefijo puntúa menos
+	minPrefixLen  = 2
+)
+
+// searchField -> Campo indexado y su peso en el ranking
+type searchField struct {
+	name   string
+	weight float64
+	text   func(p *Product) string
+}
+
+var searchFields = []searchField{
+	{name: "name", weight: 3, text: func(p *Product) string { return p.Name }},
+	{name: "sku", weight: 2, text: func(p *Product) string { return p.SKU }},
+	{name: "tags", weight: 2, text: func(p *Product) string { return strings.Join(p.Tags, ", ") }},
+	{name: "description", weight: 1, text: func(p *Product) string { return p.Description }},
+}
+
+// Palabras vacías del catálogo (español e inglés) que no aportan al ranking
+var stopWords = map[string]bool{
+	"a": true, "al": true, "con": true, "de": true, "del": true, "el": true, "en": true,
+	"la": true, "las": true, "lo": true, "los": true, "para": true, "por": true, "un": true,
+	"una": true, "y": true, "o": true, "the": true, "and": true, "of": true, "for": true,
+}
+
+var accentFolder = strings.NewReplacer(
+	"á", "a", "à", "a", "ä", "a", "â", "a",
+	"é", "e", "è", "e", "ë", "e", "ê", "e",
+	"í", "i", "ì", "i", "ï", "i", "î", "i",
+	"ó", "o", "ò", "o", "ö", "o", "ô", "o",
+	"ú", "u", "ù", "u", "ü", "u", "û", "u",
+	"ñ", "n", "ç", "c",
+)
+
+// normalizeTerm pasa a minúsculas y quita acentos
+func normalizeTerm(word string) string {
+	return accentFolder.Replace(strings.ToLower(word))
+}
+
+// tokenSpan -> Palabra del texto original con su posición en bytes
+type tokenSpan struct {
+	term       string
+	start, end int
+}
+
+// tokenSpans separa el texto en palabras (letras y dígitos)
+func tokenSpans(text string) []tokenSpan {
+	var spans []tokenSpan
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		}
+		if !isWord && start >= 0 {
+			spans = append(spans, tokenSpan{term: normalizeTerm(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, tokenSpan{term: normalizeTerm(text[start:]), start: start, end: len(text)})
+	}
+	return spans
+}
+
+// tokenize devuelve los términos indexables de un texto
+func tokenize(text string) []string {
+	var terms []string
+	for _, span := range tokenSpans(text) {
+		if !stopWords[span.term] {
+			terms = append(terms, span.term)
+		}
+	}
+	return terms
+}
+
+// indexedDoc -> Producto indexado con sus frecuencias ponderadas por campo
+type indexedDoc struct {
+	product Product
+	terms   map[string]float64
+	length  float64
+}
+
+// SearchIndex -> Índice invertido en memoria con ranking BM25 sobre varios campos
+type SearchIndex struct {
+	mu       sync.RWMutex
+	docs     map[string]*indexedDoc
+	postings map[string]map[string]float64 // término -> id -> frecuencia ponderada
+	terms    []string                      // Términos ordenados para buscar por prefijo
+	dirty    bool
+	totalLen float64
+	builtAt  time.Time
+}
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		docs:     map[string]*indexedDoc{},
+		postings: map[string]map[string]float64{},
+	}
+}
+
+// Rebuild reemplaza el contenido del índice por los productos dados
+func (idx *SearchIndex) Rebuild(products []Product, now time.Time) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.docs = make(map[string]*indexedDoc, len(products))
+	idx.postings = map[string]map[string]float64{}
+	idx.totalLen = 0
+	for i := range products {
+		idx.add(products[i])
+	}
+	idx.dirty = true
+	idx.builtAt = now
+}
+
+// BuiltAt -> Momento de la última reconstrucción completa (cero si nunca se construyó)
+func (idx *SearchIndex) BuiltAt() time.Time {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.builtAt
+}
+
+// Len -> Número de productos indexados
+func (idx *SearchIndex) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// Upsert indexa un producto nuevo o reemplaza su versión anterior
+func (idx *SearchIndex) Upsert(product Product) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(product.ID)
+	idx.add(product)
+	idx.dirty = true
+}
+
+// Remove saca un producto del índice
+func (idx *SearchIndex) Remove(id string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(id)
+	idx.dirty = true
+}
+
+func (idx *SearchIndex) add(product Product) {
+	doc := &indexedDoc{product: product, terms: map[string]float64{}}
+	for _, field := range searchFields {
+		text := field.text(&product)
+		terms := tokenize(text)
+		if field.name == "sku" {
+			// El SKU también se indexa entero: "AB-123" -> "ab123"
+			if whole := strings.Join(terms, ""); len(terms) > 1 {
+				terms = append(terms, whole)
+			}
+		}
+		for _, term := range terms {
+			doc.terms[term] += field.weight
+		}
+		doc.length += field.weight * float64(len(terms))
+	}
+
+	for term, tf := range doc.terms {
+		if idx.postings[term] == nil {
+			idx.postings[term] = map[string]float64{}
+		}
+		idx.postings[term][product.ID] = tf
+	}
+	idx.docs[product.ID] = doc
+	idx.totalLen += doc.length
+}
+
+func (idx *SearchIndex) remove(id string) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for term := range doc.terms {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	idx.totalLen -= doc.length
+	delete(idx.docs, id)
+}
+
+// expand devuelve los términos del índice que coinciden con un término de la
+// búsqueda y el peso de cada uno (1 exacto, prefixPenalty por prefijo)
+func (idx *SearchIndex) expand(queryTerm string) map[string]float64 {
+	matches := map[string]float64{}
+	if _, ok := idx.postings[queryTerm]; ok {
+		matches[queryTerm] = 1
+	}
+	if len([]rune(queryTerm)) < minPrefixLen {
+		return matches
+	}
+
+	i := sort.SearchStrings(idx.terms, queryTerm)
+	for ; i < len(idx.terms) && strings.HasPrefix(idx.terms[i], queryTerm); i++ {
+		if idx.terms[i] != queryTerm {
+			matches[idx.terms[i]] = prefixPenalty
+		}
+	}
+	return matches
+}
+
+// Search devuelve los productos que contienen todos los términos de la búsqueda,
+// ordenados por relevancia, con Score y Highlights rellenados
+func (idx *SearchIndex) Search(query string, keep func(*Product) bool) []Product {
+	queryTerms := tokenize(query)
+	if len(queryTerms) == 0 {
+		return []Product{}
+	}
+
+	// Ordenar los términos requiere el lock de escritura
+	idx.mu.Lock()
+	if idx.dirty {
+		idx.terms = idx.terms[:0]
+		for term := range idx.postings {
+			idx.terms = append(idx.terms, term)
+		}
+		sort.Strings(idx.terms)
+		idx.dirty = false
+	}
+	idx.mu.Unlock()
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	n := float64(len(idx.docs))
+	avgLen := idx.totalLen / math.Max(n, 1)
+	scores := map[string]float64{}
+	matchedAll := map[string]int{}
+
+	for _, queryTerm := range queryTerms {
+		matched := map[string]bool{}
+		for term, weight := range idx.expand(queryTerm) {
+			postings := idx.postings[term]
+			df := float64(len(postings))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			for id, tf := range postings {
+				doc := idx.docs[id]
+				norm := tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*doc.length/avgLen))
+				scores[id] += weight * idf * norm
+				matched[id] = true
+			}
+		}
+		for id := range matched {
+			matchedAll[id]++
+		}
+	}
+
+	results := []Product{}
+	for id, count := range matchedAll {
+		if count < len(queryTerms) {
+			continue
+		}
+		product := idx.docs[id].product
+		if keep != nil && !keep(&product) {
+			continue
+		}
+		product.Score = math.Round(scores[id]*1000) / 1000
+		product.Highlights = highlight(&product, queryTerms)
+		results = append(results, product)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	return results
+}
+
+// highlight marca con <em> las palabras que coinciden con la búsqueda en cada
+// campo. El texto del producto se escapa: el resultado es HTML seguro de insertar.
+func highlight(product *Product, queryTerms []string) map[string]string {
+	highlights := map[string]string{}
+	for _, field := range searchFields {
+		text := field.text(product)
+		if field.name == "sku" && text != "" && matchesAny(strings.Join(tokenize(text), ""), queryTerms) {
+			highlights[field.name] = "<em>" + html.EscapeString(text) + "</em>"
+			continue
+		}
+		var b strings.Builder
+		last := 0
+		for _, span := range tokenSpans(text) {
+			if !matchesAny(span.term, queryTerms) {
+				continue
+			}
+			b.WriteString(html.EscapeString(text[last:span.start]))
+			b.WriteString("<em>")
+			b.WriteString(html.EscapeString(text[span.start:span.end]))
+			b.WriteString("</em>")
+			last = span.end
+		}
+		if last > 0 {
+			b.WriteString(html.EscapeString(text[last:]))
+			highlights[field.name] = b.String()
+		}
+	}
+	if len(highlights) == 0 {
+		return nil
+	}
+	return highlights
+}
+
+func matchesAny(term string, queryTerms []string) bool {
+	for _, q := range queryTerms {
+		if term == q || (len([]rune(q)) >= minPrefixLen && strings.HasPrefix(term, q)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Los agregados de estadísticas son registros auxiliares de la tabla: uno global
+// y uno por categoría. El consumidor del stream les aplica deltas con ADD, así
+// que leerlos es O(1); RebuildStats los recalcula desde cero.
+const (
+	RecordStats        = "stats"
+	RecordStatsEvent   = "stats_event"
+	StatsEventTTL      = 48 * time.Hour // Más que la retención del stream (24 h)
+	maxRebuildAttempts = 3
+
+	statsTotal          = "total_products"
+	statsInventoryValue = "inventory_value"
+	statsPriceSum       = "price_sum"
+	statsLowStock       = "low_stock"
+	statsVariants       = "total_variants"
+	statsLowVariants    = "low_stock_variants"
+	statsStatusPrefix   = "status#"
+	statsCategoryPrefix = "category#"
+	statsBucketPrefix   = "price_bucket#"
+	statsCurrencyPrefix = "currency#"
+)
+
+// Los importes se suman por moneda: inventory_value#USD, price_sum#USD,
+// currency#USD (productos) y price_bucket#USD#0-10
+
+// currencyCounter -> Contador de una moneda (inventory_value#EUR)
+func currencyCounter(counter, currency string) string {
+	return counter + "#" + currency
+}
+
+// priceBucketBounds -> Límites superiores de los rangos de precio; el último es abierto
+var priceBucketBounds = []float64{10, 50, 100, 500}
+
+// statsRecordID -> Clave del agregado global ("") o de una categoría
+func statsRecordID(category string) string {
+	if category == "" {
+		return "stats#global"
+	}
+	return "stats#category#" + category
+}
+
+// priceBucket devuelve la etiqueta del rango de precio (0-10, 10-50, ..., 500+)
+func priceBucket(price float64) string {
+	lower := 0.0
+	for _, upper := range priceBucketBounds {
+		if price < upper {
+			return formatBound(lower) + "-" + formatBound(upper)
+		}
+		lower = upper
+	}
+	return formatBound(lower) + "+"
+}
+
+func formatBound(bound float64) string {
+	return strconv.FormatFloat(bound, 'f', -1, 64)
+}
+
+// statsCounters -> Incrementos por atributo de un agregado
+type statsCounters map[string]float64
+
+// add suma (sign = 1) o resta (sign = -1) la contribución de un producto. Los
+// productos en la papelera no cuentan.
+func (c statsCounters) add(p *Product, sign float64, byCategory bool) {
+	if p == nil || p.IsDeleted() {
+		return
+	}
+	price := p.Price()
+	currency := normalizeCurrency(p.Currency)
+	c[statsTotal] += sign
+	c[statsCurrencyPrefix+currency] += sign
+	for valueCurrency, value := range p.InventoryValues() {
+		c[currencyCounter(statsInventoryValue, valueCurrency)] += sign * value
+	}
+	c[currencyCounter(statsPriceSum, currency)] += sign * price
+	c[statsStatusPrefix+p.Status] += sign
+	c[statsBucketPrefix+currency+"#"+priceBucket(price)] += sign
+	if p.IsLowStock() {
+		c[statsLowStock] += sign
+	}
+	c[statsVariants] += sign * float64(len(p.Variants))
+	c[statsLowVariants] += sign * float64(p.LowStockVariants())
+	if byCategory {
+		c[statsCategoryPrefix+p.Category] += sign
+	}
+}
+
+// statsDeltas -> Incrementos de cada agregado, por id de registro
+type statsDeltas map[string]statsCounters
+
+// add aplica un producto al agregado global y al de su categoría
+func (d statsDeltas) add(p *Product, sign float64) {
+	if p == nil {
+		return
+	}
+	d.counters("").add(p, sign, true)
+	if p.Category != "" {
+		d.counters(p.Category).add(p, sign, false)
+	}
+}
+
+func (d statsDeltas) counters(category string) statsCounters {
+	id := statsRecordID(category)
+	if d[id] == nil {
+		d[id] = statsCounters{}
+	}
+	return d[id]
+}
+
+// changeDeltas acumula los cambios de un lote: resta la imagen vieja y suma la nueva
+func changeDeltas(changes []productChange) statsDeltas {
+	deltas := statsDeltas{}
+	for _, change := range changes {
+		deltas.add(change.Old, -1)
+		deltas.add(change.New, 1)
+	}
+	return deltas
+}
+
+// applyStatsChanges aplica los deltas de cada registro del lote en su propia
+// transacción, junto a una marca stats_event#<EventID>. Si Lambda reintenta el
+// lote, los registros ya aplicados fallan en la condición de su marca y no se
+// suman dos veces.
+func (h *ProductHandler) applyStatsChanges(ctx context.Context, changes []productChange) error {
+	for _, change := range changes {
+		if err := h.applyStatsChange(ctx, change); err != nil {
+			return fmt.Errorf("record %s: %w", change.EventID, err)
+		}
+	}
+	return nil
+}
+
+func (h *ProductHandler) applyStatsChange(ctx context.Context, change productChange) error {
+	now := time.Now().UTC()
+	writes := []types.TransactWriteItem{{
+		Put: &types.Put{
+			TableName: aws.String(h.tableName),
+			Item: map[string]types.AttributeValue{
+				"id":                &types.AttributeValueMemberS{Value: statsEventID(change.EventID)},
+				recordTypeAttribute: &types.AttributeValueMemberS{Value: RecordStatsEvent},
+				"expires_at":        &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(StatsEventTTL).Unix(), 10)},
+			},
+			ConditionExpression: aws.String("attribute_not_exists(id)"),
+		},
+	}}
+
+	deltas := changeDeltas([]productChange{change})
+	ids := make([]string, 0, len(deltas))
+	for id := range deltas {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if update := h.statsUpdate(id, deltas[id], now.Format(time.RFC3339)); update != nil {
+			writes = append(writes, types.TransactWriteItem{Update: update})
+		}
+	}
+	if len(writes) == 1 {
+		return nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if err := waitBackoff(ctx, attempt); err != nil {
+				return err
+			}
+		}
+		_, err := h.dynamoClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: writes})
+		if err == nil {
+			return nil
+		}
+		codes := cancellationReasons(err)
+		if conditionFailedAt(codes, 0) {
+			return nil // Registro ya aplicado en un intento anterior
+		}
+		// Los agregados son items calientes: reintentar los conflictos entre lotes
+		if attempt >= maxBatchWriteRetries || !transactionConflict(codes) {
+			return err
+		}
+	}
+}
+
+// statsUpdate suma los contadores de un agregado con ADD; version sube en cada
+// cambio para que el rebuild detecte deltas concurrentes
+func (h *ProductHandler) statsUpdate(id string, counters statsCounters, now string) *types.Update {
+	names := map[string]string{"#record_type": recordTypeAttribute, "#updated_at": "updated_at", "#version": "version"}
+	values := map[string]types.AttributeValue{
+		":record_type": &types.AttributeValueMemberS{Value: RecordStats},
+		":now":         &types.AttributeValueMemberS{Value: now},
+		":one":         &types.AttributeValueMemberN{Value: "1"},
+	}
+
+	adds := []string{"#version :one"}
+	for _, attribute := range sortedCounterNames(counters) {
+		delta := counters[attribute]
+		if delta == 0 {
+			continue
+		}
+		i := len(adds)
+		names[fmt.Sprintf("#c%d", i)] = attribute
+		values[fmt.Sprintf(":c%d", i)] = &types.AttributeValueMemberN{Value: strconv.FormatFloat(delta, 'f', -1, 64)}
+		adds = append(adds, fmt.Sprintf("#c%d :c%d", i, i))
+	}
+	if len(adds) == 1 {
+		return nil
+	}
+
+	return &types.Update{
+		TableName: aws.String(h.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:          aws.String("SET #record_type = :record_type, #updated_at = :now ADD " + strings.Join(adds, ", ")),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}
+}
+
+// statsEventID -> Marca de un registro del stream ya sumado a las estadísticas
+func statsEventID(eventID string) string {
+	return "stats_event#" + eventID
+}
+
+// transactionConflict indica si alguna operación chocó con otra transacción
+func transactionConflict(codes []string) bool {
+	for _, code := range codes {
+		if code == "TransactionConflict" {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedCounterNames(counters statsCounters) []string {
+	names := make([]string, 0, len(counters))
+	for name := range counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// statsItem construye el agregado completo que escribe el rebuild
+func statsItem(id string, counters statsCounters, version int64, now string) map[string]types.AttributeValue {
+	item := map[string]types.AttributeValue{
+		"id":                &types.AttributeValueMemberS{Value: id},
+		recordTypeAttribute: &types.AttributeValueMemberS{Value: RecordStats},
+		"updated_at":        &types.AttributeValueMemberS{Value: now},
+		"rebuilt_at":        &types.AttributeValueMemberS{Value: now},
+		"version":           &types.AttributeValueMemberN{Value: strconv.FormatInt(version, 10)},
+	}
+	for attribute, value := range counters {
+		item[attribute] = &types.AttributeValueMemberN{Value: strconv.FormatFloat(value, 'f', -1, 64)}
+	}
+	return item
+}
+
+// errStatsChanged -> Llegaron deltas del stream mientras se recalculaba
+var errStatsChanged = errors.New("statistics changed during rebuild")
+
+// RebuildStats recalcula los agregados leyendo todos los productos y borra los de
+// categorías que ya no tienen productos. Sirve para reconciliar tras fallos del
+// stream o para inicializar una tabla con datos previos. Las escrituras van
+// condicionadas a la versión leída antes del Scan: si el stream aplica un delta
+// entretanto, el rebuild vuelve a empezar en vez de pisarlo.
+func (h *ProductHandler) RebuildStats(ctx context.Context) (*ProductStats, error) {
+	for attempt := 1; ; attempt++ {
+		stats, err := h.rebuildStatsOnce(ctx)
+		if !errors.Is(err, errStatsChanged) || attempt >= maxRebuildAttempts {
+			return stats, err
+		}
+		log.Printf("📊 Statistics changed during rebuild, retrying (attempt %d)", attempt+1)
+	}
+}
+
+func (h *ProductHandler) rebuildStatsOnce(ctx context.Context) (*ProductStats, error) {
+	previous, err := h.readStatsItem(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	// Versiones de los agregados existentes, leídas antes del Scan
+	versions := map[string]int64{}
+	if previous != nil {
+		versions[statsRecordID("")] = statsVersion(previous)
+	}
+	for attribute := range previous {
+		if category, ok := strings.CutPrefix(attribute, statsCategoryPrefix); ok && category != "" {
+			item, err := h.readStatsItem(ctx, category)
+			if err != nil {
+				return nil, err
+			}
+			if item != nil {
+				versions[statsRecordID(category)] = statsVersion(item)
+			}
+		}
+	}
+
+	products, _, err := h.readAllProducts(ctx, planProductQuery(&ProductFilter{}), nil)
+	if err != nil {
+		return nil, fmt.Errorf("reading products: %w", err)
+	}
+
+	deltas := statsDeltas{}
+	deltas.counters("")
+	for i := range products {
+		deltas.add(&products[i], 1)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for id, counters := range deltas {
+		version, existed := versions[id]
+		_, err := h.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:                 aws.String(h.tableName),
+			Item:                      statsItem(id, counters, version+1, now),
+			ConditionExpression:       statsVersionCondition(existed),
+			ExpressionAttributeNames:  statsVersionNames(existed),
+			ExpressionAttributeValues: statsVersionValues(version, existed),
+		})
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return nil, errStatsChanged
+		}
+		if err != nil {
+			return nil, fmt.Errorf("writing %s: %w", id, err)
+		}
+	}
+
+	for id, version := range versions {
+		if deltas[id] != nil {
+			continue
+		}
+		_, err := h.dynamoClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(h.tableName),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: id},
+			},
+			ConditionExpression:       statsVersionCondition(true),
+			ExpressionAttributeNames:  statsVersionNames(true),
+			ExpressionAttributeValues: statsVersionValues(version, true),
+		})
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return nil, errStatsChanged
+		}
+		if err != nil {
+			return nil, fmt.Errorf("deleting stale %s: %w", id, err)
+		}
+	}
+
+	current, err := h.readStatsItem(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("📊 Rebuilt statistics from %d products (%d categories)", len(products), len(deltas)-1)
+	stats := statsFromItem(current, "", DefaultCurrency)
+	return &stats, nil
+}
+
+// statsVersion lee el contador de versión de un agregado (0 si no tiene)
+func statsVersion(item map[string]types.AttributeValue) int64 {
+	if number, ok := item["version"].(*types.AttributeValueMemberN); ok {
+		version, _ := strconv.ParseInt(number.Value, 10, 64)
+		return version
+	}
+	return 0
+}
+
+// statsVersionCondition exige la versión leída, o que el agregado siga sin existir
+func statsVersionCondition(existed bool) *string {
+	if !existed {
+		return aws.String("attribute_not_exists(id)")
+	}
+	return aws.String("#version = :version OR (attribute_not_exists(#version) AND :version = :zero)")
+}
+
+func statsVersionNames(existed bool) map[string]string {
+	if !existed {
+		return nil
+	}
+	return map[string]string{"#version": "version"}
+}
+
+func statsVersionValues(version int64, existed bool) map[string]types.AttributeValue {
+	if !existed {
+		return nil
+	}
+	return map[string]types.AttributeValue{
+		":version": &types.AttributeValueMemberN{Value: strconv.FormatInt(version, 10)},
+		":zero":    &types.AttributeValueMemberN{Value: "0"},
+	}
+}
+
+// readStatsItem lee un agregado; nil si todavía no existe
+func (h *ProductHandler) readStatsItem(ctx context.Context, category string) (map[string]types.AttributeValue, error) {
+	result, err := h.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(h.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: statsRecordID(category)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", statsRecordID(category), err)
+	}
+	return result.Item, nil
+}
+
+// statsFromItem traduce el agregado a la respuesta de la API; los importes de
+// primer nivel son los de currency
+func statsFromItem(item map[string]types.AttributeValue, category, currency string) ProductStats {
+	stats := ProductStats{
+		Currency:   currency,
+		ByCurrency: map[string]CurrencyStats{},
+		Category:   category,
+		ByStatus:   map[string]int{},
+	}
+	if category == "" {
+		stats.ByCategory = map[string]int{}
+	}
+
+	counts := map[string]int{}
+	values := map[string]float64{}
+	priceSums := map[string]float64{}
+	buckets := map[string]map[string]int{}
+	for attribute, value := range item {
+		number, ok := value.(*types.AttributeValueMemberN)
+		if !ok {
+			continue
+		}
+		amount, err := strconv.ParseFloat(number.Value, 64)
+		if err != nil {
+			continue
+		}
+		count := int(amount + 0.5)
+
+		// Los contadores sin moneda (anteriores al desglose) se ignoran hasta el rebuild
+		if name, cur, ok := strings.Cut(attribute, "#"); ok && (name == statsInventoryValue || name == statsPriceSum) {
+			if name == statsInventoryValue {
+				values[cur] = amount
+			} else {
+				priceSums[cur] = amount
+			}
+			continue
+		}
+
+		switch {
+		case attribute == statsTotal:
+			stats.TotalProducts = count
+		case attribute == statsLowStock:
+			stats.LowStockItems = count
+		case strings.HasPrefix(attribute, statsCurrencyPrefix) && count > 0:
+			counts[strings.TrimPrefix(attribute, statsCurrencyPrefix)] = count
+		case attribute == statsVariants:
+			stats.TotalVariants = count
+		case attribute == statsLowVariants:
+			stats.LowStockVariants = count
+		case strings.HasPrefix(attribute, statsStatusPrefix) && count > 0:
+			stats.ByStatus[strings.TrimPrefix(attribute, statsStatusPrefix)] = count
+		case strings.HasPrefix(attribute, statsCategoryPrefix) && count > 0 && category == "":
+			stats.ByCategory[strings.TrimPrefix(attribute, statsCategoryPrefix)] = count
+		case strings.HasPrefix(attribute, statsBucketPrefix) && count > 0:
+			if cur, bucket, ok := strings.Cut(strings.TrimPrefix(attribute, statsBucketPrefix), "#"); ok {
+				if buckets[cur] == nil {
+					buckets[cur] = map[string]int{}
+				}
+				buckets[cur][bucket] = count
+			}
+		}
+	}
+
+	// Una variante puede tener stock valorado en una moneda sin productos base
+	for cur := range values {
+		if _, ok := counts[cur]; !ok {
+			counts[cur] = 0
+		}
+	}
+	for cur, products := range counts {
+		currencyStats := CurrencyStats{
+			Products:          products,
+			TotalValue:        values[cur],
+			PriceDistribution: priceDistribution(buckets[cur]),
+		}
+		// AveragePrice es la media de precios, no el valor del inventario por producto
+		if products > 0 {
+			currencyStats.AveragePrice = priceSums[cur] / float64(products)
+		}
+		stats.ByCurrency[cur] = currencyStats
+	}
+	selected := stats.ByCurrency[currency]
+	stats.TotalValue = selected.TotalValue
+	stats.AveragePrice = selected.AveragePrice
+	stats.PriceDistribution = priceDistribution(buckets[currency])
+	stats.ActiveProducts = stats.ByStatus[StatusActive]
+	stats.OutOfStock = stats.ByStatus[StatusOutOfStock]
+
+	if category != "" {
+		stats.TopCategory = category
+	}
+	maxCount := 0
+	for name, count := range stats.ByCategory {
+		if count > maxCount || (count == maxCount && name < stats.TopCategory) {
+			maxCount = count
+			stats.TopCategory = name
+		}
+	}
+
+	if updated, ok := item["updated_at"].(*types.AttributeValueMemberS); ok {
+		if t, err := time.Parse(time.RFC3339, updated.Value); err == nil {
+			stats.UpdatedAt = &t
+		}
+	}
+	if rebuilt, ok := item["rebuilt_at"].(*types.AttributeValueMemberS); ok {
+		if t, err := time.Parse(time.RFC3339, rebuilt.Value); err == nil {
+			stats.RebuiltAt = &t
+		}
+	}
+	return stats
+}
+
+// priceDistribution -> Rangos en orden ascendente, incluidos los vacíos
+func priceDistribution(buckets map[string]int) []PriceBucketCount {
+	distribution := []PriceBucketCount{}
+	lower := 0.0
+	for i := 0; i <= len(priceBucketBounds); i++ {
+		bucket := PriceBucketCount{Range: priceBucket(lower), Min: lower}
+		bucket.Count = buckets[bucket.Range]
+		if i < len(priceBucketBounds) {
+			upper := priceBucketBounds[i]
+			bucket.Max = &upper
+			lower = upper
+		}
+		distribution = append(distribution, bucket)
+	}
+	return distribution
+}
+
+// getProductStats devuelve el agregado global o el de ?category=X, con los
+// importes en ?currency= (DefaultCurrency por defecto)
+func (h *ProductHandler) getProductStats(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	if request.QueryStringParameters["status"] != "" {
+		return h.validationErrorResponse(headers, ValidationErrors{
+			{Field: "status", Message: "is not supported; use the by_status breakdown"},
+		}), nil
+	}
+	category := request.QueryStringParameters["category"]
+	currency := normalizeCurrency(request.QueryStringParameters["currency"])
+	if !validCurrency(currency) {
+		return h.validationErrorResponse(headers, ValidationErrors{{Field: "currency", Message: "unsupported currency"}}), nil
+	}
+
+	item, err := h.readStatsItem(ctx, category)
+	if err != nil {
+		return h.errorResponse(headers, 500, fmt.Sprintf("Error reading statistics: %v", err)), nil
+	}
+
+	response := ProductResponse{
+		Success: true,
+		Message: "Product statistics retrieved successfully",
+		Data:    statsFromItem(item, category, currency),
+	}
+
+	return h.successResponse(headers, response), nil
+}
+
+// rebuildProductStats recalcula los agregados (POST /products/stats/rebuild)
+func (h *ProductHandler) rebuildProductStats(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	stats, err := h.RebuildStats(ctx)
+	if err != nil {
+		return h.errorResponse(headers, 500, fmt.Sprintf("Error rebuilding statistics: %v", err)), nil
+	}
+
+	response := ProductResponse{
+		Success: true,
+		Message: "Product statistics rebuilt successfully",
+		Data:    stats,
+	}
+
+	return h.successResponse(headers, response), nil
+}
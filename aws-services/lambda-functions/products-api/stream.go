@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// productChange -> Estado de un producto antes y después de un registro del stream.
+// nil = no existía (INSERT/REMOVE) o no es un producto.
+type productChange struct {
+	EventID    string
+	EventName  string
+	OccurredAt time.Time
+	Old        *Product
+	New        *Product
+}
+
+// HandleStream consume DynamoDB Streams (NEW_AND_OLD_IMAGES) de la tabla de
+// productos: devuelve el stock de las reservas que borró el TTL, publica los
+// eventos de dominio y actualiza el historial de precios y las estadísticas. Si
+// devuelve error, Lambda reintenta el lote completo.
+func (h *ProductHandler) HandleStream(ctx context.Context, event events.DynamoDBEvent) error {
+	if err := h.expireStreamReservations(ctx, event.Records); err != nil {
+		return err
+	}
+
+	changes := make([]productChange, 0, len(event.Records))
+	for _, record := range event.Records {
+		change, err := productChangeFromRecord(record)
+		if err != nil {
+			return fmt.Errorf("record %s: %w", record.EventID, err)
+		}
+		if change.Old != nil || change.New != nil {
+			changes = append(changes, change)
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	// Primero los eventos: si fallan, el reintento no vuelve a sumar estadísticas
+	var published []ProductEvent
+	for _, change := range changes {
+		published = append(published, productEvents(change)...)
+	}
+	if len(published) > 0 {
+		if err := h.events.Publish(ctx, published); err != nil {
+			return fmt.Errorf("publishing product events: %w", err)
+		}
+	}
+
+	if err := h.appendPriceHistory(ctx, changes); err != nil {
+		return fmt.Errorf("updating price history: %w", err)
+	}
+
+	if err := h.applyStatsChanges(ctx, changes); err != nil {
+		return fmt.Errorf("updating statistics: %w", err)
+	}
+	return nil
+}
+
+// productChangeFromRecord decodifica las imágenes del registro; los registros
+// auxiliares (reservas de SKU y de stock, idempotencia, agregados) se ignoran
+func productChangeFromRecord(record events.DynamoDBEventRecord) (productChange, error) {
+	change := productChange{
+		EventID:    record.EventID,
+		EventName:  record.EventName,
+		OccurredAt: record.Change.ApproximateCreationDateTime.UTC(),
+	}
+
+	var err error
+	if change.Old, err = productFromImage(record.Change.OldImage); err != nil {
+		return change, fmt.Errorf("old image: %w", err)
+	}
+	if change.New, err = productFromImage(record.Change.NewImage); err != nil {
+		return change, fmt.Errorf("new image: %w", err)
+	}
+	return change, nil
+}
+
+func productFromImage(image map[string]events.DynamoDBAttributeValue) (*Product, error) {
+	if len(image) == 0 {
+		return nil, nil
+	}
+	item := streamImageItem(image)
+	if isAuxiliaryRecord(item) {
+		return nil, nil
+	}
+
+	var product Product
+	if err := attributevalue.UnmarshalMap(item, &product); err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// streamImageItem convierte una imagen del stream al tipo del SDK
+func streamImageItem(image map[string]events.DynamoDBAttributeValue) map[string]types.AttributeValue {
+	item := make(map[string]types.AttributeValue, len(image))
+	for name, value := range image {
+		item[name] = streamAttributeValue(value)
+	}
+	return item
+}
+
+func streamAttributeValue(value events.DynamoDBAttributeValue) types.AttributeValue {
+	switch value.DataType() {
+	case events.DataTypeString:
+		return &types.AttributeValueMemberS{Value: value.String()}
+	case events.DataTypeNumber:
+		return &types.AttributeValueMemberN{Value: value.Number()}
+	case events.DataTypeBoolean:
+		return &types.AttributeValueMemberBOOL{Value: value.Boolean()}
+	case events.DataTypeBinary:
+		return &types.AttributeValueMemberB{Value: value.Binary()}
+	case events.DataTypeStringSet:
+		return &types.AttributeValueMemberSS{Value: value.StringSet()}
+	case events.DataTypeNumberSet:
+		return &types.AttributeValueMemberNS{Value: value.NumberSet()}
+	case events.DataTypeBinarySet:
+		return &types.AttributeValueMemberBS{Value: value.BinarySet()}
+	case events.DataTypeList:
+		list := value.List()
+		values := make([]types.AttributeValue, len(list))
+		for i, element := range list {
+			values[i] = streamAttributeValue(element)
+		}
+		return &types.AttributeValueMemberL{Value: values}
+	case events.DataTypeMap:
+		return &types.AttributeValueMemberM{Value: streamImageItem(value.Map())}
+	}
+	return &types.AttributeValueMemberNULL{Value: true}
+}
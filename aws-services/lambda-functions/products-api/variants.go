@@ -0,0 +1,558 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// Las variantes viven dentro del item del producto padre: una escritura de
+// variantes es una escritura del producto (versión, ETag, stream). El stock del
+// padre es la suma del de sus variantes: la primera variante hereda el stock
+// del producto y, al quitar la última, hay que indicar el que le queda.
+
+// MaxVariants -> Variantes por producto (cada una lleva su reserva de SKU en la
+// misma transacción que el purge, limitada a 100 operaciones)
+const MaxVariants = 50
+
+var errVariantNotFound = errors.New("variant not found")
+
+// Variant -> Variante de un producto (talla, color...)
+type Variant struct {
+	ID         string            `json:"id" dynamodbav:"id"`
+	SKU        string            `json:"sku" dynamodbav:"sku"`
+	Attributes map[string]string `json:"attributes" dynamodbav:"attributes"`
+	Stock      int               `json:"stock" dynamodbav:"stock"`
+	Price      *Money            `json:"price,omitempty" dynamodbav:"price,omitempty"` // nil = precio del producto
+	CreatedAt  time.Time         `json:"created_at" dynamodbav:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+// CreateVariantRequest -> Para crear variantes
+type CreateVariantRequest struct {
+	SKU        string            `json:"sku"`
+	Attributes map[string]string `json:"attributes"`
+	Stock      *int              `json:"stock,omitempty"` // Sin stock, la primera variante lleva el del producto
+	Price      *json.Number      `json:"price,omitempty"` // En la moneda del producto
+}
+
+// UpdateVariantRequest -> Para actualizar variantes; "price": null quita el override
+type UpdateVariantRequest struct {
+	SKU        *string            `json:"sku,omitempty"`
+	Attributes *map[string]string `json:"attributes,omitempty"` // Reemplaza el mapa completo
+	Stock      *int               `json:"stock,omitempty"`
+	Price      nullableNumber     `json:"price"`
+}
+
+// nullableNumber distingue un campo ausente de uno enviado como null
+type nullableNumber struct {
+	Set   bool
+	Value *json.Number
+}
+
+func (n *nullableNumber) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	if string(data) == "null" {
+		n.Value = nil
+		return nil
+	}
+	var value json.Number
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	n.Value = &value
+	return nil
+}
+
+func (v *Variant) IsLowStock() bool {
+	return v.Stock <= LowStocktThreshold
+}
+
+// EffectivePrice -> Override de la variante o precio del producto
+func (v *Variant) EffectivePrice(product *Product) Money {
+	if v.Price != nil {
+		return *v.Price
+	}
+	return product.BasePrice()
+}
+
+// InventoryValues -> Valor del stock por moneda; con variantes, cada una a su precio
+func (p *Product) InventoryValues() map[string]float64 {
+	if len(p.Variants) == 0 {
+		return map[string]float64{normalizeCurrency(p.Currency): p.Price() * float64(p.Stock)}
+	}
+	values := map[string]float64{}
+	for i := range p.Variants {
+		price := p.Variants[i].EffectivePrice(p)
+		values[normalizeCurrency(price.Currency)] += minorUnitsToFloat(price.Amount, price.Currency) * float64(p.Variants[i].Stock)
+	}
+	return values
+}
+
+// LowStockVariants cuenta las variantes con stock bajo
+func (p *Product) LowStockVariants() int {
+	count := 0
+	for i := range p.Variants {
+		if p.Variants[i].IsLowStock() {
+			count++
+		}
+	}
+	return count
+}
+
+func (p *Product) variantIndex(variantID string) int {
+	for i := range p.Variants {
+		if p.Variants[i].ID == variantID {
+			return i
+		}
+	}
+	return -1
+}
+
+// variantStock -> Stock del padre a partir de sus variantes
+func variantStock(variants []Variant) int {
+	total := 0
+	for _, variant := range variants {
+		total += variant.Stock
+	}
+	return total
+}
+
+// normalizeAttributes quita espacios y pasa las claves a minúsculas
+func normalizeAttributes(attributes map[string]string, errs *ValidationErrors) map[string]string {
+	normalized := make(map[string]string, len(attributes))
+	for name, value := range attributes {
+		key := strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if key == "" || value == "" {
+			*errs = append(*errs, ValidationError{Field: "attributes." + name, Message: "name and value must not be empty"})
+			continue
+		}
+		normalized[key] = value
+	}
+	return normalized
+}
+
+// validateVariantSet comprueba que SKUs y combinaciones de atributos no se repiten
+func validateVariantSet(product *Product, variants []Variant, changed int) ValidationErrors {
+	var errs ValidationErrors
+	variant := &variants[changed]
+	if len(variant.Attributes) == 0 {
+		errs = append(errs, ValidationError{Field: "attributes", Message: "must have at least one attribute"})
+	}
+	if skuGuardID(variant.SKU) == skuGuardID(product.SKU) {
+		errs = append(errs, ValidationError{Field: "sku", Message: "must differ from the product SKU"})
+	}
+	for i := range variants {
+		if i == changed {
+			continue
+		}
+		if skuGuardID(variants[i].SKU) == skuGuardID(variant.SKU) {
+			errs = append(errs, ValidationError{Field: "sku", Message: "already used by variant " + variants[i].ID})
+		}
+		if len(variant.Attributes) > 0 && maps.Equal(variants[i].Attributes, variant.Attributes) {
+			errs = append(errs, ValidationError{Field: "attributes", Message: "same attributes as variant " + variants[i].ID})
+		}
+	}
+	return errs
+}
+
+// parseVariantPrice convierte el override a la moneda del producto
+func parseVariantPrice(raw json.Number, product *Product) (*Money, ValidationErrors) {
+	amount, err := parseMinorUnits(raw.String(), product.Currency)
+	if err != nil {
+		return nil, ValidationErrors{{Field: "price", Message: err.Error()}}
+	}
+	if amount <= 0 {
+		return nil, ValidationErrors{{Field: "price", Message: "must be greater than 0"}}
+	}
+	return &Money{Amount: amount, Currency: product.Currency}, nil
+}
+
+// variantMutation calcula la lista nueva de variantes sobre el producto leído, las
+// operaciones sobre reservas de SKU y la variante afectada. Puede devolver
+// ValidationErrors o errVariantNotFound. Si deja el producto sin variantes,
+// fija en current.Stock el stock que le queda.
+type variantMutation func(current *Product, now time.Time) ([]Variant, []types.TransactWriteItem, *Variant, error)
+
+// changeVariants lee el padre, aplica la mutación y la escribe condicionada a la
+// versión leída, reintentando si otra escritura se adelanta (sin If-Match)
+func (h *ProductHandler) changeVariants(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string, mutate variantMutation) (*Product, *Variant, *events.APIGatewayProxyResponse) {
+	fail := func(response events.APIGatewayProxyResponse) (*Product, *Variant, *events.APIGatewayProxyResponse) {
+		return nil, nil, &response
+	}
+
+	ifMatch, err := parseIfMatch(headerValue(request.Headers, "If-Match"))
+	if err != nil {
+		return fail(h.errorResponse(headers, 400, "Invalid If-Match header"))
+	}
+
+	for attempt := 1; ; attempt++ {
+		item, err := h.fetchProductItem(ctx, request.PathParameters["id"])
+		if err != nil {
+			return fail(h.errorResponse(headers, 500, fmt.Sprintf("Error getting product: %v", err)))
+		}
+		if item == nil {
+			return fail(h.errorResponse(headers, 404, "Product not found"))
+		}
+		var current Product
+		if err := attributevalue.UnmarshalMap(item, &current); err != nil {
+			return fail(h.errorResponse(headers, 500, fmt.Sprintf("Error unmarshaling product: %v", err)))
+		}
+		if current.IsDeleted() {
+			return fail(h.errorResponse(headers, 409, "Product is deleted, restore it before updating"))
+		}
+		if ifMatch.active() && !ifMatch.matches(current.Version) {
+			return fail(h.preconditionFailed(headers, item))
+		}
+
+		now := time.Now().UTC()
+		variants, guards, variant, err := mutate(&current, now)
+		var errs ValidationErrors
+		switch {
+		case errors.As(err, &errs):
+			return fail(h.validationErrorResponse(headers, errs))
+		case errors.Is(err, errVariantNotFound):
+			return fail(h.errorResponse(headers, 404, "Variant not found"))
+		case err != nil:
+			return fail(h.errorResponse(headers, 500, err.Error()))
+		}
+
+		product, err := h.writeVariants(ctx, &current, variants, guards, now)
+		if err == nil {
+			return &product, variant, nil
+		}
+
+		codes := cancellationReasons(err)
+		switch {
+		case codes == nil:
+			return fail(h.errorResponse(headers, 500, fmt.Sprintf("Error updating variants: %v", err)))
+		case conditionFailedAt(codes, 0):
+			latest := cancellationItem(err, 0)
+			switch {
+			case latest == nil:
+				return fail(h.errorResponse(headers, 404, "Product not found"))
+			case ifMatch.active():
+				return fail(h.preconditionFailed(headers, latest))
+			case attempt < maxUpdateAttempts:
+				continue
+			}
+			return fail(h.codedErrorResponse(headers, 409, ErrorCodeConcurrentUpdate, "Product is being modified concurrently, please retry"))
+		}
+		for i := 1; i < len(codes); i++ {
+			if conditionFailedAt(codes, i) {
+				return fail(h.errorResponse(headers, 409, "A product or variant with this SKU already exists"))
+			}
+		}
+		return fail(h.errorResponse(headers, 500, fmt.Sprintf("Error updating variants: %v", err)))
+	}
+}
+
+// writeVariants guarda la lista de variantes, el stock y estado derivados y la
+// versión + 1 en la misma transacción que los cambios de reservas de SKU
+func (h *ProductHandler) writeVariants(ctx context.Context, current *Product, variants []Variant, guards []types.TransactWriteItem, now time.Time) (Product, error) {
+	stock := current.Stock
+	if len(variants) > 0 {
+		stock = variantStock(variants)
+	}
+	status := deriveStatus(current.Status, stock)
+
+	values := map[string]types.AttributeValue{
+		":stock":        &types.AttributeValueMemberN{Value: strconv.Itoa(stock)},
+		":status":       &types.AttributeValueMemberS{Value: status},
+		":updated_at":   &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+		":next_version": &types.AttributeValueMemberN{Value: strconv.FormatInt(current.Version+1, 10)},
+	}
+	update := "SET stock = :stock, #status = :status, updated_at = :updated_at, version = :next_version"
+	if len(variants) > 0 {
+		list, err := attributevalue.Marshal(variants)
+		if err != nil {
+			return Product{}, fmt.Errorf("marshaling variants: %w", err)
+		}
+		values[":variants"] = list
+		update += ", variants = :variants"
+	} else {
+		update += " REMOVE variants"
+	}
+	read := versionPrecondition{versions: []int64{current.Version}}
+
+	writes := append([]types.TransactWriteItem{{
+		Update: &types.Update{
+			TableName: aws.String(h.tableName),
+			Key: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: current.ID},
+			},
+			UpdateExpression:                    aws.String(update),
+			ConditionExpression:                 aws.String("attribute_exists(id) AND attribute_not_exists(deleted_at) AND " + read.condition(values)),
+			ExpressionAttributeNames:            map[string]string{"#status": "status"},
+			ExpressionAttributeValues:           values,
+			ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+		},
+	}}, guards...)
+
+	if _, err := h.dynamoClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: writes}); err != nil {
+		return Product{}, err
+	}
+
+	product := *current
+	product.Variants = variants
+	product.Stock = stock
+	product.Status = status
+	product.UpdatedAt = now
+	product.Version = current.Version + 1
+	return product, nil
+}
+
+// reserveSKUGuard reserva un SKU de variante para el producto padre
+func (h *ProductHandler) reserveSKUGuard(sku, productID string) types.TransactWriteItem {
+	return types.TransactWriteItem{
+		Put: &types.Put{
+			TableName:           aws.String(h.tableName),
+			Item:                skuGuardItem(sku, productID),
+			ConditionExpression: aws.String("attribute_not_exists(id)"),
+		},
+	}
+}
+
+// readVariantParent lee el producto padre (también si está en la papelera)
+func (h *ProductHandler) readVariantParent(ctx context.Context, productID string, headers map[string]string) (*Product, *events.APIGatewayProxyResponse) {
+	item, err := h.fetchProductItem(ctx, productID)
+	if err != nil {
+		response := h.errorResponse(headers, 500, fmt.Sprintf("Error getting product: %v", err))
+		return nil, &response
+	}
+	if item == nil {
+		response := h.errorResponse(headers, 404, "Product not found")
+		return nil, &response
+	}
+	var product Product
+	if err := attributevalue.UnmarshalMap(item, &product); err != nil {
+		response := h.errorResponse(headers, 500, fmt.Sprintf("Error unmarshaling product: %v", err))
+		return nil, &response
+	}
+	return &product, nil
+}
+
+// listVariants devuelve las variantes de un producto
+func (h *ProductHandler) listVariants(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	product, failed := h.readVariantParent(ctx, request.PathParameters["id"], headers)
+	if failed != nil {
+		return *failed, nil
+	}
+	variants := product.Variants
+	if variants == nil {
+		variants = []Variant{}
+	}
+	headers["ETag"] = productETag(product.Version)
+
+	response := ProductResponse{
+		Success: true,
+		Message: "Variants retrieved successfully",
+		Data:    variants,
+	}
+
+	return h.successResponse(headers, response), nil
+}
+
+// getVariant devuelve una variante
+func (h *ProductHandler) getVariant(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	product, failed := h.readVariantParent(ctx, request.PathParameters["id"], headers)
+	if failed != nil {
+		return *failed, nil
+	}
+	i := product.variantIndex(request.PathParameters["variantId"])
+	if i < 0 {
+		return h.errorResponse(headers, 404, "Variant not found"), nil
+	}
+	headers["ETag"] = productETag(product.Version)
+
+	response := ProductResponse{
+		Success: true,
+		Message: "Variant retrieved successfully",
+		Data:    product.Variants[i],
+	}
+
+	return h.successResponse(headers, response), nil
+}
+
+// createVariant añade una variante y reserva su SKU
+func (h *ProductHandler) createVariant(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	var req CreateVariantRequest
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+		return h.errorResponse(headers, 400, "Invalid JSON body"), nil
+	}
+
+	product, variant, failed := h.changeVariants(ctx, request, headers, func(current *Product, now time.Time) ([]Variant, []types.TransactWriteItem, *Variant, error) {
+		var errs ValidationErrors
+		if len(current.Variants) >= MaxVariants {
+			return nil, nil, nil, ValidationErrors{{Field: "variants", Message: fmt.Sprintf("a product can have at most %d variants", MaxVariants)}}
+		}
+		if strings.TrimSpace(req.SKU) == "" {
+			errs = append(errs, ValidationError{Field: "sku", Message: "is required"})
+		}
+		// La primera variante pasa a llevar todo el stock del producto
+		stock := 0
+		switch {
+		case req.Stock == nil && len(current.Variants) == 0:
+			stock = current.Stock
+		case req.Stock == nil:
+		case *req.Stock < 0:
+			errs = append(errs, ValidationError{Field: "stock", Message: "must be greater than or equal to 0"})
+		case len(current.Variants) == 0 && current.Stock > 0 && *req.Stock != current.Stock:
+			errs = append(errs, ValidationError{Field: "stock", Message: fmt.Sprintf("the first variant takes the product stock (%d); omit stock or send that value", current.Stock)})
+		default:
+			stock = *req.Stock
+		}
+		variant := Variant{
+			ID:         uuid.New().String(),
+			SKU:        strings.TrimSpace(req.SKU),
+			Attributes: normalizeAttributes(req.Attributes, &errs),
+			Stock:      stock,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		if req.Price != nil {
+			price, priceErrs := parseVariantPrice(*req.Price, current)
+			errs = append(errs, priceErrs...)
+			variant.Price = price
+		}
+
+		variants := append(append([]Variant{}, current.Variants...), variant)
+		if errs = append(errs, validateVariantSet(current, variants, len(variants)-1)...); len(errs) > 0 {
+			return nil, nil, nil, errs
+		}
+		return variants, []types.TransactWriteItem{h.reserveSKUGuard(variant.SKU, current.ID)}, &variants[len(variants)-1], nil
+	})
+	if failed != nil {
+		return *failed, nil
+	}
+	h.search.Upsert(*product)
+	headers["ETag"] = productETag(product.Version)
+
+	body, _ := json.Marshal(ProductResponse{
+		Success: true,
+		Message: "Variant created successfully",
+		Data:    variant,
+	})
+	return events.APIGatewayProxyResponse{StatusCode: 201, Headers: headers, Body: string(body)}, nil
+}
+
+// updateVariant cambia SKU, atributos, stock o precio de una variante
+func (h *ProductHandler) updateVariant(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	var req UpdateVariantRequest
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+		return h.errorResponse(headers, 400, "Invalid JSON body"), nil
+	}
+	variantID := request.PathParameters["variantId"]
+
+	product, variant, failed := h.changeVariants(ctx, request, headers, func(current *Product, now time.Time) ([]Variant, []types.TransactWriteItem, *Variant, error) {
+		i := current.variantIndex(variantID)
+		if i < 0 {
+			return nil, nil, nil, errVariantNotFound
+		}
+		variants := append([]Variant{}, current.Variants...)
+		variant := &variants[i]
+		previousSKU := variant.SKU
+
+		var errs ValidationErrors
+		if req.SKU != nil {
+			if strings.TrimSpace(*req.SKU) == "" {
+				errs = append(errs, ValidationError{Field: "sku", Message: "must not be empty"})
+			}
+			variant.SKU = strings.TrimSpace(*req.SKU)
+		}
+		if req.Attributes != nil {
+			variant.Attributes = normalizeAttributes(*req.Attributes, &errs)
+		}
+		if req.Stock != nil {
+			if *req.Stock < 0 {
+				errs = append(errs, ValidationError{Field: "stock", Message: "must be greater than or equal to 0"})
+			}
+			variant.Stock = *req.Stock
+		}
+		if req.Price.Set {
+			variant.Price = nil
+			if req.Price.Value != nil {
+				price, priceErrs := parseVariantPrice(*req.Price.Value, current)
+				errs = append(errs, priceErrs...)
+				variant.Price = price
+			}
+		}
+		variant.UpdatedAt = now
+
+		if errs = append(errs, validateVariantSet(current, variants, i)...); len(errs) > 0 {
+			return nil, nil, nil, errs
+		}
+
+		var guards []types.TransactWriteItem
+		if skuGuardID(previousSKU) != skuGuardID(variant.SKU) {
+			guards = append(guards, h.reserveSKUGuard(variant.SKU, current.ID), h.releaseSKUGuard(previousSKU, current.ID))
+		}
+		return variants, guards, variant, nil
+	})
+	if failed != nil {
+		return *failed, nil
+	}
+	h.search.Upsert(*product)
+	headers["ETag"] = productETag(product.Version)
+
+	response := ProductResponse{
+		Success: true,
+		Message: "Variant updated successfully",
+		Data:    variant,
+	}
+
+	return h.successResponse(headers, response), nil
+}
+
+// deleteVariant quita una variante y libera su SKU. Quitar la última pide el
+// stock que le queda al producto (?stock=N); si no, se quedaría a 0.
+func (h *ProductHandler) deleteVariant(ctx context.Context, request events.APIGatewayProxyRequest, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	variantID := request.PathParameters["variantId"]
+	rawStock, hasStock := request.QueryStringParameters["stock"]
+
+	product, _, failed := h.changeVariants(ctx, request, headers, func(current *Product, now time.Time) ([]Variant, []types.TransactWriteItem, *Variant, error) {
+		i := current.variantIndex(variantID)
+		if i < 0 {
+			return nil, nil, nil, errVariantNotFound
+		}
+		removed := current.Variants[i]
+		variants := append(append([]Variant{}, current.Variants[:i]...), current.Variants[i+1:]...)
+		if len(variants) == 0 {
+			stock, err := strconv.Atoi(rawStock)
+			switch {
+			case !hasStock:
+				return nil, nil, nil, ValidationErrors{{Field: "stock", Message: "is required to remove the last variant"}}
+			case err != nil || stock < 0:
+				return nil, nil, nil, ValidationErrors{{Field: "stock", Message: "must be an integer greater than or equal to 0"}}
+			}
+			current.Stock = stock
+		}
+		return variants, []types.TransactWriteItem{h.releaseSKUGuard(removed.SKU, current.ID)}, &removed, nil
+	})
+	if failed != nil {
+		return *failed, nil
+	}
+	h.search.Upsert(*product)
+	headers["ETag"] = productETag(product.Version)
+
+	response := ProductResponse{
+		Success: true,
+		Message: "Variant deleted successfully",
+		Data:    product,
+	}
+
+	return h.successResponse(headers, response), nil
+}
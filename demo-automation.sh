@@ -260,6 +260,32 @@ demo_error_simulation() {
     wait_for_demo
 }
 
+# Demo 6b: Chaos Scenario
+demo_chaos_scenario() {
+    print_demo_step "Chaos Scenario Timeline"
+    
+    local scenario_file="configs/scenarios/lambda-surge-gcp-errors.yaml"
+    
+    print_info "Starting scenario from $scenario_file..."
+    print_command "curl -X POST $DASHBOARD_URL/api/v1/scenarios?preempt=true"
+    response=$(curl -s -X POST "$DASHBOARD_URL/api/v1/scenarios?preempt=true" \
+        -H "Content-Type: application/x-yaml" \
+        --data-binary "@$scenario_file")
+    echo "$response" | jq . 2>/dev/null || echo "$response"
+    
+    scenario_id=$(echo "$response" | jq -r '.scenario.id' 2>/dev/null)
+    if [ -n "$scenario_id" ] && [ "$scenario_id" != "null" ]; then
+        sleep 5
+        print_info "Scenario progress..."
+        curl -s "$DASHBOARD_URL/api/v1/scenarios/$scenario_id?samples=false" | jq '{status, current_step, progress}' 2>/dev/null
+        
+        print_info "Cancelling scenario and restoring baseline..."
+        demo_api_call "DELETE" "$DASHBOARD_URL/api/v1/scenarios/$scenario_id" "" "Cancel Scenario"
+    fi
+    
+    wait_for_demo
+}
+
 # Demo 7: Dashboard Integration
 demo_dashboard() {
     print_demo_step "Interactive Dashboard Demo"
@@ -349,6 +375,7 @@ main() {
     echo -e "  🌐 Google Cloud Functions orders API"
     echo -e "  🔄 Load simulation and auto-scaling"
     echo -e "  🚨 Error simulation and alerting"
+    echo -e "  🎬 Chaos scenario timelines"
     echo -e "  🎛️  Interactive dashboard"
     echo -e "  📈 Monitoring and observability"
     echo -e "  💰 Cost optimization"
@@ -366,6 +393,7 @@ main() {
     demo_gcp_orders
     demo_load_simulation
     demo_error_simulation
+    demo_chaos_scenario
     demo_dashboard
     demo_monitoring
     demo_cost_optimization
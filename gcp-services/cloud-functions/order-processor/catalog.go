@@ -0,0 +1,247 @@
+package orderprocessor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// El catálogo es la products API de AWS: los precios, nombres e imágenes de un
+// pedido salen de ahí, nunca del cliente.
+
+// Cliente del catálogo
+const (
+	CatalogRequestTimeout   = 5 * time.Second
+	CatalogBreakerThreshold = 5                // Fallos seguidos que abren el circuito
+	CatalogBreakerCooldown  = 30 * time.Second // Tiempo abierto antes de probar de nuevo
+)
+
+var (
+	errProductNotFound = errors.New("product not found")
+	errCircuitOpen     = errors.New("products API circuit breaker is open")
+)
+
+// CatalogProduct -> Producto tal como lo devuelve GET /products/{id}
+type CatalogProduct struct {
+	ID        string                 `json:"id"`
+	Name      string                 `json:"name"`
+	SKU       string                 `json:"sku"`
+	Status    string                 `json:"status"` // active, inactive, out_of_stock
+	Stock     int                    `json:"stock"`
+	ImageURL  string                 `json:"image_url"`
+	Price     json.Number            `json:"price"` // Decimal exacto en Currency
+	Currency  string                 `json:"currency"`
+	PriceList map[string]json.Number `json:"price_list,omitempty"`
+	Variants  []CatalogVariant       `json:"variants,omitempty"`
+	DeletedAt *time.Time             `json:"deleted_at,omitempty"`
+}
+
+// CatalogVariant -> Variante de un producto del catálogo
+type CatalogVariant struct {
+	ID         string            `json:"id"`
+	SKU        string            `json:"sku"`
+	Attributes map[string]string `json:"attributes"`
+	Stock      int               `json:"stock"`
+	Price      *CatalogMoney     `json:"price,omitempty"` // nil = precio del producto
+}
+
+// CatalogMoney -> Importe del catálogo
+type CatalogMoney struct {
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency"`
+}
+
+// Catalog -> Origen de los productos de un pedido
+type Catalog interface {
+	GetProduct(ctx context.Context, productID string) (*CatalogProduct, error)
+}
+
+// circuitBreaker corta las llamadas a un servicio caído: tras threshold fallos
+// seguidos rechaza durante cooldown y luego deja pasar una llamada de prueba
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow indica si se puede llamar; con el circuito medio abierto solo pasa una
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return errCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// record registra el resultado de una llamada
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// ProductsAPIClient -> Cliente HTTP de la products API
+type ProductsAPIClient struct {
+	baseURL    string
+	apiKey     string // X-Api-Key de las rutas /reservations
+	httpClient *http.Client
+	breaker    *circuitBreaker
+}
+
+func NewProductsAPIClient(baseURL, apiKey string) *ProductsAPIClient {
+	return &ProductsAPIClient{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: CatalogRequestTimeout},
+		breaker:    newCircuitBreaker(CatalogBreakerThreshold, CatalogBreakerCooldown),
+	}
+}
+
+// apiError -> Respuesta 4xx de la products API
+type apiError struct {
+	StatusCode int
+	Code       string // Campo code del envelope (p. ej. insufficient_stock)
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("products API returned %d: %s", e.StatusCode, e.Message)
+}
+
+// call hace una petición a la products API y decodifica su campo data en out.
+// Los 4xx son respuestas válidas del servicio (*apiError); los errores de red y
+// los 5xx cuentan como fallos para el circuit breaker.
+func (c *ProductsAPIClient) call(ctx context.Context, method, path string, body, out interface{}) error {
+	if err := c.breaker.allow(); err != nil {
+		return err
+	}
+
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			c.breaker.record(true)
+			return fmt.Errorf("marshaling products API request: %w", err)
+		}
+		payload = bytes.NewReader(data)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, payload)
+	if err != nil {
+		c.breaker.record(true)
+		return fmt.Errorf("building products API request: %w", err)
+	}
+	request.Header.Set("Accept", "application/json")
+	if c.apiKey != "" {
+		request.Header.Set("X-Api-Key", c.apiKey)
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		c.breaker.record(false)
+		return fmt.Errorf("calling products API: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 500 {
+		c.breaker.record(false)
+		return fmt.Errorf("products API returned %d", response.StatusCode)
+	}
+	var envelope struct {
+		Success bool            `json:"success"`
+		Message string          `json:"message"`
+		Code    string          `json:"code"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&envelope); err != nil && response.StatusCode < 300 {
+		c.breaker.record(false)
+		return fmt.Errorf("decoding products API response: %w", err)
+	}
+	c.breaker.record(true)
+	if response.StatusCode >= 300 {
+		return &apiError{StatusCode: response.StatusCode, Code: envelope.Code, Message: envelope.Message}
+	}
+	if out != nil {
+		if err := json.Unmarshal(envelope.Data, out); err != nil {
+			return fmt.Errorf("decoding products API response: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetProduct lee un producto; un 404 es errProductNotFound
+func (c *ProductsAPIClient) GetProduct(ctx context.Context, productID string) (*CatalogProduct, error) {
+	var product CatalogProduct
+	err := c.call(ctx, http.MethodGet, "/products/"+url.PathEscape(productID), nil, &product)
+	var failed *apiError
+	if errors.As(err, &failed) && failed.StatusCode == http.StatusNotFound {
+		return nil, errProductNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// MemoryCatalog es un catálogo con reservas de stock en memoria (desarrollo
+// local y pruebas)
+type MemoryCatalog struct {
+	mu           sync.RWMutex
+	products     map[string]CatalogProduct
+	reservations map[string]memoryReservation
+}
+
+func NewMemoryCatalog(products ...CatalogProduct) *MemoryCatalog {
+	catalog := &MemoryCatalog{products: map[string]CatalogProduct{}, reservations: map[string]memoryReservation{}}
+	for _, product := range products {
+		catalog.Put(product)
+	}
+	return catalog
+}
+
+// Put añade o reemplaza un producto
+func (c *MemoryCatalog) Put(product CatalogProduct) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.products[product.ID] = product
+}
+
+func (c *MemoryCatalog) GetProduct(ctx context.Context, productID string) (*CatalogProduct, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	product, ok := c.products[productID]
+	if !ok {
+		return nil, errProductNotFound
+	}
+	return &product, nil
+}
@@ -0,0 +1,263 @@
+package orderprocessor
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/api/option"
+	pubsub "google.golang.org/api/pubsub/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Crear un pedido o pedir su reproceso publica un comando en Pub/Sub; el
+// consumidor (ProcessOrderPubSub) ejecuta el workflow. Pub/Sub entrega al menos
+// una vez: cada mensaje procesado se registra en processed_messages y las
+// reentregas se descartan.
+
+// Comandos
+const (
+	CommandProcessOrder = "process_order"
+
+	DefaultCommandsTopic = "order-commands"
+
+	ProcessedMessageTTL = 7 * 24 * time.Hour // Más que la retención de Pub/Sub
+)
+
+// OrderCommand -> Mensaje de comando publicado en el topic de pedidos
+type OrderCommand struct {
+	ID          string    `json:"id"` // Deduplica si el mensaje llega sin messageId
+	Type        string    `json:"type"`
+	OrderID     string    `json:"order_id"`
+	Force       bool      `json:"force,omitempty"` // Ejecutar ya un reintento programado
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// CommandPublisher -> Destino de los comandos de pedido
+type CommandPublisher interface {
+	Publish(ctx context.Context, command OrderCommand) (messageID string, err error)
+}
+
+// newProcessOrderCommand crea el comando que dispara el workflow de un pedido
+func newProcessOrderCommand(orderID string, force bool) OrderCommand {
+	return OrderCommand{
+		ID:          uuid.New().String(),
+		Type:        CommandProcessOrder,
+		OrderID:     orderID,
+		Force:       force,
+		RequestedAt: time.Now(),
+	}
+}
+
+// pubsubPublisher publica en un topic de Pub/Sub por la API REST. Con
+// PUBSUB_EMULATOR_HOST definido usa el emulador.
+type pubsubPublisher struct {
+	topics *pubsub.ProjectsTopicsService
+	topic  string
+}
+
+func NewPubSubPublisher(ctx context.Context, projectID, topic string) (CommandPublisher, error) {
+	var opts []option.ClientOption
+	if host := os.Getenv("PUBSUB_EMULATOR_HOST"); host != "" {
+		opts = append(opts, option.WithEndpoint("http://"+host+"/"), option.WithoutAuthentication())
+	}
+	service, err := pubsub.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating Pub/Sub client: %w", err)
+	}
+	return &pubsubPublisher{
+		topics: pubsub.NewProjectsTopicsService(service),
+		topic:  fmt.Sprintf("projects/%s/topics/%s", projectID, topic),
+	}, nil
+}
+
+func (p *pubsubPublisher) Publish(ctx context.Context, command OrderCommand) (string, error) {
+	data, err := json.Marshal(command)
+	if err != nil {
+		return "", fmt.Errorf("marshaling command: %w", err)
+	}
+	result, err := p.topics.Publish(p.topic, &pubsub.PublishRequest{
+		Messages: []*pubsub.PubsubMessage{{
+			Data: base64.StdEncoding.EncodeToString(data),
+			Attributes: map[string]string{
+				"type":     command.Type,
+				"order_id": command.OrderID,
+			},
+		}},
+	}).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("publishing command: %w", err)
+	}
+	if len(result.MessageIds) == 0 {
+		return "", errors.New("publishing command: no message id returned")
+	}
+	return result.MessageIds[0], nil
+}
+
+// MemoryBus es un publicador/suscriptor en memoria (desarrollo local y pruebas).
+// Deliver entrega los mensajes pendientes; los que fallan quedan para la
+// siguiente entrega con el mismo ID, como una reentrega de Pub/Sub.
+type MemoryBus struct {
+	mu         sync.Mutex
+	next       int
+	pending    []PubSubMessage
+	subscriber func(ctx context.Context, m PubSubMessage) error
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{}
+}
+
+// Subscribe registra el consumidor de los mensajes
+func (b *MemoryBus) Subscribe(subscriber func(ctx context.Context, m PubSubMessage) error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscriber = subscriber
+}
+
+func (b *MemoryBus) Publish(ctx context.Context, command OrderCommand) (string, error) {
+	data, err := json.Marshal(command)
+	if err != nil {
+		return "", fmt.Errorf("marshaling command: %w", err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.next++
+	message := PubSubMessage{
+		Data:        data,
+		Attributes:  map[string]string{"type": command.Type, "order_id": command.OrderID},
+		MessageID:   "mem-" + strconv.Itoa(b.next),
+		PublishTime: time.Now(),
+	}
+	b.pending = append(b.pending, message)
+	log.Printf("📨 Queued %s for order %s (%s)", command.Type, command.OrderID, message.MessageID)
+	return message.MessageID, nil
+}
+
+// Pending devuelve una copia de los mensajes sin entregar
+func (b *MemoryBus) Pending() []PubSubMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]PubSubMessage(nil), b.pending...)
+}
+
+// Deliver entrega los mensajes pendientes al suscriptor y devuelve cuántos se
+// confirmaron
+func (b *MemoryBus) Deliver(ctx context.Context) (int, error) {
+	b.mu.Lock()
+	messages, subscriber := b.pending, b.subscriber
+	b.pending = nil
+	b.mu.Unlock()
+	if subscriber == nil {
+		b.mu.Lock()
+		b.pending = append(messages, b.pending...)
+		b.mu.Unlock()
+		return 0, errors.New("memory bus has no subscriber")
+	}
+
+	acked := 0
+	var failed []PubSubMessage
+	for _, message := range messages {
+		if err := subscriber(ctx, message); err != nil {
+			log.Printf("Message %s nacked: %v", message.MessageID, err)
+			failed = append(failed, message)
+			continue
+		}
+		acked++
+	}
+	b.mu.Lock()
+	b.pending = append(failed, b.pending...)
+	b.mu.Unlock()
+	return acked, nil
+}
+
+// processedMessage -> Registro de un mensaje ya procesado
+type processedMessage struct {
+	MessageID   string    `firestore:"message_id"`
+	CommandType string    `firestore:"command_type"`
+	OrderID     string    `firestore:"order_id"`
+	Result      string    `firestore:"result"`
+	ProcessedAt time.Time `firestore:"processed_at"`
+	ExpiresAt   time.Time `firestore:"expires_at"` // Política TTL de Firestore
+}
+
+// HandlePubSubMessage decodifica un comando y lo ejecuta. Devolver error hace
+// que Pub/Sub reentregue el mensaje; los mensajes inválidos se descartan.
+func (h *OrderHandler) HandlePubSubMessage(ctx context.Context, m PubSubMessage) error {
+	var command OrderCommand
+	if err := json.Unmarshal(m.Data, &command); err != nil {
+		log.Printf("Discarding undecodable message %s: %v", m.MessageID, err)
+		return nil
+	}
+	if command.Type != CommandProcessOrder || command.OrderID == "" {
+		log.Printf("Discarding unknown command %q in message %s", command.Type, m.MessageID)
+		return nil
+	}
+
+	dedupID := m.MessageID
+	if dedupID == "" {
+		dedupID = "command-" + command.ID
+	}
+	ref := h.firestoreClient.Collection(CollectionProcessedMessages).Doc(dedupID)
+	if _, err := ref.Get(ctx); err == nil {
+		log.Printf("🔁 Skipping duplicate message %s for order %s", dedupID, command.OrderID)
+		return nil
+	} else if !isNotFound(err) {
+		return fmt.Errorf("checking message %s: %w", dedupID, err)
+	}
+
+	// Dos entregas simultáneas del mismo mensaje no ejecutan el workflow dos
+	// veces: el lease deja pasar a una y la otra recibe errWorkflowLeased
+	workflow, err := h.RunWorkflow(ctx, command.OrderID, command.Force)
+	var result string
+	switch {
+	case errors.Is(err, errWorkflowFinished):
+		result = "already_finished"
+	case errors.Is(err, errWorkflowLeased):
+		result = "already_running"
+	case errors.Is(err, errWorkflowNotDue):
+		result = "retry_scheduled" // Lo ejecutará ResumeWorkflows a su hora
+	case err != nil && workflow == nil:
+		return fmt.Errorf("processing order %s: %w", command.OrderID, err)
+	case err != nil:
+		// Interrumpido: queda guardado y ResumeWorkflows lo retoma
+		log.Printf("Order workflow for %s not finished: %v", command.OrderID, err)
+		result = workflow.Status
+	default:
+		result = workflow.Status
+	}
+
+	now := time.Now()
+	_, err = ref.Create(ctx, processedMessage{
+		MessageID:   dedupID,
+		CommandType: command.Type,
+		OrderID:     command.OrderID,
+		Result:      result,
+		ProcessedAt: now,
+		ExpiresAt:   now.Add(ProcessedMessageTTL),
+	})
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		// El workflow ya avanzó; una reentrega solo encontraría el lease o el final
+		log.Printf("Error recording message %s: %v", dedupID, err)
+	}
+	return nil
+}
+
+// publishProcessCommand publica el comando de proceso de un pedido
+func (h *OrderHandler) publishProcessCommand(ctx context.Context, orderID string, force bool) (string, error) {
+	messageID, err := h.commands.Publish(ctx, newProcessOrderCommand(orderID, force))
+	if err != nil {
+		return "", err
+	}
+	log.Printf("📨 Published %s for order %s (message %s)", CommandProcessOrder, orderID, messageID)
+	return messageID, nil
+}
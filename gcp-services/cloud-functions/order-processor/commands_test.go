@@ -0,0 +1,159 @@
+package orderprocessor
+
+// Necesita el emulador de Firestore (y las variables que pide init):
+//
+//	gcloud emulators firestore start --host-port=localhost:8085
+//	FIRESTORE_EMULATOR_HOST=localhost:8085 GCP_PROJECT_ID=test PAYMENT_PROVIDER=fake \
+//	PRODUCTS_API_URL=http://localhost:8080 go test ./...
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// countingPayments cuenta las autorizaciones: una por ejecución del workflow
+type countingPayments struct {
+	PaymentProvider
+	mu         sync.Mutex
+	authorized int
+}
+
+func (p *countingPayments) Authorize(ctx context.Context, request PaymentRequest) (*PaymentResult, error) {
+	p.mu.Lock()
+	p.authorized++
+	p.mu.Unlock()
+	return p.PaymentProvider.Authorize(ctx, request)
+}
+
+func (p *countingPayments) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.authorized
+}
+
+// emulatorHandler crea un handler contra el emulador de Firestore con catálogo,
+// bus y pagos en memoria
+func emulatorHandler(t *testing.T) (*OrderHandler, *MemoryBus, *countingPayments) {
+	t.Helper()
+	host := os.Getenv("FIRESTORE_EMULATOR_HOST")
+	if host == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST not set, skipping Firestore emulator tests")
+	}
+	conn, err := net.DialTimeout("tcp", host, time.Second)
+	if err != nil {
+		t.Skipf("Firestore emulator not reachable at %s: %v", host, err)
+	}
+	conn.Close()
+
+	client, err := firestore.NewClient(context.Background(), "test-"+time.Now().Format("150405.000000"))
+	if err != nil {
+		t.Fatalf("creating Firestore client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	h := NewOrderHandler(client, "test")
+	bus := NewMemoryBus()
+	bus.Subscribe(h.HandlePubSubMessage)
+	h.commands = bus
+	catalog := NewMemoryCatalog(CatalogProduct{
+		ID:       "prod-1",
+		Name:     "Keyboard",
+		SKU:      "KB-1",
+		Status:   "active",
+		Stock:    10,
+		Price:    "49.99",
+		Currency: DefaultCurrency,
+	})
+	h.catalog, h.inventory = catalog, catalog
+	payments := &countingPayments{PaymentProvider: NewFakePaymentProvider()}
+	h.payments = payments
+	return h, bus, payments
+}
+
+func createTestOrder(t *testing.T, h *OrderHandler) string {
+	t.Helper()
+	body, _ := json.Marshal(CreateOrderRequest{
+		UserID:        "user-1",
+		UserEmail:     "user@example.com",
+		Items:         []OrderItem{{ProductID: "prod-1", Quantity: 1}},
+		PaymentMethod: "credit_card",
+		ShippingInfo:  Shipping{FullName: "Test User", Address: "Main St 1", City: "Madrid", Country: "ES"},
+	})
+	recorder := httptest.NewRecorder()
+	h.HandleHTTP(recorder, httptest.NewRequest(http.MethodPost, "/orders", bytes.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("creating order: status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	var response struct {
+		Data Order `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding order: %v", err)
+	}
+	return response.Data.ID
+}
+
+func TestDeliverTwiceRunsWorkflowOnce(t *testing.T) {
+	h, bus, payments := emulatorHandler(t)
+	ctx := context.Background()
+	orderID := createTestOrder(t, h)
+
+	pending := bus.Pending()
+	if len(pending) != 1 {
+		t.Fatalf("expected one queued command, got %d", len(pending))
+	}
+	messageID := pending[0].MessageID
+
+	// La primera entrega procesa el mensaje pero pierde el ack: Pub/Sub lo reentrega
+	deliveries := 0
+	bus.Subscribe(func(ctx context.Context, m PubSubMessage) error {
+		deliveries++
+		if err := h.HandlePubSubMessage(ctx, m); err != nil {
+			return err
+		}
+		if deliveries == 1 {
+			return errors.New("ack lost")
+		}
+		return nil
+	})
+
+	if acked, err := bus.Deliver(ctx); err != nil || acked != 0 {
+		t.Fatalf("first delivery: acked %d, err %v", acked, err)
+	}
+	if pending := bus.Pending(); len(pending) != 1 || pending[0].MessageID != messageID {
+		t.Fatalf("expected %s to be redelivered, pending %+v", messageID, pending)
+	}
+	if acked, err := bus.Deliver(ctx); err != nil || acked != 1 {
+		t.Fatalf("second delivery: acked %d, err %v", acked, err)
+	}
+
+	if deliveries != 2 {
+		t.Fatalf("subscriber saw %d deliveries, want 2", deliveries)
+	}
+	if count := payments.count(); count != 1 {
+		t.Fatalf("workflow authorized payment %d times, want 1", count)
+	}
+
+	snapshot, err := h.firestoreClient.Collection(CollectionProcessedMessages).Doc(messageID).Get(ctx)
+	if err != nil {
+		t.Fatalf("reading processed message: %v", err)
+	}
+	var processed processedMessage
+	if err := snapshot.DataTo(&processed); err != nil {
+		t.Fatalf("decoding processed message: %v", err)
+	}
+	if processed.OrderID != orderID || processed.Result != WorkflowCompleted {
+		t.Fatalf("processed message = %+v, want order %s completed", processed, orderID)
+	}
+}
@@ -21,6 +21,11 @@ MEMORY="256MB"
 TIMEOUT="60s"
 TRIGGER_TYPE="http"
 FIRESTORE_DATABASE="(default)"
+COMMANDS_TOPIC="${ORDER_COMMANDS_TOPIC:-order-commands}"
+PRODUCTS_API_URL="${PRODUCTS_API_URL}"
+PAYMENT_PROVIDER="${PAYMENT_PROVIDER}"
+STRIPE_SECRET="${STRIPE_SECRET:-stripe-api-key}"
+PRODUCTS_API_SECRET="${PRODUCTS_API_SECRET:-products-api-key}"
 
 echo -e "${BLUE} Starting Google Cloud Functions deployment...${NC}"
 
@@ -63,6 +68,44 @@ fi
 
 print_status "Using Project ID: $PROJECT_ID"
 
+# Products API (AWS): fuente de precios y stock de los pedidos
+if [ -z "$PRODUCTS_API_URL" ]; then
+    print_error "PRODUCTS_API_URL not set"
+    echo "Please set PRODUCTS_API_URL to the products API base URL (API Gateway stage URL)"
+    exit 1
+fi
+
+print_status "Using Products API: $PRODUCTS_API_URL"
+
+# Las rutas /reservations exigen la INTERNAL_API_KEY de la products API; aquí
+# se lee de Secret Manager como PRODUCTS_API_KEY
+SECRET_FLAGS=(--set-secrets="PRODUCTS_API_KEY=$PRODUCTS_API_SECRET:latest")
+print_status "Products API key from secret $PRODUCTS_API_SECRET"
+
+# Pasarela de pagos: stripe, con la clave en Secret Manager, o fake (sin cobros
+# reales). Hay que elegirla: el fake guarda los pagos en memoria de cada
+# instancia y las funciones HTTP, consumidor y resumer no la comparten
+if [ -z "$PAYMENT_PROVIDER" ]; then
+    print_error "PAYMENT_PROVIDER not set"
+    echo "Please set PAYMENT_PROVIDER to stripe, or to fake for test environments"
+    exit 1
+fi
+
+case "$PAYMENT_PROVIDER" in
+    fake)
+        print_warning "Using the fake payment provider: no real charges will be made"
+        print_warning "Fake payments live in each instance's memory; captures and refunds may not find them"
+        ;;
+    stripe)
+        SECRET_FLAGS=(--set-secrets="PRODUCTS_API_KEY=$PRODUCTS_API_SECRET:latest,STRIPE_API_KEY=$STRIPE_SECRET:latest")
+        print_status "Using Stripe (API key from secret $STRIPE_SECRET)"
+        ;;
+    *)
+        print_error "PAYMENT_PROVIDER must be fake or stripe"
+        exit 1
+        ;;
+esac
+
 # Set the project
 gcloud config set project "$PROJECT_ID"
 
@@ -75,6 +118,8 @@ REQUIRED_APIS=(
     "cloudbuild.googleapis.com"
     "logging.googleapis.com"
     "pubsub.googleapis.com"
+    "cloudscheduler.googleapis.com"
+    "secretmanager.googleapis.com"
 )
 
 for api in "${REQUIRED_APIS[@]}"; do
@@ -149,11 +194,25 @@ EOF
 
 print_status "Firestore collections configuration ready"
 
+# Step 3b: Pub/Sub topic for order commands and TTL for message dedup records
+echo -e "${BLUE} Setting up Pub/Sub...${NC}"
+
+if ! gcloud pubsub topics describe "$COMMANDS_TOPIC" &> /dev/null; then
+    gcloud pubsub topics create "$COMMANDS_TOPIC"
+    print_status "Topic created: $COMMANDS_TOPIC"
+else
+    print_status "Topic already exists: $COMMANDS_TOPIC"
+fi
+
+gcloud firestore fields ttls update expires_at \
+    --collection-group=processed_messages \
+    --enable-ttl &> /dev/null || print_warning "Could not enable TTL on processed_messages"
+
 # Step 4: Prepare function files
 echo -e "${BLUE} Preparing function files...${NC}"
 
 # Check if required files exist
-REQUIRED_FILES=("main.go" "handler.go" "models.go" "go.mod")
+REQUIRED_FILES=("main.go" "handler.go" "models.go" "workflow.go" "saga.go" "commands.go" "catalog.go" "pricing.go" "inventory.go" "payments.go" "statemachine.go" "refunds.go" "go.mod")
 for file in "${REQUIRED_FILES[@]}"; do
     if [ ! -f "$file" ]; then
         print_error "Required file missing: $file"
@@ -188,7 +247,8 @@ gcloud functions deploy "$FUNCTION_NAME" \
     --memory="$MEMORY" \
     --timeout="$TIMEOUT" \
     --allow-unauthenticated \
-    --set-env-vars="GCP_PROJECT_ID=$PROJECT_ID" \
+    --set-env-vars="GCP_PROJECT_ID=$PROJECT_ID,ORDER_COMMANDS_TOPIC=$COMMANDS_TOPIC,PRODUCTS_API_URL=$PRODUCTS_API_URL,PAYMENT_PROVIDER=$PAYMENT_PROVIDER" \
+    "${SECRET_FLAGS[@]}" \
     --max-instances=10 \
     --min-instances=0
 
@@ -236,7 +296,8 @@ gcloud functions deploy "${FUNCTION_NAME}-health" \
     --memory="128MB" \
     --timeout="10s" \
     --allow-unauthenticated \
-    --set-env-vars="GCP_PROJECT_ID=$PROJECT_ID" \
+    --set-env-vars="GCP_PROJECT_ID=$PROJECT_ID,PRODUCTS_API_URL=$PRODUCTS_API_URL,PAYMENT_PROVIDER=$PAYMENT_PROVIDER" \
+    "${SECRET_FLAGS[@]}" \
     --max-instances=5 \
     --min-instances=0 &> /dev/null
 
@@ -244,6 +305,93 @@ HEALTH_URL=$(gcloud functions describe "${FUNCTION_NAME}-health" --region="$REGI
 
 print_status "Health check function deployed"
 
+# Step 9a: Deploy the command consumer behind a push subscription
+echo -e "${BLUE} Deploying order command consumer...${NC}"
+
+gcloud functions deploy "${FUNCTION_NAME}-consumer" \
+    --gen2 \
+    --runtime="$RUNTIME" \
+    --region="$REGION" \
+    --source=. \
+    --entry-point=ProcessOrderPush \
+    --trigger=http \
+    --memory="$MEMORY" \
+    --timeout="$TIMEOUT" \
+    --no-allow-unauthenticated \
+    --set-env-vars="GCP_PROJECT_ID=$PROJECT_ID,ORDER_COMMANDS_TOPIC=$COMMANDS_TOPIC,PRODUCTS_API_URL=$PRODUCTS_API_URL,PAYMENT_PROVIDER=$PAYMENT_PROVIDER" \
+    "${SECRET_FLAGS[@]}" \
+    --max-instances=10 \
+    --min-instances=0 &> /dev/null
+
+CONSUMER_URL=$(gcloud functions describe "${FUNCTION_NAME}-consumer" --region="$REGION" --gen2 --format="value(serviceConfig.uri)")
+PUSH_SA="${PUSH_SERVICE_ACCOUNT:-$(gcloud iam service-accounts list --filter="email~compute@developer.gserviceaccount.com" --format="value(email)" | head -n 1)}"
+
+gcloud functions add-invoker-policy-binding "${FUNCTION_NAME}-consumer" \
+    --region="$REGION" \
+    --member="serviceAccount:$PUSH_SA" &> /dev/null
+
+# El ack deadline cubre una ejecución completa del workflow (45s)
+if ! gcloud pubsub subscriptions describe "${COMMANDS_TOPIC}-consumer" &> /dev/null; then
+    gcloud pubsub subscriptions create "${COMMANDS_TOPIC}-consumer" \
+        --topic="$COMMANDS_TOPIC" \
+        --push-endpoint="$CONSUMER_URL" \
+        --push-auth-service-account="$PUSH_SA" \
+        --ack-deadline=120 \
+        --min-retry-delay=10s \
+        --max-retry-delay=600s &> /dev/null
+else
+    gcloud pubsub subscriptions update "${COMMANDS_TOPIC}-consumer" \
+        --push-endpoint="$CONSUMER_URL" \
+        --push-auth-service-account="$PUSH_SA" &> /dev/null
+fi
+
+print_status "Command consumer deployed and subscribed to $COMMANDS_TOPIC"
+
+# Step 9b: Deploy workflow resumer and schedule it
+echo -e "${BLUE} Deploying workflow resumer...${NC}"
+
+gcloud functions deploy "${FUNCTION_NAME}-resume" \
+    --gen2 \
+    --runtime="$RUNTIME" \
+    --region="$REGION" \
+    --source=. \
+    --entry-point=ResumeWorkflows \
+    --trigger=http \
+    --memory="$MEMORY" \
+    --timeout="$TIMEOUT" \
+    --no-allow-unauthenticated \
+    --set-env-vars="GCP_PROJECT_ID=$PROJECT_ID,ORDER_COMMANDS_TOPIC=$COMMANDS_TOPIC,PRODUCTS_API_URL=$PRODUCTS_API_URL,PAYMENT_PROVIDER=$PAYMENT_PROVIDER" \
+    "${SECRET_FLAGS[@]}" \
+    --max-instances=1 \
+    --min-instances=0 &> /dev/null
+
+RESUME_URL=$(gcloud functions describe "${FUNCTION_NAME}-resume" --region="$REGION" --gen2 --format="value(serviceConfig.uri)")
+SCHEDULER_SA="${SCHEDULER_SERVICE_ACCOUNT:-$(gcloud iam service-accounts list --filter="email~compute@developer.gserviceaccount.com" --format="value(email)" | head -n 1)}"
+
+gcloud functions add-invoker-policy-binding "${FUNCTION_NAME}-resume" \
+    --region="$REGION" \
+    --member="serviceAccount:$SCHEDULER_SA" &> /dev/null
+
+# Cada 5 minutos: publica un process_order por cada workflow cuyo lease caducó
+# (instancia congelada o caída) o con un reintento vencido; los ejecuta el consumidor
+if gcloud scheduler jobs describe "${FUNCTION_NAME}-resume" --location="$REGION" &> /dev/null; then
+    gcloud scheduler jobs update http "${FUNCTION_NAME}-resume" \
+        --location="$REGION" \
+        --schedule="*/5 * * * *" \
+        --uri="$RESUME_URL" \
+        --http-method=POST \
+        --oidc-service-account-email="$SCHEDULER_SA" &> /dev/null
+else
+    gcloud scheduler jobs create http "${FUNCTION_NAME}-resume" \
+        --location="$REGION" \
+        --schedule="*/5 * * * *" \
+        --uri="$RESUME_URL" \
+        --http-method=POST \
+        --oidc-service-account-email="$SCHEDULER_SA" &> /dev/null
+fi
+
+print_status "Workflow resumer deployed and scheduled"
+
 # Step 10: Display deployment information
 echo -e "${BLUE} Deployment Summary${NC}"
 echo -e "${GREEN}================================${NC}"
@@ -253,6 +401,9 @@ echo -e "Project ID: $PROJECT_ID"
 echo -e "Firestore: Enabled"
 echo -e "Function URL: $FUNCTION_URL"
 echo -e "Health Check: $HEALTH_URL"
+echo -e "Workflow Resumer: $RESUME_URL"
+echo -e "Commands Topic: $COMMANDS_TOPIC"
+echo -e "Command Consumer: $CONSUMER_URL"
 echo -e "${GREEN}================================${NC}"
 
 # Step 11: Test the deployment
@@ -287,19 +438,17 @@ echo -e "${BLUE}💡 Quick test commands:${NC}"
 echo -e "# List orders (empty initially)"
 echo -e "curl -X GET $FUNCTION_URL/orders"
 echo ""
-echo -e "# Create a test order"
+echo -e "# Create a test order (product_id must exist in the products API; prices come from the catalog)"
 echo -e "curl -X POST $FUNCTION_URL/orders -H 'Content-Type: application/json' -d '{
   \"user_id\": \"user_123\",
   \"user_email\": \"test@example.com\",
   \"payment_method\": \"credit_card\",
+  \"payment_token\": \"pm_card_visa\",
   \"items\": [
     {
       \"product_id\": \"prod_1\",
-      \"product_name\": \"Test Product\",
       \"sku\": \"TEST001\",
-      \"quantity\": 2,
-      \"unit_price\": 29.99,
-      \"total_price\": 59.98
+      \"quantity\": 2
     }
   ],
   \"shipping_info\": {
@@ -314,6 +463,21 @@ echo -e "curl -X POST $FUNCTION_URL/orders -H 'Content-Type: application/json' -
   }
 }'"
 echo ""
+echo -e "# Get the processing workflow of an order"
+echo -e "curl -X GET $FUNCTION_URL/orders/ORDER_ID/workflow"
+echo ""
+echo -e "# Ship a paid order and get its status history"
+echo -e "curl -X PUT $FUNCTION_URL/orders/ORDER_ID -H 'Content-Type: application/json' -d '{\"status\": \"shipped\", \"tracking_id\": \"TRACKING_ID\"}'"
+echo -e "curl -X GET $FUNCTION_URL/orders/ORDER_ID/history"
+echo ""
+echo -e "# Refund one unit of an item (no body refunds everything left)"
+echo -e "curl -X POST $FUNCTION_URL/orders/ORDER_ID/refund -H 'Content-Type: application/json' -d '{\"refund_id\": \"REFUND_ID\", \"items\": [{\"sku\": \"SKU\", \"quantity\": 1}]}'"
+echo ""
+echo -e "# Return flow: request, approve, receive (refunds and restocks)"
+echo -e "curl -X POST $FUNCTION_URL/orders/ORDER_ID/returns -H 'Content-Type: application/json' -d '{\"items\": [{\"sku\": \"SKU\", \"quantity\": 1}], \"reason\": \"damaged\"}'"
+echo -e "curl -X POST $FUNCTION_URL/orders/ORDER_ID/returns/RETURN_ID/approve"
+echo -e "curl -X POST $FUNCTION_URL/orders/ORDER_ID/returns/RETURN_ID/receive"
+echo ""
 echo -e "# Get order statistics"
 echo -e "curl -X GET $FUNCTION_URL/orders/stats"
 
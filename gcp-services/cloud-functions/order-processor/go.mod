@@ -5,8 +5,9 @@ go 1.21
 require (
 	cloud.google.com/go/firestore v1.13.0
 	cloud.google.com/go/functions v1.15.4
-	github.com/google/uuid v1.3.1
+	github.com/google/uuid v1.4.0
 	google.golang.org/api v0.149.0
+	google.golang.org/grpc v1.59.0
 )
 
 require (
@@ -28,10 +29,10 @@ require (
 	golang.org/x/sys v0.13.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
 	golang.org/x/time v0.3.0 // indirect
+	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/genproto v0.0.0-20231016165738-49dd2c1f3d0b // indirect
 	google.golang.org/genproto/googleapis/api v0.0.0-20231016165738-49dd2c1f3d0b // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20231030173426-d783a09b4405 // indirect
-	google.golang.org/grpc v1.59.0 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
-)
\ No newline at end of file
+)
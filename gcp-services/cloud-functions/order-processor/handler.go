@@ -3,6 +3,7 @@ package orderprocessor
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,18 +13,26 @@ import (
 
 	"cloud.google.com/go/firestore"
 	"github.com/google/uuid"
-	"google.golang.org/api/iterator"
 )
 
 type OrderHandler struct {
 	firestoreClient *firestore.Client
 	projectID       string
+	commands        CommandPublisher
+	catalog         Catalog
+	inventory       Inventory
+	payments        PaymentProvider
 }
 
 func NewOrderHandler(firestoreClient *firestore.Client, projectID string) *OrderHandler {
+	catalog := NewMemoryCatalog()
 	return &OrderHandler{
 		firestoreClient: firestoreClient,
 		projectID:       projectID,
+		commands:        NewMemoryBus(),
+		catalog:         catalog,
+		inventory:       catalog,
+		payments:        NewFakePaymentProvider(),
 	}
 }
 
@@ -41,45 +50,7 @@ func (h *OrderHandler) HandleHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if workflow.Status != WorkflowFailed {
-		workflow.Status = WorkflowCompleted
-		completedAt := time.Now()
-		workflow.CompletedAt = &completedAt
-
-		// Actualizar estado del pedido
-		updates := []firestore.Update{
-			{Path: "status", Value: StatusProcessing},
-			{Path: "payment_status", Value: PaymentCompleted},
-			{Path: "processed_at", Value: completedAt},
-			{Path: "updated_at", Value: completedAt},
-		}
-
-		_, err := h.firestoreClient.Collection(CollectionOrders).Doc(orderID).Update(ctx, updates)
-		if err != nil {
-			log.Printf("Error updating order status: %v", err)
-		} else {
-			log.Printf("✅ Order workflow completed successfully for: %s", orderID)
-		}
-	}
-}
-
-// Helper functions
-func (h *OrderHandler) successResponse(w http.ResponseWriter, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(data)
-}
-
-func (h *OrderHandler) errorResponse(w http.ResponseWriter, statusCode int, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	
-	response := OrderResponse{
-		Success: false,
-		Message: message,
-	}
-	json.NewEncoder(w).Encode(response)
-}ctx := r.Context()
+	ctx := r.Context()
 
 	// Router básico basado en path y método
 	path := strings.TrimPrefix(r.URL.Path, "/")
@@ -110,6 +81,30 @@ func (h *OrderHandler) errorResponse(w http.ResponseWriter, statusCode int, mess
 		// GET /orders/stats
 		h.getOrderStats(ctx, w, r)
 		
+	case r.Method == "GET" && len(pathParts) == 3 && pathParts[0] == "orders" && pathParts[2] == "workflow":
+		// GET /orders/{id}/workflow
+		h.getOrderWorkflow(ctx, w, r, pathParts[1])
+		
+	case r.Method == "GET" && len(pathParts) == 3 && pathParts[0] == "orders" && pathParts[2] == "history":
+		// GET /orders/{id}/history
+		h.getOrderHistory(ctx, w, r, pathParts[1])
+		
+	case r.Method == "POST" && len(pathParts) == 3 && pathParts[0] == "orders" && pathParts[2] == "refund":
+		// POST /orders/{id}/refund
+		h.refundOrderHandler(ctx, w, r, pathParts[1])
+		
+	case r.Method == "POST" && len(pathParts) == 3 && pathParts[0] == "orders" && pathParts[2] == "returns":
+		// POST /orders/{id}/returns
+		h.createReturn(ctx, w, r, pathParts[1])
+		
+	case r.Method == "POST" && len(pathParts) == 5 && pathParts[0] == "orders" && pathParts[2] == "returns" && pathParts[4] == "approve":
+		// POST /orders/{id}/returns/{returnId}/approve
+		h.approveReturn(ctx, w, r, pathParts[1], pathParts[3])
+		
+	case r.Method == "POST" && len(pathParts) == 5 && pathParts[0] == "orders" && pathParts[2] == "returns" && pathParts[4] == "receive":
+		// POST /orders/{id}/returns/{returnId}/receive
+		h.receiveReturn(ctx, w, r, pathParts[1], pathParts[3])
+		
 	case r.Method == "POST" && len(pathParts) == 3 && pathParts[0] == "orders" && pathParts[2] == "process":
 		// POST /orders/{id}/process
 		h.processOrder(ctx, w, r, pathParts[1])
@@ -265,6 +260,24 @@ func (h *OrderHandler) createOrder(ctx context.Context, w http.ResponseWriter, r
 		return
 	}
 
+	// Precios, nombres e imágenes salen del catálogo, no de la petición
+	items, err := h.resolveOrderItems(ctx, req.Items, DefaultCurrency)
+	var invalid *OrderValidationError
+	switch {
+	case errors.As(err, &invalid):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(OrderResponse{
+			Success: false,
+			Message: "Some order items are not valid",
+			Data:    invalid.Items,
+		})
+		return
+	case err != nil:
+		h.errorResponse(w, http.StatusServiceUnavailable, fmt.Sprintf("Products catalog unavailable: %v", err))
+		return
+	}
+
 	// Crear pedido
 	now := time.Now()
 	order := Order{
@@ -272,9 +285,10 @@ func (h *OrderHandler) createOrder(ctx context.Context, w http.ResponseWriter, r
 		UserID:        req.UserID,
 		UserEmail:     req.UserEmail,
 		Status:        StatusPending,
-		Items:         req.Items,
+		Items:         mergeOrderItems(items),
 		Currency:      DefaultCurrency,
 		PaymentMethod: req.PaymentMethod,
+		PaymentToken:  req.PaymentToken,
 		PaymentStatus: PaymentPending,
 		ShippingInfo:  req.ShippingInfo,
 		CreatedAt:     now,
@@ -285,15 +299,20 @@ func (h *OrderHandler) createOrder(ctx context.Context, w http.ResponseWriter, r
 	// Calcular total
 	order.CalculateTotal()
 
-	// Guardar en Firestore
-	_, err := h.firestoreClient.Collection(CollectionOrders).Doc(order.ID).Set(ctx, order)
-	if err != nil {
+	// Guardar pedido y workflow juntos: ningún pedido queda sin workflow
+	batch := h.firestoreClient.Batch()
+	batch.Set(h.firestoreClient.Collection(CollectionOrders).Doc(order.ID), order)
+	batch.Set(h.workflowRef(order.ID), newWorkflow(order.ID, now))
+	if _, err := batch.Commit(ctx); err != nil {
 		h.errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error creating order: %v", err))
 		return
 	}
 
-	// Iniciar workflow de procesamiento (async)
-	go h.startOrderWorkflow(context.Background(), order.ID)
+	// El workflow lo ejecuta el consumidor de Pub/Sub. Si publicar falla, el
+	// workflow ya está guardado como pending y lo retoma ResumeWorkflows.
+	if _, err := h.publishProcessCommand(ctx, order.ID, false); err != nil {
+		log.Printf("Error publishing process command for %s: %v", order.ID, err)
+	}
 
 	response := OrderResponse{
 		Success: true,
@@ -317,35 +336,37 @@ func (h *OrderHandler) updateOrder(ctx context.Context, w http.ResponseWriter, r
 		return
 	}
 
-	// Construir updates
-	updates := []firestore.Update{
-		{Path: "updated_at", Value: time.Now()},
-	}
-
+	// Los cambios de status y payment_status pasan por la máquina de estados;
+	// el resto de campos se escriben en la misma transacción
+	change := OrderTransition{Source: SourceAPI, Reason: req.Reason}
 	if req.Status != nil {
-		updates = append(updates, firestore.Update{Path: "status", Value: *req.Status})
+		change.Status = *req.Status
 	}
 	if req.PaymentStatus != nil {
-		updates = append(updates, firestore.Update{Path: "payment_status", Value: *req.PaymentStatus})
+		change.PaymentStatus = *req.PaymentStatus
 	}
 	if req.ShippingInfo != nil {
-		updates = append(updates, firestore.Update{Path: "shipping_info", Value: *req.ShippingInfo})
+		change.Updates = append(change.Updates, firestore.Update{Path: "shipping_info", Value: *req.ShippingInfo})
 	}
 	if req.Notes != nil {
-		updates = append(updates, firestore.Update{Path: "notes", Value: *req.Notes})
+		change.Updates = append(change.Updates, firestore.Update{Path: "notes", Value: *req.Notes})
 	}
 	if req.TrackingID != nil {
-		updates = append(updates, firestore.Update{Path: "shipping_info.tracking_id", Value: *req.TrackingID})
+		change.Updates = append(change.Updates, firestore.Update{Path: "shipping_info.tracking_id", Value: *req.TrackingID})
 	}
 
 	// Actualizar en Firestore
-	_, err := h.firestoreClient.Collection(CollectionOrders).Doc(orderID).Update(ctx, updates)
-	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			h.errorResponse(w, http.StatusNotFound, "Order not found")
-		} else {
-			h.errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error updating order: %v", err))
-		}
+	_, err := h.transitionOrder(ctx, orderID, change)
+	var invalid *InvalidTransitionError
+	switch {
+	case errors.Is(err, errOrderNotFound):
+		h.errorResponse(w, http.StatusNotFound, "Order not found")
+		return
+	case errors.As(err, &invalid):
+		h.errorResponse(w, http.StatusConflict, invalid.Error())
+		return
+	case err != nil:
+		h.errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error updating order: %v", err))
 		return
 	}
 
@@ -379,38 +400,22 @@ func (h *OrderHandler) cancelOrder(ctx context.Context, w http.ResponseWriter, r
 		return
 	}
 
-	// Obtener pedido actual
-	doc, err := h.firestoreClient.Collection(CollectionOrders).Doc(orderID).Get(ctx)
-	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			h.errorResponse(w, http.StatusNotFound, "Order not found")
-		} else {
-			h.errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error getting order: %v", err))
-		}
-		return
-	}
-
-	var order Order
-	if err := doc.DataTo(&order); err != nil {
-		h.errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error converting order: %v", err))
+	// Solo pending y processing pueden cancelarse (CanBeCancelled); si el
+	// workflow ya cobró, al completar verá el pedido cancelado y reembolsará
+	order, err := h.transitionOrder(ctx, orderID, OrderTransition{
+		Status: StatusCancelled,
+		Source: SourceAPI,
+		Reason: "cancelled by request",
+	})
+	var invalid *InvalidTransitionError
+	switch {
+	case errors.Is(err, errOrderNotFound):
+		h.errorResponse(w, http.StatusNotFound, "Order not found")
 		return
-	}
-	order.ID = doc.Ref.ID
-
-	// Verificar si se puede cancelar
-	if !order.CanBeCancelled() {
-		h.errorResponse(w, http.StatusBadRequest, "Order cannot be cancelled in current status")
+	case errors.As(err, &invalid):
+		h.errorResponse(w, http.StatusConflict, "Order cannot be cancelled in current status: "+invalid.Error())
 		return
-	}
-
-	// Actualizar status
-	updates := []firestore.Update{
-		{Path: "status", Value: StatusCancelled},
-		{Path: "updated_at", Value: time.Now()},
-	}
-
-	_, err = h.firestoreClient.Collection(CollectionOrders).Doc(orderID).Update(ctx, updates)
-	if err != nil {
+	case err != nil:
 		h.errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error cancelling order: %v", err))
 		return
 	}
@@ -418,6 +423,7 @@ func (h *OrderHandler) cancelOrder(ctx context.Context, w http.ResponseWriter, r
 	response := OrderResponse{
 		Success: true,
 		Message: "Order cancelled successfully",
+		Data:    order,
 	}
 
 	h.successResponse(w, response)
@@ -430,15 +436,47 @@ func (h *OrderHandler) processOrder(ctx context.Context, w http.ResponseWriter,
 		return
 	}
 
-	// Iniciar workflow de procesamiento
-	go h.startOrderWorkflow(context.Background(), orderID)
+	if _, err := h.fetchOrder(ctx, orderID); err != nil {
+		if isNotFound(err) {
+			h.errorResponse(w, http.StatusNotFound, "Order not found")
+		} else {
+			h.errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error getting order: %v", err))
+		}
+		return
+	}
+
+	// Un workflow terminado no se vuelve a encolar
+	doc, err := h.workflowRef(orderID).Get(ctx)
+	if err != nil && !isNotFound(err) {
+		h.errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error getting workflow: %v", err))
+		return
+	}
+	if err == nil {
+		var workflow ProcessOrderWorkflow
+		if err := doc.DataTo(&workflow); err == nil && (workflow.Status == WorkflowCompleted || workflow.Status == WorkflowFailed) {
+			h.errorResponse(w, http.StatusConflict, "Order has already been processed")
+			return
+		}
+	}
+
+	messageID, err := h.publishProcessCommand(ctx, orderID, true)
+	if err != nil {
+		h.errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error queuing order processing: %v", err))
+		return
+	}
 
 	response := OrderResponse{
 		Success: true,
-		Message: "Order processing started",
+		Message: "Order processing queued",
+		Data: map[string]string{
+			"order_id":   orderID,
+			"message_id": messageID,
+		},
 	}
 
-	h.successResponse(w, response)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
 }
 
 // getOrderStats obtiene estadísticas de pedidos
@@ -470,7 +508,7 @@ func (h *OrderHandler) getOrderStats(ctx context.Context, w http.ResponseWriter,
 				continue
 			}
 
-			totalRevenue += order.TotalAmount
+			totalRevenue += order.NetAmount()
 			statusCount[order.Status]++
 			paymentMethodCount[order.PaymentMethod]++
 
@@ -480,7 +518,7 @@ func (h *OrderHandler) getOrderStats(ctx context.Context, w http.ResponseWriter,
 		}
 
 		stats.PendingOrders = statusCount[StatusPending]
-		stats.ProcessedOrders = statusCount[StatusProcessing]
+		stats.ProcessedOrders = statusCount[StatusProcessing] + statusCount[StatusPaid]
 		stats.CompletedOrders = statusCount[StatusDelivered]
 		stats.CancelledOrders = statusCount[StatusCancelled]
 		stats.TotalRevenue = totalRevenue
@@ -506,39 +544,20 @@ func (h *OrderHandler) getOrderStats(ctx context.Context, w http.ResponseWriter,
 	h.successResponse(w, response)
 }
 
-// startOrderWorkflow inicia el workflow de procesamiento de pedidos
-func (h *OrderHandler) startOrderWorkflow(ctx context.Context, orderID string) {
-	log.Printf("Starting order workflow for order: %s", orderID)
-
-	workflow := ProcessOrderWorkflow{
-		OrderID:   orderID,
-		Step:      StepValidateOrder,
-		Status:    WorkflowRunning,
-		StartedAt: time.Now(),
-	}
+// Helper functions
+func (h *OrderHandler) successResponse(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
 
-	// Simular pasos del workflow
-	steps := []string{
-		StepValidateOrder,
-		StepReserveInventory,
-		StepProcessPayment,
-		StepUpdateInventory,
-		StepSendNotifications,
-		StepCompleteOrder,
+func (h *OrderHandler) errorResponse(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	
+	response := OrderResponse{
+		Success: false,
+		Message: message,
 	}
-
-	for _, step := range steps {
-		workflow.Step = step
-		log.Printf("📋 Processing step: %s for order: %s", step, orderID)
-
-		// Simular procesamiento (en producción sería lógica real)
-		time.Sleep(1 * time.Second)
-
-		// Simular algunos fallos ocasionalmente
-		if step == StepProcessPayment && time.Now().UnixNano()%10 == 0 {
-			log.Printf("Payment failed for order: %s", orderID)
-			workflow.Status = WorkflowFailed
-			workflow.ErrorMessage = "Payment processing failed"
-			break
-		}
-	}
\ No newline at end of file
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,310 @@
+package orderprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// El stock vive en la products API de AWS. El workflow reserva cada item antes
+// de cobrar (hold con caducidad), consume las reservas tras el cobro y las
+// libera al compensar. Los reembolsos y devoluciones reponen parte de una
+// reserva consumida. Los IDs de reserva salen del pedido, así que repetir un
+// paso repite las mismas llamadas y la API las trata como duplicadas.
+
+// Reservas de inventario
+const (
+	InventoryReserve = "reserve"
+	InventoryConsume = "consume"
+	InventoryRelease = "release"
+	InventoryRestock = "restock"
+
+	ReservationHeld     = "held"
+	ReservationConsumed = "consumed"
+	ReservationReleased = "released"
+	ReservationExpired  = "expired"
+
+	InventoryHoldTTL = 30 * time.Minute // Cubre los reintentos del cobro y la espera al resumer
+)
+
+var (
+	errInsufficientStock   = errors.New("insufficient stock")
+	errReservationRejected = errors.New("reservation rejected")
+	errReservationExpired  = errors.New("reservation expired")
+	errReservationNotFound = errors.New("reservation not found")
+)
+
+// ReservationRequest -> Reserva de stock de un item
+type ReservationRequest struct {
+	ID         string `json:"reservation_id"`
+	ProductID  string `json:"product_id"`
+	SKU        string `json:"sku,omitempty"`
+	Quantity   int    `json:"quantity"`
+	TTLSeconds int    `json:"ttl_seconds,omitempty"`
+	Reference  string `json:"reference,omitempty"`
+}
+
+// StockReservation -> Reserva tal como la devuelve la products API
+type StockReservation struct {
+	ID            string    `json:"id"`
+	ProductID     string    `json:"product_id"`
+	VariantID     string    `json:"variant_id,omitempty"`
+	SKU           string    `json:"sku"`
+	Quantity      int       `json:"quantity"`
+	Returned      int       `json:"returned,omitempty"` // Unidades consumidas que se repusieron
+	Status        string    `json:"status"`             // held, consumed, released, expired
+	HoldExpiresAt time.Time `json:"hold_expires_at"`
+}
+
+// Inventory -> Reservas de stock
+type Inventory interface {
+	Reserve(ctx context.Context, request ReservationRequest) (*StockReservation, error)
+	Consume(ctx context.Context, reservationID string) (*StockReservation, error)
+	Release(ctx context.Context, reservationID string) (*StockReservation, error)
+	Restock(ctx context.Context, reservationID, restockID string, quantity int) (*StockReservation, error)
+}
+
+// Códigos de error de la products API para las reservas
+const (
+	apiCodeConcurrentUpdate    = "concurrent_update"
+	apiCodeInsufficientStock   = "insufficient_stock"
+	apiCodeReservationNotFound = "reservation_not_found"
+	apiCodeReservationExpired  = "reservation_expired"
+)
+
+// reservationError traduce los 4xx de las reservas según su código; el resto
+// de errores (red, 5xx, circuito abierto, escritura concurrente) se reintentan
+func reservationError(err error) error {
+	var failed *apiError
+	if !errors.As(err, &failed) {
+		return err
+	}
+	switch failed.Code {
+	case apiCodeReservationNotFound:
+		return errReservationNotFound
+	case apiCodeReservationExpired:
+		return errReservationExpired
+	case apiCodeInsufficientStock:
+		return fmt.Errorf("%w: %s", errInsufficientStock, failed.Message)
+	case apiCodeConcurrentUpdate:
+		return err
+	}
+	return fmt.Errorf("%w: %s", errReservationRejected, failed.Message)
+}
+
+func (c *ProductsAPIClient) Reserve(ctx context.Context, request ReservationRequest) (*StockReservation, error) {
+	var reservation StockReservation
+	if err := c.call(ctx, http.MethodPost, "/reservations", request, &reservation); err != nil {
+		return nil, reservationError(err)
+	}
+	return &reservation, nil
+}
+
+func (c *ProductsAPIClient) Consume(ctx context.Context, reservationID string) (*StockReservation, error) {
+	var reservation StockReservation
+	if err := c.call(ctx, http.MethodPost, "/reservations/"+url.PathEscape(reservationID)+"/consume", nil, &reservation); err != nil {
+		return nil, reservationError(err)
+	}
+	return &reservation, nil
+}
+
+func (c *ProductsAPIClient) Release(ctx context.Context, reservationID string) (*StockReservation, error) {
+	var reservation StockReservation
+	if err := c.call(ctx, http.MethodPost, "/reservations/"+url.PathEscape(reservationID)+"/release", nil, &reservation); err != nil {
+		return nil, reservationError(err)
+	}
+	return &reservation, nil
+}
+
+func (c *ProductsAPIClient) Restock(ctx context.Context, reservationID, restockID string, quantity int) (*StockReservation, error) {
+	var reservation StockReservation
+	body := map[string]interface{}{"restock_id": restockID, "quantity": quantity}
+	if err := c.call(ctx, http.MethodPost, "/reservations/"+url.PathEscape(reservationID)+"/restock", body, &reservation); err != nil {
+		return nil, reservationError(err)
+	}
+	return &reservation, nil
+}
+
+// memoryReservation -> Reserva del catálogo en memoria
+type memoryReservation struct {
+	StockReservation
+	variant  int             // -1 = el producto
+	restocks map[string]bool // restock_id ya aplicados
+}
+
+// Reserve descuenta el stock del producto o la variante; repetir el ID devuelve
+// la misma reserva
+func (c *MemoryCatalog) Reserve(ctx context.Context, request ReservationRequest) (*StockReservation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.reservations[request.ID]; ok {
+		if existing.Status == ReservationReleased || existing.Status == ReservationExpired {
+			return nil, fmt.Errorf("%w: reservation is already %s", errReservationRejected, existing.Status)
+		}
+		reservation := existing.StockReservation
+		return &reservation, nil
+	}
+
+	product, ok := c.products[request.ProductID]
+	if !ok || product.DeletedAt != nil || product.Status == "inactive" {
+		return nil, fmt.Errorf("%w: product is not available", errReservationRejected)
+	}
+	line, err := resolveLine(&product, request.SKU)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errReservationRejected, err)
+	}
+	if line.stock() < request.Quantity {
+		return nil, fmt.Errorf("%w for SKU %s: requested %d, available %d", errInsufficientStock, line.sku(), request.Quantity, line.stock())
+	}
+
+	ttl := time.Duration(request.TTLSeconds) * time.Second
+	if ttl == 0 {
+		ttl = InventoryHoldTTL
+	}
+	reservation := memoryReservation{
+		StockReservation: StockReservation{
+			ID:            request.ID,
+			ProductID:     product.ID,
+			SKU:           line.sku(),
+			Quantity:      request.Quantity,
+			Status:        ReservationHeld,
+			HoldExpiresAt: time.Now().Add(ttl),
+		},
+		variant: -1,
+	}
+	if line.variant != nil {
+		reservation.VariantID = line.variant.ID
+		for i := range product.Variants {
+			if product.Variants[i].ID == line.variant.ID {
+				reservation.variant = i
+			}
+		}
+	}
+	c.adjustStock(&product, reservation.variant, -request.Quantity)
+	c.reservations[request.ID] = reservation
+	result := reservation.StockReservation
+	return &result, nil
+}
+
+func (c *MemoryCatalog) Consume(ctx context.Context, reservationID string) (*StockReservation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reservation, ok := c.reservations[reservationID]
+	switch {
+	case !ok:
+		return nil, errReservationNotFound
+	case reservation.Status == ReservationHeld && time.Now().After(reservation.HoldExpiresAt):
+		return nil, errReservationExpired
+	case reservation.Status == ReservationHeld:
+		reservation.Status = ReservationConsumed
+		c.reservations[reservationID] = reservation
+	case reservation.Status != ReservationConsumed:
+		return nil, fmt.Errorf("%w: reservation is already %s", errReservationRejected, reservation.Status)
+	}
+	result := reservation.StockReservation
+	return &result, nil
+}
+
+func (c *MemoryCatalog) Release(ctx context.Context, reservationID string) (*StockReservation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reservation, ok := c.reservations[reservationID]
+	if !ok {
+		return nil, errReservationNotFound
+	}
+	if reservation.Status == ReservationHeld || reservation.Status == ReservationConsumed {
+		if product, ok := c.products[reservation.ProductID]; ok {
+			c.adjustStock(&product, reservation.variant, reservation.Quantity-reservation.Returned)
+		}
+		reservation.Status = ReservationReleased
+		c.reservations[reservationID] = reservation
+	}
+	result := reservation.StockReservation
+	return &result, nil
+}
+
+// Restock repone parte de una reserva consumida; repetir restockID no repone dos veces
+func (c *MemoryCatalog) Restock(ctx context.Context, reservationID, restockID string, quantity int) (*StockReservation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reservation, ok := c.reservations[reservationID]
+	switch {
+	case !ok:
+		return nil, errReservationNotFound
+	case reservation.restocks[restockID]:
+	case reservation.Status != ReservationConsumed:
+		return nil, fmt.Errorf("%w: reservation is %s", errReservationRejected, reservation.Status)
+	case quantity > reservation.Quantity-reservation.Returned:
+		return nil, fmt.Errorf("%w: only %d units left to restock", errReservationRejected, reservation.Quantity-reservation.Returned)
+	default:
+		if product, ok := c.products[reservation.ProductID]; ok {
+			c.adjustStock(&product, reservation.variant, quantity)
+		}
+		restocks := map[string]bool{restockID: true}
+		for id := range reservation.restocks {
+			restocks[id] = true
+		}
+		reservation.restocks = restocks
+		reservation.Returned += quantity
+		if reservation.Returned == reservation.Quantity {
+			reservation.Status = ReservationReleased
+		}
+		c.reservations[reservationID] = reservation
+	}
+	result := reservation.StockReservation
+	return &result, nil
+}
+
+// adjustStock suma delta al stock (el del producto es la suma de sus variantes)
+func (c *MemoryCatalog) adjustStock(product *CatalogProduct, variant, delta int) {
+	product.Variants = append([]CatalogVariant(nil), product.Variants...)
+	if variant >= 0 && variant < len(product.Variants) {
+		product.Variants[variant].Stock += delta
+	}
+	product.Stock += delta
+	c.products[product.ID] = *product
+}
+
+// reservationID -> ID de la reserva del item i de un pedido (estable entre reintentos)
+func reservationID(orderID string, i int) string {
+	return "order-" + orderID + "-" + strconv.Itoa(i)
+}
+
+// reservationStatus devuelve el último estado conocido de una reserva ("" si
+// ninguna llamada tuvo éxito)
+func reservationStatus(workflow *ProcessOrderWorkflow, id string) string {
+	status := ""
+	for _, update := range workflow.InventoryUpdates {
+		if update.ReservationID == id && update.Error == "" {
+			status = update.Status
+		}
+	}
+	return status
+}
+
+// recordInventoryCall añade al workflow el resultado de una llamada de inventario
+func recordInventoryCall(workflow *ProcessOrderWorkflow, item OrderItem, id, operation string, reservation *StockReservation, err error) {
+	update := InventoryUpdate{
+		ProductID:     item.ProductID,
+		SKU:           item.SKU,
+		Quantity:      item.Quantity,
+		Operation:     operation,
+		ReservationID: id,
+		RecordedAt:    time.Now(),
+	}
+	switch {
+	case err != nil:
+		update.Error = err.Error()
+	case reservation != nil:
+		update.Status = reservation.Status
+		if reservation.Status == ReservationHeld {
+			expiresAt := reservation.HoldExpiresAt
+			update.HoldExpiresAt = &expiresAt
+		}
+	}
+	workflow.InventoryUpdates = append(workflow.InventoryUpdates, update)
+}
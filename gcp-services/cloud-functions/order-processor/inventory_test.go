@@ -0,0 +1,51 @@
+package orderprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReservationErrorUsesCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		code    string
+		message string
+		want    error // nil = se reintenta tal cual
+	}{
+		{"reservation not found", http.StatusNotFound, "reservation_not_found", "Reservation not found", errReservationNotFound},
+		{"product not found", http.StatusNotFound, "product_not_found", "Product not found", errReservationRejected},
+		{"expired", http.StatusGone, "reservation_expired", "Reservation expired", errReservationExpired},
+		{"insufficient stock", http.StatusConflict, "insufficient_stock", "Not enough units", errInsufficientStock},
+		{"concurrent update", http.StatusConflict, "concurrent_update", "Try again", nil},
+		{"already released", http.StatusConflict, "reservation_conflict", "Reservation is already released", errReservationRejected},
+		{"no code", http.StatusConflict, "", "Insufficient stock for SKU KB-1", errReservationRejected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				fmt.Fprintf(w, `{"success":false,"message":%q,"code":%q}`, tt.message, tt.code)
+			}))
+			defer server.Close()
+
+			_, err := NewProductsAPIClient(server.URL, "key").Consume(context.Background(), "order-1-0")
+			if tt.want == nil {
+				var failed *apiError
+				if !errors.As(err, &failed) || failed.Code != tt.code {
+					t.Fatalf("error = %v, want the API error with code %s", err, tt.code)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -2,9 +2,12 @@ package orderprocessor
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"cloud.google.com/go/firestore"
 )
@@ -34,9 +37,53 @@ func init() {
 	// Inicializar handler
 	handler = NewOrderHandler(firestoreClient, projectID)
 
+	// Comandos de proceso por Pub/Sub (PUBSUB_EMULATOR_HOST para el emulador)
+	topic := os.Getenv("ORDER_COMMANDS_TOPIC")
+	if topic == "" {
+		topic = DefaultCommandsTopic
+	}
+	publisher, err := NewPubSubPublisher(ctx, projectID, topic)
+	if err != nil {
+		log.Fatalf("Failed to create Pub/Sub publisher: %v", err)
+	}
+	handler.commands = publisher
+
+	// Catálogo y reservas de stock: la products API de AWS. Las reservas exigen
+	// PRODUCTS_API_KEY (la INTERNAL_API_KEY de la API)
+	productsAPIURL := os.Getenv("PRODUCTS_API_URL")
+	if productsAPIURL == "" {
+		log.Fatal("PRODUCTS_API_URL environment variable must be set")
+	}
+	productsAPIKey := os.Getenv("PRODUCTS_API_KEY")
+	if productsAPIKey == "" {
+		log.Println("⚠️  PRODUCTS_API_KEY not set, the products API will reject stock reservations unless it runs in local mode")
+	}
+	productsAPI := NewProductsAPIClient(productsAPIURL, productsAPIKey)
+	handler.catalog = productsAPI
+	handler.inventory = productsAPI
+
+	// Pasarela de pagos: stripe (STRIPE_API_KEY; STRIPE_API_URL para un servidor
+	// compatible) o fake, el proveedor determinista para entornos sin cobros reales
+	paymentProvider := os.Getenv("PAYMENT_PROVIDER")
+	switch paymentProvider {
+	case "stripe":
+		apiKey := os.Getenv("STRIPE_API_KEY")
+		if apiKey == "" {
+			log.Fatal("STRIPE_API_KEY environment variable must be set for the stripe payment provider")
+		}
+		handler.payments = NewStripeProvider(os.Getenv("STRIPE_API_URL"), apiKey)
+	case "fake":
+		handler.payments = NewFakePaymentProvider()
+	default:
+		log.Fatalf("PAYMENT_PROVIDER must be stripe or fake, got %q", paymentProvider)
+	}
+
 	log.Println("🚀 Order Processor Cloud Function initialized successfully")
 	log.Printf("📊 Project ID: %s", projectID)
 	log.Printf("🔥 Firestore: Connected")
+	log.Printf("📨 Commands topic: %s", topic)
+	log.Printf("🛒 Products API: %s", productsAPIURL)
+	log.Printf("💳 Payment provider: %s", paymentProvider)
 }
 
 // ProcessOrder es el punto de entrada para la Cloud Function HTTP
@@ -44,19 +91,58 @@ func ProcessOrder(w http.ResponseWriter, r *http.Request) {
 	handler.HandleHTTP(w, r)
 }
 
-// ProcessOrderPubSub maneja mensajes de Pub/Sub (para procesamiento asíncrono)
+// ResumeWorkflows publica un comando por cada workflow abandonado o con un
+// reintento vencido; lo invoca Cloud Scheduler
+func ResumeWorkflows(w http.ResponseWriter, r *http.Request) {
+	resumed, err := handler.ResumeWorkflows(r.Context())
+	if err != nil {
+		log.Printf("Error resuming workflows: %v", err)
+		handler.errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error resuming workflows: %v", err))
+		return
+	}
+	handler.successResponse(w, OrderResponse{
+		Success: true,
+		Message: "Workflows resumed",
+		Data:    map[string]int{"resumed": resumed},
+	})
+}
+
+// ProcessOrderPubSub consume los comandos del topic de pedidos (trigger de Pub/Sub)
 func ProcessOrderPubSub(ctx context.Context, m PubSubMessage) error {
-	log.Printf("📨 Received Pub/Sub message: %s", string(m.Data))
-	
-	// Aquí puedes procesar mensajes asíncronos
-	// Por ejemplo, para procesar pagos, actualizar inventario, etc.
-	
-	return nil
+	log.Printf("📨 Received Pub/Sub message %s", m.MessageID)
+	return handler.HandlePubSubMessage(ctx, m)
+}
+
+// ProcessOrderPush consume los comandos de una suscripción push: 2xx confirma el
+// mensaje y cualquier otro código hace que Pub/Sub lo reentregue
+func ProcessOrderPush(w http.ResponseWriter, r *http.Request) {
+	var push PubSubPushRequest
+	if err := json.NewDecoder(r.Body).Decode(&push); err != nil {
+		// Un envelope ilegible no mejora reentregándolo
+		log.Printf("Discarding invalid push request: %v", err)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := handler.HandlePubSubMessage(r.Context(), push.Message); err != nil {
+		log.Printf("Error processing message %s: %v", push.Message.MessageID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // PubSubMessage representa un mensaje de Pub/Sub
 type PubSubMessage struct {
-	Data []byte `json:"data"`
+	Data        []byte            `json:"data"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	MessageID   string            `json:"messageId"`
+	PublishTime time.Time         `json:"publishTime"`
+}
+
+// PubSubPushRequest -> Cuerpo de una entrega push
+type PubSubPushRequest struct {
+	Message      PubSubMessage `json:"message"`
+	Subscription string        `json:"subscription"`
 }
 
 // HealthCheck endpoint para verificar que la función está funcionando
@@ -64,12 +150,5 @@ func HealthCheck(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	
-	response := map[string]interface{}{
-		"status":    "healthy",
-		"service":   "order-processor",
-		"timestamp": "2024-01-01T00:00:00Z",
-		"version":   "1.0.0",
-	}
-	
 	w.Write([]byte(`{"status":"healthy","service":"order-processor","version":"1.0.0"}`))
 }
\ No newline at end of file
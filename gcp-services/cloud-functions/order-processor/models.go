@@ -14,12 +14,17 @@ type Order struct {
 	TotalAmount   float64     `json:"total_amount" firestore:"total_amount"`
 	Currency      string      `json:"currency" firestore:"currency"`
 	PaymentMethod string      `json:"payment_method" firestore:"payment_method"`
+	PaymentToken  string      `json:"payment_token,omitempty" firestore:"payment_token"` // Método de pago tokenizado en el proveedor
 	PaymentStatus string      `json:"payment_status" firestore:"payment_status"`
 	ShippingInfo  Shipping    `json:"shipping_info" firestore:"shipping_info"`
 	CreatedAt     time.Time   `json:"created_at" firestore:"created_at"`
 	UpdatedAt     time.Time   `json:"updated_at" firestore:"updated_at"`
 	ProcessedAt   *time.Time  `json:"processed_at,omitempty" firestore:"processed_at"`
 	Notes         string      `json:"notes" firestore:"notes"`
+
+	RefundedAmount float64       `json:"refunded_amount" firestore:"refunded_amount"` // Suma de los reembolsos completados
+	Refunds        []RefundEntry `json:"refunds,omitempty" firestore:"refunds"`       // Ledger de reembolsos
+	Returns        []OrderReturn `json:"returns,omitempty" firestore:"returns"`
 }
 
 // OrderItem representa un item dentro de un pedido
@@ -31,6 +36,8 @@ type OrderItem struct {
 	UnitPrice   float64 `json:"unit_price" firestore:"unit_price"`
 	TotalPrice  float64 `json:"total_price" firestore:"total_price"`
 	ImageURL    string  `json:"image_url" firestore:"image_url"`
+
+	RefundedQuantity int `json:"refunded_quantity,omitempty" firestore:"refunded_quantity"`
 }
 
 // Shipping información de envío
@@ -53,6 +60,7 @@ type CreateOrderRequest struct {
 	UserEmail     string      `json:"user_email" binding:"required,email"`
 	Items         []OrderItem `json:"items" binding:"required,dive"`
 	PaymentMethod string      `json:"payment_method" binding:"required"`
+	PaymentToken  string      `json:"payment_token"`
 	ShippingInfo  Shipping    `json:"shipping_info" binding:"required"`
 	Notes         string      `json:"notes"`
 }
@@ -64,6 +72,7 @@ type UpdateOrderRequest struct {
 	ShippingInfo  *Shipping   `json:"shipping_info,omitempty"`
 	Notes         *string     `json:"notes,omitempty"`
 	TrackingID    *string     `json:"tracking_id,omitempty"`
+	Reason        string      `json:"reason,omitempty"` // Motivo del cambio de estado (status_history)
 }
 
 // OrderResponse respuesta estándar para pedidos
@@ -98,32 +107,41 @@ type OrderStats struct {
 	RecentOrders      int     `json:"recent_orders_24h"`
 }
 
-// PaymentResult resultado del procesamiento de pago
+// PaymentResult resultado de una operación con el proveedor de pagos
 type PaymentResult struct {
-	Success       bool      `json:"success"`
-	TransactionID string    `json:"transaction_id"`
-	Amount        float64   `json:"amount"`
-	Currency      string    `json:"currency"`
-	ProcessedAt   time.Time `json:"processed_at"`
-	ProviderID    string    `json:"provider_id"`
-	ErrorMessage  string    `json:"error_message,omitempty"`
+	Success         bool      `json:"success" firestore:"success"`
+	Status          string    `json:"status" firestore:"status"` // authorized, captured, declined, requires_action, refunded, voided
+	TransactionID   string    `json:"transaction_id" firestore:"transaction_id"`
+	AuthorizationID string    `json:"authorization_id,omitempty" firestore:"authorization_id"`
+	Amount          float64   `json:"amount" firestore:"amount"`
+	Currency        string    `json:"currency" firestore:"currency"`
+	ProcessedAt     time.Time `json:"processed_at" firestore:"processed_at"`
+	ProviderID      string    `json:"provider_id" firestore:"provider_id"`
+	DeclineCode     string    `json:"decline_code,omitempty" firestore:"decline_code"`
+	NextActionURL   string    `json:"next_action_url,omitempty" firestore:"next_action_url"` // Autenticación 3DS
+	ErrorMessage    string    `json:"error_message,omitempty" firestore:"error_message"`
 }
 
-// InventoryUpdate para actualizar inventario en AWS
+// InventoryUpdate registra una llamada de inventario a AWS
 type InventoryUpdate struct {
-	ProductID string `json:"product_id"`
-	SKU       string `json:"sku"`
-	Quantity  int    `json:"quantity"`
-	Operation string `json:"operation"` // reserve, release, consume
+	ProductID     string     `json:"product_id" firestore:"product_id"`
+	SKU           string     `json:"sku" firestore:"sku"`
+	Quantity      int        `json:"quantity" firestore:"quantity"`
+	Operation     string     `json:"operation" firestore:"operation"` // reserve, release, consume
+	ReservationID string     `json:"reservation_id" firestore:"reservation_id"`
+	Status        string     `json:"status,omitempty" firestore:"status"` // Estado de la reserva tras la llamada
+	HoldExpiresAt *time.Time `json:"hold_expires_at,omitempty" firestore:"hold_expires_at"`
+	Error         string     `json:"error,omitempty" firestore:"error"`
+	RecordedAt    time.Time  `json:"recorded_at" firestore:"recorded_at"`
 }
 
 // NotificationPayload para enviar notificaciones
 type NotificationPayload struct {
-	Type      string                 `json:"type"`      // email, sms, push
-	Recipient string                 `json:"recipient"` // email, phone, user_id
-	Template  string                 `json:"template"`  // order_created, order_shipped, etc
-	Data      map[string]interface{} `json:"data"`
-	Priority  string                 `json:"priority"`  // low, normal, high
+	Type      string                 `json:"type" firestore:"type"`           // email, sms, push
+	Recipient string                 `json:"recipient" firestore:"recipient"` // email, phone, user_id
+	Template  string                 `json:"template" firestore:"template"`   // order_created, order_shipped, etc
+	Data      map[string]interface{} `json:"data" firestore:"data"`
+	Priority  string                 `json:"priority" firestore:"priority"` // low, normal, high
 }
 
 // OrderFilter filtros para búsqueda de pedidos
@@ -155,9 +173,13 @@ const (
 	
 	// Payment Status
 	PaymentPending   = "pending"
+	PaymentAuthorized = "authorized"
+	PaymentRequiresAction = "requires_action"
 	PaymentCompleted = "completed"
 	PaymentFailed    = "failed"
 	PaymentRefunded  = "refunded"
+	PaymentPartiallyRefunded = "partially_refunded"
+	PaymentVoided    = "voided"
 	
 	// Payment Methods
 	PaymentCreditCard = "credit_card"
@@ -173,6 +195,8 @@ const (
 	// Firestore Collections
 	CollectionOrders = "orders"
 	CollectionUsers  = "users"
+	CollectionWorkflows = "workflows"
+	CollectionProcessedMessages = "processed_messages"
 	
 	// Limits
 	MaxPageSize     = 100
@@ -185,9 +209,9 @@ const (
 // Helper functions
 func (o *Order) CalculateTotal() {
 	total := 0.0
-	for _, item := range o.Items {
-		item.TotalPrice = item.UnitPrice * float64(item.Quantity)
-		total += item.TotalPrice
+	for i := range o.Items {
+		o.Items[i].TotalPrice = o.Items[i].UnitPrice * float64(o.Items[i].Quantity)
+		total += o.Items[i].TotalPrice
 	}
 	o.TotalAmount = total
 }
@@ -204,6 +228,41 @@ func (o *Order) CanBeShipped() bool {
 	return o.Status == StatusPaid && o.PaymentStatus == PaymentCompleted
 }
 
+func (o *Order) CanBeRefunded() bool {
+	return (o.Status == StatusPaid || o.Status == StatusShipped || o.Status == StatusDelivered) &&
+		(o.PaymentStatus == PaymentCompleted || o.PaymentStatus == PaymentPartiallyRefunded)
+}
+
+func (o *Order) CanBeReturned() bool {
+	return o.Status == StatusShipped || o.Status == StatusDelivered
+}
+
+// RecalculateRefunds rehace refunded_amount y las cantidades reembolsadas de
+// cada item a partir de los reembolsos completados del ledger
+func (o *Order) RecalculateRefunds() {
+	o.RefundedAmount = 0
+	for i := range o.Items {
+		o.Items[i].RefundedQuantity = 0
+	}
+	for _, refund := range o.Refunds {
+		if refund.Status != RefundSucceeded {
+			continue
+		}
+		o.RefundedAmount += refund.Amount
+		for _, item := range refund.Items {
+			if i := o.itemIndex(item.SKU); i >= 0 {
+				o.Items[i].RefundedQuantity += item.Quantity
+			}
+		}
+	}
+	o.RefundedAmount = fromMinorUnits(toMinorUnits(o.RefundedAmount, o.Currency), o.Currency)
+}
+
+// NetAmount -> Importe cobrado menos lo reembolsado
+func (o *Order) NetAmount() float64 {
+	return o.roundAmount(o.TotalAmount - o.RefundedAmount)
+}
+
 func (o *Order) GetTotalItems() int {
 	total := 0
 	for _, item := range o.Items {
@@ -231,19 +290,28 @@ func (f *OrderFilter) Validate() error {
 	return nil
 }
 
-// ProcessOrderWorkflow representa el flujo de procesamiento
+// ProcessOrderWorkflow representa el flujo de procesamiento. Se guarda en la
+// colección workflows (mismo ID que el pedido) después de cada paso.
 type ProcessOrderWorkflow struct {
-	OrderID           string    `json:"order_id"`
-	Step              string    `json:"step"`
-	Status            string    `json:"status"`
-	StartedAt         time.Time `json:"started_at"`
-	CompletedAt       *time.Time `json:"completed_at,omitempty"`
-	ErrorMessage      string    `json:"error_message,omitempty"`
-	RetryCount        int       `json:"retry_count"`
-	NextRetryAt       *time.Time `json:"next_retry_at,omitempty"`
-	PaymentResult     *PaymentResult `json:"payment_result,omitempty"`
-	InventoryUpdates  []InventoryUpdate `json:"inventory_updates,omitempty"`
-	NotificationsSent []NotificationPayload `json:"notifications_sent,omitempty"`
+	OrderID           string                `json:"order_id" firestore:"order_id"`
+	Step              string                `json:"step" firestore:"step"` // Siguiente paso a ejecutar
+	Status            string                `json:"status" firestore:"status"`
+	CompletedSteps    []string              `json:"completed_steps" firestore:"completed_steps"`
+	StartedAt         time.Time             `json:"started_at" firestore:"started_at"`
+	UpdatedAt         time.Time             `json:"updated_at" firestore:"updated_at"`
+	CompletedAt       *time.Time            `json:"completed_at,omitempty" firestore:"completed_at"`
+	ErrorMessage      string                `json:"error_message,omitempty" firestore:"error_message"`
+	RetryCount        int                   `json:"retry_count" firestore:"retry_count"` // Reintentos del paso actual
+	NextRetryAt       *time.Time            `json:"next_retry_at,omitempty" firestore:"next_retry_at"`
+	Executions        int                   `json:"executions" firestore:"executions"` // Veces que un worker tomó el lease
+	LeaseOwner        string                `json:"lease_owner,omitempty" firestore:"lease_owner"`
+	LeaseExpiresAt    *time.Time            `json:"lease_expires_at,omitempty" firestore:"lease_expires_at"`
+	FailedStep        string                `json:"failed_step,omitempty" firestore:"failed_step"`
+	Compensations     []CompensationRecord  `json:"compensations,omitempty" firestore:"compensations"`
+	PaymentResult     *PaymentResult        `json:"payment_result,omitempty" firestore:"payment_result"`
+	Refund            *PaymentResult        `json:"refund,omitempty" firestore:"refund"`
+	InventoryUpdates  []InventoryUpdate     `json:"inventory_updates,omitempty" firestore:"inventory_updates"`
+	NotificationsSent []NotificationPayload `json:"notifications_sent,omitempty" firestore:"notifications_sent"`
 }
 
 // Workflow Steps
@@ -262,5 +330,18 @@ const (
 	WorkflowRunning   = "running"
 	WorkflowCompleted = "completed"
 	WorkflowFailed    = "failed"
-	WorkflowRetrying  = "retrying"
-)
\ No newline at end of file
+	WorkflowRetrying  = "retrying"     // Esperando NextRetryAt para repetir el paso
+	WorkflowCompensating = "compensating" // Deshaciendo los pasos tras un fallo
+)
+
+// Ejecución de workflows
+const (
+	WorkflowRunTimeout    = 45 * time.Second // Por debajo del timeout de la función (60s)
+	WorkflowLeaseDuration = 90 * time.Second // Más que una ejecución; se renueva en cada paso
+	WorkflowMinStepTime   = 5 * time.Second  // Margen para esperar un reintento dentro de la ejecución
+)
+
+// active indica si el workflow puede avanzar ya (no terminó ni espera un reintento)
+func (w *ProcessOrderWorkflow) active() bool {
+	return (w.Status == WorkflowRunning || w.Status == WorkflowCompensating) && w.NextRetryAt == nil
+}
\ No newline at end of file
@@ -0,0 +1,474 @@
+package orderprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// El cobro va en dos fases: el workflow autoriza el importe y lo captura en el
+// mismo paso; al compensar, una autorización sin capturar se anula (void) y un
+// cobro capturado se reembolsa. Cada operación lleva una clave de idempotencia
+// derivada del pedido, así que repetir el paso no cobra dos veces.
+
+// Estados de un pago en el proveedor
+const (
+	PaymentStateAuthorized     = "authorized"
+	PaymentStateCaptured       = "captured"
+	PaymentStateDeclined       = "declined"
+	PaymentStateRequiresAction = "requires_action" // 3DS: el cliente debe autenticar el pago
+	PaymentStateRefunded       = "refunded"
+	PaymentStateVoided         = "voided"
+
+	PaymentRequestTimeout = 10 * time.Second
+	DefaultStripeAPIURL   = "https://api.stripe.com"
+)
+
+var (
+	errPaymentDeclined       = errors.New("payment declined")
+	errPaymentRequiresAction = errors.New("payment requires customer authentication")
+	errPaymentRejected       = errors.New("payment request rejected")
+	errPaymentTimeout        = errors.New("payment provider timed out")
+)
+
+// PaymentRequest -> Autorización del importe de un pedido
+type PaymentRequest struct {
+	OrderID        string
+	Amount         float64
+	Currency       string
+	PaymentMethod  string // credit_card, paypal...
+	PaymentToken   string // Método de pago tokenizado en el proveedor
+	CustomerEmail  string
+	IdempotencyKey string
+}
+
+// PaymentProvider -> Pasarela de pagos. Un rechazo de la tarjeta o una
+// autenticación pendiente no son errores: vienen en el Status del resultado.
+type PaymentProvider interface {
+	Authorize(ctx context.Context, request PaymentRequest) (*PaymentResult, error)
+	Capture(ctx context.Context, authorizationID, idempotencyKey string) (*PaymentResult, error)
+	Refund(ctx context.Context, authorizationID string, amount float64, currency, idempotencyKey string) (*PaymentResult, error)
+	Void(ctx context.Context, authorizationID, idempotencyKey string) (*PaymentResult, error)
+}
+
+// paymentKey -> Clave de idempotencia de una operación de pago del pedido
+func paymentKey(orderID, operation string) string {
+	return "order-" + orderID + "-" + operation
+}
+
+// zeroDecimalCurrencies -> Monedas sin decimales (el importe va en unidades)
+var zeroDecimalCurrencies = map[string]bool{"JPY": true, "KRW": true, "CLP": true, "VND": true}
+
+// toMinorUnits convierte un importe a la unidad mínima de la moneda
+func toMinorUnits(amount float64, currency string) int64 {
+	if zeroDecimalCurrencies[strings.ToUpper(currency)] {
+		return int64(math.Round(amount))
+	}
+	return int64(math.Round(amount * 100))
+}
+
+func fromMinorUnits(amount int64, currency string) float64 {
+	if zeroDecimalCurrencies[strings.ToUpper(currency)] {
+		return float64(amount)
+	}
+	return float64(amount) / 100
+}
+
+// StripeProvider habla la API de PaymentIntents de Stripe (o un servidor
+// compatible, como stripe-mock): autoriza con capture_method=manual
+type StripeProvider struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+func NewStripeProvider(baseURL, apiKey string) *StripeProvider {
+	if baseURL == "" {
+		baseURL = DefaultStripeAPIURL
+	}
+	return &StripeProvider{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: PaymentRequestTimeout},
+	}
+}
+
+// stripeIntent -> Campos del PaymentIntent que usa el workflow
+type stripeIntent struct {
+	ID               string             `json:"id"`
+	Status           string             `json:"status"`
+	Amount           int64              `json:"amount"`
+	AmountReceived   int64              `json:"amount_received"`
+	Currency         string             `json:"currency"`
+	LastPaymentError *stripeErrorDetail `json:"last_payment_error"`
+	NextAction       *struct {
+		RedirectToURL *struct {
+			URL string `json:"url"`
+		} `json:"redirect_to_url"`
+	} `json:"next_action"`
+}
+
+// stripeErrorDetail -> Motivo de un error o rechazo de Stripe
+type stripeErrorDetail struct {
+	Type          string        `json:"type"`
+	Code          string        `json:"code"`
+	DeclineCode   string        `json:"decline_code"`
+	Message       string        `json:"message"`
+	PaymentIntent *stripeIntent `json:"payment_intent,omitempty"`
+}
+
+// post envía un formulario a Stripe. Los errores de red, 429 y 5xx se pueden
+// reintentar con la misma clave; un card_error devuelve el intent rechazado.
+func (p *StripeProvider) post(ctx context.Context, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("building payment request: %w", err)
+	}
+	request.Header.Set("Authorization", "Bearer "+p.apiKey)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Idempotency-Key", idempotencyKey)
+
+	response, err := p.httpClient.Do(request)
+	if err != nil {
+		var timeout interface{ Timeout() bool }
+		if errors.As(err, &timeout) && timeout.Timeout() {
+			return fmt.Errorf("%w: %v", errPaymentTimeout, err)
+		}
+		return fmt.Errorf("calling payment provider: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500 {
+		return fmt.Errorf("payment provider returned %d", response.StatusCode)
+	}
+	if response.StatusCode >= 300 {
+		var body struct {
+			Error stripeErrorDetail `json:"error"`
+		}
+		if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+			return fmt.Errorf("%w: provider returned %d", errPaymentRejected, response.StatusCode)
+		}
+		failed := body.Error
+		if intent, ok := out.(*stripeIntent); ok && failed.Type == "card_error" && failed.PaymentIntent != nil {
+			*intent = *failed.PaymentIntent
+			if intent.LastPaymentError == nil {
+				failed.PaymentIntent = nil
+				intent.LastPaymentError = &failed
+			}
+			return nil
+		}
+		return fmt.Errorf("%w: %s (%s)", errPaymentRejected, failed.Message, failed.Code)
+	}
+	if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding payment response: %w", err)
+	}
+	return nil
+}
+
+// result traduce un PaymentIntent al resultado del workflow
+func (intent *stripeIntent) result() *PaymentResult {
+	result := &PaymentResult{
+		TransactionID:   intent.ID,
+		AuthorizationID: intent.ID,
+		Amount:          fromMinorUnits(intent.Amount, intent.Currency),
+		Currency:        strings.ToUpper(intent.Currency),
+		ProcessedAt:     time.Now(),
+		ProviderID:      "stripe",
+	}
+	switch intent.Status {
+	case "requires_capture":
+		result.Status, result.Success = PaymentStateAuthorized, true
+	case "succeeded":
+		result.Status, result.Success = PaymentStateCaptured, true
+		if intent.AmountReceived > 0 {
+			result.Amount = fromMinorUnits(intent.AmountReceived, intent.Currency)
+		}
+	case "requires_action":
+		result.Status = PaymentStateRequiresAction
+		if intent.NextAction != nil && intent.NextAction.RedirectToURL != nil {
+			result.NextActionURL = intent.NextAction.RedirectToURL.URL
+		}
+	case "canceled":
+		result.Status = PaymentStateVoided
+	default: // requires_payment_method: la tarjeta se rechazó
+		result.Status = PaymentStateDeclined
+	}
+	if intent.LastPaymentError != nil {
+		result.DeclineCode = intent.LastPaymentError.DeclineCode
+		if result.DeclineCode == "" {
+			result.DeclineCode = intent.LastPaymentError.Code
+		}
+		result.ErrorMessage = intent.LastPaymentError.Message
+	}
+	return result
+}
+
+func (p *StripeProvider) Authorize(ctx context.Context, request PaymentRequest) (*PaymentResult, error) {
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(toMinorUnits(request.Amount, request.Currency), 10))
+	form.Set("currency", strings.ToLower(request.Currency))
+	form.Set("capture_method", "manual")
+	form.Set("confirm", "true")
+	form.Set("metadata[order_id]", request.OrderID)
+	form.Set("description", "Order "+request.OrderID)
+	if request.PaymentToken != "" {
+		form.Set("payment_method", request.PaymentToken)
+	}
+	if request.CustomerEmail != "" {
+		form.Set("receipt_email", request.CustomerEmail)
+	}
+
+	var intent stripeIntent
+	if err := p.post(ctx, "/v1/payment_intents", form, request.IdempotencyKey, &intent); err != nil {
+		return nil, err
+	}
+	return intent.result(), nil
+}
+
+func (p *StripeProvider) Capture(ctx context.Context, authorizationID, idempotencyKey string) (*PaymentResult, error) {
+	var intent stripeIntent
+	if err := p.post(ctx, "/v1/payment_intents/"+url.PathEscape(authorizationID)+"/capture", url.Values{}, idempotencyKey, &intent); err != nil {
+		return nil, err
+	}
+	return intent.result(), nil
+}
+
+func (p *StripeProvider) Refund(ctx context.Context, authorizationID string, amount float64, currency, idempotencyKey string) (*PaymentResult, error) {
+	form := url.Values{}
+	form.Set("payment_intent", authorizationID)
+	form.Set("amount", strconv.FormatInt(toMinorUnits(amount, currency), 10))
+
+	var refund struct {
+		ID       string `json:"id"`
+		Status   string `json:"status"` // succeeded, pending, failed
+		Amount   int64  `json:"amount"`
+		Currency string `json:"currency"`
+	}
+	if err := p.post(ctx, "/v1/refunds", form, idempotencyKey, &refund); err != nil {
+		return nil, err
+	}
+	result := &PaymentResult{
+		Success:         refund.Status != "failed",
+		Status:          PaymentStateRefunded,
+		TransactionID:   refund.ID,
+		AuthorizationID: authorizationID,
+		Amount:          fromMinorUnits(refund.Amount, refund.Currency),
+		Currency:        strings.ToUpper(refund.Currency),
+		ProcessedAt:     time.Now(),
+		ProviderID:      "stripe",
+	}
+	if !result.Success {
+		result.ErrorMessage = "refund failed"
+	}
+	return result, nil
+}
+
+func (p *StripeProvider) Void(ctx context.Context, authorizationID, idempotencyKey string) (*PaymentResult, error) {
+	var intent stripeIntent
+	if err := p.post(ctx, "/v1/payment_intents/"+url.PathEscape(authorizationID)+"/cancel", url.Values{}, idempotencyKey, &intent); err != nil {
+		return nil, err
+	}
+	return intent.result(), nil
+}
+
+// Resultados programables del proveedor falso
+const (
+	FakeApprove         = "approve"
+	FakeDecline         = "decline"
+	FakeTimeout         = "timeout"           // Falla sin llegar al proveedor
+	FakeTimeoutAfterAck = "timeout_after_ack" // El proveedor lo hizo, pero la respuesta se pierde
+	FakeRequire3DS      = "require_3ds"
+)
+
+// Tokens de prueba del proveedor falso (sin guion programado)
+var fakePaymentTokens = map[string]string{
+	"pm_card_visa":                   FakeApprove,
+	"pm_card_chargeDeclined":         FakeDecline,
+	"pm_card_timeout":                FakeTimeout,
+	"pm_card_threeDSecure2":          FakeRequire3DS,
+	"pm_card_authenticationRequired": FakeRequire3DS,
+}
+
+// FakePaymentProvider es un proveedor determinista en memoria (desarrollo
+// local y pruebas). Script programa el resultado de las siguientes llamadas de
+// un pedido; sin guion decide el token de pago y, si no lo reconoce, aprueba.
+// Como el real, repetir una clave de idempotencia devuelve el mismo resultado.
+type FakePaymentProvider struct {
+	mu        sync.Mutex
+	scripts   map[string][]string // order_id -> resultados pendientes
+	responses map[string]PaymentResult
+	intents   map[string]*fakeIntent
+}
+
+// fakeIntent -> Autorización del proveedor falso
+type fakeIntent struct {
+	orderID  string
+	amount   float64
+	currency string
+	status   string
+	refunded float64
+}
+
+func NewFakePaymentProvider() *FakePaymentProvider {
+	return &FakePaymentProvider{
+		scripts:   map[string][]string{},
+		responses: map[string]PaymentResult{},
+		intents:   map[string]*fakeIntent{},
+	}
+}
+
+// Script programa los resultados de las próximas llamadas del pedido
+func (f *FakePaymentProvider) Script(orderID string, outcomes ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scripts[orderID] = append(f.scripts[orderID], outcomes...)
+}
+
+// next devuelve el siguiente resultado programado del pedido
+func (f *FakePaymentProvider) next(orderID, token string) string {
+	if script := f.scripts[orderID]; len(script) > 0 {
+		f.scripts[orderID] = script[1:]
+		return script[0]
+	}
+	if outcome, ok := fakePaymentTokens[token]; ok {
+		return outcome
+	}
+	return FakeApprove
+}
+
+// idempotent ejecuta operation una sola vez por clave. Un timeout simulado
+// antes de llegar al proveedor no guarda nada; después, sí. Fuera de Authorize
+// solo cuentan los resultados de timeout.
+func (f *FakePaymentProvider) idempotent(key, orderID, token string, operation func(outcome string) (*PaymentResult, error)) (*PaymentResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if response, ok := f.responses[key]; ok {
+		result := response
+		return &result, nil
+	}
+	outcome := f.next(orderID, token)
+	if outcome == FakeTimeout {
+		return nil, errPaymentTimeout
+	}
+	result, err := operation(outcome)
+	if err != nil {
+		return nil, err
+	}
+	f.responses[key] = *result
+	if outcome == FakeTimeoutAfterAck {
+		return nil, errPaymentTimeout
+	}
+	return result, nil
+}
+
+func (f *FakePaymentProvider) Authorize(ctx context.Context, request PaymentRequest) (*PaymentResult, error) {
+	return f.idempotent(request.IdempotencyKey, request.OrderID, request.PaymentToken, func(outcome string) (*PaymentResult, error) {
+		id := "pi_fake_" + request.OrderID
+		result := &PaymentResult{
+			TransactionID:   id,
+			AuthorizationID: id,
+			Amount:          request.Amount,
+			Currency:        request.Currency,
+			ProcessedAt:     time.Now(),
+			ProviderID:      "fake",
+		}
+		intent := &fakeIntent{orderID: request.OrderID, amount: request.Amount, currency: request.Currency}
+		switch outcome {
+		case FakeDecline:
+			result.Status, result.DeclineCode, result.ErrorMessage = PaymentStateDeclined, "card_declined", "Your card was declined."
+		case FakeRequire3DS:
+			result.Status, result.NextActionURL = PaymentStateRequiresAction, "https://payments.local/3ds/"+id
+		default:
+			result.Status, result.Success = PaymentStateAuthorized, true
+		}
+		intent.status = result.Status
+		f.intents[id] = intent
+		return result, nil
+	})
+}
+
+// intentResult -> Resultado con el estado actual de una autorización
+func (f *FakePaymentProvider) intentResult(id string, intent *fakeIntent) *PaymentResult {
+	return &PaymentResult{
+		Success:         intent.status == PaymentStateAuthorized || intent.status == PaymentStateCaptured,
+		Status:          intent.status,
+		TransactionID:   id,
+		AuthorizationID: id,
+		Amount:          intent.amount,
+		Currency:        intent.currency,
+		ProcessedAt:     time.Now(),
+		ProviderID:      "fake",
+	}
+}
+
+func (f *FakePaymentProvider) Capture(ctx context.Context, authorizationID, idempotencyKey string) (*PaymentResult, error) {
+	intent, ok := f.intent(authorizationID)
+	if !ok {
+		return nil, fmt.Errorf("%w: no such payment intent %s", errPaymentRejected, authorizationID)
+	}
+	return f.idempotent(idempotencyKey, intent.orderID, "", func(outcome string) (*PaymentResult, error) {
+		if intent.status != PaymentStateAuthorized {
+			return nil, fmt.Errorf("%w: payment intent is %s", errPaymentRejected, intent.status)
+		}
+		intent.status = PaymentStateCaptured
+		return f.intentResult(authorizationID, intent), nil
+	})
+}
+
+func (f *FakePaymentProvider) Refund(ctx context.Context, authorizationID string, amount float64, currency, idempotencyKey string) (*PaymentResult, error) {
+	intent, ok := f.intent(authorizationID)
+	if !ok {
+		return nil, fmt.Errorf("%w: no such payment intent %s", errPaymentRejected, authorizationID)
+	}
+	return f.idempotent(idempotencyKey, intent.orderID, "", func(outcome string) (*PaymentResult, error) {
+		if intent.status != PaymentStateCaptured && intent.status != PaymentStateRefunded {
+			return nil, fmt.Errorf("%w: payment intent is %s", errPaymentRejected, intent.status)
+		}
+		if intent.refunded+amount > intent.amount+0.005 {
+			return nil, fmt.Errorf("%w: refund exceeds the captured amount", errPaymentRejected)
+		}
+		intent.refunded += amount
+		if intent.refunded+0.005 >= intent.amount {
+			intent.status = PaymentStateRefunded
+		}
+		return &PaymentResult{
+			Success:         true,
+			Status:          PaymentStateRefunded,
+			TransactionID:   "re_fake_" + idempotencyKey,
+			AuthorizationID: authorizationID,
+			Amount:          amount,
+			Currency:        currency,
+			ProcessedAt:     time.Now(),
+			ProviderID:      "fake",
+		}, nil
+	})
+}
+
+func (f *FakePaymentProvider) Void(ctx context.Context, authorizationID, idempotencyKey string) (*PaymentResult, error) {
+	intent, ok := f.intent(authorizationID)
+	if !ok {
+		return nil, fmt.Errorf("%w: no such payment intent %s", errPaymentRejected, authorizationID)
+	}
+	return f.idempotent(idempotencyKey, intent.orderID, "", func(outcome string) (*PaymentResult, error) {
+		if intent.status == PaymentStateCaptured || intent.status == PaymentStateRefunded {
+			return nil, fmt.Errorf("%w: payment intent is %s", errPaymentRejected, intent.status)
+		}
+		intent.status = PaymentStateVoided
+		return f.intentResult(authorizationID, intent), nil
+	})
+}
+
+func (f *FakePaymentProvider) intent(id string) (*fakeIntent, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	intent, ok := f.intents[id]
+	return intent, ok
+}
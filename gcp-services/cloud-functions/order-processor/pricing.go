@@ -0,0 +1,210 @@
+package orderprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ItemError -> Motivo por el que un item del pedido no es válido
+type ItemError struct {
+	Index     int    `json:"index"`
+	ProductID string `json:"product_id"`
+	SKU       string `json:"sku,omitempty"`
+	Message   string `json:"message"`
+}
+
+// OrderValidationError -> Items rechazados contra el catálogo
+type OrderValidationError struct {
+	Items []ItemError
+}
+
+func (e *OrderValidationError) Error() string {
+	messages := make([]string, len(e.Items))
+	for i, item := range e.Items {
+		messages[i] = fmt.Sprintf("item %d (%s): %s", item.Index, item.ProductID, item.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// catalogLine -> Item resuelto contra el catálogo
+type catalogLine struct {
+	product *CatalogProduct
+	variant *CatalogVariant
+}
+
+func (l catalogLine) sku() string {
+	if l.variant != nil {
+		return l.variant.SKU
+	}
+	return l.product.SKU
+}
+
+func (l catalogLine) stock() int {
+	if l.variant != nil {
+		return l.variant.Stock
+	}
+	return l.product.Stock
+}
+
+// name -> Nombre del producto y, si es una variante, sus atributos
+func (l catalogLine) name() string {
+	if l.variant == nil || len(l.variant.Attributes) == 0 {
+		return l.product.Name
+	}
+	attributes := make([]string, 0, len(l.variant.Attributes))
+	for key, value := range l.variant.Attributes {
+		attributes = append(attributes, key+": "+value)
+	}
+	sort.Strings(attributes)
+	return l.product.Name + " (" + strings.Join(attributes, ", ") + ")"
+}
+
+// unitPrice -> Precio en la moneda del pedido: override de la variante o precio
+// del producto en su moneda, o su lista de precios en otra moneda
+func (l catalogLine) unitPrice(currency string) (float64, error) {
+	if strings.EqualFold(l.product.Currency, currency) || (l.product.Currency == "" && currency == DefaultCurrency) {
+		amount := l.product.Price
+		if l.variant != nil && l.variant.Price != nil {
+			amount = l.variant.Price.Amount
+		}
+		return amount.Float64()
+	}
+	if l.variant != nil && l.variant.Price != nil {
+		return 0, fmt.Errorf("variant is not sold in %s", currency)
+	}
+	amount, ok := l.product.PriceList[currency]
+	if !ok {
+		return 0, fmt.Errorf("product is not sold in %s", currency)
+	}
+	return amount.Float64()
+}
+
+// resolveLine busca el producto (o la variante, por SKU) de un item
+func resolveLine(product *CatalogProduct, sku string) (catalogLine, error) {
+	line := catalogLine{product: product}
+	switch {
+	case product.DeletedAt != nil:
+		return line, errors.New("product is no longer available")
+	case product.Status == "inactive":
+		return line, errors.New("product is not available")
+	}
+
+	if sku == "" || strings.EqualFold(sku, product.SKU) {
+		if len(product.Variants) > 0 {
+			return line, errors.New("product has variants, order one of them by its SKU")
+		}
+		return line, nil
+	}
+	for i := range product.Variants {
+		if strings.EqualFold(sku, product.Variants[i].SKU) {
+			line.variant = &product.Variants[i]
+			return line, nil
+		}
+	}
+	return line, fmt.Errorf("sku %s does not belong to the product", sku)
+}
+
+// mergeOrderItems junta las líneas repetidas de un mismo SKU (ya resueltas
+// contra el catálogo) en una sola, para que reembolsos y devoluciones, que
+// identifican cada línea por SKU, no se queden con la primera.
+func mergeOrderItems(items []OrderItem) []OrderItem {
+	merged := make([]OrderItem, 0, len(items))
+	lines := map[string]int{}
+	for _, item := range items {
+		key := strings.ToUpper(item.SKU)
+		if i, ok := lines[key]; ok {
+			merged[i].Quantity += item.Quantity
+			merged[i].TotalPrice = merged[i].UnitPrice * float64(merged[i].Quantity)
+			continue
+		}
+		lines[key] = len(merged)
+		merged = append(merged, item)
+	}
+	return merged
+}
+
+// resolveOrderItems reemplaza nombre, SKU, imagen y precios de cada item por los
+// del catálogo y comprueba disponibilidad y stock (sumando items repetidos).
+// Devuelve *OrderValidationError con un error por item rechazado; cualquier
+// otro error es del catálogo (caído, timeout, circuito abierto).
+func (h *OrderHandler) resolveOrderItems(ctx context.Context, items []OrderItem, currency string) ([]OrderItem, error) {
+	var invalid []ItemError
+	reject := func(i int, item OrderItem, message string) {
+		invalid = append(invalid, ItemError{Index: i, ProductID: item.ProductID, SKU: item.SKU, Message: message})
+	}
+
+	products := map[string]*CatalogProduct{}
+	resolved := make([]OrderItem, len(items))
+	requested := map[string]int{} // Unidades pedidas por SKU
+	available := map[string]int{}
+	indexes := map[string][]int{}
+
+	for i, item := range items {
+		resolved[i] = item
+		if item.ProductID == "" {
+			reject(i, item, "product_id is required")
+			continue
+		}
+		if item.Quantity <= 0 {
+			reject(i, item, "quantity must be greater than 0")
+			continue
+		}
+
+		product, ok := products[item.ProductID]
+		if !ok {
+			var err error
+			product, err = h.catalog.GetProduct(ctx, item.ProductID)
+			if errors.Is(err, errProductNotFound) {
+				products[item.ProductID] = nil
+			} else if err != nil {
+				return nil, err
+			} else {
+				products[item.ProductID] = product
+			}
+		}
+		if product == nil {
+			reject(i, item, "product not found")
+			continue
+		}
+
+		line, err := resolveLine(product, item.SKU)
+		if err != nil {
+			reject(i, item, err.Error())
+			continue
+		}
+		price, err := line.unitPrice(currency)
+		if err != nil {
+			reject(i, item, err.Error())
+			continue
+		}
+
+		resolved[i].ProductName = line.name()
+		resolved[i].SKU = line.sku()
+		resolved[i].ImageURL = product.ImageURL
+		resolved[i].UnitPrice = price
+		resolved[i].TotalPrice = price * float64(item.Quantity)
+
+		key := strings.ToUpper(line.sku())
+		requested[key] += item.Quantity
+		available[key] = line.stock()
+		indexes[key] = append(indexes[key], i)
+	}
+
+	for key, quantity := range requested {
+		if quantity <= available[key] {
+			continue
+		}
+		for _, i := range indexes[key] {
+			reject(i, resolved[i], fmt.Sprintf("insufficient stock: requested %d, available %d", quantity, available[key]))
+		}
+	}
+
+	if len(invalid) > 0 {
+		sort.SliceStable(invalid, func(a, b int) bool { return invalid[a].Index < invalid[b].Index })
+		return nil, &OrderValidationError{Items: invalid}
+	}
+	return resolved, nil
+}
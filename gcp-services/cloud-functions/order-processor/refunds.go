@@ -0,0 +1,698 @@
+package orderprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+)
+
+// Un reembolso (POST /orders/{id}/refund, o al recibir una devolución) se
+// apunta primero como pending en el ledger del pedido, en la misma transacción
+// que valida cantidades e importe. Después se reembolsa en el proveedor y se
+// repone el stock, y una segunda transacción lo marca como succeeded, recalcula
+// los totales y mueve payment_status y status. Las claves de idempotencia salen
+// del ID del reembolso: repetir la petición con el mismo refund_id retoma uno
+// que quedó a medias sin reembolsar ni reponer dos veces.
+
+// Reembolsos y devoluciones
+const (
+	RefundPending   = "pending"
+	RefundSucceeded = "succeeded"
+	RefundFailed    = "failed"
+
+	ReturnRequested = "requested"
+	ReturnApproved  = "approved"
+	ReturnReceived  = "received" // Mercancía en el almacén; se está reembolsando
+	ReturnRefunded  = "refunded"
+)
+
+var (
+	errInvalidRefund    = errors.New("invalid refund")
+	errRefundNotAllowed = errors.New("refund not allowed")
+	errInvalidReturn    = errors.New("invalid return")
+	errReturnNotAllowed = errors.New("return not allowed")
+	errReturnNotFound   = errors.New("return not found")
+)
+
+// RefundItem -> Unidades de un item del pedido
+type RefundItem struct {
+	SKU      string  `json:"sku" firestore:"sku"`
+	Quantity int     `json:"quantity" firestore:"quantity"`
+	Amount   float64 `json:"amount,omitempty" firestore:"amount"` // Precio unitario * cantidad
+}
+
+// RefundEntry -> Apunte del ledger de reembolsos del pedido
+type RefundEntry struct {
+	ID            string       `json:"id" firestore:"id"`
+	Status        string       `json:"status" firestore:"status"` // pending, succeeded, failed
+	Amount        float64      `json:"amount" firestore:"amount"`
+	Currency      string       `json:"currency" firestore:"currency"`
+	Items         []RefundItem `json:"items,omitempty" firestore:"items"`
+	Restock       bool         `json:"restock" firestore:"restock"`
+	ReturnID      string       `json:"return_id,omitempty" firestore:"return_id"`
+	Reason        string       `json:"reason,omitempty" firestore:"reason"`
+	TransactionID string       `json:"transaction_id,omitempty" firestore:"transaction_id"` // Reembolso en el proveedor
+	RestockError  string       `json:"restock_error,omitempty" firestore:"restock_error"`   // Stock que no se pudo reponer
+	Error         string       `json:"error,omitempty" firestore:"error"`
+	CreatedAt     time.Time    `json:"created_at" firestore:"created_at"`
+	CompletedAt   *time.Time   `json:"completed_at,omitempty" firestore:"completed_at"`
+}
+
+// OrderReturn -> Devolución de items de un pedido
+type OrderReturn struct {
+	ID          string       `json:"id" firestore:"id"`
+	Status      string       `json:"status" firestore:"status"` // requested, approved, received, refunded
+	Items       []RefundItem `json:"items" firestore:"items"`
+	Reason      string       `json:"reason,omitempty" firestore:"reason"`
+	RefundID    string       `json:"refund_id,omitempty" firestore:"refund_id"`
+	RequestedAt time.Time    `json:"requested_at" firestore:"requested_at"`
+	ApprovedAt  *time.Time   `json:"approved_at,omitempty" firestore:"approved_at"`
+	ReceivedAt  *time.Time   `json:"received_at,omitempty" firestore:"received_at"`
+}
+
+// RefundRequest -> Para POST /orders/{id}/refund. Sin items ni amount se
+// reembolsa todo lo que queda.
+type RefundRequest struct {
+	ID      string       `json:"refund_id"` // Opcional; repetirlo retoma el mismo reembolso
+	Items   []RefundItem `json:"items"`
+	Amount  float64      `json:"amount"`  // Importe sin items (p. ej. una compensación al cliente)
+	Restock *bool        `json:"restock"` // Por defecto, solo si el pedido no se ha enviado
+	Reason  string       `json:"reason"`
+}
+
+// CreateReturnRequest -> Para POST /orders/{id}/returns
+type CreateReturnRequest struct {
+	Items  []RefundItem `json:"items"`
+	Reason string       `json:"reason"`
+}
+
+// itemIndex -> Posición del item con ese SKU (-1 si no está). createOrder junta
+// las líneas repetidas, así que cada SKU aparece una sola vez.
+func (o *Order) itemIndex(sku string) int {
+	for i, item := range o.Items {
+		if strings.EqualFold(item.SKU, strings.TrimSpace(sku)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// findRefund -> Apunte del ledger con ese ID (-1 si no está)
+func (o *Order) findRefund(id string) int {
+	for i, refund := range o.Refunds {
+		if refund.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// findRefundFor -> Apunte (no fallido) que reembolsa esa devolución (-1 si no hay)
+func (o *Order) findRefundFor(returnID string) int {
+	for i, refund := range o.Refunds {
+		if refund.ReturnID == returnID && refund.Status != RefundFailed {
+			return i
+		}
+	}
+	return -1
+}
+
+// findReturn -> Devolución con ese ID (-1 si no está)
+func (o *Order) findReturn(id string) int {
+	for i, ret := range o.Returns {
+		if ret.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// committedQuantities -> Unidades de cada item ya reembolsadas, en curso o en
+// una devolución abierta. skipReturn excluye una devolución (la que se reembolsa).
+func (o *Order) committedQuantities(skipReturn string) []int {
+	committed := make([]int, len(o.Items))
+	add := func(items []RefundItem) {
+		for _, item := range items {
+			if i := o.itemIndex(item.SKU); i >= 0 {
+				committed[i] += item.Quantity
+			}
+		}
+	}
+	for _, refund := range o.Refunds {
+		if refund.Status != RefundFailed {
+			add(refund.Items)
+		}
+	}
+	for _, ret := range o.Returns {
+		if ret.ID != skipReturn && ret.Status != ReturnRefunded && o.findRefundFor(ret.ID) < 0 {
+			add(ret.Items)
+		}
+	}
+	return committed
+}
+
+// committedAmount -> Importe ya reembolsado o en curso
+func (o *Order) committedAmount() float64 {
+	total := 0.0
+	for _, refund := range o.Refunds {
+		if refund.Status != RefundFailed {
+			total += refund.Amount
+		}
+	}
+	return total
+}
+
+// roundAmount redondea a la unidad mínima de la moneda del pedido
+func (o *Order) roundAmount(amount float64) float64 {
+	return fromMinorUnits(toMinorUnits(amount, o.Currency), o.Currency)
+}
+
+// resolveItems comprueba los items pedidos contra lo que queda del pedido y
+// calcula su importe. invalid y conflict son los errores de una petición mal
+// formada o que pide más unidades de las que quedan (reembolso o devolución).
+func (o *Order) resolveItems(requested []RefundItem, skipReturn string, invalid, conflict error) ([]RefundItem, error) {
+	committed := o.committedQuantities(skipReturn)
+	resolved := make([]RefundItem, 0, len(requested))
+	for _, item := range requested {
+		i := o.itemIndex(item.SKU)
+		switch {
+		case i < 0:
+			return nil, fmt.Errorf("%w: SKU %q is not in the order", invalid, item.SKU)
+		case item.Quantity <= 0:
+			return nil, fmt.Errorf("%w: quantity of %s must be greater than 0", invalid, item.SKU)
+		case committed[i]+item.Quantity > o.Items[i].Quantity:
+			return nil, fmt.Errorf("%w: only %d units of %s left", conflict, o.Items[i].Quantity-committed[i], o.Items[i].SKU)
+		}
+		committed[i] += item.Quantity
+		resolved = append(resolved, RefundItem{
+			SKU:      o.Items[i].SKU,
+			Quantity: item.Quantity,
+			Amount:   o.roundAmount(o.Items[i].UnitPrice * float64(item.Quantity)),
+		})
+	}
+	return resolved, nil
+}
+
+// newRefundEntry valida la petición contra el pedido y arma el apunte pending
+func (o *Order) newRefundEntry(req RefundRequest, returnID string, now time.Time) (*RefundEntry, error) {
+	if !o.CanBeRefunded() {
+		return nil, fmt.Errorf("%w: order is %s and payment is %s", errRefundNotAllowed, o.Status, o.PaymentStatus)
+	}
+	if len(req.Items) > 0 && req.Amount != 0 {
+		return nil, fmt.Errorf("%w: use either items or amount", errInvalidRefund)
+	}
+	if req.Amount < 0 {
+		return nil, fmt.Errorf("%w: amount must be greater than 0", errInvalidRefund)
+	}
+
+	entry := &RefundEntry{
+		ID:        req.ID,
+		Status:    RefundPending,
+		Currency:  o.Currency,
+		Restock:   o.Status == StatusPaid, // Sin enviar, la mercancía sigue en el almacén
+		ReturnID:  returnID,
+		Reason:    req.Reason,
+		CreatedAt: now,
+	}
+	if req.Restock != nil {
+		entry.Restock = *req.Restock
+	}
+
+	switch {
+	case len(req.Items) > 0:
+		items, err := o.resolveItems(req.Items, returnID, errInvalidRefund, errRefundNotAllowed)
+		if err != nil {
+			return nil, err
+		}
+		entry.Items = items
+		for _, item := range items {
+			entry.Amount += item.Amount
+		}
+	case req.Amount > 0:
+		entry.Amount = o.roundAmount(req.Amount)
+		entry.Restock = false
+	default:
+		// Reembolso total: lo que queda de cada item y del importe, salvo lo que
+		// está en devoluciones abiertas (se reembolsa al recibirlas)
+		committed := o.committedQuantities(returnID)
+		entry.Amount = o.TotalAmount - o.committedAmount()
+		for i, item := range o.Items {
+			if left := item.Quantity - committed[i]; left > 0 {
+				entry.Items = append(entry.Items, RefundItem{SKU: item.SKU, Quantity: left, Amount: o.roundAmount(item.UnitPrice * float64(left))})
+			}
+		}
+		for _, ret := range o.Returns {
+			if ret.ID != returnID && ret.Status != ReturnRefunded && o.findRefundFor(ret.ID) < 0 {
+				for _, item := range ret.Items {
+					entry.Amount -= item.Amount
+				}
+			}
+		}
+	}
+
+	entry.Amount = o.roundAmount(entry.Amount)
+	remaining := o.roundAmount(o.TotalAmount - o.committedAmount())
+	switch {
+	case entry.Amount <= 0:
+		return nil, fmt.Errorf("%w: nothing left to refund", errRefundNotAllowed)
+	case entry.Amount > remaining:
+		return nil, fmt.Errorf("%w: %.2f exceeds the %.2f left to refund", errRefundNotAllowed, entry.Amount, remaining)
+	}
+	return entry, nil
+}
+
+// capturedPayment devuelve el cobro del pedido guardado por el workflow
+func (h *OrderHandler) capturedPayment(ctx context.Context, orderID string) (*PaymentResult, error) {
+	doc, err := h.workflowRef(orderID).Get(ctx)
+	switch {
+	case isNotFound(err):
+		return nil, fmt.Errorf("%w: order has no payment", errRefundNotAllowed)
+	case err != nil:
+		return nil, fmt.Errorf("getting workflow: %w", err)
+	}
+	var workflow ProcessOrderWorkflow
+	if err := doc.DataTo(&workflow); err != nil {
+		return nil, fmt.Errorf("converting workflow: %w", err)
+	}
+	if workflow.PaymentResult == nil || workflow.PaymentResult.Status != PaymentStateCaptured {
+		return nil, fmt.Errorf("%w: payment was not captured", errRefundNotAllowed)
+	}
+	return workflow.PaymentResult, nil
+}
+
+// beginRefund apunta el reembolso como pending. Si el ID ya está en el ledger
+// devuelve ese apunte para retomarlo o responder con él.
+func (h *OrderHandler) beginRefund(ctx context.Context, orderID string, req RefundRequest, returnID string) (*RefundEntry, error) {
+	ref := h.firestoreClient.Collection(CollectionOrders).Doc(orderID)
+	var entry *RefundEntry
+
+	err := h.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		switch {
+		case isNotFound(err):
+			return errOrderNotFound
+		case err != nil:
+			return err
+		}
+		var order Order
+		if err := doc.DataTo(&order); err != nil {
+			return fmt.Errorf("converting order: %w", err)
+		}
+
+		if i := order.findRefund(req.ID); i >= 0 {
+			existing := order.Refunds[i]
+			entry = &existing
+			return nil
+		}
+		now := time.Now()
+		entry, err = order.newRefundEntry(req, returnID, now)
+		if err != nil {
+			return err
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "refunds", Value: append(order.Refunds, *entry)},
+			{Path: "updated_at", Value: now},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// failRefund marca como failed un reembolso que el proveedor rechazó
+func (h *OrderHandler) failRefund(ctx context.Context, orderID, refundID string, cause error) error {
+	_, err := h.transitionOrder(ctx, orderID, OrderTransition{
+		Source: SourceRefund,
+		Apply: func(order *Order, change *OrderTransition) ([]firestore.Update, error) {
+			i := order.findRefund(refundID)
+			if i < 0 || order.Refunds[i].Status != RefundPending {
+				return nil, nil
+			}
+			completedAt := time.Now()
+			order.Refunds[i].Status = RefundFailed
+			order.Refunds[i].Error = cause.Error()
+			order.Refunds[i].CompletedAt = &completedAt
+			return []firestore.Update{{Path: "refunds", Value: order.Refunds}}, nil
+		},
+	})
+	return err
+}
+
+// completeRefund cierra el apunte, recalcula los totales y mueve los estados:
+// reembolsado todo, payment_status y status pasan a refunded
+func (h *OrderHandler) completeRefund(ctx context.Context, orderID string, completed RefundEntry) (*Order, error) {
+	return h.transitionOrder(ctx, orderID, OrderTransition{
+		Source: SourceRefund,
+		Reason: "refund " + completed.ID,
+		Apply: func(order *Order, change *OrderTransition) ([]firestore.Update, error) {
+			i := order.findRefund(completed.ID)
+			if i < 0 {
+				return nil, fmt.Errorf("refund %s is not in the ledger", completed.ID)
+			}
+			if order.Refunds[i].Status == RefundSucceeded {
+				return nil, nil
+			}
+			completedAt := time.Now()
+			completed.Status = RefundSucceeded
+			completed.CompletedAt = &completedAt
+			order.Refunds[i] = completed
+			order.RecalculateRefunds()
+
+			updates := []firestore.Update{
+				{Path: "refunds", Value: order.Refunds},
+				{Path: "items", Value: order.Items},
+				{Path: "refunded_amount", Value: order.RefundedAmount},
+			}
+			if r := order.findReturn(completed.ReturnID); r >= 0 {
+				order.Returns[r].Status = ReturnRefunded
+				order.Returns[r].RefundID = completed.ID
+				updates = append(updates, firestore.Update{Path: "returns", Value: order.Returns})
+			}
+
+			change.PaymentStatus = PaymentPartiallyRefunded
+			if order.NetAmount() <= 0 {
+				change.PaymentStatus = PaymentRefunded
+				change.Status = StatusRefunded
+			}
+			return updates, nil
+		},
+	})
+}
+
+// refundOrder ejecuta un reembolso de principio a fin (o retoma uno pending).
+// replayed indica que ya estaba completado antes de la llamada.
+func (h *OrderHandler) refundOrder(ctx context.Context, orderID string, req RefundRequest, returnID string) (*Order, bool, error) {
+	if req.ID == "" {
+		req.ID = uuid.New().String()
+	}
+	payment, err := h.capturedPayment(ctx, orderID)
+	if err != nil {
+		return nil, false, err
+	}
+	entry, err := h.beginRefund(ctx, orderID, req, returnID)
+	if err != nil {
+		return nil, false, err
+	}
+	if entry.Status == RefundFailed {
+		return nil, false, fmt.Errorf("%w: refund %s failed: %s", errRefundNotAllowed, entry.ID, entry.Error)
+	}
+	if entry.Status == RefundSucceeded {
+		order, err := h.getOrderByID(ctx, orderID)
+		return order, true, err
+	}
+
+	result, err := h.payments.Refund(ctx, payment.AuthorizationID, entry.Amount, entry.Currency, paymentKey(orderID, "refund-"+entry.ID))
+	switch {
+	case errors.Is(err, errPaymentRejected) || (err == nil && !result.Success):
+		if err == nil {
+			err = fmt.Errorf("%w: %s", errPaymentRejected, result.ErrorMessage)
+		}
+		if failErr := h.failRefund(ctx, orderID, entry.ID, err); failErr != nil {
+			return nil, false, fmt.Errorf("recording failed refund: %w", failErr)
+		}
+		return nil, false, fmt.Errorf("%w: %v", errRefundNotAllowed, err)
+	case err != nil:
+		return nil, false, fmt.Errorf("refunding payment: %w", err) // Queda pending: se retoma con el mismo refund_id
+	}
+	entry.TransactionID = result.TransactionID
+
+	if entry.Restock {
+		if err := h.restockRefund(ctx, orderID, entry); err != nil {
+			return nil, false, err
+		}
+	}
+
+	order, err := h.completeRefund(ctx, orderID, *entry)
+	if err != nil {
+		return nil, false, fmt.Errorf("completing refund: %w", err)
+	}
+	return order, false, nil
+}
+
+// restockRefund repone en AWS las unidades reembolsadas. Una reserva que ya no
+// existe o no admite la reposición no frena el reembolso: queda apuntado en
+// restock_error. Los errores de red se devuelven para reintentar.
+func (h *OrderHandler) restockRefund(ctx context.Context, orderID string, entry *RefundEntry) error {
+	order, err := h.getOrderByID(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	var skipped []string
+	for _, item := range entry.Items {
+		i := order.itemIndex(item.SKU)
+		if i < 0 {
+			continue
+		}
+		_, err := h.inventory.Restock(ctx, reservationID(orderID, i), "refund-"+entry.ID, item.Quantity)
+		switch {
+		case errors.Is(err, errReservationNotFound), errors.Is(err, errReservationRejected):
+			skipped = append(skipped, fmt.Sprintf("%s: %v", item.SKU, err))
+		case err != nil:
+			return fmt.Errorf("restocking %s: %w", item.SKU, err)
+		}
+	}
+	entry.RestockError = strings.Join(skipped, "; ")
+	return nil
+}
+
+// getOrderByID lee un pedido (errOrderNotFound si no existe)
+func (h *OrderHandler) getOrderByID(ctx context.Context, orderID string) (*Order, error) {
+	doc, err := h.firestoreClient.Collection(CollectionOrders).Doc(orderID).Get(ctx)
+	switch {
+	case isNotFound(err):
+		return nil, errOrderNotFound
+	case err != nil:
+		return nil, err
+	}
+	var order Order
+	if err := doc.DataTo(&order); err != nil {
+		return nil, fmt.Errorf("converting order: %w", err)
+	}
+	order.ID = doc.Ref.ID
+	return &order, nil
+}
+
+// updateReturn aplica mutate a una devolución dentro de una transacción
+func (h *OrderHandler) updateReturn(ctx context.Context, orderID, returnID string, mutate func(order *Order, ret *OrderReturn) error) (*OrderReturn, error) {
+	ref := h.firestoreClient.Collection(CollectionOrders).Doc(orderID)
+	var result OrderReturn
+
+	err := h.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		switch {
+		case isNotFound(err):
+			return errOrderNotFound
+		case err != nil:
+			return err
+		}
+		var order Order
+		if err := doc.DataTo(&order); err != nil {
+			return fmt.Errorf("converting order: %w", err)
+		}
+		i := order.findReturn(returnID)
+		if i < 0 {
+			return errReturnNotFound
+		}
+		before := order.Returns[i]
+		if err := mutate(&order, &order.Returns[i]); err != nil {
+			return err
+		}
+		result = order.Returns[i]
+		if result.Status == before.Status {
+			return nil
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "returns", Value: order.Returns},
+			{Path: "updated_at", Value: time.Now()},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// refundErrorResponse traduce los errores de reembolsos y devoluciones
+func (h *OrderHandler) refundErrorResponse(w http.ResponseWriter, err error) {
+	var invalid *InvalidTransitionError
+	switch {
+	case errors.Is(err, errOrderNotFound):
+		h.errorResponse(w, http.StatusNotFound, "Order not found")
+	case errors.Is(err, errReturnNotFound):
+		h.errorResponse(w, http.StatusNotFound, "Return not found")
+	case errors.Is(err, errInvalidRefund), errors.Is(err, errInvalidReturn):
+		h.errorResponse(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, errRefundNotAllowed), errors.Is(err, errReturnNotAllowed), errors.As(err, &invalid):
+		h.errorResponse(w, http.StatusConflict, err.Error())
+	default:
+		// Proveedor de pagos o inventario caídos: el reembolso queda pending
+		h.errorResponse(w, http.StatusBadGateway, fmt.Sprintf("Error processing refund, retry with the same refund_id: %v", err))
+	}
+}
+
+// refundOrderHandler reembolsa un pedido, entero o por items
+func (h *OrderHandler) refundOrderHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, orderID string) {
+	var req RefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.errorResponse(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	order, replayed, err := h.refundOrder(ctx, orderID, req, "")
+	if err != nil {
+		h.refundErrorResponse(w, err)
+		return
+	}
+
+	message := "Order refunded successfully"
+	if replayed {
+		message = "Refund already processed"
+	}
+	response := OrderResponse{
+		Success: true,
+		Message: message,
+		Data:    order,
+	}
+
+	h.successResponse(w, response)
+}
+
+// createReturn abre una devolución de items de un pedido enviado
+func (h *OrderHandler) createReturn(ctx context.Context, w http.ResponseWriter, r *http.Request, orderID string) {
+	var req CreateReturnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.errorResponse(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	if len(req.Items) == 0 {
+		h.errorResponse(w, http.StatusBadRequest, "At least one item is required")
+		return
+	}
+
+	ref := h.firestoreClient.Collection(CollectionOrders).Doc(orderID)
+	var ret OrderReturn
+	err := h.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		switch {
+		case isNotFound(err):
+			return errOrderNotFound
+		case err != nil:
+			return err
+		}
+		var order Order
+		if err := doc.DataTo(&order); err != nil {
+			return fmt.Errorf("converting order: %w", err)
+		}
+		if !order.CanBeReturned() || !order.CanBeRefunded() {
+			return fmt.Errorf("%w: order is %s and payment is %s", errReturnNotAllowed, order.Status, order.PaymentStatus)
+		}
+		items, err := order.resolveItems(req.Items, "", errInvalidReturn, errReturnNotAllowed)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		ret = OrderReturn{
+			ID:          uuid.New().String(),
+			Status:      ReturnRequested,
+			Items:       items,
+			Reason:      req.Reason,
+			RequestedAt: now,
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "returns", Value: append(order.Returns, ret)},
+			{Path: "updated_at", Value: now},
+		})
+	})
+	if err != nil {
+		h.refundErrorResponse(w, err)
+		return
+	}
+
+	response := OrderResponse{
+		Success: true,
+		Message: "Return requested successfully",
+		Data:    ret,
+	}
+
+	h.successResponse(w, response)
+}
+
+// approveReturn acepta una devolución solicitada (aprobar dos veces no falla)
+func (h *OrderHandler) approveReturn(ctx context.Context, w http.ResponseWriter, r *http.Request, orderID, returnID string) {
+	ret, err := h.updateReturn(ctx, orderID, returnID, func(order *Order, ret *OrderReturn) error {
+		if ret.Status != ReturnRequested {
+			return nil // Ya aprobada (o más adelante)
+		}
+		approvedAt := time.Now()
+		ret.Status = ReturnApproved
+		ret.ApprovedAt = &approvedAt
+		return nil
+	})
+	if err != nil {
+		h.refundErrorResponse(w, err)
+		return
+	}
+
+	response := OrderResponse{
+		Success: true,
+		Message: "Return approved successfully",
+		Data:    ret,
+	}
+
+	h.successResponse(w, response)
+}
+
+// receiveReturn registra la llegada de la mercancía y reembolsa sus items
+// reponiendo el stock. Si el reembolso falla a medias, repetir la llamada lo
+// retoma.
+func (h *OrderHandler) receiveReturn(ctx context.Context, w http.ResponseWriter, r *http.Request, orderID, returnID string) {
+	ret, err := h.updateReturn(ctx, orderID, returnID, func(order *Order, ret *OrderReturn) error {
+		switch ret.Status {
+		case ReturnRequested:
+			return fmt.Errorf("%w: return has not been approved", errReturnNotAllowed)
+		case ReturnApproved:
+			receivedAt := time.Now()
+			ret.Status = ReturnReceived
+			ret.ReceivedAt = &receivedAt
+		}
+		return nil
+	})
+	if err != nil {
+		h.refundErrorResponse(w, err)
+		return
+	}
+
+	if ret.Status == ReturnReceived {
+		restock := true
+		order, _, err := h.refundOrder(ctx, orderID, RefundRequest{
+			ID:      "return-" + ret.ID,
+			Items:   ret.Items,
+			Restock: &restock,
+			Reason:  strings.TrimSpace("return " + ret.ID + " " + ret.Reason),
+		}, ret.ID)
+		if err != nil {
+			h.refundErrorResponse(w, err)
+			return
+		}
+		if i := order.findReturn(ret.ID); i >= 0 {
+			ret = &order.Returns[i]
+		}
+	}
+
+	response := OrderResponse{
+		Success: true,
+		Message: "Return received and refunded successfully",
+		Data:    ret,
+	}
+
+	h.successResponse(w, response)
+}
@@ -0,0 +1,193 @@
+package orderprocessor
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// refundableOrder -> Pedido entregado y cobrado: 2 x SKU-A a 10 y 1 x SKU-B a 5
+func refundableOrder() *Order {
+	return &Order{
+		ID:            "order-1",
+		Status:        StatusDelivered,
+		PaymentStatus: PaymentCompleted,
+		Currency:      DefaultCurrency,
+		TotalAmount:   25,
+		Items: []OrderItem{
+			{SKU: "SKU-A", Quantity: 2, UnitPrice: 10, TotalPrice: 20},
+			{SKU: "SKU-B", Quantity: 1, UnitPrice: 5, TotalPrice: 5},
+		},
+	}
+}
+
+func TestCommittedQuantities(t *testing.T) {
+	order := refundableOrder()
+	order.Refunds = []RefundEntry{
+		{ID: "r1", Status: RefundSucceeded, Amount: 10, Items: []RefundItem{{SKU: "SKU-A", Quantity: 1, Amount: 10}}},
+		{ID: "r2", Status: RefundFailed, Amount: 5, Items: []RefundItem{{SKU: "SKU-B", Quantity: 1, Amount: 5}}},
+		{ID: "r3", Status: RefundPending, Amount: 10, ReturnID: "ret-2", Items: []RefundItem{{SKU: "sku-a", Quantity: 1, Amount: 10}}},
+	}
+	order.Returns = []OrderReturn{
+		{ID: "ret-1", Status: ReturnApproved, Items: []RefundItem{{SKU: "SKU-B", Quantity: 1, Amount: 5}}},
+		{ID: "ret-2", Status: ReturnReceived, Items: []RefundItem{{SKU: "SKU-A", Quantity: 1, Amount: 10}}}, // Ya contado en r3
+		{ID: "ret-3", Status: ReturnRefunded, Items: []RefundItem{{SKU: "SKU-B", Quantity: 1, Amount: 5}}},
+	}
+
+	if got := order.committedQuantities(""); !reflect.DeepEqual(got, []int{2, 1}) {
+		t.Fatalf("committedQuantities(\"\") = %v, want [2 1]", got)
+	}
+	if got := order.committedQuantities("ret-1"); !reflect.DeepEqual(got, []int{2, 0}) {
+		t.Fatalf("committedQuantities(ret-1) = %v, want [2 0]", got)
+	}
+}
+
+func TestResolveItems(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested []RefundItem
+		want      []RefundItem
+		wantErr   error
+	}{
+		{"prices each item", []RefundItem{{SKU: "sku-a", Quantity: 2}, {SKU: "SKU-B", Quantity: 1}}, []RefundItem{{SKU: "SKU-A", Quantity: 2, Amount: 20}, {SKU: "SKU-B", Quantity: 1, Amount: 5}}, nil},
+		{"ignores requested amount", []RefundItem{{SKU: "SKU-B", Quantity: 1, Amount: 100}}, []RefundItem{{SKU: "SKU-B", Quantity: 1, Amount: 5}}, nil},
+		{"unknown sku", []RefundItem{{SKU: "SKU-C", Quantity: 1}}, nil, errInvalidRefund},
+		{"zero quantity", []RefundItem{{SKU: "SKU-A", Quantity: 0}}, nil, errInvalidRefund},
+		{"more than ordered", []RefundItem{{SKU: "SKU-A", Quantity: 3}}, nil, errRefundNotAllowed},
+		{"repeated sku adds up", []RefundItem{{SKU: "SKU-A", Quantity: 2}, {SKU: "SKU-A", Quantity: 1}}, nil, errRefundNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := refundableOrder().resolveItems(tt.requested, "", errInvalidRefund, errRefundNotAllowed)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("items = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// Lo que ya está reembolsado no se puede volver a pedir
+	order := refundableOrder()
+	order.Refunds = []RefundEntry{{ID: "r1", Status: RefundSucceeded, Amount: 5, Items: []RefundItem{{SKU: "SKU-B", Quantity: 1, Amount: 5}}}}
+	if _, err := order.resolveItems([]RefundItem{{SKU: "SKU-B", Quantity: 1}}, "", errInvalidReturn, errReturnNotAllowed); !errors.Is(err, errReturnNotAllowed) {
+		t.Fatalf("error = %v, want %v", err, errReturnNotAllowed)
+	}
+}
+
+func TestNewRefundEntry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("full refund after an amount-only partial", func(t *testing.T) {
+		order := refundableOrder()
+		order.PaymentStatus = PaymentPartiallyRefunded
+		order.Refunds = []RefundEntry{{ID: "r1", Status: RefundSucceeded, Amount: 5}}
+
+		entry, err := order.newRefundEntry(RefundRequest{ID: "r2"}, "", now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if entry.Amount != 20 {
+			t.Fatalf("amount = %v, want 20", entry.Amount)
+		}
+		want := []RefundItem{{SKU: "SKU-A", Quantity: 2, Amount: 20}, {SKU: "SKU-B", Quantity: 1, Amount: 5}}
+		if !reflect.DeepEqual(entry.Items, want) {
+			t.Fatalf("items = %+v, want %+v", entry.Items, want)
+		}
+		if entry.Status != RefundPending || entry.Restock {
+			t.Fatalf("entry is %s with restock %v, want pending without restock", entry.Status, entry.Restock)
+		}
+	})
+
+	t.Run("full refund with an open return", func(t *testing.T) {
+		order := refundableOrder()
+		order.Returns = []OrderReturn{{ID: "ret-1", Status: ReturnApproved, Items: []RefundItem{{SKU: "SKU-A", Quantity: 1, Amount: 10}}}}
+
+		entry, err := order.newRefundEntry(RefundRequest{}, "", now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if entry.Amount != 15 {
+			t.Fatalf("amount = %v, want 15", entry.Amount)
+		}
+		want := []RefundItem{{SKU: "SKU-A", Quantity: 1, Amount: 10}, {SKU: "SKU-B", Quantity: 1, Amount: 5}}
+		if !reflect.DeepEqual(entry.Items, want) {
+			t.Fatalf("items = %+v, want %+v", entry.Items, want)
+		}
+	})
+
+	t.Run("refunding a return", func(t *testing.T) {
+		order := refundableOrder()
+		order.Returns = []OrderReturn{{ID: "ret-1", Status: ReturnReceived, Items: []RefundItem{{SKU: "SKU-A", Quantity: 1, Amount: 10}}}}
+
+		entry, err := order.newRefundEntry(RefundRequest{Items: order.Returns[0].Items}, "ret-1", now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if entry.Amount != 10 || entry.ReturnID != "ret-1" {
+			t.Fatalf("entry = %+v, want 10 for ret-1", entry)
+		}
+	})
+
+	t.Run("paid order restocks by default", func(t *testing.T) {
+		order := refundableOrder()
+		order.Status = StatusPaid
+
+		entry, err := order.newRefundEntry(RefundRequest{Items: []RefundItem{{SKU: "SKU-B", Quantity: 1}}}, "", now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !entry.Restock {
+			t.Fatal("restock = false, want true for an order that has not shipped")
+		}
+	})
+
+	tests := []struct {
+		name    string
+		mutate  func(*Order)
+		req     RefundRequest
+		wantErr error
+	}{
+		{"order not paid", func(o *Order) { o.Status = StatusProcessing; o.PaymentStatus = PaymentAuthorized }, RefundRequest{}, errRefundNotAllowed},
+		{"items and amount", nil, RefundRequest{Items: []RefundItem{{SKU: "SKU-A", Quantity: 1}}, Amount: 5}, errInvalidRefund},
+		{"negative amount", nil, RefundRequest{Amount: -1}, errInvalidRefund},
+		{"amount over what is left", func(o *Order) { o.Refunds = []RefundEntry{{ID: "r1", Status: RefundSucceeded, Amount: 20}} }, RefundRequest{Amount: 10}, errRefundNotAllowed},
+		{"nothing left", func(o *Order) { o.Refunds = []RefundEntry{{ID: "r1", Status: RefundPending, Amount: 25}} }, RefundRequest{}, errRefundNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := refundableOrder()
+			if tt.mutate != nil {
+				tt.mutate(order)
+			}
+			if _, err := order.newRefundEntry(tt.req, "", now); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMergeOrderItems(t *testing.T) {
+	items := []OrderItem{
+		{ProductID: "p1", SKU: "SKU-A", Quantity: 1, UnitPrice: 10, TotalPrice: 10},
+		{ProductID: "p2", SKU: "SKU-B", Quantity: 1, UnitPrice: 5, TotalPrice: 5},
+		{ProductID: "p1", SKU: "sku-a", Quantity: 2, UnitPrice: 10, TotalPrice: 20},
+	}
+	want := []OrderItem{
+		{ProductID: "p1", SKU: "SKU-A", Quantity: 3, UnitPrice: 10, TotalPrice: 30},
+		{ProductID: "p2", SKU: "SKU-B", Quantity: 1, UnitPrice: 5, TotalPrice: 5},
+	}
+	if got := mergeOrderItems(items); !reflect.DeepEqual(got, want) {
+		t.Fatalf("mergeOrderItems = %+v, want %+v", got, want)
+	}
+}
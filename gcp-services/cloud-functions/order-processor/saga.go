@@ -0,0 +1,215 @@
+package orderprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Cuando un paso falla sin remedio (error permanente o reintentos agotados) el
+// workflow pasa a compensating y deshace lo que hicieron los pasos completados
+// (y el que falló, que pudo quedar a medias): libera inventario, reembolsa el
+// cobro, cancela el pedido y avisa al cliente. Cada compensación queda
+// registrada en el workflow.
+
+// Acciones compensatorias
+const (
+	CompensationReleaseInventory = "release_inventory"
+	CompensationRefundPayment    = "refund_payment"
+	CompensationCancelOrder      = "cancel_order"
+	CompensationNotifyCustomer   = "notify_customer"
+
+	CompensationCompleted = "completed"
+	CompensationFailed    = "failed"
+)
+
+// retryPolicy -> Reintentos de un paso: backoff exponencial con jitter
+type retryPolicy struct {
+	MaxAttempts    int // Incluye el primer intento
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+}
+
+var (
+	defaultRetry      = retryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: 30 * time.Second, Multiplier: 2}
+	paymentRetry      = retryPolicy{MaxAttempts: 4, InitialBackoff: 2 * time.Second, MaxBackoff: time.Minute, Multiplier: 3}
+	noRetry           = retryPolicy{MaxAttempts: 1}
+	compensationRetry = retryPolicy{MaxAttempts: 8, InitialBackoff: 2 * time.Second, MaxBackoff: 5 * time.Minute, Multiplier: 2}
+)
+
+// backoff devuelve la espera antes del reintento número attempt (1, 2, ...):
+// la mitad fija y la otra mitad aleatoria, para no sincronizar reintentos
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := min(float64(p.InitialBackoff)*math.Pow(p.Multiplier, float64(attempt-1)), float64(p.MaxBackoff))
+	half := time.Duration(delay / 2)
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// permanentError marca un fallo que no se arregla reintentando
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	return permanentError{err: err}
+}
+
+func isPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// CompensationRecord -> Acción compensatoria ejecutada al fallar el workflow
+type CompensationRecord struct {
+	Step       string    `json:"step" firestore:"step"` // Paso que deshace (o el que falló)
+	Action     string    `json:"action" firestore:"action"`
+	Status     string    `json:"status" firestore:"status"` // completed, failed
+	Attempts   int       `json:"attempts" firestore:"attempts"`
+	Error      string    `json:"error,omitempty" firestore:"error"`
+	ExecutedAt time.Time `json:"executed_at" firestore:"executed_at"`
+}
+
+// compensation -> Acción pendiente de la saga
+type compensation struct {
+	Step   string
+	Action string
+	Run    func(h *OrderHandler, ctx context.Context, workflow *ProcessOrderWorkflow, order *Order) error
+}
+
+// workflowCompensations lista las compensaciones del workflow fallido: las de
+// los pasos completados y la del que falló, en el orden de los pasos (primero
+// se devuelve el stock, luego el dinero) y, al final, cancelar el pedido y
+// avisar al cliente
+func workflowCompensations(workflow *ProcessOrderWorkflow) []compensation {
+	touched := map[string]bool{workflow.FailedStep: true}
+	for _, name := range workflow.CompletedSteps {
+		touched[name] = true
+	}
+
+	var list []compensation
+	for _, step := range workflowSteps {
+		if touched[step.Name] && step.Compensate != nil {
+			list = append(list, compensation{Step: step.Name, Action: step.CompensateAction, Run: step.Compensate})
+		}
+	}
+	return append(list,
+		compensation{Step: workflow.FailedStep, Action: CompensationCancelOrder, Run: (*OrderHandler).cancelOrderCompensation},
+		compensation{Step: workflow.FailedStep, Action: CompensationNotifyCustomer, Run: (*OrderHandler).notifyCustomerCompensation},
+	)
+}
+
+// nextCompensation devuelve la primera compensación aún sin registrar
+func nextCompensation(workflow *ProcessOrderWorkflow) (compensation, bool) {
+	done := map[string]bool{}
+	for _, record := range workflow.Compensations {
+		done[record.Action] = true
+	}
+	for _, c := range workflowCompensations(workflow) {
+		if !done[c.Action] {
+			return c, true
+		}
+	}
+	return compensation{}, false
+}
+
+// releaseInventoryCompensation devuelve al inventario lo reservado o consumido.
+// Se libera cada reserva del pedido aunque no conste su creación (la respuesta
+// pudo perderse); las que no existen en AWS no se registran.
+func (h *OrderHandler) releaseInventoryCompensation(ctx context.Context, workflow *ProcessOrderWorkflow, order *Order) error {
+	for i, item := range order.Items {
+		id := reservationID(order.ID, i)
+		if status := reservationStatus(workflow, id); status == ReservationReleased || status == ReservationExpired {
+			continue
+		}
+		reservation, err := h.inventory.Release(ctx, id)
+		if errors.Is(err, errReservationNotFound) {
+			continue
+		}
+		recordInventoryCall(workflow, item, id, InventoryRelease, reservation, err)
+		if err != nil {
+			return fmt.Errorf("releasing reservation of %s: %w", item.SKU, err)
+		}
+	}
+	return nil
+}
+
+// refundPaymentCompensation reembolsa un cobro capturado o anula una
+// autorización sin capturar (también la que espera autenticación 3DS)
+func (h *OrderHandler) refundPaymentCompensation(ctx context.Context, workflow *ProcessOrderWorkflow, order *Order) error {
+	payment := workflow.PaymentResult
+	if payment == nil || workflow.Refund != nil {
+		return nil
+	}
+
+	switch payment.Status {
+	case PaymentStateCaptured:
+		refund, err := h.payments.Refund(ctx, payment.AuthorizationID, payment.Amount, payment.Currency, paymentKey(order.ID, "refund"))
+		if err != nil {
+			return fmt.Errorf("refunding payment: %w", err)
+		}
+		if !refund.Success {
+			return fmt.Errorf("refunding payment: %s", refund.ErrorMessage)
+		}
+		workflow.Refund = refund
+		return h.transitionStatus(ctx, order.ID, OrderTransition{
+			PaymentStatus: PaymentRefunded,
+			Source:        SourceCompensation,
+			Reason:        "payment refunded",
+		})
+
+	case PaymentStateAuthorized, PaymentStateRequiresAction:
+		voided, err := h.payments.Void(ctx, payment.AuthorizationID, paymentKey(order.ID, "void"))
+		if err != nil {
+			return fmt.Errorf("voiding payment: %w", err)
+		}
+		workflow.PaymentResult = voided
+		return h.transitionStatus(ctx, order.ID, OrderTransition{
+			PaymentStatus: PaymentVoided,
+			Source:        SourceCompensation,
+			Reason:        "payment voided",
+		})
+	}
+	return nil
+}
+
+// cancelOrderCompensation cancela el pedido; si no llegó a intentarse el cobro,
+// el pago queda como fallido (si no, payment_status ya dice qué pasó)
+func (h *OrderHandler) cancelOrderCompensation(ctx context.Context, workflow *ProcessOrderWorkflow, order *Order) error {
+	change := OrderTransition{
+		Status: StatusCancelled,
+		Source: SourceCompensation,
+		Reason: "order processing failed",
+	}
+	if workflow.PaymentResult == nil {
+		change.PaymentStatus = PaymentFailed
+	}
+	return h.transitionStatus(ctx, order.ID, change)
+}
+
+// notifyCustomerCompensation avisa al cliente de que su pedido no pudo procesarse
+func (h *OrderHandler) notifyCustomerCompensation(ctx context.Context, workflow *ProcessOrderWorkflow, order *Order) error {
+	for _, sent := range workflow.NotificationsSent {
+		if sent.Template == "order_failed" {
+			return nil
+		}
+	}
+	workflow.NotificationsSent = append(workflow.NotificationsSent, NotificationPayload{
+		Type:      "email",
+		Recipient: order.UserEmail,
+		Template:  "order_failed",
+		Data: map[string]interface{}{
+			"order_id": order.ID,
+			"reason":   workflow.ErrorMessage,
+			"refunded": workflow.Refund != nil,
+		},
+		Priority: "high",
+	})
+	return nil
+}
@@ -0,0 +1,247 @@
+package orderprocessor
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// compensationActions -> Acciones de una lista de compensaciones, en orden
+func compensationActions(list []compensation) []string {
+	actions := make([]string, len(list))
+	for i, c := range list {
+		actions[i] = c.Action
+	}
+	return actions
+}
+
+func TestWorkflowCompensations(t *testing.T) {
+	tests := []struct {
+		name      string
+		failed    string
+		completed []string
+		want      []string
+	}{
+		{"validation failed", StepValidateOrder, nil,
+			[]string{CompensationCancelOrder, CompensationNotifyCustomer}},
+		{"reservation failed", StepReserveInventory, []string{StepValidateOrder},
+			[]string{CompensationReleaseInventory, CompensationCancelOrder, CompensationNotifyCustomer}},
+		{"payment failed", StepProcessPayment, []string{StepValidateOrder, StepReserveInventory},
+			[]string{CompensationReleaseInventory, CompensationRefundPayment, CompensationCancelOrder, CompensationNotifyCustomer}},
+		{"inventory update failed", StepUpdateInventory, []string{StepValidateOrder, StepReserveInventory, StepProcessPayment},
+			[]string{CompensationReleaseInventory, CompensationRefundPayment, CompensationCancelOrder, CompensationNotifyCustomer}},
+		{"completion failed", StepCompleteOrder, []string{StepValidateOrder, StepReserveInventory, StepProcessPayment, StepUpdateInventory, StepSendNotifications},
+			[]string{CompensationReleaseInventory, CompensationRefundPayment, CompensationCancelOrder, CompensationNotifyCustomer}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list := workflowCompensations(&ProcessOrderWorkflow{FailedStep: tt.failed, CompletedSteps: tt.completed})
+			if got := compensationActions(list); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("compensations = %v, want %v", got, tt.want)
+			}
+			for _, c := range list {
+				if c.Run == nil {
+					t.Fatalf("compensation %s has no Run", c.Action)
+				}
+			}
+		})
+	}
+}
+
+func TestNextCompensation(t *testing.T) {
+	workflow := &ProcessOrderWorkflow{
+		Status:         WorkflowCompensating,
+		FailedStep:     StepProcessPayment,
+		CompletedSteps: []string{StepValidateOrder, StepReserveInventory},
+	}
+
+	var got []string
+	for {
+		next, ok := nextCompensation(workflow)
+		if !ok {
+			break
+		}
+		got = append(got, next.Action)
+		// Una compensación fallida también cuenta como hecha: queda para revisión manual
+		status := CompensationCompleted
+		if next.Action == CompensationRefundPayment {
+			status = CompensationFailed
+		}
+		workflow.Compensations = append(workflow.Compensations, CompensationRecord{Step: next.Step, Action: next.Action, Status: status})
+		if len(got) > 10 {
+			t.Fatalf("nextCompensation does not finish: %v", got)
+		}
+	}
+
+	want := []string{CompensationReleaseInventory, CompensationRefundPayment, CompensationCancelOrder, CompensationNotifyCustomer}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("compensations = %v, want %v", got, want)
+	}
+}
+
+func TestHandleStepFailure(t *testing.T) {
+	h := &OrderHandler{}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	policy := retryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: time.Minute, Multiplier: 2}
+	transient := errors.New("connection reset")
+
+	t.Run("schedules a retry", func(t *testing.T) {
+		workflow := &ProcessOrderWorkflow{Status: WorkflowRunning, Step: StepProcessPayment}
+		h.handleStepFailure(workflow, StepProcessPayment, policy, transient, now)
+		if workflow.Status != WorkflowRetrying || workflow.RetryCount != 1 {
+			t.Fatalf("workflow is %s with %d retries, want retrying with 1", workflow.Status, workflow.RetryCount)
+		}
+		if workflow.NextRetryAt == nil || !workflow.NextRetryAt.After(now) {
+			t.Fatalf("next retry at %v, want after %v", workflow.NextRetryAt, now)
+		}
+	})
+
+	t.Run("exhausted retries compensate", func(t *testing.T) {
+		workflow := &ProcessOrderWorkflow{Status: WorkflowRunning, Step: StepProcessPayment, RetryCount: policy.MaxAttempts - 1}
+		h.handleStepFailure(workflow, StepProcessPayment, policy, transient, now)
+		if workflow.Status != WorkflowCompensating || workflow.FailedStep != StepProcessPayment || workflow.RetryCount != 0 {
+			t.Fatalf("workflow = %+v, want compensating %s", workflow, StepProcessPayment)
+		}
+		if !strings.Contains(workflow.ErrorMessage, "connection reset") {
+			t.Fatalf("error message = %q", workflow.ErrorMessage)
+		}
+	})
+
+	t.Run("permanent errors do not retry", func(t *testing.T) {
+		workflow := &ProcessOrderWorkflow{Status: WorkflowRunning, Step: StepProcessPayment}
+		h.handleStepFailure(workflow, StepProcessPayment, policy, permanent(errPaymentDeclined), now)
+		if workflow.Status != WorkflowCompensating || workflow.NextRetryAt != nil {
+			t.Fatalf("workflow = %+v, want compensating without retry", workflow)
+		}
+	})
+
+	t.Run("failed compensation is recorded", func(t *testing.T) {
+		workflow := &ProcessOrderWorkflow{Status: WorkflowCompensating, FailedStep: StepUpdateInventory, RetryCount: policy.MaxAttempts - 1}
+		h.handleStepFailure(workflow, CompensationRefundPayment, policy, transient, now)
+		want := []CompensationRecord{{
+			Step: StepUpdateInventory, Action: CompensationRefundPayment, Status: CompensationFailed,
+			Attempts: policy.MaxAttempts, Error: "connection reset", ExecutedAt: now,
+		}}
+		if !reflect.DeepEqual(workflow.Compensations, want) {
+			t.Fatalf("compensations = %+v, want %+v", workflow.Compensations, want)
+		}
+		if workflow.Status != WorkflowCompensating || workflow.RetryCount != 0 {
+			t.Fatalf("workflow is %s with %d retries, want compensating with 0", workflow.Status, workflow.RetryCount)
+		}
+	})
+}
+
+// fastRetries acorta las esperas entre reintentos mientras dura el test
+func fastRetries(t *testing.T) {
+	t.Helper()
+	steps := append([]workflowStep(nil), workflowSteps...)
+	compensations := compensationRetry
+	t.Cleanup(func() {
+		copy(workflowSteps, steps)
+		compensationRetry = compensations
+	})
+	for i := range workflowSteps {
+		workflowSteps[i].Retry.InitialBackoff = time.Millisecond
+		workflowSteps[i].Retry.MaxBackoff = time.Millisecond
+	}
+	compensationRetry.InitialBackoff = time.Millisecond
+	compensationRetry.MaxBackoff = time.Millisecond
+}
+
+// assertCompensated comprueba que el workflow falló en step y compensó en orden
+func assertCompensated(t *testing.T, h *OrderHandler, workflow *ProcessOrderWorkflow, step string) {
+	t.Helper()
+	if workflow.Status != WorkflowFailed || workflow.FailedStep != step {
+		t.Fatalf("workflow is %s at %s, want failed at %s", workflow.Status, workflow.FailedStep, step)
+	}
+	var actions []string
+	for _, record := range workflow.Compensations {
+		if record.Status != CompensationCompleted {
+			t.Fatalf("compensation %s is %s: %s", record.Action, record.Status, record.Error)
+		}
+		actions = append(actions, record.Action)
+	}
+	want := []string{CompensationReleaseInventory, CompensationRefundPayment, CompensationCancelOrder, CompensationNotifyCustomer}
+	if !reflect.DeepEqual(actions, want) {
+		t.Fatalf("compensations = %v, want %v", actions, want)
+	}
+
+	order, err := h.fetchOrder(context.Background(), workflow.OrderID)
+	if err != nil {
+		t.Fatalf("reading order: %v", err)
+	}
+	if order.Status != StatusCancelled || order.PaymentStatus != PaymentFailed {
+		t.Fatalf("order is %s with payment %s, want cancelled with payment failed", order.Status, order.PaymentStatus)
+	}
+	product, err := h.catalog.GetProduct(context.Background(), "prod-1")
+	if err != nil {
+		t.Fatalf("reading product: %v", err)
+	}
+	if product.Stock != 10 {
+		t.Fatalf("stock = %d, want 10 after releasing the reservation", product.Stock)
+	}
+}
+
+func TestDeclinedPaymentCompensates(t *testing.T) {
+	h, _, payments := emulatorHandler(t)
+	orderID := createTestOrder(t, h)
+	payments.PaymentProvider.(*FakePaymentProvider).Script(orderID, FakeDecline)
+
+	workflow, err := h.RunWorkflow(context.Background(), orderID, false)
+	if err != nil {
+		t.Fatalf("running workflow: %v", err)
+	}
+	assertCompensated(t, h, workflow, StepProcessPayment)
+	if payments.count() != 1 {
+		t.Fatalf("authorized %d times, want 1 (a decline is not retried)", payments.count())
+	}
+}
+
+func TestPaymentTimeoutsExhaustRetries(t *testing.T) {
+	fastRetries(t)
+	h, _, payments := emulatorHandler(t)
+	orderID := createTestOrder(t, h)
+	timeouts := make([]string, paymentRetry.MaxAttempts)
+	for i := range timeouts {
+		timeouts[i] = FakeTimeout
+	}
+	payments.PaymentProvider.(*FakePaymentProvider).Script(orderID, timeouts...)
+
+	workflow, err := h.RunWorkflow(context.Background(), orderID, false)
+	if err != nil {
+		t.Fatalf("running workflow: %v", err)
+	}
+	assertCompensated(t, h, workflow, StepProcessPayment)
+	if payments.count() != paymentRetry.MaxAttempts {
+		t.Fatalf("authorized %d times, want %d", payments.count(), paymentRetry.MaxAttempts)
+	}
+	if workflow.PaymentResult != nil {
+		t.Fatalf("payment result = %+v, want none after timeouts", workflow.PaymentResult)
+	}
+}
+
+func TestOptionalStepIsSkipped(t *testing.T) {
+	fastRetries(t)
+	_, i, _ := workflowStepAt(StepSendNotifications)
+	workflowSteps[i].Run = func(h *OrderHandler, ctx context.Context, workflow *ProcessOrderWorkflow, order *Order) error {
+		return errors.New("notifications unavailable")
+	}
+	h, _, _ := emulatorHandler(t)
+	orderID := createTestOrder(t, h)
+
+	workflow, err := h.RunWorkflow(context.Background(), orderID, false)
+	if err != nil {
+		t.Fatalf("running workflow: %v", err)
+	}
+	if workflow.Status != WorkflowCompleted || len(workflow.Compensations) != 0 {
+		t.Fatalf("workflow is %s with compensations %+v, want completed without compensating", workflow.Status, workflow.Compensations)
+	}
+	want := []string{StepValidateOrder, StepReserveInventory, StepProcessPayment, StepUpdateInventory, StepSendNotifications, StepCompleteOrder}
+	if !reflect.DeepEqual(workflow.CompletedSteps, want) {
+		t.Fatalf("completed steps = %v, want %v", workflow.CompletedSteps, want)
+	}
+}
@@ -0,0 +1,269 @@
+package orderprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// status y payment_status solo cambian por las transiciones de estas tablas.
+// Cada cambio se hace en una transacción de Firestore (se relee el pedido y se
+// comprueban las guardas) y queda registrado en orders/{id}/status_history.
+// Repetir el estado actual no es un cambio: los pasos del workflow pueden
+// reintentarse sin fallar ni duplicar el historial.
+
+// Origen de un cambio de estado
+const (
+	SourceAPI          = "api"          // PUT/DELETE /orders/{id}
+	SourceWorkflow     = "workflow"     // Pasos del workflow
+	SourceCompensation = "compensation" // Compensaciones de la saga
+	SourceRefund       = "refund"       // Reembolsos y devoluciones
+
+	CollectionStatusHistory = "status_history" // Subcolección de cada pedido
+
+	FieldStatus        = "status"
+	FieldPaymentStatus = "payment_status"
+)
+
+var errOrderNotFound = errors.New("order not found")
+
+// transitionRule -> Transición permitida de un campo de estado
+type transitionRule struct {
+	From    string
+	To      string
+	Sources []string             // Quién puede hacerla
+	Guard   func(o *Order) error // Condición sobre el pedido (ya con el nuevo payment_status)
+}
+
+var orderTransitions = []transitionRule{
+	{From: StatusPending, To: StatusProcessing, Sources: []string{SourceWorkflow}},
+	{From: StatusPending, To: StatusCancelled, Sources: []string{SourceAPI, SourceCompensation}},
+	{From: StatusProcessing, To: StatusPaid, Sources: []string{SourceWorkflow}, Guard: requirePaymentComplete},
+	{From: StatusProcessing, To: StatusCancelled, Sources: []string{SourceAPI, SourceCompensation}},
+	{From: StatusPaid, To: StatusShipped, Sources: []string{SourceAPI}, Guard: requireShippable},
+	{From: StatusPaid, To: StatusRefunded, Sources: []string{SourceRefund}, Guard: requirePaymentRefunded},
+	{From: StatusShipped, To: StatusDelivered, Sources: []string{SourceAPI}},
+	{From: StatusShipped, To: StatusRefunded, Sources: []string{SourceRefund}, Guard: requirePaymentRefunded},
+	{From: StatusDelivered, To: StatusRefunded, Sources: []string{SourceRefund}, Guard: requirePaymentRefunded},
+}
+
+// El cobro es cosa del workflow; payment_status no se cambia desde la API
+var paymentTransitions = []transitionRule{
+	{From: PaymentPending, To: PaymentAuthorized, Sources: []string{SourceWorkflow}},
+	{From: PaymentPending, To: PaymentRequiresAction, Sources: []string{SourceWorkflow}},
+	{From: PaymentPending, To: PaymentFailed, Sources: []string{SourceWorkflow, SourceCompensation}},
+	{From: PaymentRequiresAction, To: PaymentAuthorized, Sources: []string{SourceWorkflow}},
+	{From: PaymentRequiresAction, To: PaymentVoided, Sources: []string{SourceCompensation}},
+	{From: PaymentAuthorized, To: PaymentCompleted, Sources: []string{SourceWorkflow}},
+	{From: PaymentAuthorized, To: PaymentVoided, Sources: []string{SourceCompensation}},
+	{From: PaymentCompleted, To: PaymentRefunded, Sources: []string{SourceCompensation, SourceRefund}},
+	{From: PaymentCompleted, To: PaymentPartiallyRefunded, Sources: []string{SourceRefund}},
+	{From: PaymentPartiallyRefunded, To: PaymentRefunded, Sources: []string{SourceRefund}},
+}
+
+func requirePaymentComplete(o *Order) error {
+	if !o.IsPaymentComplete() {
+		return fmt.Errorf("payment is %s", o.PaymentStatus)
+	}
+	return nil
+}
+
+func requireShippable(o *Order) error {
+	if !o.CanBeShipped() {
+		return fmt.Errorf("order is not paid (payment is %s)", o.PaymentStatus)
+	}
+	return nil
+}
+
+func requirePaymentRefunded(o *Order) error {
+	if o.PaymentStatus != PaymentRefunded {
+		return fmt.Errorf("payment is %s", o.PaymentStatus)
+	}
+	return nil
+}
+
+// InvalidTransitionError -> Cambio de estado que la máquina no permite
+type InvalidTransitionError struct {
+	Field  string
+	From   string
+	To     string
+	Reason string
+}
+
+func (e *InvalidTransitionError) Error() string {
+	msg := fmt.Sprintf("cannot change %s from %s to %s", e.Field, e.From, e.To)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// StatusChange -> Entrada de orders/{id}/status_history
+type StatusChange struct {
+	Field     string    `json:"field" firestore:"field"` // status, payment_status
+	From      string    `json:"from" firestore:"from"`
+	To        string    `json:"to" firestore:"to"`
+	Source    string    `json:"source" firestore:"source"` // api, workflow, compensation
+	Reason    string    `json:"reason,omitempty" firestore:"reason"`
+	ChangedAt time.Time `json:"changed_at" firestore:"changed_at"`
+}
+
+// OrderTransition -> Cambio de estado pedido; los campos vacíos no cambian
+type OrderTransition struct {
+	Status        string
+	PaymentStatus string
+	Source        string
+	Reason        string
+	Updates       []firestore.Update // Otros campos a escribir junto al cambio
+
+	// Apply, si está, se ejecuta dentro de la transacción con el pedido recién
+	// leído: puede modificarlo, fijar los estados destino y devolver más campos
+	Apply func(order *Order, change *OrderTransition) ([]firestore.Update, error)
+}
+
+// checkTransition busca la regla from -> to del campo y comprueba origen y guarda
+func checkTransition(rules []transitionRule, field, source string, order *Order, from, to string) error {
+	for _, rule := range rules {
+		if rule.From != from || rule.To != to {
+			continue
+		}
+		allowed := false
+		for _, s := range rule.Sources {
+			allowed = allowed || s == source
+		}
+		if !allowed {
+			return &InvalidTransitionError{Field: field, From: from, To: to, Reason: "not allowed from " + source}
+		}
+		if rule.Guard != nil {
+			if err := rule.Guard(order); err != nil {
+				return &InvalidTransitionError{Field: field, From: from, To: to, Reason: err.Error()}
+			}
+		}
+		return nil
+	}
+	return &InvalidTransitionError{Field: field, From: from, To: to}
+}
+
+// transitionOrder aplica el cambio en una transacción y devuelve el pedido
+// resultante. payment_status se aplica antes que status para que las guardas
+// de status vean el pago nuevo.
+func (h *OrderHandler) transitionOrder(ctx context.Context, orderID string, change OrderTransition) (*Order, error) {
+	ref := h.firestoreClient.Collection(CollectionOrders).Doc(orderID)
+	var order Order
+
+	err := h.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		switch {
+		case isNotFound(err):
+			return errOrderNotFound
+		case err != nil:
+			return err
+		}
+		order = Order{}
+		if err := doc.DataTo(&order); err != nil {
+			return fmt.Errorf("converting order: %w", err)
+		}
+		order.ID = doc.Ref.ID
+
+		now := time.Now()
+		var history []StatusChange
+		change := change // Cada intento parte de la petición original
+		updates := append([]firestore.Update(nil), change.Updates...)
+		if change.Apply != nil {
+			extra, err := change.Apply(&order, &change)
+			if err != nil {
+				return err
+			}
+			updates = append(updates, extra...)
+		}
+		if change.PaymentStatus != "" && change.PaymentStatus != order.PaymentStatus {
+			if err := checkTransition(paymentTransitions, FieldPaymentStatus, change.Source, &order, order.PaymentStatus, change.PaymentStatus); err != nil {
+				return err
+			}
+			history = append(history, StatusChange{Field: FieldPaymentStatus, From: order.PaymentStatus, To: change.PaymentStatus})
+			order.PaymentStatus = change.PaymentStatus
+			updates = append(updates, firestore.Update{Path: FieldPaymentStatus, Value: order.PaymentStatus})
+		}
+		if change.Status != "" && change.Status != order.Status {
+			if err := checkTransition(orderTransitions, FieldStatus, change.Source, &order, order.Status, change.Status); err != nil {
+				return err
+			}
+			history = append(history, StatusChange{Field: FieldStatus, From: order.Status, To: change.Status})
+			order.Status = change.Status
+			updates = append(updates, firestore.Update{Path: FieldStatus, Value: order.Status})
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+
+		order.UpdatedAt = now
+		updates = append(updates, firestore.Update{Path: "updated_at", Value: now})
+		if err := tx.Update(ref, updates); err != nil {
+			return err
+		}
+		for _, entry := range history {
+			entry.Source = change.Source
+			entry.Reason = change.Reason
+			entry.ChangedAt = now
+			if err := tx.Create(ref.Collection(CollectionStatusHistory).NewDoc(), entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// transitionStatus es transitionOrder para los pasos y compensaciones del
+// workflow: una transición inválida no se arregla reintentando
+func (h *OrderHandler) transitionStatus(ctx context.Context, orderID string, change OrderTransition) error {
+	_, err := h.transitionOrder(ctx, orderID, change)
+	var invalid *InvalidTransitionError
+	if errors.As(err, &invalid) || errors.Is(err, errOrderNotFound) {
+		return permanent(err)
+	}
+	return err
+}
+
+// getOrderHistory devuelve el historial de estados de un pedido
+func (h *OrderHandler) getOrderHistory(ctx context.Context, w http.ResponseWriter, r *http.Request, orderID string) {
+	iter := h.firestoreClient.Collection(CollectionOrders).Doc(orderID).
+		Collection(CollectionStatusHistory).
+		OrderBy("changed_at", firestore.Asc).
+		Documents(ctx)
+	defer iter.Stop()
+
+	history := []StatusChange{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			h.errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error getting status history: %v", err))
+			return
+		}
+		var entry StatusChange
+		if err := doc.DataTo(&entry); err != nil {
+			h.errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error converting status change: %v", err))
+			return
+		}
+		history = append(history, entry)
+	}
+
+	response := OrderResponse{
+		Success: true,
+		Message: "Status history retrieved successfully",
+		Data:    history,
+	}
+
+	h.successResponse(w, response)
+}
@@ -0,0 +1,58 @@
+package orderprocessor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckTransition(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []transitionRule
+		field   string
+		source  string
+		order   Order
+		from    string
+		to      string
+		wantErr string // Vacío = permitida
+	}{
+		{"workflow starts processing", orderTransitions, FieldStatus, SourceWorkflow, Order{}, StatusPending, StatusProcessing, ""},
+		{"pending to delivered", orderTransitions, FieldStatus, SourceAPI, Order{}, StatusPending, StatusDelivered, "cannot change status from pending to delivered"},
+		{"cancelled to processing", orderTransitions, FieldStatus, SourceWorkflow, Order{}, StatusCancelled, StatusProcessing, "cannot change status from cancelled to processing"},
+		{"api cancels pending", orderTransitions, FieldStatus, SourceAPI, Order{}, StatusPending, StatusCancelled, ""},
+		{"api cannot mark paid", orderTransitions, FieldStatus, SourceAPI, Order{PaymentStatus: PaymentCompleted}, StatusProcessing, StatusPaid, "not allowed from api"},
+		{"paid needs completed payment", orderTransitions, FieldStatus, SourceWorkflow, Order{PaymentStatus: PaymentAuthorized}, StatusProcessing, StatusPaid, "payment is authorized"},
+		{"paid with completed payment", orderTransitions, FieldStatus, SourceWorkflow, Order{PaymentStatus: PaymentCompleted}, StatusProcessing, StatusPaid, ""},
+		{"ship needs full payment", orderTransitions, FieldStatus, SourceAPI, Order{Status: StatusPaid, PaymentStatus: PaymentPartiallyRefunded}, StatusPaid, StatusShipped, "order is not paid"},
+		{"ship paid order", orderTransitions, FieldStatus, SourceAPI, Order{Status: StatusPaid, PaymentStatus: PaymentCompleted}, StatusPaid, StatusShipped, ""},
+		{"refund needs refunded payment", orderTransitions, FieldStatus, SourceRefund, Order{PaymentStatus: PaymentPartiallyRefunded}, StatusDelivered, StatusRefunded, "payment is partially_refunded"},
+		{"api sets payment status", paymentTransitions, FieldPaymentStatus, SourceAPI, Order{}, PaymentAuthorized, PaymentCompleted, "not allowed from api"},
+		{"api refunds payment", paymentTransitions, FieldPaymentStatus, SourceAPI, Order{}, PaymentCompleted, PaymentRefunded, "not allowed from api"},
+		{"workflow captures payment", paymentTransitions, FieldPaymentStatus, SourceWorkflow, Order{}, PaymentAuthorized, PaymentCompleted, ""},
+		{"compensation voids authorization", paymentTransitions, FieldPaymentStatus, SourceCompensation, Order{}, PaymentAuthorized, PaymentVoided, ""},
+		{"refunded payment is final", paymentTransitions, FieldPaymentStatus, SourceRefund, Order{}, PaymentRefunded, PaymentCompleted, "cannot change payment_status from refunded to completed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkTransition(tt.rules, tt.field, tt.source, &tt.order, tt.from, tt.to)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var invalid *InvalidTransitionError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("error = %v, want InvalidTransitionError", err)
+			}
+			if invalid.Field != tt.field || invalid.From != tt.from || invalid.To != tt.to {
+				t.Fatalf("error describes %s %s -> %s", invalid.Field, invalid.From, invalid.To)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %q, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,575 @@
+package orderprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// El estado de cada workflow se guarda en Firestore después de cada paso. Antes
+// de ejecutarlo, el worker toma un lease: si la instancia se congela o muere a
+// mitad, el lease caduca y otro worker lo retoma desde el último paso completado.
+
+var (
+	errWorkflowLeased   = errors.New("workflow is being executed by another worker")
+	errWorkflowFinished = errors.New("workflow already finished")
+	errLeaseLost        = errors.New("workflow lease lost")
+	errWorkflowNotDue   = errors.New("workflow is waiting to retry a step")
+)
+
+// workflowStep -> Paso del workflow. Un paso puede repetirse (reintentos, o si
+// el worker muere antes de guardar el checkpoint), así que debe ser idempotente.
+type workflowStep struct {
+	Name     string
+	Run      func(h *OrderHandler, ctx context.Context, workflow *ProcessOrderWorkflow, order *Order) error
+	Retry    retryPolicy
+	Optional bool // Si agota los reintentos se salta en vez de compensar
+
+	// Deshace el paso si el workflow falla después (o en él)
+	CompensateAction string
+	Compensate       func(h *OrderHandler, ctx context.Context, workflow *ProcessOrderWorkflow, order *Order) error
+}
+
+var workflowSteps = []workflowStep{
+	{Name: StepValidateOrder, Run: (*OrderHandler).validateOrderStep, Retry: defaultRetry},
+	{Name: StepReserveInventory, Run: (*OrderHandler).reserveInventoryStep, Retry: defaultRetry,
+		CompensateAction: CompensationReleaseInventory, Compensate: (*OrderHandler).releaseInventoryCompensation},
+	{Name: StepProcessPayment, Run: (*OrderHandler).processPaymentStep, Retry: paymentRetry,
+		CompensateAction: CompensationRefundPayment, Compensate: (*OrderHandler).refundPaymentCompensation},
+	{Name: StepUpdateInventory, Run: (*OrderHandler).updateInventoryStep, Retry: defaultRetry},
+	{Name: StepSendNotifications, Run: (*OrderHandler).sendNotificationsStep, Retry: defaultRetry, Optional: true},
+	{Name: StepCompleteOrder, Run: (*OrderHandler).completeOrderStep, Retry: defaultRetry},
+}
+
+// workflowStepAt devuelve el paso con ese nombre y su posición
+func workflowStepAt(name string) (workflowStep, int, bool) {
+	for i, step := range workflowSteps {
+		if step.Name == name {
+			return step, i, true
+		}
+	}
+	return workflowStep{}, -1, false
+}
+
+func isNotFound(err error) bool {
+	return status.Code(err) == codes.NotFound
+}
+
+func (h *OrderHandler) workflowRef(orderID string) *firestore.DocumentRef {
+	return h.firestoreClient.Collection(CollectionWorkflows).Doc(orderID)
+}
+
+// newWorkflow crea el estado inicial de un pedido
+func newWorkflow(orderID string, now time.Time) ProcessOrderWorkflow {
+	return ProcessOrderWorkflow{
+		OrderID:        orderID,
+		Step:           workflowSteps[0].Name,
+		Status:         WorkflowPending,
+		CompletedSteps: []string{},
+		StartedAt:      now,
+		UpdatedAt:      now,
+	}
+}
+
+// fetchOrder lee un pedido; devuelve el error de Firestore tal cual (NotFound incluido)
+func (h *OrderHandler) fetchOrder(ctx context.Context, orderID string) (*Order, error) {
+	doc, err := h.firestoreClient.Collection(CollectionOrders).Doc(orderID).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var order Order
+	if err := doc.DataTo(&order); err != nil {
+		return nil, fmt.Errorf("converting order: %w", err)
+	}
+	order.ID = doc.Ref.ID
+	return &order, nil
+}
+
+// RunWorkflow ejecuta el workflow de un pedido desde el último paso completado.
+// Los reintentos que caben en WorkflowRunTimeout se esperan aquí; si no, el
+// workflow queda en retrying y lo retoma ResumeWorkflows a su hora. Si se agota
+// el tiempo a mitad de un paso, lo retoma cuando caduca el lease.
+// force ejecuta ya un reintento programado (reproceso manual).
+func (h *OrderHandler) RunWorkflow(ctx context.Context, orderID string, force bool) (*ProcessOrderWorkflow, error) {
+	ctx, cancel := context.WithTimeout(ctx, WorkflowRunTimeout)
+	defer cancel()
+
+	for {
+		owner := uuid.New().String()
+		workflow, err := h.acquireWorkflowLease(ctx, orderID, owner, force)
+		if err != nil {
+			return workflow, err
+		}
+
+		order, err := h.fetchOrder(ctx, orderID)
+		if err != nil {
+			if !isNotFound(err) {
+				return workflow, fmt.Errorf("getting order: %w", err)
+			}
+			workflow.Status = WorkflowFailed
+			workflow.ErrorMessage = "order not found"
+			return workflow, h.checkpointWorkflow(ctx, workflow, owner)
+		}
+
+		if err := h.executeWorkflow(ctx, workflow, order, owner); err != nil {
+			return workflow, err
+		}
+		if workflow.NextRetryAt == nil {
+			if workflow.Status == WorkflowCompleted {
+				log.Printf("✅ Order workflow completed successfully for: %s", orderID)
+			}
+			return workflow, nil
+		}
+
+		// Esperar el reintento aquí solo si da tiempo a ejecutarlo después
+		wait := time.Until(*workflow.NextRetryAt)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait+WorkflowMinStepTime).After(deadline) {
+			log.Printf("⏳ Workflow for %s will retry at %s", orderID, workflow.NextRetryAt.Format(time.RFC3339))
+			return workflow, nil
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return workflow, nil
+		}
+	}
+}
+
+// executeWorkflow avanza pasos (o compensaciones) guardando un checkpoint tras
+// cada uno, hasta terminar o hasta que haya que esperar un reintento
+func (h *OrderHandler) executeWorkflow(ctx context.Context, workflow *ProcessOrderWorkflow, order *Order, owner string) error {
+	for workflow.active() {
+		var err error
+		var policy retryPolicy
+		var name string
+		now := time.Now()
+
+		if workflow.Status == WorkflowRunning {
+			step, i, ok := workflowStepAt(workflow.Step)
+			if !ok {
+				step, i = workflowStep{Name: workflow.Step, Retry: noRetry}, -1
+				err = permanent(fmt.Errorf("unknown step %q", workflow.Step))
+			} else {
+				log.Printf("📋 Processing step: %s for order: %s", step.Name, order.ID)
+				err = step.Run(h, ctx, workflow, order)
+			}
+			name, policy = step.Name, step.Retry
+
+			if err != nil && step.Optional && !isPermanent(err) && workflow.RetryCount+1 >= policy.MaxAttempts {
+				// Paso opcional: agotados los reintentos no deshace el pedido
+				log.Printf("⚠️  Skipping optional step %s for order %s: %v", step.Name, order.ID, err)
+				err = nil
+			}
+			if err == nil {
+				workflow.CompletedSteps = append(workflow.CompletedSteps, step.Name)
+				workflow.RetryCount = 0
+				workflow.ErrorMessage = ""
+				if i+1 < len(workflowSteps) {
+					workflow.Step = workflowSteps[i+1].Name
+				} else {
+					workflow.Status = WorkflowCompleted
+					workflow.CompletedAt = &now
+				}
+			}
+		} else {
+			next, ok := nextCompensation(workflow)
+			if !ok {
+				workflow.Status = WorkflowFailed
+				workflow.CompletedAt = &now
+			} else {
+				log.Printf("↩️  Compensating %s (%s) for order: %s", next.Step, next.Action, order.ID)
+				err = next.Run(h, ctx, workflow, order)
+				name, policy = next.Action, compensationRetry
+				if err == nil {
+					workflow.Compensations = append(workflow.Compensations, CompensationRecord{
+						Step: next.Step, Action: next.Action, Status: CompensationCompleted,
+						Attempts: workflow.RetryCount + 1, ExecutedAt: now,
+					})
+					workflow.RetryCount = 0
+				}
+			}
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				// Sin tiempo: el lease caducará y otro worker repetirá el paso
+				return fmt.Errorf("%s interrupted: %w", name, err)
+			}
+			log.Printf("❌ %s failed for order %s (attempt %d): %v", name, order.ID, workflow.RetryCount+1, err)
+			h.handleStepFailure(workflow, name, policy, err, now)
+		}
+
+		if err := h.checkpointWorkflow(ctx, workflow, owner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleStepFailure programa un reintento o, si no quedan, pasa a la siguiente
+// fase: de running a compensating, o a la siguiente compensación
+func (h *OrderHandler) handleStepFailure(workflow *ProcessOrderWorkflow, name string, policy retryPolicy, err error, now time.Time) {
+	if !isPermanent(err) && workflow.RetryCount+1 < policy.MaxAttempts {
+		workflow.RetryCount++
+		retryAt := now.Add(policy.backoff(workflow.RetryCount))
+		workflow.NextRetryAt = &retryAt
+		if workflow.Status == WorkflowRunning {
+			workflow.Status = WorkflowRetrying
+			workflow.ErrorMessage = fmt.Sprintf("%s: %v", name, err)
+		}
+		return
+	}
+
+	if workflow.Status == WorkflowCompensating {
+		// Una compensación que no se pudo hacer queda registrada para revisión manual
+		workflow.Compensations = append(workflow.Compensations, CompensationRecord{
+			Step: workflow.FailedStep, Action: name, Status: CompensationFailed,
+			Attempts: workflow.RetryCount + 1, Error: err.Error(), ExecutedAt: now,
+		})
+	} else {
+		workflow.Status = WorkflowCompensating
+		workflow.FailedStep = name
+		workflow.ErrorMessage = fmt.Sprintf("%s: %v", name, err)
+	}
+	workflow.RetryCount = 0
+}
+
+// acquireWorkflowLease toma el lease del workflow; un workflow en retrying vuelve
+// a running. Los pedidos sin documento de workflow (anteriores a la persistencia)
+// lo crean.
+func (h *OrderHandler) acquireWorkflowLease(ctx context.Context, orderID, owner string, force bool) (*ProcessOrderWorkflow, error) {
+	ref := h.workflowRef(orderID)
+	var workflow ProcessOrderWorkflow
+
+	err := h.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		now := time.Now()
+		doc, err := tx.Get(ref)
+		switch {
+		case isNotFound(err):
+			workflow = newWorkflow(orderID, now)
+		case err != nil:
+			return err
+		default:
+			workflow = ProcessOrderWorkflow{}
+			if err := doc.DataTo(&workflow); err != nil {
+				return fmt.Errorf("converting workflow: %w", err)
+			}
+		}
+
+		switch {
+		case workflow.Status == WorkflowCompleted || workflow.Status == WorkflowFailed:
+			return errWorkflowFinished
+		case workflow.LeaseOwner != "" && workflow.LeaseExpiresAt != nil && now.Before(*workflow.LeaseExpiresAt):
+			return errWorkflowLeased
+		case workflow.NextRetryAt != nil && now.Before(*workflow.NextRetryAt) && !force:
+			return errWorkflowNotDue
+		}
+
+		expiresAt := now.Add(WorkflowLeaseDuration)
+		if workflow.Status != WorkflowCompensating {
+			workflow.Status = WorkflowRunning
+		}
+		workflow.NextRetryAt = nil
+		workflow.Executions++
+		workflow.LeaseOwner = owner
+		workflow.LeaseExpiresAt = &expiresAt
+		workflow.UpdatedAt = now
+		return tx.Set(ref, workflow)
+	})
+	if err != nil {
+		if errors.Is(err, errWorkflowFinished) || errors.Is(err, errWorkflowLeased) || errors.Is(err, errWorkflowNotDue) {
+			return &workflow, err
+		}
+		return nil, fmt.Errorf("acquiring workflow lease: %w", err)
+	}
+	return &workflow, nil
+}
+
+// checkpointWorkflow guarda el estado si el lease sigue siendo nuestro. Mientras
+// el workflow avanza renueva el lease; al terminar o quedar esperando un
+// reintento lo libera.
+func (h *OrderHandler) checkpointWorkflow(ctx context.Context, workflow *ProcessOrderWorkflow, owner string) error {
+	ref := h.workflowRef(workflow.OrderID)
+	return h.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		current, err := doc.DataAt("lease_owner")
+		if err != nil || current != owner {
+			return errLeaseLost
+		}
+
+		now := time.Now()
+		workflow.UpdatedAt = now
+		if workflow.active() {
+			expiresAt := now.Add(WorkflowLeaseDuration)
+			workflow.LeaseOwner = owner
+			workflow.LeaseExpiresAt = &expiresAt
+		} else {
+			workflow.LeaseOwner = ""
+			workflow.LeaseExpiresAt = nil
+		}
+		return tx.Set(ref, *workflow)
+	})
+}
+
+// ResumeWorkflows retoma los workflows pendientes, abandonados (lease caducado)
+// o con un reintento vencido publicando un comando process_order por cada uno:
+// los ejecutan en paralelo los consumidores de Pub/Sub, no esta invocación.
+// Lo invoca Cloud Scheduler; devuelve cuántos comandos se publicaron.
+func (h *OrderHandler) ResumeWorkflows(ctx context.Context) (int, error) {
+	iter := h.firestoreClient.Collection(CollectionWorkflows).
+		Where("status", "in", []string{WorkflowPending, WorkflowRunning, WorkflowRetrying, WorkflowCompensating}).
+		Documents(ctx)
+	defer iter.Stop()
+
+	resumed := 0
+	now := time.Now()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return resumed, fmt.Errorf("querying workflows: %w", err)
+		}
+
+		var workflow ProcessOrderWorkflow
+		if err := doc.DataTo(&workflow); err != nil {
+			log.Printf("Error converting workflow %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		if workflow.LeaseExpiresAt != nil && now.Before(*workflow.LeaseExpiresAt) {
+			continue
+		}
+		if workflow.NextRetryAt != nil && now.Before(*workflow.NextRetryAt) {
+			continue
+		}
+
+		// Si otro worker se adelanta, el consumidor recibe errWorkflowLeased o
+		// errWorkflowFinished y descarta el comando
+		if _, err := h.publishProcessCommand(ctx, doc.Ref.ID, false); err != nil {
+			log.Printf("Error resuming workflow %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		resumed++
+	}
+	return resumed, nil
+}
+
+// validateOrderStep vuelve a resolver los items contra el catálogo (precios,
+// disponibilidad y stock) y pasa el pedido a processing
+func (h *OrderHandler) validateOrderStep(ctx context.Context, workflow *ProcessOrderWorkflow, order *Order) error {
+	if order.Status == StatusCancelled {
+		return permanent(errors.New("order is cancelled"))
+	}
+	if len(order.Items) == 0 {
+		return permanent(errors.New("order has no items"))
+	}
+
+	items, err := h.resolveOrderItems(ctx, order.Items, order.Currency)
+	var invalid *OrderValidationError
+	switch {
+	case errors.As(err, &invalid):
+		return permanent(invalid)
+	case err != nil:
+		return fmt.Errorf("resolving items: %w", err) // Catálogo caído: se reintenta
+	}
+
+	order.Items = items
+	order.CalculateTotal()
+	return h.transitionStatus(ctx, order.ID, OrderTransition{
+		Status: StatusProcessing,
+		Source: SourceWorkflow,
+		Reason: "order validated",
+		Updates: []firestore.Update{
+			{Path: "items", Value: order.Items},
+			{Path: "total_amount", Value: order.TotalAmount},
+		},
+	})
+}
+
+// reserveInventoryStep reserva en AWS el stock de cada item. Los items ya
+// reservados en un intento anterior se saltan; si la respuesta se perdió, la
+// API reconoce el ID repetido y devuelve la reserva existente.
+func (h *OrderHandler) reserveInventoryStep(ctx context.Context, workflow *ProcessOrderWorkflow, order *Order) error {
+	for i, item := range order.Items {
+		id := reservationID(order.ID, i)
+		if status := reservationStatus(workflow, id); status == ReservationHeld || status == ReservationConsumed {
+			continue
+		}
+		reservation, err := h.inventory.Reserve(ctx, ReservationRequest{
+			ID:         id,
+			ProductID:  item.ProductID,
+			SKU:        item.SKU,
+			Quantity:   item.Quantity,
+			TTLSeconds: int(InventoryHoldTTL.Seconds()),
+			Reference:  order.ID,
+		})
+		recordInventoryCall(workflow, item, id, InventoryReserve, reservation, err)
+		switch {
+		case errors.Is(err, errInsufficientStock), errors.Is(err, errReservationRejected):
+			return permanent(fmt.Errorf("reserving %s: %w", item.SKU, err))
+		case err != nil:
+			return fmt.Errorf("reserving %s: %w", item.SKU, err)
+		}
+	}
+	return nil
+}
+
+// processPaymentStep autoriza y captura el importe del pedido. Cada fase queda
+// en workflow.PaymentResult y payment_status del pedido; repetir el paso sigue
+// desde la última, y las claves de idempotencia evitan un segundo cobro si se
+// perdió una respuesta. Un rechazo o una autenticación 3DS pendiente no se
+// reintentan: el pedido se compensa y el cliente puede volver a comprar.
+func (h *OrderHandler) processPaymentStep(ctx context.Context, workflow *ProcessOrderWorkflow, order *Order) error {
+	payment := workflow.PaymentResult
+	if payment == nil || (payment.Status != PaymentStateAuthorized && payment.Status != PaymentStateCaptured) {
+		result, err := h.payments.Authorize(ctx, PaymentRequest{
+			OrderID:        order.ID,
+			Amount:         order.TotalAmount,
+			Currency:       order.Currency,
+			PaymentMethod:  order.PaymentMethod,
+			PaymentToken:   order.PaymentToken,
+			CustomerEmail:  order.UserEmail,
+			IdempotencyKey: paymentKey(order.ID, "authorize"),
+		})
+		switch {
+		case errors.Is(err, errPaymentRejected):
+			return permanent(fmt.Errorf("authorizing payment: %w", err))
+		case err != nil:
+			return fmt.Errorf("authorizing payment: %w", err)
+		}
+		workflow.PaymentResult = result
+
+		switch result.Status {
+		case PaymentStateAuthorized, PaymentStateCaptured:
+			if err := h.setPaymentStatus(ctx, order.ID, PaymentAuthorized); err != nil {
+				return err
+			}
+		case PaymentStateRequiresAction:
+			if err := h.setPaymentStatus(ctx, order.ID, PaymentRequiresAction); err != nil {
+				return err
+			}
+			return permanent(errPaymentRequiresAction)
+		case PaymentStateDeclined:
+			if err := h.setPaymentStatus(ctx, order.ID, PaymentFailed); err != nil {
+				return err
+			}
+			return permanent(fmt.Errorf("%w: %s", errPaymentDeclined, result.DeclineCode))
+		default:
+			return permanent(fmt.Errorf("%w: unexpected authorization status %s", errPaymentRejected, result.Status))
+		}
+	}
+
+	if workflow.PaymentResult.Status != PaymentStateCaptured {
+		result, err := h.payments.Capture(ctx, workflow.PaymentResult.AuthorizationID, paymentKey(order.ID, "capture"))
+		switch {
+		case errors.Is(err, errPaymentRejected):
+			return permanent(fmt.Errorf("capturing payment: %w", err))
+		case err != nil:
+			return fmt.Errorf("capturing payment: %w", err)
+		case result.Status != PaymentStateCaptured:
+			return permanent(fmt.Errorf("%w: unexpected capture status %s", errPaymentRejected, result.Status))
+		}
+		workflow.PaymentResult = result
+	}
+	return h.setPaymentStatus(ctx, order.ID, PaymentCompleted)
+}
+
+// setPaymentStatus cambia payment_status del pedido desde el workflow
+func (h *OrderHandler) setPaymentStatus(ctx context.Context, orderID, paymentStatus string) error {
+	return h.transitionStatus(ctx, orderID, OrderTransition{
+		PaymentStatus: paymentStatus,
+		Source:        SourceWorkflow,
+	})
+}
+
+// updateInventoryStep consume las reservas tras el cobro. Un hold que caducó
+// (o que el TTL ya borró) no se puede recuperar: el pedido se compensa.
+func (h *OrderHandler) updateInventoryStep(ctx context.Context, workflow *ProcessOrderWorkflow, order *Order) error {
+	for i, item := range order.Items {
+		id := reservationID(order.ID, i)
+		if reservationStatus(workflow, id) == ReservationConsumed {
+			continue
+		}
+		reservation, err := h.inventory.Consume(ctx, id)
+		recordInventoryCall(workflow, item, id, InventoryConsume, reservation, err)
+		switch {
+		case errors.Is(err, errReservationExpired), errors.Is(err, errReservationNotFound), errors.Is(err, errReservationRejected):
+			return permanent(fmt.Errorf("consuming reservation of %s: %w", item.SKU, err))
+		case err != nil:
+			return fmt.Errorf("consuming reservation of %s: %w", item.SKU, err)
+		}
+	}
+	return nil
+}
+
+// sendNotificationsStep registra la confirmación al cliente (una sola vez)
+func (h *OrderHandler) sendNotificationsStep(ctx context.Context, workflow *ProcessOrderWorkflow, order *Order) error {
+	for _, sent := range workflow.NotificationsSent {
+		if sent.Template == "order_confirmed" {
+			return nil
+		}
+	}
+	workflow.NotificationsSent = append(workflow.NotificationsSent, NotificationPayload{
+		Type:      "email",
+		Recipient: order.UserEmail,
+		Template:  "order_confirmed",
+		Data: map[string]interface{}{
+			"order_id":     order.ID,
+			"total_amount": order.TotalAmount,
+			"currency":     order.Currency,
+		},
+		Priority: "normal",
+	})
+	return nil
+}
+
+// completeOrderStep pasa el pedido a paid (el pago ya quedó capturado). Si se
+// canceló mientras se procesaba, la transición falla y el workflow compensa.
+func (h *OrderHandler) completeOrderStep(ctx context.Context, workflow *ProcessOrderWorkflow, order *Order) error {
+	return h.transitionStatus(ctx, order.ID, OrderTransition{
+		Status:  StatusPaid,
+		Source:  SourceWorkflow,
+		Reason:  "order processed",
+		Updates: []firestore.Update{{Path: "processed_at", Value: time.Now()}},
+	})
+}
+
+// getOrderWorkflow devuelve el estado del workflow de un pedido
+func (h *OrderHandler) getOrderWorkflow(ctx context.Context, w http.ResponseWriter, r *http.Request, orderID string) {
+	doc, err := h.workflowRef(orderID).Get(ctx)
+	if err != nil {
+		if isNotFound(err) {
+			h.errorResponse(w, http.StatusNotFound, "Workflow not found")
+		} else {
+			h.errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error getting workflow: %v", err))
+		}
+		return
+	}
+
+	var workflow ProcessOrderWorkflow
+	if err := doc.DataTo(&workflow); err != nil {
+		h.errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Error converting workflow: %v", err))
+		return
+	}
+
+	response := OrderResponse{
+		Success: true,
+		Message: "Workflow retrieved successfully",
+		Data:    workflow,
+	}
+
+	h.successResponse(w, response)
+}
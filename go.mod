@@ -8,6 +8,7 @@ require (
 	github.com/aws/aws-sdk-go-v2 v1.21.0
 	github.com/aws/aws-sdk-go-v2/config v1.18.39
 	github.com/aws/aws-sdk-go-v2/service/dynamodb v1.21.5
+	github.com/aws/aws-sdk-go-v2/service/eventbridge v1.20.5
 	github.com/aws/aws-sdk-go-v2/service/s3 v1.38.5
 	github.com/aws/aws-sdk-go-v2/service/lambda v1.39.7
 	github.com/aws/aws-sdk-go-v2/service/cloudwatch v1.27.8
@@ -29,6 +30,7 @@ require (
 	// Configuration
 	github.com/spf13/viper v1.17.0
 	github.com/joho/godotenv v1.5.1
+	gopkg.in/yaml.v3 v3.0.1
 	
 	// Database
 	gorm.io/gorm v1.25.5
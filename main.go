@@ -1,12 +1,15 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"net/http"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -144,6 +147,10 @@ var (
 	currentMetrics = DashboardMetrics{}
 	activeAlerts   = []Alert{}
 	startTime      = time.Now()
+
+	// metricsMu protege currentMetrics frente a la simulación y los escenarios
+	metricsMu      sync.RWMutex
+	scenarioEngine *ScenarioEngine
 )
 
 func main() {
@@ -168,6 +175,8 @@ func main() {
 
 	// Inicializar datos de ejemplo
 	initializeMockData()
+	seed := simulationSeed()
+	scenarioEngine = NewScenarioEngine(realClock{}, rand.New(rand.NewSource(seed+1)))
 
 	// Rutas principales
 	setupRoutes(router)
@@ -175,8 +184,9 @@ func main() {
 	// Banner de inicio
 	printEnhancedBanner()
 
-	// Iniciar simulación de datos en tiempo real
-	go startDataSimulation()
+	// Iniciar simulación de datos en tiempo real (SIM_SEED la hace reproducible)
+	simulator := NewDataSimulator(rand.New(rand.NewSource(seed)), realClock{}, currentMetrics)
+	go simulator.Run(context.Background(), scenarioEngine)
 
 	// Obtener puerto
 	port := getPort()
@@ -224,6 +234,13 @@ func setupRoutes(router *gin.Engine) {
 		v1.POST("/simulate/load", simulateLoad)
 		v1.POST("/simulate/error", simulateError)
 		v1.POST("/simulate/alert", simulateAlert)
+
+		// Escenarios de caos
+		v1.GET("/scenarios", listScenarios)
+		v1.POST("/scenarios", startScenario)
+		v1.POST("/scenarios/plan", planScenario)
+		v1.GET("/scenarios/:id", getScenario)
+		v1.DELETE("/scenarios/:id", cancelScenario)
 	}
 
 	// API para el collector (puerto 8081)
@@ -252,7 +269,16 @@ func serveDashboard(c *gin.Context) {
 	c.File(dashboardPath)
 }
 
+// metricsSnapshot copia currentMetrics bajo el lock de lectura; los handlers
+// serializan la copia mientras la simulación sigue escribiendo
+func metricsSnapshot() DashboardMetrics {
+	metricsMu.RLock()
+	defer metricsMu.RUnlock()
+	return currentMetrics
+}
+
 func getDashboardData(c *gin.Context) {
+	metrics := metricsSnapshot()
 	dashboard := MultiCloudDashboard{
 		Status:    "operational",
 		Timestamp: time.Now(),
@@ -263,7 +289,7 @@ func getDashboardData(c *gin.Context) {
 			"gcp_firestore":   map[string]string{"status": "healthy", "region": "us-central1"},
 			"monitoring":      map[string]string{"status": "active", "collector": "running"},
 		},
-		Metrics: currentMetrics,
+		Metrics: metrics,
 		Alerts:  activeAlerts,
 		Version: "2.0.0",
 	}
@@ -294,35 +320,36 @@ func getHealthStatus(c *gin.Context) {
 }
 
 func getMetricsSnapshot(c *gin.Context) {
+	metrics := metricsSnapshot()
 	snapshot := map[string]interface{}{
 		"timestamp": time.Now(),
 		"aws_metrics": map[string]interface{}{
-			"lambda_invocations": currentMetrics.AWS.Lambda.Invocations,
-			"lambda_duration":    currentMetrics.AWS.Lambda.Duration,
-			"lambda_errors":      currentMetrics.AWS.Lambda.Errors,
-			"dynamodb_requests":  currentMetrics.AWS.DynamoDB.Requests,
-			"dynamodb_latency":   currentMetrics.AWS.DynamoDB.Latency,
+			"lambda_invocations": metrics.AWS.Lambda.Invocations,
+			"lambda_duration":    metrics.AWS.Lambda.Duration,
+			"lambda_errors":      metrics.AWS.Lambda.Errors,
+			"dynamodb_requests":  metrics.AWS.DynamoDB.Requests,
+			"dynamodb_latency":   metrics.AWS.DynamoDB.Latency,
 		},
 		"gcp_metrics": map[string]interface{}{
-			"function_invocations": currentMetrics.GCP.CloudFunctions.Invocations,
-			"function_duration":    currentMetrics.GCP.CloudFunctions.Duration,
-			"function_errors":      currentMetrics.GCP.CloudFunctions.Errors,
-			"firestore_reads":      currentMetrics.GCP.Firestore.Reads,
-			"firestore_writes":     currentMetrics.GCP.Firestore.Writes,
+			"function_invocations": metrics.GCP.CloudFunctions.Invocations,
+			"function_duration":    metrics.GCP.CloudFunctions.Duration,
+			"function_errors":      metrics.GCP.CloudFunctions.Errors,
+			"firestore_reads":      metrics.GCP.Firestore.Reads,
+			"firestore_writes":     metrics.GCP.Firestore.Writes,
 		},
 		"business_metrics": map[string]interface{}{
-			"total_products":       currentMetrics.Business.Products.Total,
-			"total_orders":         currentMetrics.Business.Orders.Total,
-			"total_revenue":        currentMetrics.Business.Revenue.Total,
-			"average_order_value":  currentMetrics.Business.Revenue.AverageOrder,
-			"error_rate":           currentMetrics.System.Performance.ErrorRate,
+			"total_products":       metrics.Business.Products.Total,
+			"total_orders":         metrics.Business.Orders.Total,
+			"total_revenue":        metrics.Business.Revenue.Total,
+			"average_order_value":  metrics.Business.Revenue.AverageOrder,
+			"error_rate":           metrics.System.Performance.ErrorRate,
 		},
 		"health_status": map[string]string{
-			"aws_lambda":      currentMetrics.System.Health.AWSLambda,
-			"aws_dynamodb":    currentMetrics.System.Health.AWSDynamoDB,
-			"gcp_functions":   currentMetrics.System.Health.GCPFunctions,
-			"gcp_firestore":   currentMetrics.System.Health.GCPFirestore,
-			"overall":         currentMetrics.System.Health.Overall,
+			"aws_lambda":      metrics.System.Health.AWSLambda,
+			"aws_dynamodb":    metrics.System.Health.AWSDynamoDB,
+			"gcp_functions":   metrics.System.Health.GCPFunctions,
+			"gcp_firestore":   metrics.System.Health.GCPFirestore,
+			"overall":         metrics.System.Health.Overall,
 		},
 	}
 
@@ -330,32 +357,36 @@ func getMetricsSnapshot(c *gin.Context) {
 }
 
 func getAWSMetrics(c *gin.Context) {
+	metrics := metricsSnapshot()
 	c.JSON(200, map[string]interface{}{
-		"lambda": currentMetrics.AWS.Lambda,
-		"dynamodb": currentMetrics.AWS.DynamoDB,
-		"costs": currentMetrics.AWS.Costs,
+		"lambda": metrics.AWS.Lambda,
+		"dynamodb": metrics.AWS.DynamoDB,
+		"costs": metrics.AWS.Costs,
 	})
 }
 
 func getGCPMetrics(c *gin.Context) {
+	metrics := metricsSnapshot()
 	c.JSON(200, map[string]interface{}{
-		"cloud_functions": currentMetrics.GCP.CloudFunctions,
-		"firestore": currentMetrics.GCP.Firestore,
-		"costs": currentMetrics.GCP.Costs,
+		"cloud_functions": metrics.GCP.CloudFunctions,
+		"firestore": metrics.GCP.Firestore,
+		"costs": metrics.GCP.Costs,
 	})
 }
 
 func getBusinessMetrics(c *gin.Context) {
+	metrics := metricsSnapshot()
 	c.JSON(200, map[string]interface{}{
-		"products": currentMetrics.Business.Products,
-		"orders": currentMetrics.Business.Orders,
-		"revenue": currentMetrics.Business.Revenue,
-		"performance": currentMetrics.System.Performance,
+		"products": metrics.Business.Products,
+		"orders": metrics.Business.Orders,
+		"revenue": metrics.Business.Revenue,
+		"performance": metrics.System.Performance,
 	})
 }
 
 func getSystemMetrics(c *gin.Context) {
-	c.JSON(200, currentMetrics.System)
+	metrics := metricsSnapshot()
+	c.JSON(200, metrics.System)
 }
 
 func getAlerts(c *gin.Context) {
@@ -389,24 +420,35 @@ func acknowledgeAlert(c *gin.Context) {
 
 // Simulación de carga y errores
 func simulateLoad(c *gin.Context) {
-	// Simular aumento de tráfico
-	currentMetrics.AWS.Lambda.Invocations += 50
-	currentMetrics.GCP.CloudFunctions.Invocations += 30
-	currentMetrics.System.Performance.RequestsToday += 80
-	
+	// Simular aumento de tráfico durante 30 segundos
+	run, err := scenarioEngine.Start(loadSpikeScenario(), true)
+	if err != nil {
+		c.JSON(500, map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
 	c.JSON(200, map[string]interface{}{
-		"success": true,
-		"message": "Load simulation started",
-		"duration": "30 seconds",
+		"success":     true,
+		"message":     "Load simulation started",
+		"duration":    "30 seconds",
+		"scenario_id": run.ID,
 	})
 }
 
 func simulateError(c *gin.Context) {
-	// Simular errores
-	currentMetrics.AWS.Lambda.Errors += 5
-	currentMetrics.GCP.CloudFunctions.Errors += 3
-	currentMetrics.System.Performance.ErrorRate += 1.0
-	
+	// Simular errores durante 30 segundos
+	run, err := scenarioEngine.Start(errorSpikeScenario(), true)
+	if err != nil {
+		c.JSON(500, map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
 	// Crear alerta
 	alert := Alert{
 		ID:        fmt.Sprintf("alert_%d", time.Now().Unix()),
@@ -424,9 +466,122 @@ func simulateError(c *gin.Context) {
 		"success": true,
 		"message": "Error simulation started",
 		"alert_created": alert.ID,
+		"duration": "30 seconds",
+		"scenario_id": run.ID,
+	})
+}
+
+// Escenarios de caos
+func listScenarios(c *gin.Context) {
+	runs := scenarioEngine.List()
+	c.JSON(200, map[string]interface{}{
+		"scenarios": runs,
+		"total":     len(runs),
+	})
+}
+
+func startScenario(c *gin.Context) {
+	scenario, ok := bindScenario(c)
+	if !ok {
+		return
+	}
+
+	run, err := scenarioEngine.Start(scenario, c.Query("preempt") == "true")
+	if err != nil {
+		status := 400
+		if errors.Is(err, ErrScenarioActive) {
+			status = 409
+		}
+		c.JSON(status, map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c.JSON(202, map[string]interface{}{
+		"success":  true,
+		"message":  "Scenario started",
+		"scenario": run,
+	})
+}
+
+// planScenario calcula la línea de tiempo sin tocar las métricas (dry run)
+func planScenario(c *gin.Context) {
+	scenario, ok := bindScenario(c)
+	if !ok {
+		return
+	}
+
+	baseline := scenario.liveBaseline()
+
+	c.JSON(200, map[string]interface{}{
+		"success":  true,
+		"scenario": scenario,
+		"baseline": baseline,
+		"samples":  scenario.Plan(baseline),
+	})
+}
+
+func getScenario(c *gin.Context) {
+	run, ok := scenarioEngine.Get(c.Param("id"), c.Query("samples") != "false")
+	if !ok {
+		c.JSON(404, map[string]interface{}{
+			"success": false,
+			"message": "Scenario not found",
+		})
+		return
+	}
+
+	c.JSON(200, run)
+}
+
+func cancelScenario(c *gin.Context) {
+	run, err := scenarioEngine.Cancel(c.Param("id"))
+	switch {
+	case errors.Is(err, ErrScenarioNotFound):
+		c.JSON(404, map[string]interface{}{
+			"success": false,
+			"message": "Scenario not found",
+		})
+		return
+	case errors.Is(err, ErrScenarioFinished):
+		c.JSON(409, map[string]interface{}{
+			"success":  false,
+			"message":  fmt.Sprintf("Scenario already %s", run.Status),
+			"scenario": run,
+		})
+		return
+	}
+
+	c.JSON(200, map[string]interface{}{
+		"success":  true,
+		"message":  "Scenario cancelled",
+		"scenario": run,
 	})
 }
 
+func bindScenario(c *gin.Context) (Scenario, bool) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(400, map[string]interface{}{
+			"success": false,
+			"error":   "Invalid request body",
+		})
+		return Scenario{}, false
+	}
+
+	scenario, err := ParseScenario(body, c.ContentType())
+	if err != nil {
+		c.JSON(400, map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return Scenario{}, false
+	}
+	return scenario, true
+}
+
 func simulateAlert(c *gin.Context) {
 	var request struct {
 		Message  string `json:"message"`
@@ -546,26 +701,6 @@ func initializeMockData() {
 	}
 }
 
-func startDataSimulation() {
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		// Simular variaciones en los datos
-		currentMetrics.AWS.Lambda.Invocations += randomInt(-5, 15)
-		currentMetrics.GCP.CloudFunctions.Invocations += randomInt(-3, 10)
-		currentMetrics.Business.Orders.Today += randomInt(0, 3)
-		currentMetrics.System.Performance.RequestsToday += randomInt(1, 20)
-		
-		// Limpiar alertas muy antiguas
-		cleanOldAlerts()
-	}
-}
-
-func randomInt(min, max int) int {
-	return min + int(time.Now().UnixNano()%int64(max-min+1))
-}
-
 func countUnacknowledgedAlerts() int {
 	count := 0
 	for _, alert := range activeAlerts {
@@ -0,0 +1,707 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Estados de una ejecución de escenario
+const (
+	ScenarioRunning   = "running"
+	ScenarioCompleted = "completed"
+	ScenarioCancelled = "cancelled"
+	ScenarioPreempted = "preempted"
+
+	defaultScenarioTick = time.Second
+	minScenarioTick     = 100 * time.Millisecond
+	maxScenarioTicks    = 10000
+)
+
+// Duration acepta "2m", "30s" o nanosegundos tanto en JSON como en YAML
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	return d.set(raw)
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var raw interface{}
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	return d.set(raw)
+}
+
+func (d *Duration) set(raw interface{}) error {
+	switch v := raw.(type) {
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", v, err)
+		}
+		*d = Duration(parsed)
+	case float64:
+		*d = Duration(time.Duration(v))
+	case int:
+		*d = Duration(time.Duration(v))
+	default:
+		return fmt.Errorf("invalid duration %v", raw)
+	}
+	return nil
+}
+
+// Scenario describe una línea de tiempo de caos sobre DashboardMetrics
+type Scenario struct {
+	Name        string             `json:"name" yaml:"name"`
+	Description string             `json:"description,omitempty" yaml:"description,omitempty"`
+	Seed        *int64             `json:"seed,omitempty" yaml:"seed,omitempty"` // Sin semilla Start elige una; 0 es una semilla válida
+	Tick        Duration           `json:"tick" yaml:"tick"`
+	Baseline    map[string]float64 `json:"baseline,omitempty" yaml:"baseline,omitempty"` // Fija el punto de partida para resultados reproducibles
+	Steps       []ScenarioStep     `json:"steps" yaml:"steps"`
+}
+
+// ScenarioStep es un tramo de la línea de tiempo
+type ScenarioStep struct {
+	Name     string           `json:"name" yaml:"name"`
+	Duration Duration         `json:"duration" yaml:"duration"`
+	Effects  []ScenarioEffect `json:"effects,omitempty" yaml:"effects,omitempty"`
+	Recover  bool             `json:"recover,omitempty" yaml:"recover,omitempty"` // Vuelve todas las métricas tocadas a su baseline
+}
+
+// ScenarioEffect lleva una métrica a un valor absoluto o a un múltiplo de su baseline
+type ScenarioEffect struct {
+	Metric string   `json:"metric" yaml:"metric"`
+	Factor float64  `json:"factor,omitempty" yaml:"factor,omitempty"`
+	Value  *float64 `json:"value,omitempty" yaml:"value,omitempty"`
+	Ramp   bool     `json:"ramp,omitempty" yaml:"ramp,omitempty"`     // Interpola linealmente durante el paso
+	Jitter float64  `json:"jitter,omitempty" yaml:"jitter,omitempty"` // Ruido relativo por tick (0.05 = ±5%)
+}
+
+// ScenarioSample valores aplicados en un tick
+type ScenarioSample struct {
+	Tick   int                `json:"tick"`
+	Offset Duration           `json:"offset"`
+	Step   string             `json:"step"`
+	Values map[string]float64 `json:"values"`
+}
+
+// ScenarioRun estado y resultados de una ejecución
+type ScenarioRun struct {
+	ID          string             `json:"id"`
+	Scenario    Scenario           `json:"scenario"`
+	Status      string             `json:"status"`
+	CurrentStep string             `json:"current_step"`
+	TicksDone   int                `json:"ticks_done"`
+	TotalTicks  int                `json:"total_ticks"`
+	Progress    float64            `json:"progress"`
+	Baseline    map[string]float64 `json:"baseline"`
+	StartedAt   time.Time          `json:"started_at"`
+	FinishedAt  *time.Time         `json:"finished_at,omitempty"`
+	Samples     []ScenarioSample   `json:"samples,omitempty"`
+
+	plan   []ScenarioSample
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// scenarioMetric accede a un campo de DashboardMetrics por nombre
+type scenarioMetric struct {
+	get func(m *DashboardMetrics) float64
+	set func(m *DashboardMetrics, v float64)
+}
+
+var scenarioMetrics = map[string]scenarioMetric{
+	"aws.lambda.invocations": {
+		get: func(m *DashboardMetrics) float64 { return float64(m.AWS.Lambda.Invocations) },
+		set: func(m *DashboardMetrics, v float64) { m.AWS.Lambda.Invocations = int(math.Round(v)) },
+	},
+	"aws.lambda.duration": {
+		get: func(m *DashboardMetrics) float64 { return m.AWS.Lambda.Duration },
+		set: func(m *DashboardMetrics, v float64) { m.AWS.Lambda.Duration = v },
+	},
+	"aws.lambda.error_rate": {
+		get: func(m *DashboardMetrics) float64 { return 100 - m.AWS.Lambda.SuccessRate },
+		set: func(m *DashboardMetrics, v float64) {
+			v = math.Min(v, 100)
+			m.AWS.Lambda.SuccessRate = 100 - v
+			m.AWS.Lambda.Errors = int(math.Round(float64(m.AWS.Lambda.Invocations) * v / 100))
+		},
+	},
+	"aws.dynamodb.requests": {
+		get: func(m *DashboardMetrics) float64 { return float64(m.AWS.DynamoDB.Requests) },
+		set: func(m *DashboardMetrics, v float64) { m.AWS.DynamoDB.Requests = int(math.Round(v)) },
+	},
+	"aws.dynamodb.latency": {
+		get: func(m *DashboardMetrics) float64 { return m.AWS.DynamoDB.Latency },
+		set: func(m *DashboardMetrics, v float64) { m.AWS.DynamoDB.Latency = v },
+	},
+	"gcp.functions.invocations": {
+		get: func(m *DashboardMetrics) float64 { return float64(m.GCP.CloudFunctions.Invocations) },
+		set: func(m *DashboardMetrics, v float64) { m.GCP.CloudFunctions.Invocations = int(math.Round(v)) },
+	},
+	"gcp.functions.duration": {
+		get: func(m *DashboardMetrics) float64 { return m.GCP.CloudFunctions.Duration },
+		set: func(m *DashboardMetrics, v float64) { m.GCP.CloudFunctions.Duration = v },
+	},
+	"gcp.functions.error_rate": {
+		get: func(m *DashboardMetrics) float64 { return 100 - m.GCP.CloudFunctions.SuccessRate },
+		set: func(m *DashboardMetrics, v float64) {
+			v = math.Min(v, 100)
+			m.GCP.CloudFunctions.SuccessRate = 100 - v
+			m.GCP.CloudFunctions.Errors = int(math.Round(float64(m.GCP.CloudFunctions.Invocations) * v / 100))
+		},
+	},
+	"gcp.firestore.reads": {
+		get: func(m *DashboardMetrics) float64 { return float64(m.GCP.Firestore.Reads) },
+		set: func(m *DashboardMetrics, v float64) { m.GCP.Firestore.Reads = int(math.Round(v)) },
+	},
+	"gcp.firestore.writes": {
+		get: func(m *DashboardMetrics) float64 { return float64(m.GCP.Firestore.Writes) },
+		set: func(m *DashboardMetrics, v float64) { m.GCP.Firestore.Writes = int(math.Round(v)) },
+	},
+	"gcp.firestore.latency": {
+		get: func(m *DashboardMetrics) float64 { return m.GCP.Firestore.Latency },
+		set: func(m *DashboardMetrics, v float64) { m.GCP.Firestore.Latency = v },
+	},
+	"system.response_time": {
+		get: func(m *DashboardMetrics) float64 { return float64(m.System.Performance.ResponseTime) },
+		set: func(m *DashboardMetrics, v float64) { m.System.Performance.ResponseTime = int(math.Round(v)) },
+	},
+	"system.error_rate": {
+		get: func(m *DashboardMetrics) float64 { return m.System.Performance.ErrorRate },
+		set: func(m *DashboardMetrics, v float64) { m.System.Performance.ErrorRate = v },
+	},
+	"system.requests_today": {
+		get: func(m *DashboardMetrics) float64 { return float64(m.System.Performance.RequestsToday) },
+		set: func(m *DashboardMetrics, v float64) { m.System.Performance.RequestsToday = int(math.Round(v)) },
+	},
+	"business.orders.today": {
+		get: func(m *DashboardMetrics) float64 { return float64(m.Business.Orders.Today) },
+		set: func(m *DashboardMetrics, v float64) { m.Business.Orders.Today = int(math.Round(v)) },
+	},
+}
+
+// ParseScenario decodifica un escenario en JSON o YAML según el content type
+func ParseScenario(data []byte, contentType string) (Scenario, error) {
+	var scenario Scenario
+	var err error
+	if strings.Contains(contentType, "yaml") {
+		err = yaml.Unmarshal(data, &scenario)
+	} else {
+		err = json.Unmarshal(data, &scenario)
+	}
+	if err != nil {
+		return scenario, fmt.Errorf("invalid scenario: %w", err)
+	}
+	return scenario, scenario.Validate()
+}
+
+// Validate aplica valores por defecto y verifica la línea de tiempo
+func (s *Scenario) Validate() error {
+	if s.Name == "" {
+		s.Name = "unnamed"
+	}
+	if s.Tick == 0 {
+		s.Tick = Duration(defaultScenarioTick)
+	}
+	if s.Tick.Duration() < minScenarioTick {
+		return fmt.Errorf("tick must be at least %s", minScenarioTick)
+	}
+	if len(s.Steps) == 0 {
+		return errors.New("scenario must have at least one step")
+	}
+	for name := range s.Baseline {
+		if _, ok := scenarioMetrics[name]; !ok {
+			return fmt.Errorf("baseline: unknown metric %q", name)
+		}
+	}
+
+	totalTicks := 0
+	for i := range s.Steps {
+		step := &s.Steps[i]
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step-%d", i+1)
+		}
+		if step.Duration <= 0 {
+			return fmt.Errorf("%s: duration must be positive", step.Name)
+		}
+		for _, effect := range step.Effects {
+			if _, ok := scenarioMetrics[effect.Metric]; !ok {
+				return fmt.Errorf("%s: unknown metric %q", step.Name, effect.Metric)
+			}
+			if effect.Value == nil && effect.Factor <= 0 {
+				return fmt.Errorf("%s: %s needs a value or a positive factor", step.Name, effect.Metric)
+			}
+			if effect.Value != nil && *effect.Value < 0 {
+				return fmt.Errorf("%s: %s value cannot be negative", step.Name, effect.Metric)
+			}
+			if effect.Jitter < 0 || effect.Jitter > 1 {
+				return fmt.Errorf("%s: %s jitter must be between 0 and 1", step.Name, effect.Metric)
+			}
+		}
+		totalTicks += s.stepTicks(*step)
+	}
+	if totalTicks > maxScenarioTicks {
+		return fmt.Errorf("scenario is too long: %d ticks (max %d)", totalTicks, maxScenarioTicks)
+	}
+	return nil
+}
+
+func (s *Scenario) stepTicks(step ScenarioStep) int {
+	n := int(math.Ceil(float64(step.Duration) / float64(s.Tick)))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// metricNames devuelve las métricas que toca el escenario, ordenadas
+func (s *Scenario) metricNames() []string {
+	seen := map[string]bool{}
+	for name := range s.Baseline {
+		seen[name] = true
+	}
+	for _, step := range s.Steps {
+		for _, effect := range step.Effects {
+			seen[effect.Metric] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// liveBaseline toma el punto de partida de currentMetrics salvo lo fijado en Baseline
+func (s *Scenario) liveBaseline() map[string]float64 {
+	baseline := make(map[string]float64)
+	metricsMu.RLock()
+	defer metricsMu.RUnlock()
+	for _, name := range s.metricNames() {
+		if v, ok := s.Baseline[name]; ok {
+			baseline[name] = v
+		} else {
+			baseline[name] = scenarioMetrics[name].get(&currentMetrics)
+		}
+	}
+	return baseline
+}
+
+// applyScenarioValues escribe los valores en currentMetrics
+func applyScenarioValues(values map[string]float64) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	setScenarioValues(&currentMetrics, values)
+}
+
+// setScenarioValues escribe los valores por nombre. Las tasas de error van al
+// final porque derivan el número de errores de las invocaciones.
+func setScenarioValues(metrics *DashboardMetrics, values map[string]float64) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		ri, rj := strings.HasSuffix(names[i], "error_rate"), strings.HasSuffix(names[j], "error_rate")
+		if ri != rj {
+			return rj
+		}
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+		scenarioMetrics[name].set(metrics, values[name])
+	}
+}
+
+type scenarioTarget struct {
+	to     float64
+	ramp   bool
+	jitter float64
+}
+
+// seed devuelve la semilla del escenario; sin semilla el plan usa 0
+func (s *Scenario) seed() int64 {
+	if s.Seed == nil {
+		return 0
+	}
+	return *s.Seed
+}
+
+// Plan calcula todos los ticks del escenario. Con la misma semilla y el mismo
+// baseline el resultado es idéntico, así que sirve también como dry run.
+func (s *Scenario) Plan(baseline map[string]float64) []ScenarioSample {
+	rng := rand.New(rand.NewSource(s.seed()))
+	names := s.metricNames()
+
+	current := make(map[string]float64, len(names))
+	for _, name := range names {
+		current[name] = baseline[name]
+	}
+
+	var samples []ScenarioSample
+	tick := 0
+	for _, step := range s.Steps {
+		from := make(map[string]float64, len(current))
+		for name, v := range current {
+			from[name] = v
+		}
+
+		targets := map[string]scenarioTarget{}
+		if step.Recover {
+			for _, name := range names {
+				targets[name] = scenarioTarget{to: baseline[name], ramp: true}
+			}
+		}
+		for _, effect := range step.Effects {
+			to := baseline[effect.Metric] * effect.Factor
+			if effect.Value != nil {
+				to = *effect.Value
+			}
+			targets[effect.Metric] = scenarioTarget{to: to, ramp: effect.Ramp, jitter: effect.Jitter}
+		}
+
+		n := s.stepTicks(step)
+		for i := 0; i < n; i++ {
+			progress := float64(i+1) / float64(n)
+			values := make(map[string]float64, len(names))
+			for _, name := range names {
+				v := current[name]
+				if t, ok := targets[name]; ok {
+					v = t.to
+					if t.ramp {
+						v = from[name] + (t.to-from[name])*progress
+					}
+					if t.jitter > 0 {
+						v *= 1 + t.jitter*(2*rng.Float64()-1)
+					}
+				}
+				values[name] = math.Max(v, 0)
+			}
+			tick++
+			samples = append(samples, ScenarioSample{
+				Tick:   tick,
+				Offset: Duration(time.Duration(tick) * s.Tick.Duration()),
+				Step:   step.Name,
+				Values: values,
+			})
+		}
+
+		// El valor final del paso (sin ruido) es el punto de partida del siguiente
+		for name, t := range targets {
+			current[name] = t.to
+		}
+	}
+	return samples
+}
+
+// ScenarioEngine ejecuta escenarios de uno en uno sobre currentMetrics
+type ScenarioEngine struct {
+	mu     sync.Mutex
+	clock  Clock
+	seeds  *rand.Rand // Semillas para escenarios que no fijan la suya
+	runs   map[string]*ScenarioRun
+	order  []string
+	active *ScenarioRun
+}
+
+// NewScenarioEngine crea un motor sin ejecuciones
+func NewScenarioEngine(clock Clock, seeds *rand.Rand) *ScenarioEngine {
+	return &ScenarioEngine{
+		clock: clock,
+		seeds: seeds,
+		runs:  make(map[string]*ScenarioRun),
+	}
+}
+
+// ErrScenarioActive se devuelve si ya hay un escenario corriendo
+var ErrScenarioActive = errors.New("another scenario is already running")
+
+// Start lanza un escenario. Con preempt cancela el escenario activo en lugar de fallar.
+func (e *ScenarioEngine) Start(scenario Scenario, preempt bool) (ScenarioRun, error) {
+	if err := scenario.Validate(); err != nil {
+		return ScenarioRun{}, err
+	}
+
+	e.mu.Lock()
+	active := e.active
+	e.mu.Unlock()
+	if active != nil {
+		if !preempt {
+			return ScenarioRun{}, ErrScenarioActive
+		}
+		e.stop(active, ScenarioPreempted)
+	}
+
+	if scenario.Seed == nil {
+		e.mu.Lock()
+		seed := e.seeds.Int63()
+		e.mu.Unlock()
+		scenario.Seed = &seed
+	}
+
+	baseline := scenario.liveBaseline()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &ScenarioRun{
+		ID:        fmt.Sprintf("scenario_%d", e.clock.Now().UnixNano()),
+		Scenario:  scenario,
+		Status:    ScenarioRunning,
+		Baseline:  baseline,
+		StartedAt: e.clock.Now(),
+		plan:      scenario.Plan(baseline),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	run.TotalTicks = len(run.plan)
+
+	e.mu.Lock()
+	if e.active != nil {
+		e.mu.Unlock()
+		cancel()
+		return ScenarioRun{}, ErrScenarioActive
+	}
+	// Dos arranques en el mismo instante (relojes de baja resolución) no comparten ID
+	if e.runs[run.ID] != nil {
+		run.ID = fmt.Sprintf("%s_%d", run.ID, len(e.order))
+	}
+	e.runs[run.ID] = run
+	e.order = append(e.order, run.ID)
+	e.active = run
+	e.mu.Unlock()
+
+	go e.execute(ctx, run)
+
+	log.Printf("🎬 Scenario %s started: %s (%d ticks, seed %d)", run.ID, scenario.Name, run.TotalTicks, *scenario.Seed)
+	return e.snapshot(run, false), nil
+}
+
+// Errores de Cancel
+var (
+	ErrScenarioNotFound = errors.New("scenario not found")
+	ErrScenarioFinished = errors.New("scenario already finished")
+)
+
+// Cancel detiene un escenario en curso y restaura su baseline. Una ejecución ya
+// terminada (completada, cancelada o desplazada) devuelve ErrScenarioFinished.
+func (e *ScenarioEngine) Cancel(id string) (ScenarioRun, error) {
+	e.mu.Lock()
+	run, ok := e.runs[id]
+	e.mu.Unlock()
+	if !ok {
+		return ScenarioRun{}, ErrScenarioNotFound
+	}
+	if !e.stop(run, ScenarioCancelled) {
+		return e.snapshot(run, false), ErrScenarioFinished
+	}
+	return e.snapshot(run, false), nil
+}
+
+// Get devuelve el progreso y, opcionalmente, los ticks aplicados
+func (e *ScenarioEngine) Get(id string, withSamples bool) (ScenarioRun, bool) {
+	e.mu.Lock()
+	run, ok := e.runs[id]
+	e.mu.Unlock()
+	if !ok {
+		return ScenarioRun{}, false
+	}
+	return e.snapshot(run, withSamples), true
+}
+
+// DrivenMetrics devuelve las métricas que controla el escenario activo
+func (e *ScenarioEngine) DrivenMetrics() map[string]bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	driven := map[string]bool{}
+	if e.active == nil {
+		return driven
+	}
+	for _, name := range e.active.Scenario.metricNames() {
+		driven[name] = true
+	}
+	return driven
+}
+
+// List devuelve todas las ejecuciones, la más reciente primero
+func (e *ScenarioEngine) List() []ScenarioRun {
+	e.mu.Lock()
+	ids := append([]string(nil), e.order...)
+	e.mu.Unlock()
+
+	runs := make([]ScenarioRun, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		if run, ok := e.Get(ids[i], false); ok {
+			runs = append(runs, run)
+		}
+	}
+	return runs
+}
+
+// stop marca la ejecución con status y espera a que restaure su baseline.
+// Devuelve false si ya había terminado.
+func (e *ScenarioEngine) stop(run *ScenarioRun, status string) bool {
+	e.mu.Lock()
+	if run.Status != ScenarioRunning {
+		e.mu.Unlock()
+		return false
+	}
+	run.Status = status
+	e.mu.Unlock()
+
+	run.cancel()
+	<-run.done
+	return true
+}
+
+func (e *ScenarioEngine) execute(ctx context.Context, run *ScenarioRun) {
+	defer close(run.done)
+
+	ticks, stop := e.clock.Ticker(run.Scenario.Tick.Duration())
+	defer stop()
+
+	for _, sample := range run.plan {
+		select {
+		case <-ctx.Done():
+			e.restore(run)
+			e.finish(run, "")
+			log.Printf("🛑 Scenario %s stopped at tick %d/%d", run.ID, sample.Tick-1, run.TotalTicks)
+			return
+		case <-ticks:
+		}
+
+		applyScenarioValues(sample.Values)
+
+		e.mu.Lock()
+		run.TicksDone = sample.Tick
+		run.CurrentStep = sample.Step
+		run.Progress = math.Round(float64(sample.Tick)/float64(run.TotalTicks)*1000) / 10
+		run.Samples = append(run.Samples, sample)
+		e.mu.Unlock()
+	}
+
+	// Un stop que llega tras el último tick gana: se restaura como si no hubiera acabado
+	if !e.complete(run) {
+		e.restore(run)
+		e.finish(run, "")
+		log.Printf("🛑 Scenario %s stopped after its last tick", run.ID)
+		return
+	}
+	log.Printf("✅ Scenario %s completed", run.ID)
+}
+
+// complete marca la ejecución como completada si nadie la detuvo antes
+func (e *ScenarioEngine) complete(run *ScenarioRun) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if run.Status != ScenarioRunning {
+		return false
+	}
+	run.Status = ScenarioCompleted
+	e.finishLocked(run)
+	return true
+}
+
+// restore devuelve las métricas tocadas a su baseline al cancelar
+func (e *ScenarioEngine) restore(run *ScenarioRun) {
+	applyScenarioValues(run.Baseline)
+}
+
+func (e *ScenarioEngine) finish(run *ScenarioRun, status string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if status != "" {
+		run.Status = status
+	}
+	e.finishLocked(run)
+}
+
+func (e *ScenarioEngine) finishLocked(run *ScenarioRun) {
+	finishedAt := e.clock.Now()
+	run.FinishedAt = &finishedAt
+	if e.active == run {
+		e.active = nil
+	}
+}
+
+func (e *ScenarioEngine) snapshot(run *ScenarioRun, withSamples bool) ScenarioRun {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	copied := *run
+	copied.Samples = nil
+	if withSamples {
+		copied.Samples = append([]ScenarioSample(nil), run.Samples...)
+	}
+	copied.plan = nil
+	copied.cancel = nil
+	copied.done = nil
+	return copied
+}
+
+// loadSpikeScenario reemplaza el antiguo incremento puntual de /simulate/load
+func loadSpikeScenario() Scenario {
+	return Scenario{
+		Name: "load-spike",
+		Tick: Duration(time.Second),
+		Steps: []ScenarioStep{
+			{Name: "ramp-up", Duration: Duration(10 * time.Second), Effects: []ScenarioEffect{
+				{Metric: "aws.lambda.invocations", Factor: 1.5, Ramp: true, Jitter: 0.05},
+				{Metric: "gcp.functions.invocations", Factor: 1.4, Ramp: true, Jitter: 0.05},
+				{Metric: "aws.dynamodb.requests", Factor: 1.5, Ramp: true},
+				{Metric: "system.response_time", Factor: 1.3, Ramp: true, Jitter: 0.1},
+			}},
+			{Name: "sustained", Duration: Duration(15 * time.Second), Effects: []ScenarioEffect{
+				{Metric: "aws.lambda.invocations", Factor: 1.5, Jitter: 0.05},
+				{Metric: "gcp.functions.invocations", Factor: 1.4, Jitter: 0.05},
+				{Metric: "system.response_time", Factor: 1.3, Jitter: 0.1},
+			}},
+			{Name: "recover", Duration: Duration(5 * time.Second), Recover: true},
+		},
+	}
+}
+
+// errorSpikeScenario reemplaza el antiguo incremento puntual de /simulate/error
+func errorSpikeScenario() Scenario {
+	lambdaRate, gcpRate := 6.0, 5.0
+	return Scenario{
+		Name: "error-spike",
+		Tick: Duration(time.Second),
+		Steps: []ScenarioStep{
+			{Name: "degrade", Duration: Duration(5 * time.Second), Effects: []ScenarioEffect{
+				{Metric: "aws.lambda.error_rate", Value: &lambdaRate, Ramp: true},
+				{Metric: "gcp.functions.error_rate", Value: &gcpRate, Ramp: true},
+				{Metric: "system.error_rate", Factor: 1.4, Ramp: true},
+			}},
+			{Name: "errors", Duration: Duration(20 * time.Second), Effects: []ScenarioEffect{
+				{Metric: "aws.lambda.error_rate", Value: &lambdaRate, Jitter: 0.1},
+				{Metric: "gcp.functions.error_rate", Value: &gcpRate, Jitter: 0.1},
+				{Metric: "system.error_rate", Factor: 1.4, Jitter: 0.05},
+			}},
+			{Name: "recover", Duration: Duration(5 * time.Second), Recover: true},
+		},
+	}
+}
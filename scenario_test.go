@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func seeded(seed int64) *int64 {
+	return &seed
+}
+
+func TestScenarioPlanIsReproducible(t *testing.T) {
+	initializeMockData()
+
+	tests := []struct {
+		name     string
+		scenario func() Scenario
+	}{
+		{"load spike", loadSpikeScenario},
+		{"error spike", errorSpikeScenario},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scenario := tt.scenario()
+			baseline := scenario.liveBaseline()
+
+			scenario.Seed = seeded(42)
+			first := scenario.Plan(baseline)
+			second := scenario.Plan(baseline)
+			if !reflect.DeepEqual(first, second) {
+				t.Fatal("same seed produced different plans")
+			}
+			if len(first) != scenario.stepTicks(scenario.Steps[0])+scenario.stepTicks(scenario.Steps[1])+scenario.stepTicks(scenario.Steps[2]) {
+				t.Fatalf("plan has %d ticks", len(first))
+			}
+
+			scenario.Seed = seeded(43)
+			if reflect.DeepEqual(first, scenario.Plan(baseline)) {
+				t.Fatal("different seeds produced identical plans")
+			}
+
+			// 0 es una semilla como cualquier otra
+			scenario.Seed = seeded(0)
+			if !reflect.DeepEqual(scenario.Plan(baseline), scenario.Plan(baseline)) {
+				t.Fatal("seed 0 produced different plans")
+			}
+		})
+	}
+}
+
+func TestScenarioValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		scenario Scenario
+		wantErr  bool
+	}{
+		{"load spike", loadSpikeScenario(), false},
+		{"no steps", Scenario{Name: "empty", Tick: Duration(time.Second)}, true},
+		{"unknown metric", Scenario{Name: "bad", Tick: Duration(time.Second), Steps: []ScenarioStep{
+			{Name: "s", Duration: Duration(time.Second), Effects: []ScenarioEffect{{Metric: "nope", Factor: 2}}},
+		}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.scenario.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// waitForStatus espera a que la ejecución deje de estar en running
+func waitForStatus(t *testing.T, engine *ScenarioEngine, id string) ScenarioRun {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		run, ok := engine.Get(id, false)
+		if !ok {
+			t.Fatalf("scenario %s not found", id)
+		}
+		if run.Status != ScenarioRunning {
+			return run
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("scenario %s still running", id)
+	return ScenarioRun{}
+}
+
+func TestScenarioEngineLifecycle(t *testing.T) {
+	initializeMockData()
+	short := Scenario{
+		Name: "short",
+		Seed: seeded(1),
+		Tick: Duration(time.Second),
+		Steps: []ScenarioStep{
+			{Name: "spike", Duration: Duration(2 * time.Second), Effects: []ScenarioEffect{
+				{Metric: "aws.lambda.invocations", Factor: 2},
+			}},
+		},
+	}
+
+	t.Run("start while active", func(t *testing.T) {
+		engine := NewScenarioEngine(&fakeClock{now: time.Unix(0, 0)}, rand.New(rand.NewSource(1)))
+		run, err := engine.Start(short, false)
+		if err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+		if _, err := engine.Start(short, false); !errors.Is(err, ErrScenarioActive) {
+			t.Fatalf("second Start error = %v, want ErrScenarioActive", err)
+		}
+
+		preempting, err := engine.Start(short, true)
+		if err != nil {
+			t.Fatalf("preempting Start: %v", err)
+		}
+		if first, _ := engine.Get(run.ID, false); first.Status != ScenarioPreempted {
+			t.Fatalf("preempted run status = %s", first.Status)
+		}
+		if _, err := engine.Cancel(preempting.ID); err != nil {
+			t.Fatalf("Cancel: %v", err)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		engine := NewScenarioEngine(&fakeClock{now: time.Unix(0, 0)}, rand.New(rand.NewSource(1)))
+		run, err := engine.Start(short, false)
+		if err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+		cancelled, err := engine.Cancel(run.ID)
+		if err != nil || cancelled.Status != ScenarioCancelled {
+			t.Fatalf("Cancel = %s, %v; want cancelled", cancelled.Status, err)
+		}
+		if again, err := engine.Cancel(run.ID); !errors.Is(err, ErrScenarioFinished) || again.Status != ScenarioCancelled {
+			t.Fatalf("second Cancel = %s, %v; want ErrScenarioFinished and cancelled", again.Status, err)
+		}
+		if _, err := engine.Cancel("missing"); !errors.Is(err, ErrScenarioNotFound) {
+			t.Fatalf("Cancel of unknown id error = %v, want ErrScenarioNotFound", err)
+		}
+	})
+
+	t.Run("cancel after completion", func(t *testing.T) {
+		clock := &fakeClock{now: time.Unix(0, 0), ticks: make(chan time.Time)}
+		engine := NewScenarioEngine(clock, rand.New(rand.NewSource(1)))
+		run, err := engine.Start(short, false)
+		if err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+		for i := 0; i < run.TotalTicks; i++ {
+			clock.ticks <- time.Unix(int64(i+1), 0)
+		}
+
+		finished := waitForStatus(t, engine, run.ID)
+		if finished.Status != ScenarioCompleted || finished.TicksDone != run.TotalTicks {
+			t.Fatalf("run = %s after %d/%d ticks, want completed", finished.Status, finished.TicksDone, run.TotalTicks)
+		}
+		if after, err := engine.Cancel(run.ID); !errors.Is(err, ErrScenarioFinished) || after.Status != ScenarioCompleted {
+			t.Fatalf("Cancel of completed run = %s, %v; want ErrScenarioFinished and completed", after.Status, err)
+		}
+		if len(engine.DrivenMetrics()) != 0 {
+			t.Fatal("finished scenario still drives metrics")
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultSimulationInterval = 10 * time.Second
+
+// Clock abstrae el tiempo para que la simulación sea reproducible
+type Clock interface {
+	Now() time.Time
+	Ticker(d time.Duration) (<-chan time.Time, func())
+}
+
+// realClock usa el reloj del sistema
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Ticker(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
+
+// boundedWalk es un paseo aleatorio con reversión a la media y límites duros
+type boundedWalk struct {
+	value     float64
+	mean      float64
+	min       float64
+	max       float64
+	reversion float64 // Fracción de la distancia a la media que se recupera por tick
+	sigma     float64 // Desviación del ruido por tick
+}
+
+func (w *boundedWalk) next(rng *rand.Rand) float64 {
+	w.value += w.reversion*(w.mean-w.value) + w.sigma*rng.NormFloat64()
+	w.value = math.Max(w.min, math.Min(w.max, w.value))
+	return w.value
+}
+
+// DataSimulator genera métricas realistas: tráfico con curva diaria, paseos
+// acotados y errores/latencia correlacionados a través de un nivel de estrés
+type DataSimulator struct {
+	rng      *rand.Rand
+	clock    Clock
+	interval time.Duration
+
+	lambdaBase  float64
+	gcpBase     float64
+	lambdaNoise boundedWalk
+	gcpNoise    boundedWalk
+	stress      boundedWalk
+
+	lambdaErrBase    float64
+	gcpErrBase       float64
+	lambdaDurBase    float64
+	gcpDurBase       float64
+	dynamoLatBase    float64
+	firestoreLatBase float64
+	responseBase     float64
+	ordersPerTick    float64
+
+	day string
+}
+
+// NewDataSimulator toma los valores iniciales de metrics como medias de los paseos
+func NewDataSimulator(rng *rand.Rand, clock Clock, metrics DashboardMetrics) *DataSimulator {
+	return &DataSimulator{
+		rng:      rng,
+		clock:    clock,
+		interval: defaultSimulationInterval,
+
+		lambdaBase:  float64(metrics.AWS.Lambda.Invocations),
+		gcpBase:     float64(metrics.GCP.CloudFunctions.Invocations),
+		lambdaNoise: boundedWalk{value: 1, mean: 1, min: 0.7, max: 1.3, reversion: 0.2, sigma: 0.04},
+		gcpNoise:    boundedWalk{value: 1, mean: 1, min: 0.7, max: 1.3, reversion: 0.2, sigma: 0.04},
+		stress:      boundedWalk{value: 0, mean: 0, min: 0, max: 1, reversion: 0.1, sigma: 0.05},
+
+		lambdaErrBase:    100 - metrics.AWS.Lambda.SuccessRate,
+		gcpErrBase:       100 - metrics.GCP.CloudFunctions.SuccessRate,
+		lambdaDurBase:    metrics.AWS.Lambda.Duration,
+		gcpDurBase:       metrics.GCP.CloudFunctions.Duration,
+		dynamoLatBase:    metrics.AWS.DynamoDB.Latency,
+		firestoreLatBase: metrics.GCP.Firestore.Latency,
+		responseBase:     float64(metrics.System.Performance.ResponseTime),
+		ordersPerTick:    1,
+
+		day: clock.Now().Format("2006-01-02"),
+	}
+}
+
+// diurnalFactor devuelve la carga relativa según la hora: pico a las 14:00, valle a las 02:00
+func diurnalFactor(t time.Time) float64 {
+	hour := float64(t.Hour()) + float64(t.Minute())/60
+	return 1 + 0.35*math.Cos(2*math.Pi*(hour-14)/24)
+}
+
+// Step calcula un tick y lo aplica sobre las métricas que no controla un escenario
+func (s *DataSimulator) Step(metrics *DashboardMetrics, driven map[string]bool) {
+	now := s.clock.Now()
+	load := diurnalFactor(now)
+
+	// La carga alta empuja el estrés hacia arriba; el estrés se recupera solo
+	s.stress.mean = math.Max(0, (load-1)*0.3)
+	stress := s.stress.next(s.rng)
+
+	lambdaInv := s.lambdaBase * load * s.lambdaNoise.next(s.rng)
+	gcpInv := s.gcpBase * load * s.gcpNoise.next(s.rng)
+
+	lambdaErr := s.lambdaErrBase*(1+4*stress) + 0.1*math.Abs(s.rng.NormFloat64())
+	gcpErr := s.gcpErrBase*(1+4*stress) + 0.1*math.Abs(s.rng.NormFloat64())
+	errRate := (lambdaErr*lambdaInv + gcpErr*gcpInv) / math.Max(lambdaInv+gcpInv, 1)
+
+	latency := 1 + 1.5*stress
+	orders := s.poisson(s.ordersPerTick * load)
+
+	values := map[string]float64{
+		"aws.lambda.invocations":    lambdaInv,
+		"aws.lambda.duration":       s.lambdaDurBase * latency,
+		"aws.dynamodb.requests":     lambdaInv * 0.95,
+		"aws.dynamodb.latency":      s.dynamoLatBase * latency,
+		"gcp.functions.invocations": gcpInv,
+		"gcp.functions.duration":    s.gcpDurBase * latency,
+		"gcp.firestore.latency":     s.firestoreLatBase * latency,
+		"system.response_time":      s.responseBase * latency,
+		"system.error_rate":         errRate,
+		"aws.lambda.error_rate":     lambdaErr,
+		"gcp.functions.error_rate":  gcpErr,
+	}
+
+	// Los contadores diarios se reinician a medianoche
+	if day := now.Format("2006-01-02"); day != s.day {
+		s.day = day
+		metrics.Business.Orders.Today = 0
+		metrics.System.Performance.RequestsToday = 0
+	}
+	values["business.orders.today"] = float64(metrics.Business.Orders.Today) + float64(orders)
+	values["system.requests_today"] = float64(metrics.System.Performance.RequestsToday) + lambdaInv + gcpInv
+
+	for name := range driven {
+		delete(values, name)
+	}
+	setScenarioValues(metrics, values)
+}
+
+// poisson genera conteos enteros con media lambda (algoritmo de Knuth)
+func (s *DataSimulator) poisson(lambda float64) int {
+	limit := math.Exp(-lambda)
+	k, p := 0, 1.0
+	for {
+		p *= s.rng.Float64()
+		if p <= limit {
+			return k
+		}
+		k++
+	}
+}
+
+// Run aplica un tick cada intervalo hasta que se cancele el contexto
+func (s *DataSimulator) Run(ctx context.Context, engine *ScenarioEngine) {
+	ticks, stop := s.clock.Ticker(s.interval)
+	defer stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticks:
+		}
+
+		driven := engine.DrivenMetrics()
+		metricsMu.Lock()
+		s.Step(&currentMetrics, driven)
+		metricsMu.Unlock()
+
+		// Limpiar alertas muy antiguas
+		cleanOldAlerts()
+	}
+}
+
+// simulationSeed lee SIM_SEED; sin valor usa una semilla aleatoria y la registra
+func simulationSeed() int64 {
+	if raw := os.Getenv("SIM_SEED"); raw != "" {
+		seed, err := strconv.ParseInt(raw, 10, 64)
+		if err == nil {
+			log.Printf("🎲 Simulation seed (SIM_SEED): %d", seed)
+			return seed
+		}
+		log.Printf("Warning: invalid SIM_SEED %q, using random seed", raw)
+	}
+	seed := time.Now().UnixNano()
+	log.Printf("🎲 Simulation seed: %d (set SIM_SEED to reproduce)", seed)
+	return seed
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock avanza solo cuando el test lo pide; sus tickers reciben de ticks
+// (sin ticks no disparan nunca)
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	ticks chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Ticker(d time.Duration) (<-chan time.Time, func()) {
+	if c.ticks != nil {
+		return c.ticks, func() {}
+	}
+	return make(chan time.Time), func() {}
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// simulate ejecuta ticks pasos de una simulación con semilla fija
+func simulate(seed int64, ticks int) (DashboardMetrics, *DataSimulator) {
+	initializeMockData()
+	metrics := currentMetrics
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}
+	simulator := NewDataSimulator(rand.New(rand.NewSource(seed)), clock, metrics)
+
+	for i := 0; i < ticks; i++ {
+		simulator.Step(&metrics, nil)
+		clock.advance(defaultSimulationInterval)
+	}
+	return metrics, simulator
+}
+
+func TestSimulationIsReproducible(t *testing.T) {
+	first, _ := simulate(42, 500)
+	second, _ := simulate(42, 500)
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("same seed produced different metrics:\n%+v\n%+v", first, second)
+	}
+
+	other, _ := simulate(43, 500)
+	if reflect.DeepEqual(first, other) {
+		t.Fatal("different seeds produced identical metrics")
+	}
+}
+
+func TestSimulationWalksStayInBounds(t *testing.T) {
+	initializeMockData()
+	metrics := currentMetrics
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}
+	simulator := NewDataSimulator(rand.New(rand.NewSource(7)), clock, metrics)
+
+	// Dos días completos cruzan el pico diario y el reinicio de medianoche
+	for i := 0; i < int(48*time.Hour/defaultSimulationInterval); i++ {
+		simulator.Step(&metrics, nil)
+		clock.advance(defaultSimulationInterval)
+
+		for name, walk := range map[string]boundedWalk{
+			"lambdaNoise": simulator.lambdaNoise,
+			"gcpNoise":    simulator.gcpNoise,
+			"stress":      simulator.stress,
+		} {
+			if walk.value < walk.min || walk.value > walk.max {
+				t.Fatalf("tick %d: %s = %v outside [%v, %v]", i, name, walk.value, walk.min, walk.max)
+			}
+		}
+		if rate := metrics.AWS.Lambda.SuccessRate; rate < 0 || rate > 100 {
+			t.Fatalf("tick %d: lambda success rate %v outside [0, 100]", i, rate)
+		}
+		if metrics.AWS.Lambda.Invocations < 0 || metrics.GCP.CloudFunctions.Invocations < 0 {
+			t.Fatalf("tick %d: negative invocations", i)
+		}
+	}
+}
+
+func TestSimulationSeed(t *testing.T) {
+	t.Setenv("SIM_SEED", "0")
+	if seed := simulationSeed(); seed != 0 {
+		t.Fatalf("SIM_SEED=0: got seed %d, want 0", seed)
+	}
+
+	t.Setenv("SIM_SEED", "12345")
+	if seed := simulationSeed(); seed != 12345 {
+		t.Fatalf("SIM_SEED=12345: got seed %d", seed)
+	}
+}